@@ -271,7 +271,7 @@ func (h *csiPluginSupervisorHook) registerPlugin(socketPath string) (func(), err
 	}
 
 	mkInfoFn := func(pluginType string) *dynamicplugins.PluginInfo {
-		return &dynamicplugins.PluginInfo{
+		pluginInfo := &dynamicplugins.PluginInfo{
 			Type:    pluginType,
 			Name:    h.task.CSIPluginConfig.ID,
 			Version: info.PluginVersion,
@@ -285,6 +285,20 @@ func (h *csiPluginSupervisorHook) registerPlugin(socketPath string) (func(), err
 				"ContainerMountPoint": h.task.CSIPluginConfig.MountDir,
 			},
 		}
+
+		// Controllers have no CSI RPC for reporting their own topology, so
+		// zonal controllers (one instance per zone rather than a single
+		// cluster-wide controller) are scoped by the datacenter of the
+		// client they're registered from.
+		if pluginType == dynamicplugins.PluginTypeCSIController {
+			if node := h.runner.clientConfig.Node; node != nil && node.Datacenter != "" {
+				pluginInfo.AccessibleTopology = &structs.CSITopology{
+					Segments: map[string]string{"datacenter": node.Datacenter},
+				}
+			}
+		}
+
+		return pluginInfo
 	}
 
 	registrations := []*dynamicplugins.PluginInfo{}
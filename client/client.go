@@ -381,7 +381,7 @@ func NewClient(cfg *config.Config, consulCatalog consul.CatalogAPI, consulProxie
 			dynamicplugins.PluginTypeCSINode: func(info *dynamicplugins.PluginInfo) (interface{}, error) {
 				return csi.NewClient(info.ConnectionInfo.SocketPath, logger.Named("csi_client").With("plugin.name", info.Name, "plugin.type", "client"))
 			}, // TODO(tgross): refactor these dispenser constructors into csimanager to tidy it up
-		})
+		}, logger.Named("dynamic_registry"))
 
 	// Setup the clients RPC server
 	c.setupClientRpc()
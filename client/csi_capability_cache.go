@@ -0,0 +1,62 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/csi"
+)
+
+const (
+	// csiControllerCapabilitiesTTL bounds how long a cached
+	// ControllerGetCapabilities response for a plugin is reused before
+	// GetCapabilities queries the plugin again.
+	csiControllerCapabilitiesTTL = 5 * time.Minute
+)
+
+// csiControllerCapabilitiesCacheEntry holds one plugin's most recently
+// fetched capability set and when it expires.
+type csiControllerCapabilitiesCacheEntry struct {
+	capabilities *csi.ControllerCapabilitySet
+	expiresAt    time.Time
+}
+
+// csiControllerCapabilitiesCache caches each plugin's ControllerGetCapabilities
+// response for csiControllerCapabilitiesTTL, so that CSIController.GetCapabilities
+// doesn't have to hit the plugin's gRPC endpoint on every call. Plugins are
+// tracked independently by PluginID.
+type csiControllerCapabilitiesCache struct {
+	lock    sync.Mutex
+	plugins map[string]*csiControllerCapabilitiesCacheEntry
+}
+
+func newCSIControllerCapabilitiesCache() *csiControllerCapabilitiesCache {
+	return &csiControllerCapabilitiesCache{
+		plugins: make(map[string]*csiControllerCapabilitiesCacheEntry),
+	}
+}
+
+// Get returns the cached capability set for pluginID, if any, and whether it
+// was found and still unexpired.
+func (c *csiControllerCapabilitiesCache) Get(pluginID string) (*csi.ControllerCapabilitySet, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.plugins[pluginID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.capabilities, true
+}
+
+// Set stores caps as pluginID's current capability set, valid for
+// csiControllerCapabilitiesTTL.
+func (c *csiControllerCapabilitiesCache) Set(pluginID string, caps *csi.ControllerCapabilitySet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.plugins[pluginID] = &csiControllerCapabilitiesCacheEntry{
+		capabilities: caps,
+		expiresAt:    time.Now().Add(csiControllerCapabilitiesTTL),
+	}
+}
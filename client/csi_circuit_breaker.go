@@ -0,0 +1,96 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// csiCircuitBreakerThreshold is the number of consecutive failed calls
+	// to a plugin required to trip its circuit open.
+	csiCircuitBreakerThreshold = 5
+
+	// csiCircuitBreakerCooldown is how long an open circuit fast-fails
+	// further calls before allowing a single probe call through to test
+	// whether the plugin has recovered.
+	csiCircuitBreakerCooldown = 30 * time.Second
+)
+
+// csiCircuitBreakerState tracks one plugin's consecutive failure count and,
+// once tripped, when it's eligible to be probed again.
+type csiCircuitBreakerState struct {
+	consecutiveFailures int
+	openUntil           time.Time
+	probing             bool
+}
+
+// csiCircuitBreaker fast-fails calls to a CSI plugin that's failing every
+// request, so a single unhealthy plugin can't waste the request timeout on
+// every operation routed to it. Plugins are tracked independently by
+// PluginID: a failing plugin never affects calls to a different one.
+type csiCircuitBreaker struct {
+	lock    sync.Mutex
+	plugins map[string]*csiCircuitBreakerState
+}
+
+func newCSICircuitBreaker() *csiCircuitBreaker {
+	return &csiCircuitBreaker{
+		plugins: make(map[string]*csiCircuitBreakerState),
+	}
+}
+
+// Allow reports whether a call to pluginID should proceed. It returns false
+// while the circuit is open and its cool-down hasn't elapsed. Once the
+// cool-down elapses, Allow lets a single call through to probe the plugin's
+// health and holds off further calls until that probe's outcome is
+// recorded via RecordSuccess or RecordFailure.
+func (b *csiCircuitBreaker) Allow(pluginID string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.plugins[pluginID]
+	if !ok || state.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(state.openUntil) {
+		return false
+	}
+
+	if state.probing {
+		return false
+	}
+
+	state.probing = true
+	return true
+}
+
+// RecordSuccess closes pluginID's circuit, clearing any accumulated
+// failures.
+func (b *csiCircuitBreaker) RecordSuccess(pluginID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.plugins, pluginID)
+}
+
+// RecordFailure counts a failed call against pluginID, tripping the circuit
+// open for csiCircuitBreakerCooldown once csiCircuitBreakerThreshold
+// consecutive failures have been recorded.
+func (b *csiCircuitBreaker) RecordFailure(pluginID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.plugins[pluginID]
+	if !ok {
+		state = &csiCircuitBreakerState{}
+		b.plugins[pluginID] = state
+	}
+
+	state.consecutiveFailures++
+	state.probing = false
+
+	if state.consecutiveFailures >= csiCircuitBreakerThreshold {
+		state.openUntil = time.Now().Add(csiCircuitBreakerCooldown)
+	}
+}
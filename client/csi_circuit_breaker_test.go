@@ -0,0 +1,74 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCSICircuitBreaker_TripsAndRecoversPerPlugin exercises the breaker's
+// lifecycle against a single plugin: it stays closed below the failure
+// threshold, trips open at the threshold, fast-fails during the cool-down,
+// and closes again once a post-cool-down probe succeeds.
+func TestCSICircuitBreaker_TripsAndRecoversPerPlugin(t *testing.T) {
+	t.Parallel()
+
+	b := newCSICircuitBreaker()
+
+	for i := 0; i < csiCircuitBreakerThreshold-1; i++ {
+		require.True(t, b.Allow("plugin-1"))
+		b.RecordFailure("plugin-1")
+	}
+	require.True(t, b.Allow("plugin-1"), "circuit should still be closed below the threshold")
+
+	b.RecordFailure("plugin-1")
+	require.False(t, b.Allow("plugin-1"), "circuit should be open once the threshold is reached")
+
+	// a second, concurrent caller is also fast-failed while the circuit is
+	// open; it doesn't get to consume the eventual probe slot.
+	require.False(t, b.Allow("plugin-1"))
+
+	b.plugins["plugin-1"].openUntil = time.Now().Add(-time.Second)
+	require.True(t, b.Allow("plugin-1"), "a single probe should be let through once the cool-down elapses")
+	require.False(t, b.Allow("plugin-1"), "only one probe is allowed in flight at a time")
+
+	b.RecordSuccess("plugin-1")
+	require.True(t, b.Allow("plugin-1"), "a successful probe should close the circuit")
+	_, tracked := b.plugins["plugin-1"]
+	require.False(t, tracked, "a closed circuit shouldn't keep failure state around")
+}
+
+// TestCSICircuitBreaker_FailedProbeReopens exercises the case where the
+// plugin is still unhealthy when probed: the circuit reopens for another
+// cool-down instead of closing.
+func TestCSICircuitBreaker_FailedProbeReopens(t *testing.T) {
+	t.Parallel()
+
+	b := newCSICircuitBreaker()
+
+	for i := 0; i < csiCircuitBreakerThreshold; i++ {
+		b.RecordFailure("plugin-1")
+	}
+	require.False(t, b.Allow("plugin-1"))
+
+	b.plugins["plugin-1"].openUntil = time.Now().Add(-time.Second)
+	require.True(t, b.Allow("plugin-1"))
+
+	b.RecordFailure("plugin-1")
+	require.False(t, b.Allow("plugin-1"), "a failed probe should reopen the circuit")
+}
+
+// TestCSICircuitBreaker_PluginsAreIndependent exercises that a failing
+// plugin's open circuit has no effect on calls to a different plugin.
+func TestCSICircuitBreaker_PluginsAreIndependent(t *testing.T) {
+	t.Parallel()
+
+	b := newCSICircuitBreaker()
+
+	for i := 0; i < csiCircuitBreakerThreshold; i++ {
+		b.RecordFailure("unhealthy-plugin")
+	}
+	require.False(t, b.Allow("unhealthy-plugin"))
+	require.True(t, b.Allow("healthy-plugin"))
+}
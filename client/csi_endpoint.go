@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
@@ -13,12 +14,23 @@ import (
 	"github.com/hashicorp/nomad/client/structs"
 	nstructs "github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// csiTraceIDMetadataKey is the gRPC metadata key used to forward a CSI
+// operation's trace ID to the plugin, so that the plugin's own logs can be
+// correlated with the Nomad server logs for the same operation.
+const csiTraceIDMetadataKey = "nomad-trace-id"
+
 // CSI endpoint is used for interacting with CSI plugins on a client.
 // TODO: Submit metrics with labels to allow debugging per plugin perf problems.
 type CSI struct {
-	c *Client
+	c                 *Client
+	inFlight          *csiInFlightRegistry
+	breaker           *csiCircuitBreaker
+	capabilitiesCache *csiControllerCapabilitiesCache
 }
 
 const (
@@ -26,6 +38,13 @@ const (
 	// against CSI Plugins. It is copied from Kubernetes as an initial seed value.
 	// https://github.com/kubernetes/kubernetes/blob/e680ad7156f263a6d8129cc0117fda58602e50ad/pkg/volume/csi/csi_plugin.go#L52
 	CSIPluginRequestTimeout = 2 * time.Minute
+
+	// DefaultCSIControllerRequestTimeout bounds how long a CSIController RPC
+	// waits on the plugin when the request doesn't set its own Timeout. It's
+	// intentionally tighter than CSIPluginRequestTimeout so that a hung
+	// controller plugin fails fast with a clear error instead of leaving the
+	// calling RPC blocked for minutes.
+	DefaultCSIControllerRequestTimeout = 60 * time.Second
 )
 
 var (
@@ -54,20 +73,33 @@ func (c *CSI) ControllerValidateVolume(req *structs.ClientCSIControllerValidateV
 	}
 	defer plugin.Close()
 
+	if err := csi.ValidateVolumeCapabilities(req.AttachmentMode, req.AccessMode); err != nil {
+		return err
+	}
+
 	csiReq, err := req.ToCSIRequest()
 	if err != nil {
 		return err
 	}
 
-	ctx, cancelFn := c.requestContext()
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
 	defer cancelFn()
 
 	// CSI ValidateVolumeCapabilities errors for timeout, codes.Unavailable and
 	// codes.ResourceExhausted are retried; all other errors are fatal.
-	return plugin.ControllerValidateCapabilities(ctx, csiReq,
+	err = plugin.ControllerValidateCapabilities(ctx, csiReq,
 		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
 		grpc_retry.WithMax(3),
 		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+	} else {
+		c.breaker.RecordSuccess(req.PluginID)
+	}
+	return err
 }
 
 // ControllerAttachVolume is used to attach a volume from a CSI Cluster to
@@ -77,7 +109,14 @@ func (c *CSI) ControllerValidateVolume(req *structs.ClientCSIControllerValidateV
 // 1. Validate the volume request
 // 2. Call ControllerPublishVolume on the CSI Plugin to trigger a remote attachment
 //
-// In the future this may be expanded to request dynamic secrets for attachment.
+// req.Secrets, if set, is forwarded to the plugin unmodified and is never
+// logged or included in any error message returned by this RPC.
+//
+// There's no before/after snapshot of the volume's published-nodes state
+// recorded around the ControllerPublishVolume call: that would require a
+// ControllerGetVolume call, and the CSI spec version currently vendored by
+// this build has no GetVolume controller RPC to call (see the note on
+// ControllerCapabilitySet in plugins/csi/plugin.go).
 func (c *CSI) ControllerAttachVolume(req *structs.ClientCSIControllerAttachVolumeRequest, resp *structs.ClientCSIControllerAttachVolumeResponse) error {
 	defer metrics.MeasureSince([]string{"client", "csi_controller", "publish_volume"}, time.Now())
 	plugin, err := c.findControllerPlugin(req.PluginID)
@@ -101,30 +140,92 @@ func (c *CSI) ControllerAttachVolume(req *structs.ClientCSIControllerAttachVolum
 		return errors.New("ClientCSINodeID is required")
 	}
 
+	if !req.Deadline.IsZero() && !time.Now().Before(req.Deadline) {
+		return errors.New("attach deadline exceeded")
+	}
+
+	if req.RequestedDevicePath != "" && !filepath.IsAbs(req.RequestedDevicePath) {
+		return errors.New("RequestedDevicePath must be an absolute path")
+	}
+
 	csiReq, err := req.ToCSIRequest()
 	if err != nil {
 		return err
 	}
 
-	// Submit the request for a volume to the CSI Plugin.
-	ctx, cancelFn := c.requestContext()
+	done := c.inFlight.Start("ControllerAttachVolume", req.VolumeID, req.PluginID, req.ClientCSINodeID)
+	defer done()
+
+	// Submit the request for a volume to the CSI Plugin. An explicit
+	// per-request Timeout takes precedence over the scheduler-computed
+	// Deadline, since it was set by the caller specifically to bound this
+	// RPC rather than the overall attach workflow.
+	var ctx context.Context
+	var cancelFn context.CancelFunc
+	var timeout time.Duration
+	if req.Timeout > 0 {
+		ctx, cancelFn, timeout = c.controllerRequestContext(req.Timeout)
+	} else {
+		ctx, cancelFn = c.requestContextWithDeadline(req.Deadline)
+	}
 	defer cancelFn()
-	// CSI ControllerPublishVolume errors for timeout, codes.Unavailable and
-	// codes.ResourceExhausted are retried; all other errors are fatal.
-	cresp, err := plugin.ControllerPublishVolume(ctx, csiReq,
-		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
-		grpc_retry.WithMax(3),
-		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	ctx = withCSITraceID(ctx, req.TraceID)
+	c.c.logger.Trace("sending controller attach volume", "trace_id", req.TraceID, "volume_id", req.VolumeID)
+	// CSI ControllerPublishVolume errors for codes.Unavailable,
+	// codes.DeadlineExceeded and codes.ResourceExhausted are retried up to
+	// req.Retries times with doubling backoff; all other errors are fatal.
+	var cresp *csi.ControllerPublishVolumeResponse
+	resp.Attempts, err = withCSIRetry(ctx, req.Retries, req.RetryBaseDelay, func() error {
+		var rerr error
+		cresp, rerr = plugin.ControllerPublishVolume(ctx, csiReq,
+			grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+			grpc_retry.WithMax(3),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+		return rerr
+	})
 	if err != nil {
+		// Per the CSI spec, ControllerPublishVolume only returns
+		// AlreadyExists when the volume is already published to this node
+		// with incompatible capabilities or a conflicting read_only
+		// setting; a compatible republish returns success directly. So
+		// this is always a genuine conflict, not a safe retry, and must
+		// propagate like any other error.
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			if req.Timeout > 0 {
+				return csiControllerTimeoutError(req.PluginID, timeout)
+			}
+			return errors.New("attach deadline exceeded")
+		}
 		return err
 	}
+	c.breaker.RecordSuccess(req.PluginID)
 
 	resp.PublishContext = cresp.PublishContext
+	if req.AttachmentMode == nstructs.CSIVolumeAttachmentModeBlockDevice {
+		resp.DevicePath = cresp.PublishContext[csi.PublishContextDevicePathKey]
+		resp.DevicePaths = csi.ParseMultipathDevicePaths(cresp.PublishContext)
+
+		if req.RequestedDevicePath != "" && resp.DevicePath != "" && resp.DevicePath != req.RequestedDevicePath {
+			// The plugin doesn't appear to honor RequestedDevicePath; it's
+			// a best-effort hint with no CSI spec backing, so this is only
+			// worth a warning rather than failing the attach.
+			c.c.logger.Warn("plugin did not honor requested device path",
+				"volume_id", req.VolumeID, "requested", req.RequestedDevicePath, "actual", resp.DevicePath)
+		}
+	}
 	return nil
 }
 
 // ControllerDetachVolume is used to detach a volume from a CSI Cluster from
-// the storage node provided in the request.
+// the storage node provided in the request. Its required-field validation
+// deliberately mirrors ControllerAttachVolume's so that a malformed detach
+// request fails here with a clear message instead of surfacing as an
+// opaque error from the plugin.
+//
+// Like ControllerAttachVolume, this doesn't capture a before/after snapshot
+// of the volume's published-nodes state around the detach; see the note
+// there for why.
 func (c *CSI) ControllerDetachVolume(req *structs.ClientCSIControllerDetachVolumeRequest, resp *structs.ClientCSIControllerDetachVolumeResponse) error {
 	defer metrics.MeasureSince([]string{"client", "csi_controller", "unpublish_volume"}, time.Now())
 	plugin, err := c.findControllerPlugin(req.PluginID)
@@ -150,24 +251,367 @@ func (c *CSI) ControllerDetachVolume(req *structs.ClientCSIControllerDetachVolum
 
 	csiReq := req.ToCSIRequest()
 
+	done := c.inFlight.Start("ControllerDetachVolume", req.VolumeID, req.PluginID, req.ClientCSINodeID)
+	defer done()
+
 	// Submit the request for a volume to the CSI Plugin.
-	ctx, cancelFn := c.requestContext()
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
 	defer cancelFn()
-	// CSI ControllerUnpublishVolume errors for timeout, codes.Unavailable and
-	// codes.ResourceExhausted are retried; all other errors are fatal.
-	_, err = plugin.ControllerUnpublishVolume(ctx, csiReq,
-		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
-		grpc_retry.WithMax(3),
-		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	ctx = withCSITraceID(ctx, req.TraceID)
+	c.c.logger.Trace("sending controller detach volume", "trace_id", req.TraceID, "volume_id", req.VolumeID)
+	// CSI ControllerUnpublishVolume errors for codes.Unavailable,
+	// codes.DeadlineExceeded and codes.ResourceExhausted are retried up to
+	// req.Retries times with doubling backoff; all other errors are fatal.
+	resp.Attempts, err = withCSIRetry(ctx, req.Retries, req.RetryBaseDelay, func() error {
+		_, rerr := plugin.ControllerUnpublishVolume(ctx, csiReq,
+			grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+			grpc_retry.WithMax(3),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+		return rerr
+	})
 	if err != nil {
 		if errors.Is(err, nstructs.ErrCSIClientRPCIgnorable) {
 			// if the controller detach previously happened but the server failed to
 			// checkpoint, we'll get an error from the plugin but can safely ignore it.
+			c.breaker.RecordSuccess(req.PluginID)
 			c.c.logger.Debug("could not unpublish volume: %v", err)
 			return nil
 		}
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+	return nil
+}
+
+// ControllerCreateVolume is used to dynamically provision a new volume via
+// the CSI Cluster's controller plugin, rather than requiring the volume to
+// pre-exist in the storage provider.
+func (c *CSI) ControllerCreateVolume(req *structs.ClientCSIControllerCreateVolumeRequest, resp *structs.ClientCSIControllerCreateVolumeResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "create_volume"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	// The following block of validation checks should not be reached on a
+	// real Nomad cluster as all of this data should be validated when the
+	// volume create request is submitted. They serve as a defensive check
+	// before forwarding requests to plugins, and to aid with development.
+
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+
+	if req.AttachmentMode == "" || req.AccessMode == "" {
+		return errors.New("VolumeCapability is required")
+	}
+
+	csiReq, err := req.ToCSIRequest()
+	if err != nil {
+		return err
+	}
+
+	done := c.inFlight.Start("ControllerCreateVolume", req.Name, req.PluginID, "")
+	defer done()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller create volume", "name", req.Name)
+	// CSI CreateVolume errors for timeout, codes.Unavailable and
+	// codes.ResourceExhausted are retried; all other errors are fatal.
+	cresp, err := plugin.ControllerCreateVolume(ctx, csiReq,
+		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+		grpc_retry.WithMax(3),
+		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+	if cresp == nil {
+		return nil
+	}
+
+	resp.ExternalVolumeID = cresp.ExternalVolumeID
+	resp.CapacityBytes = cresp.CapacityBytes
+	resp.VolumeContext = cresp.VolumeContext
+	return nil
+}
+
+// ControllerDeleteVolume is used to reclaim the storage consumed by a
+// dynamically provisioned volume once it's no longer needed. It's
+// idempotent: if the plugin reports the volume is already gone, that's
+// treated as success rather than an error.
+func (c *CSI) ControllerDeleteVolume(req *structs.ClientCSIControllerDeleteVolumeRequest, resp *structs.ClientCSIControllerDeleteVolumeResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "delete_volume"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	// The following block of validation checks should not be reached on a
+	// real Nomad cluster as all of this data should be validated when the
+	// volume delete request is submitted. They serve as a defensive check
+	// before forwarding requests to plugins, and to aid with development.
+
+	if req.VolumeID == "" {
+		return errors.New("VolumeID is required")
+	}
+
+	csiReq := req.ToCSIRequest()
+
+	done := c.inFlight.Start("ControllerDeleteVolume", req.VolumeID, req.PluginID, "")
+	defer done()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller delete volume", "volume_id", req.VolumeID)
+	// CSI DeleteVolume errors for timeout, codes.Unavailable and
+	// codes.ResourceExhausted are retried; all other errors are fatal.
+	_, err = plugin.ControllerDeleteVolume(ctx, csiReq,
+		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+		grpc_retry.WithMax(3),
+		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+	return nil
+}
+
+// ControllerExpandVolume is used to resize a volume via the CSI Cluster's
+// controller plugin. The volume may still be attached to a node and in use.
+func (c *CSI) ControllerExpandVolume(req *structs.ClientCSIControllerExpandVolumeRequest, resp *structs.ClientCSIControllerExpandVolumeResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "expand_volume"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	// The following block of validation checks should not be reached on a
+	// real Nomad cluster as all of this data should be validated when the
+	// volume expand request is submitted. They serve as a defensive check
+	// before forwarding requests to plugins, and to aid with development.
+
+	if req.VolumeID == "" {
+		return errors.New("VolumeID is required")
+	}
+	if req.CapacityMinBytes <= 0 {
+		return errors.New("CapacityMinBytes must be greater than 0")
+	}
+
+	csiReq := req.ToCSIRequest()
+
+	done := c.inFlight.Start("ControllerExpandVolume", req.VolumeID, req.PluginID, "")
+	defer done()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller expand volume", "volume_id", req.VolumeID)
+	// CSI ControllerExpandVolume errors for timeout, codes.Unavailable and
+	// codes.ResourceExhausted are retried; all other errors are fatal.
+	cresp, err := plugin.ControllerExpandVolume(ctx, csiReq,
+		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+		grpc_retry.WithMax(3),
+		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+	if cresp == nil {
+		return nil
+	}
+
+	resp.CapacityBytes = cresp.CapacityBytes
+	resp.NodeExpansionRequired = cresp.NodeExpansionRequired
+	return nil
+}
+
+// ControllerListVolumes lists the volumes known to a CSI controller plugin,
+// for reconciling against Nomad's view of the world. A MaxEntries of zero
+// means the caller wants every volume, so this handler follows NextToken
+// internally until the plugin reports no more pages.
+func (c *CSI) ControllerListVolumes(req *structs.ClientCSIControllerListVolumesRequest, resp *structs.ClientCSIControllerListVolumesResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "list_volumes"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	if req.MaxEntries < 0 {
+		return errors.New("MaxEntries must not be negative")
+	}
+
+	done := c.inFlight.Start("ControllerListVolumes", "", req.PluginID, "")
+	defer done()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller list volumes", "plugin_id", req.PluginID)
+
+	streamAll := req.MaxEntries == 0
+	startingToken := req.StartingToken
+
+	for {
+		csiReq := &csi.ControllerListVolumesRequest{
+			MaxEntries:    req.MaxEntries,
+			StartingToken: startingToken,
+		}
+
+		// CSI ListVolumes errors for timeout, codes.Unavailable and
+		// codes.ResourceExhausted are retried; all other errors are fatal.
+		cresp, err := plugin.ControllerListVolumes(ctx, csiReq,
+			grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+			grpc_retry.WithMax(3),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+		if err != nil {
+			c.breaker.RecordFailure(req.PluginID)
+			if ctx.Err() == context.DeadlineExceeded {
+				return csiControllerTimeoutError(req.PluginID, timeout)
+			}
+			return err
+		}
+		c.breaker.RecordSuccess(req.PluginID)
+		if cresp == nil {
+			return nil
+		}
+
+		resp.Entries = append(resp.Entries, cresp.Entries...)
+		resp.NextToken = cresp.NextToken
+
+		if !streamAll || cresp.NextToken == "" {
+			return nil
+		}
+		startingToken = cresp.NextToken
+	}
+}
+
+// ControllerGetCapacity queries a CSI controller plugin for how much
+// storage capacity remains available for volumes matching the (entirely
+// optional) request filters, so that callers can make capacity-aware
+// decisions before provisioning large volumes.
+func (c *CSI) ControllerGetCapacity(req *structs.ClientCSIControllerGetCapacityRequest, resp *structs.ClientCSIControllerGetCapacityResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "get_capacity"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller get capacity", "plugin_id", req.PluginID)
+
+	cresp, err := plugin.ControllerGetCapacity(ctx, req.ToCSIRequest(),
+		grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+		grpc_retry.WithMax(3),
+		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
 		return err
 	}
+	c.breaker.RecordSuccess(req.PluginID)
+
+	resp.AvailableCapacity = cresp.AvailableCapacity
+	return nil
+}
+
+// CreateVolumeGroupSnapshot snapshots every volume in req.VolumeIDs under a
+// shared group identifier. See
+// ClientCSIControllerCreateVolumeGroupSnapshotRequest for why this is an
+// emulated group snapshot rather than an atomic one.
+func (c *CSI) CreateVolumeGroupSnapshot(req *structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest, resp *structs.ClientCSIControllerCreateVolumeGroupSnapshotResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "create_volume_group_snapshot"}, time.Now())
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	if req.PluginID == "" {
+		return errors.New("PluginID is required")
+	}
+
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+
+	caps, err := plugin.ControllerGetCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.HasCreateDeleteSnapshot {
+		return fmt.Errorf("%w: plugin %q does not support creating snapshots",
+			nstructs.ErrCSIClientRPCUnsupported, req.PluginID)
+	}
+
+	snapshotIDs := make(map[string]string, len(req.VolumeIDs))
+	for _, volID := range req.VolumeIDs {
+		csiReq := &csi.ControllerCreateSnapshotRequest{
+			ExternalSourceVolumeID: volID,
+			Name:                   req.GroupSnapshotID + "-" + volID,
+			Secrets:                req.Secrets,
+			Parameters:             req.Parameters,
+		}
+
+		// CSI CreateSnapshot errors for timeout, codes.Unavailable and
+		// codes.ResourceExhausted are retried; all other errors are fatal.
+		csiResp, err := plugin.ControllerCreateSnapshot(ctx, csiReq,
+			grpc_retry.WithPerRetryTimeout(CSIPluginRequestTimeout),
+			grpc_retry.WithMax(3),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)))
+		if err != nil {
+			c.breaker.RecordFailure(req.PluginID)
+			if ctx.Err() == context.DeadlineExceeded {
+				return csiControllerTimeoutError(req.PluginID, timeout)
+			}
+			return fmt.Errorf("failed to snapshot volume %q in group %q: %v", volID, req.GroupSnapshotID, err)
+		}
+		c.breaker.RecordSuccess(req.PluginID)
+
+		snapshotIDs[volID] = csiResp.ID
+	}
+
+	resp.GroupSnapshotID = req.GroupSnapshotID
+	resp.SnapshotIDs = snapshotIDs
 	return nil
 }
 
@@ -189,8 +633,13 @@ func (c *CSI) NodeDetachVolume(req *structs.ClientCSINodeDetachVolumeRequest, re
 		return errors.New("AllocID is required")
 	}
 
+	done := c.inFlight.Start("NodeDetachVolume", req.VolumeID, req.PluginID, req.NodeID)
+	defer done()
+
 	ctx, cancelFn := c.requestContext()
 	defer cancelFn()
+	ctx = withCSITraceID(ctx, req.TraceID)
+	c.c.logger.Trace("sending node detach volume", "trace_id", req.TraceID, "volume_id", req.VolumeID, "alloc_id", req.AllocID)
 
 	mounter, err := c.c.csimanager.MounterForPlugin(ctx, req.PluginID)
 	if err != nil {
@@ -213,12 +662,155 @@ func (c *CSI) NodeDetachVolume(req *structs.ClientCSINodeDetachVolumeRequest, re
 	return nil
 }
 
+// NodeHasVolumeMount reports whether this client still has an allocation
+// claiming the given volume, so that the server can decide whether it's
+// safe to detach the volume at the controller.
+func (c *CSI) NodeHasVolumeMount(req *structs.ClientCSINodeHasVolumeMountRequest, resp *structs.ClientCSINodeHasVolumeMountResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_node", "has_volume_mount"}, time.Now())
+
+	if req.PluginID == "" {
+		return errors.New("PluginID is required")
+	}
+	if req.VolumeID == "" {
+		return errors.New("VolumeID is required")
+	}
+
+	ctx, cancelFn := c.requestContext()
+	defer cancelFn()
+
+	mounter, err := c.c.csimanager.MounterForPlugin(ctx, req.PluginID)
+	if err != nil {
+		return err
+	}
+
+	resp.HasMount = mounter.HasMount(req.VolumeID)
+	return nil
+}
+
+// RefreshPluginCapabilities re-queries a plugin's capabilities immediately,
+// instead of waiting for the next periodic fingerprint, and updates the
+// cached fingerprint reported to the server. This lets operators pick up a
+// plugin upgrade's new capabilities without re-registering it.
+func (c *CSI) RefreshPluginCapabilities(req *structs.ClientCSIRefreshPluginCapabilitiesRequest, resp *structs.ClientCSIRefreshPluginCapabilitiesResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi", "refresh_plugin_capabilities"}, time.Now())
+
+	if req.PluginID == "" {
+		return errors.New("PluginID is required")
+	}
+	if req.Type == "" {
+		return errors.New("Type is required")
+	}
+
+	ctx, cancelFn := c.requestContext()
+	defer cancelFn()
+
+	info, err := c.c.csimanager.RefreshPluginCapabilities(ctx, req.Type, req.PluginID)
+	if err != nil {
+		return err
+	}
+
+	resp.CSIInfo = info
+	return nil
+}
+
+// ControllerProbe calls a CSI controller plugin's Probe RPC, so operators
+// can poll plugin liveness (e.g. before attempting attach operations)
+// without having to interpret fingerprint state.
+func (c *CSI) ControllerProbe(req *structs.ClientCSIControllerProbeRequest, resp *structs.ClientCSIControllerProbeResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "probe"}, time.Now())
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller probe", "plugin_id", req.PluginID)
+
+	ready, err := plugin.PluginProbe(ctx)
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+
+	resp.Ready = ready
+	resp.Time = time.Now()
+	return nil
+}
+
+// GetCapabilities reports which controller operations a CSI plugin
+// supports, so that schedulers can check whether an operation like
+// CreateVolume or Expand is available before attempting it. Results are
+// cached per plugin for csiControllerCapabilitiesTTL to avoid hammering the
+// plugin with a ControllerGetCapabilities call on every check.
+func (c *CSI) GetCapabilities(req *structs.ClientCSIControllerGetCapabilitiesRequest, resp *structs.ClientCSIControllerGetCapabilitiesResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi_controller", "get_capabilities"}, time.Now())
+
+	if cached, ok := c.capabilitiesCache.Get(req.PluginID); ok {
+		populateControllerCapabilitiesResponse(resp, cached)
+		return nil
+	}
+
+	plugin, err := c.findControllerPlugin(req.PluginID)
+	if err != nil {
+		// the server's view of the plugin health is stale, so let it know it
+		// should retry with another controller instance
+		return fmt.Errorf("%w: %v", nstructs.ErrCSIClientRPCRetryable, err)
+	}
+	defer plugin.Close()
+
+	ctx, cancelFn, timeout := c.controllerRequestContext(req.Timeout)
+	defer cancelFn()
+	c.c.logger.Trace("sending controller get capabilities", "plugin_id", req.PluginID)
+
+	caps, err := plugin.ControllerGetCapabilities(ctx)
+	if err != nil {
+		c.breaker.RecordFailure(req.PluginID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return csiControllerTimeoutError(req.PluginID, timeout)
+		}
+		return err
+	}
+	c.breaker.RecordSuccess(req.PluginID)
+
+	c.capabilitiesCache.Set(req.PluginID, caps)
+	populateControllerCapabilitiesResponse(resp, caps)
+	return nil
+}
+
+func populateControllerCapabilitiesResponse(resp *structs.ClientCSIControllerGetCapabilitiesResponse, caps *csi.ControllerCapabilitySet) {
+	resp.SupportsCreateDelete = caps.HasCreateDeleteVolume
+	resp.SupportsPublish = caps.HasPublishUnpublishVolume
+	resp.SupportsSnapshot = caps.HasCreateDeleteSnapshot
+	resp.SupportsExpand = caps.HasExpandVolume
+	resp.SupportsListVolumes = caps.HasListVolumes
+}
+
+// ListInFlight returns every CSI operation currently running against a
+// plugin on this client, for use during incident response.
+func (c *CSI) ListInFlight(req *structs.ClientCSIListInFlightRequest, resp *structs.ClientCSIListInFlightResponse) error {
+	defer metrics.MeasureSince([]string{"client", "csi", "list_in_flight"}, time.Now())
+
+	resp.InFlight = c.inFlight.List()
+	return nil
+}
+
 func (c *CSI) findControllerPlugin(name string) (csi.CSIPlugin, error) {
+	if !c.breaker.Allow(name) {
+		return nil, fmt.Errorf("%w: plugin circuit open for plugin %q", nstructs.ErrCSIClientRPCRetryable, name)
+	}
 	return c.findPlugin(dynamicplugins.PluginTypeCSIController, name)
 }
 
-func (c *CSI) findPlugin(ptype, name string) (csi.CSIPlugin, error) {
-	pIface, err := c.c.dynamicRegistry.DispensePlugin(ptype, name)
+func (c *CSI) findPlugin(ptype, name string, opts ...dynamicplugins.DispenseOpt) (csi.CSIPlugin, error) {
+	pIface, err := c.c.dynamicRegistry.DispensePlugin(ptype, name, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -234,3 +826,84 @@ func (c *CSI) findPlugin(ptype, name string) (csi.CSIPlugin, error) {
 func (c *CSI) requestContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), CSIPluginRequestTimeout)
 }
+
+// requestContextWithDeadline is like requestContext, but bounds the returned
+// context by deadline instead of CSIPluginRequestTimeout when deadline is
+// set, so that an absolute wall-clock deadline computed upstream (e.g. by
+// the scheduler) is honored even if it's tighter than the plugin's usual
+// request timeout.
+func (c *CSI) requestContextWithDeadline(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return c.requestContext()
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// controllerRequestContext returns a context bounded by timeout, falling
+// back to DefaultCSIControllerRequestTimeout when timeout is zero, along
+// with the duration that was actually applied so callers can report it in
+// a timeout error. CSIController handlers use this instead of
+// requestContext so that a per-request Timeout field can override the
+// default bound a hung plugin is allowed to block the calling RPC for.
+func (c *CSI) controllerRequestContext(timeout time.Duration) (context.Context, context.CancelFunc, time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultCSIControllerRequestTimeout
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
+	return ctx, cancelFn, timeout
+}
+
+// csiControllerTimeoutError returns a clear, actionable error for a
+// CSIController RPC that exceeded its deadline, in place of the plugin's
+// own (often opaque) context-canceled error.
+func csiControllerTimeoutError(pluginID string, timeout time.Duration) error {
+	return fmt.Errorf("controller plugin %s timed out after %s", pluginID, timeout)
+}
+
+// isRetriableCSIError reports whether err is a gRPC status code worth
+// retrying against a CSI controller plugin: one that often clears up on its
+// own as the backend recovers, rather than one caused by a malformed or
+// permanently invalid request.
+func isRetriableCSIError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// withCSIRetry calls fn, retrying up to retries additional times with
+// doubling backoff starting at baseDelay whenever fn returns an error
+// classified as retriable by isRetriableCSIError. It returns the number of
+// attempts made, always at least 1, and the error from the final attempt.
+// It stops without retrying further once ctx is done.
+func withCSIRetry(ctx context.Context, retries int, baseDelay time.Duration, fn func() error) (int, error) {
+	delay := baseDelay
+	for attempts := 1; ; attempts++ {
+		err := fn()
+		if err == nil || attempts > retries || !isRetriableCSIError(err) {
+			return attempts, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// withCSITraceID attaches traceID to ctx as outgoing gRPC metadata, so that a
+// CSI plugin that logs incoming request metadata can tie its own logs back
+// to the Nomad operation that triggered the call. If traceID is empty the
+// context is returned unmodified.
+func withCSITraceID(ctx context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, csiTraceIDMetadataKey, traceID)
+}
@@ -2,7 +2,9 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/nomad/client/dynamicplugins"
 	"github.com/hashicorp/nomad/client/structs"
@@ -10,6 +12,7 @@ import (
 	"github.com/hashicorp/nomad/plugins/csi"
 	"github.com/hashicorp/nomad/plugins/csi/fake"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
 )
 
 var fakePlugin = &dynamicplugins.PluginInfo{
@@ -118,7 +121,7 @@ func TestCSIController_AttachVolume(t *testing.T) {
 				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
 				AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
 			},
-			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{Attempts: 1},
 		},
 		{
 			Name: "handles non-nil PublishContext",
@@ -138,6 +141,1150 @@ func TestCSIController_AttachVolume(t *testing.T) {
 			},
 			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
 				PublishContext: map[string]string{"foo": "bar"},
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "extracts DevicePath for block-device attachment mode",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeBlockDevice,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				DevicePath:     "/dev/xvdf",
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "does not extract DevicePath for filesystem attachment mode",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "parses a multipath publish context into DevicePaths",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{
+						csi.PublishContextDevicePathKey:  "/dev/mapper/mpatha",
+						csi.PublishContextDevicePathsKey: "/dev/sda, /dev/sdb",
+					},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeBlockDevice,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{
+					csi.PublishContextDevicePathKey:  "/dev/mapper/mpatha",
+					csi.PublishContextDevicePathsKey: "/dev/sda, /dev/sdb",
+				},
+				DevicePath:  "/dev/mapper/mpatha",
+				DevicePaths: []string{"/dev/sda", "/dev/sdb"},
+				Attempts:    1,
+			},
+		},
+		{
+			Name: "does not extract DevicePaths for filesystem attachment mode",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{csi.PublishContextDevicePathsKey: "/dev/sda,/dev/sdb"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{csi.PublishContextDevicePathsKey: "/dev/sda,/dev/sdb"},
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "forwards a valid RequestedDevicePath to the plugin",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:            "1234-4321-1234-4321",
+				ClientCSINodeID:     "abcde",
+				AccessMode:          nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:      nstructs.CSIVolumeAttachmentModeBlockDevice,
+				RequestedDevicePath: "/dev/xvdf",
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+				DevicePath:     "/dev/xvdf",
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "rejects a non-absolute RequestedDevicePath",
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:            "1234-4321-1234-4321",
+				ClientCSINodeID:     "abcde",
+				AccessMode:          nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:      nstructs.CSIVolumeAttachmentModeBlockDevice,
+				RequestedDevicePath: "dev/xvdf",
+			},
+			ExpectedErr: errors.New("RequestedDevicePath must be an absolute path"),
+		},
+		{
+			Name: "succeeds when the plugin ignores RequestedDevicePath",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdg"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:            "1234-4321-1234-4321",
+				ClientCSINodeID:     "abcde",
+				AccessMode:          nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:      nstructs.CSIVolumeAttachmentModeBlockDevice,
+				RequestedDevicePath: "/dev/xvdf",
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdg"},
+				DevicePath:     "/dev/xvdg",
+				Attempts:       1,
+			},
+		},
+		{
+			Name: "fails immediately when the deadline has already passed",
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+				Deadline:        time.Now().Add(-time.Minute),
+			},
+			ExpectedErr: errors.New("attach deadline exceeded"),
+		},
+		{
+			Name: "succeeds with a deadline in the future",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerPublishVolumeResponse = &csi.ControllerPublishVolumeResponse{
+					PublishContext: map[string]string{"foo": "bar"},
+				}
+			},
+			Request: &structs.ClientCSIControllerAttachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+				AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+				Deadline:        time.Now().Add(time.Hour),
+			},
+			ExpectedResponse: &structs.ClientCSIControllerAttachVolumeResponse{
+				PublishContext: map[string]string{"foo": "bar"},
+				Attempts:       1,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerAttachVolumeResponse
+			err = client.ClientRPC("CSI.ControllerAttachVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+// TestCSIController_AttachVolume_TraceID asserts that a TraceID set on the
+// request is forwarded to the plugin as gRPC metadata, so that the plugin's
+// own logs for the operation can be correlated with Nomad's.
+func TestCSIController_AttachVolume_TraceID(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerPublishVolumeResponse: &csi.ControllerPublishVolumeResponse{},
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+		TraceID:         "test-trace-id",
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.NoError(err)
+
+	md, ok := metadata.FromOutgoingContext(fakeClient.LastControllerPublishVolumeCtx)
+	require.True(ok, "expected gRPC metadata on the outgoing context")
+	require.Equal([]string{"test-trace-id"}, md.Get(csiTraceIDMetadataKey))
+}
+
+// TestCSIController_AttachVolume_RequestedDevicePath asserts that a
+// RequestedDevicePath set on the request is forwarded to the plugin via
+// VolumeContext, since the CSI spec has no dedicated field for it.
+func TestCSIController_AttachVolume_RequestedDevicePath(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerPublishVolumeResponse: &csi.ControllerPublishVolumeResponse{
+			PublishContext: map[string]string{csi.PublishContextDevicePathKey: "/dev/xvdf"},
+		},
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:            "1234-4321-1234-4321",
+		ClientCSINodeID:     "abcde",
+		AccessMode:          nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:      nstructs.CSIVolumeAttachmentModeBlockDevice,
+		RequestedDevicePath: "/dev/xvdf",
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.NoError(err)
+	require.Equal("/dev/xvdf",
+		fakeClient.LastControllerPublishVolumeRequest.VolumeContext[csi.RequestedDevicePathContextKey])
+}
+
+// TestCSIController_AttachVolume_Timeout asserts that a hung controller
+// plugin is bounded by the request's Timeout field rather than blocking the
+// RPC indefinitely, and that the resulting error names the plugin and the
+// timeout that fired.
+func TestCSIController_AttachVolume_Timeout(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		BlockControllerPublishVolume: true,
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+			Timeout:  50 * time.Millisecond,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.EqualError(err, "controller plugin test-plugin timed out after 50ms")
+}
+
+// TestCSIController_AttachVolume_Retry asserts that ControllerAttachVolume
+// retries a plugin that returns a retriable error (codes.Unavailable) up to
+// the request's Retries count, eventually succeeding and recording the
+// number of attempts made.
+func TestCSIController_AttachVolume_Retry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		ControllerPublishVolumeFailures: 2,
+		NextControllerPublishVolumeResponse: &csi.ControllerPublishVolumeResponse{
+			PublishContext: map[string]string{"bar": "baz"},
+		},
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+		Retries:         3,
+		RetryBaseDelay:  time.Millisecond,
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.NoError(err)
+	require.Equal(3, resp.Attempts)
+	require.Equal(map[string]string{"bar": "baz"}, resp.PublishContext)
+}
+
+// TestCSIController_AttachVolume_Secrets asserts that the Secrets on an
+// attach request reach the plugin's ControllerPublishVolume call unmodified.
+func TestCSIController_AttachVolume_Secrets(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerPublishVolumeResponse: &csi.ControllerPublishVolumeResponse{},
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	secrets := nstructs.CSISecrets{"username": "admin", "password": "hunter2"}
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+		Secrets:         secrets,
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.NoError(err)
+	require.Equal(secrets, fakeClient.LastControllerPublishVolumeRequest.Secrets)
+}
+
+// TestCSIController_AttachVolume_AlreadyExists asserts that ControllerAttachVolume
+// propagates a plugin's codes.AlreadyExists response as an error rather than
+// treating it as a successful retry: per the CSI spec this code only fires
+// when the volume is already published to the node with incompatible
+// capabilities or a conflicting read_only setting, which is a genuine
+// conflict the caller can't resolve by retrying.
+func TestCSIController_AttachVolume_AlreadyExists(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerPublishVolumeErr: fmt.Errorf("%w: volume %q is already published at node %q with incompatible capabilities or a conflicting read_only setting: already exists",
+			csi.ErrVolumeAlreadyPublished, "1234-4321-1234-4321", "abcde"),
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.EqualError(err,
+		fmt.Sprintf("%s: volume \"1234-4321-1234-4321\" is already published at node \"abcde\" with incompatible capabilities or a conflicting read_only setting: already exists",
+			csi.ErrVolumeAlreadyPublished))
+}
+
+// TestCSIController_AttachVolume_Retry_NonRetriable asserts that a
+// non-retriable transitive error fails immediately without retrying.
+func TestCSIController_AttachVolume_Retry_NonRetriable(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerPublishVolumeErr: errors.New("hello"),
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		AccessMode:      nstructs.CSIVolumeAccessModeSingleNodeWriter,
+		AttachmentMode:  nstructs.CSIVolumeAttachmentModeFilesystem,
+		Retries:         3,
+		RetryBaseDelay:  time.Millisecond,
+	}
+
+	var resp structs.ClientCSIControllerAttachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerAttachVolume", req, &resp)
+	require.EqualError(err, "hello")
+	require.Equal(int64(1), fakeClient.ControllerPublishVolumeCallCount)
+}
+
+func TestCSIController_ValidateVolume(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerValidateVolumeRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerValidateVolumeResponse
+	}{
+		{
+			Name: "validates volumeid is not empty",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedErr: errors.New("VolumeID is required"),
+		},
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+				VolumeID: "foo",
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "validates attachmentmode",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:       "1234-4321-1234-4321",
+				AttachmentMode: nstructs.CSIVolumeAttachmentMode("bar"),
+				AccessMode:     nstructs.CSIVolumeAccessModeMultiNodeReader,
+			},
+			ExpectedErr: errors.New("Unknown volume attachment mode: bar"),
+		},
+		{
+			Name: "validates AccessMode",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:       "1234-4321-1234-4321",
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
+				AccessMode:     nstructs.CSIVolumeAccessMode("foo"),
+			},
+			ExpectedErr: errors.New("Unknown volume access mode: foo"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerValidateVolumeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:       "1234-4321-1234-4321",
+				AccessMode:     nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "rejects multi-node-multi-writer with filesystem attachment",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:       "1234-4321-1234-4321",
+				AccessMode:     nstructs.CSIVolumeAccessModeMultiNodeMultiWriter,
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedErr: errors.New("volume capability is invalid: multi-node-multi-writer access mode is not supported for file-system attachment mode, as most filesystems do not support concurrent writers across nodes; use block-device instead and coordinate writes in the application"),
+		},
+		{
+			Name: "allows multi-node-multi-writer with block-device attachment",
+			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:       "1234-4321-1234-4321",
+				AccessMode:     nstructs.CSIVolumeAccessModeMultiNodeMultiWriter,
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeBlockDevice,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerValidateVolumeResponse{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerValidateVolumeResponse
+			err = client.ClientRPC("CSI.ControllerValidateVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+func TestCSIController_DetachVolume(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerDetachVolumeRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerDetachVolumeResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "validates volumeid is not empty",
+			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedErr: errors.New("VolumeID is required"),
+		},
+		{
+			Name: "validates nodeid is not empty",
+			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID: "1234-4321-1234-4321",
+			},
+			ExpectedErr: errors.New("ClientCSINodeID is required"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerUnpublishVolumeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:        "1234-4321-1234-4321",
+				ClientCSINodeID: "abcde",
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerDetachVolumeResponse
+			err = client.ClientRPC("CSI.ControllerDetachVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+// TestCSIController_DetachVolume_Retry asserts that ControllerDetachVolume
+// retries a plugin that returns a retriable error (codes.Unavailable) up to
+// the request's Retries count, eventually succeeding and recording the
+// number of attempts made.
+func TestCSIController_DetachVolume_Retry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		ControllerUnpublishVolumeFailures: 2,
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerDetachVolumeRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+		VolumeID:        "1234-4321-1234-4321",
+		ClientCSINodeID: "abcde",
+		Retries:         3,
+		RetryBaseDelay:  time.Millisecond,
+	}
+
+	var resp structs.ClientCSIControllerDetachVolumeResponse
+	err := client.ClientRPC("CSI.ControllerDetachVolume", req, &resp)
+	require.NoError(err)
+	require.Equal(3, resp.Attempts)
+}
+
+func TestCSIController_CreateVolume(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerCreateVolumeRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerCreateVolumeResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "validates name is not empty",
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedErr: errors.New("Name is required"),
+		},
+		{
+			Name: "validates VolumeCapability is not empty",
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				Name: "test-volume",
+			},
+			ExpectedErr: errors.New("VolumeCapability is required"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerCreateVolumeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				Name:           "test-volume",
+				AccessMode:     nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "handles nil response",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerCreateVolumeResponse = nil
+			},
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				Name:           "test-volume",
+				AccessMode:     nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerCreateVolumeResponse{},
+		},
+		{
+			Name: "handles populated response",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerCreateVolumeResponse = &csi.ControllerCreateVolumeResponse{
+					ExternalVolumeID: "vol-1",
+					CapacityBytes:    1000,
+					VolumeContext:    map[string]string{"foo": "bar"},
+				}
+			},
+			Request: &structs.ClientCSIControllerCreateVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				Name:             "test-volume",
+				CapacityMinBytes: 500,
+				AccessMode:       nstructs.CSIVolumeAccessModeSingleNodeWriter,
+				AttachmentMode:   nstructs.CSIVolumeAttachmentModeFilesystem,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerCreateVolumeResponse{
+				ExternalVolumeID: "vol-1",
+				CapacityBytes:    1000,
+				VolumeContext:    map[string]string{"foo": "bar"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerCreateVolumeResponse
+			err = client.ClientRPC("CSI.ControllerCreateVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+func TestCSIController_DeleteVolume(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerDeleteVolumeRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerDeleteVolumeResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerDeleteVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "validates VolumeID is not empty",
+			Request: &structs.ClientCSIControllerDeleteVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedErr: errors.New("VolumeID is required"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerDeleteVolumeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerDeleteVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID: "test-volume",
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "handles successful delete",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerDeleteVolumeResponse = &csi.ControllerDeleteVolumeResponse{}
+			},
+			Request: &structs.ClientCSIControllerDeleteVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID: "test-volume",
+			},
+			ExpectedResponse: &structs.ClientCSIControllerDeleteVolumeResponse{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerDeleteVolumeResponse
+			err = client.ClientRPC("CSI.ControllerDeleteVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+func TestCSIController_ExpandVolume(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerExpandVolumeRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerExpandVolumeResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "validates VolumeID is not empty",
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				CapacityMinBytes: 100,
+			},
+			ExpectedErr: errors.New("VolumeID is required"),
+		},
+		{
+			Name: "validates CapacityMinBytes is greater than 0",
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID: "test-volume",
+			},
+			ExpectedErr: errors.New("CapacityMinBytes must be greater than 0"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerExpandVolumeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:         "test-volume",
+				CapacityMinBytes: 100,
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "handles nil response",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerExpandVolumeResponse = nil
+			},
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:         "test-volume",
+				CapacityMinBytes: 100,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerExpandVolumeResponse{},
+		},
+		{
+			Name: "handles populated response",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerExpandVolumeResponse = &csi.ControllerExpandVolumeResponse{
+					CapacityBytes:         150,
+					NodeExpansionRequired: true,
+				}
+			},
+			Request: &structs.ClientCSIControllerExpandVolumeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				VolumeID:         "test-volume",
+				CapacityMinBytes: 100,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerExpandVolumeResponse{
+				CapacityBytes:         150,
+				NodeExpansionRequired: true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerExpandVolumeResponse
+			err = client.ClientRPC("CSI.ControllerExpandVolume", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+func TestCSIController_ListVolumes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerListVolumesRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerListVolumesResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerListVolumesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerListVolumesErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerListVolumesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "returns a single page untouched when MaxEntries is set",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.ControllerListVolumesResponses = []*csi.ControllerListVolumesResponse{
+					{
+						Entries: []*csi.ControllerListVolumesResponseEntry{
+							{ExternalVolumeID: "vol-1", CapacityBytes: 100, PublishedNodeIDs: []string{"node-1"}},
+						},
+						NextToken: "page-2",
+					},
+				}
+			},
+			Request: &structs.ClientCSIControllerListVolumesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				MaxEntries: 1,
+			},
+			ExpectedResponse: &structs.ClientCSIControllerListVolumesResponse{
+				Entries: []*csi.ControllerListVolumesResponseEntry{
+					{ExternalVolumeID: "vol-1", CapacityBytes: 100, PublishedNodeIDs: []string{"node-1"}},
+				},
+				NextToken: "page-2",
+			},
+		},
+		{
+			Name: "concatenates every page when MaxEntries is zero",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.ControllerListVolumesResponses = []*csi.ControllerListVolumesResponse{
+					{
+						Entries: []*csi.ControllerListVolumesResponseEntry{
+							{ExternalVolumeID: "vol-1"},
+						},
+						NextToken: "page-2",
+					},
+					{
+						Entries: []*csi.ControllerListVolumesResponseEntry{
+							{ExternalVolumeID: "vol-2"},
+						},
+						NextToken: "",
+					},
+				}
+			},
+			Request: &structs.ClientCSIControllerListVolumesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedResponse: &structs.ClientCSIControllerListVolumesResponse{
+				Entries: []*csi.ControllerListVolumesResponseEntry{
+					{ExternalVolumeID: "vol-1"},
+					{ExternalVolumeID: "vol-2"},
+				},
+				NextToken: "",
 			},
 		},
 	}
@@ -161,8 +1308,8 @@ func TestCSIController_AttachVolume(t *testing.T) {
 			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
 			require.Nil(err)
 
-			var resp structs.ClientCSIControllerAttachVolumeResponse
-			err = client.ClientRPC("CSI.ControllerAttachVolume", tc.Request, &resp)
+			var resp structs.ClientCSIControllerListVolumesResponse
+			err = client.ClientRPC("CSI.ControllerListVolumes", tc.Request, &resp)
 			require.Equal(tc.ExpectedErr, err)
 			if tc.ExpectedResponse != nil {
 				require.Equal(tc.ExpectedResponse, &resp)
@@ -171,74 +1318,246 @@ func TestCSIController_AttachVolume(t *testing.T) {
 	}
 }
 
-func TestCSIController_ValidateVolume(t *testing.T) {
+func TestCSIController_GetCapacity(t *testing.T) {
 	t.Parallel()
 
 	cases := []struct {
 		Name             string
 		ClientSetupFunc  func(*fake.Client)
-		Request          *structs.ClientCSIControllerValidateVolumeRequest
+		Request          *structs.ClientCSIControllerGetCapacityRequest
 		ExpectedErr      error
-		ExpectedResponse *structs.ClientCSIControllerValidateVolumeResponse
+		ExpectedResponse *structs.ClientCSIControllerGetCapacityResponse
 	}{
 		{
-			Name: "validates volumeid is not empty",
-			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerGetCapacityRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
+		},
+		{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapacityErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerGetCapacityRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
 			},
-			ExpectedErr: errors.New("VolumeID is required"),
+			ExpectedErr: errors.New("hello"),
+		},
+		{
+			Name: "forwards topology and parameters and returns the available capacity",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapacityResponse = &csi.ControllerGetCapacityResponse{
+					AvailableCapacity: 1000,
+				}
+			},
+			Request: &structs.ClientCSIControllerGetCapacityRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				Parameters: map[string]string{"type": "pd-ssd"},
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{"zone": "us-east-1a"},
+				},
+			},
+			ExpectedResponse: &structs.ClientCSIControllerGetCapacityResponse{
+				AvailableCapacity: 1000,
+			},
 		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerGetCapacityResponse
+			err = client.ClientRPC("CSI.ControllerGetCapacity", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedResponse != nil {
+				require.Equal(tc.ExpectedResponse, &resp)
+			}
+		})
+	}
+}
+
+func TestCSIController_Probe(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		ClientSetupFunc func(*fake.Client)
+		Request         *structs.ClientCSIControllerProbeRequest
+		ExpectedErr     error
+		ExpectedReady   bool
+	}{
 		{
 			Name: "returns plugin not found errors",
-			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+			Request: &structs.ClientCSIControllerProbeRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: "some-garbage",
 				},
-				VolumeID: "foo",
 			},
 			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
 		},
 		{
-			Name: "validates attachmentmode",
-			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+			Name: "returns transitive errors",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextPluginProbeErr = errors.New("hello")
+			},
+			Request: &structs.ClientCSIControllerProbeRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
-				VolumeID:       "1234-4321-1234-4321",
-				AttachmentMode: nstructs.CSIVolumeAttachmentMode("bar"),
-				AccessMode:     nstructs.CSIVolumeAccessModeMultiNodeReader,
 			},
-			ExpectedErr: errors.New("Unknown volume attachment mode: bar"),
+			ExpectedErr: errors.New("hello"),
 		},
 		{
-			Name: "validates AccessMode",
-			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+			Name: "reports ready true",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextPluginProbeResponse = true
+			},
+			Request: &structs.ClientCSIControllerProbeRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
-				VolumeID:       "1234-4321-1234-4321",
-				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
-				AccessMode:     nstructs.CSIVolumeAccessMode("foo"),
 			},
-			ExpectedErr: errors.New("Unknown volume access mode: foo"),
+			ExpectedReady: true,
+		},
+		{
+			Name: "reports ready false",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextPluginProbeResponse = false
+			},
+			Request: &structs.ClientCSIControllerProbeRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedReady: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require := require.New(t)
+			client, cleanup := TestClient(t, nil)
+			defer cleanup()
+
+			fakeClient := &fake.Client{}
+			if tc.ClientSetupFunc != nil {
+				tc.ClientSetupFunc(fakeClient)
+			}
+
+			dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+				return fakeClient, nil
+			}
+			client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+
+			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
+			require.Nil(err)
+
+			var resp structs.ClientCSIControllerProbeResponse
+			err = client.ClientRPC("CSI.ControllerProbe", tc.Request, &resp)
+			require.Equal(tc.ExpectedErr, err)
+			if tc.ExpectedErr == nil {
+				require.Equal(tc.ExpectedReady, resp.Ready)
+				require.False(resp.Time.IsZero())
+			}
+		})
+	}
+}
+
+func TestCSIController_GetCapabilities(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name             string
+		ClientSetupFunc  func(*fake.Client)
+		Request          *structs.ClientCSIControllerGetCapabilitiesRequest
+		ExpectedErr      error
+		ExpectedResponse *structs.ClientCSIControllerGetCapabilitiesResponse
+	}{
+		{
+			Name: "returns plugin not found errors",
+			Request: &structs.ClientCSIControllerGetCapabilitiesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: "some-garbage",
+				},
+			},
+			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
 		},
 		{
 			Name: "returns transitive errors",
 			ClientSetupFunc: func(fc *fake.Client) {
-				fc.NextControllerValidateVolumeErr = errors.New("hello")
+				fc.NextControllerGetCapabilitiesErr = errors.New("hello")
 			},
-			Request: &structs.ClientCSIControllerValidateVolumeRequest{
+			Request: &structs.ClientCSIControllerGetCapabilitiesRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
-				VolumeID:       "1234-4321-1234-4321",
-				AccessMode:     nstructs.CSIVolumeAccessModeSingleNodeWriter,
-				AttachmentMode: nstructs.CSIVolumeAttachmentModeFilesystem,
 			},
 			ExpectedErr: errors.New("hello"),
 		},
+		{
+			Name: "parses the full capability set",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{
+					HasCreateDeleteVolume:     true,
+					HasPublishUnpublishVolume: true,
+					HasCreateDeleteSnapshot:   true,
+					HasExpandVolume:           true,
+					HasListVolumes:            true,
+				}
+			},
+			Request: &structs.ClientCSIControllerGetCapabilitiesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedResponse: &structs.ClientCSIControllerGetCapabilitiesResponse{
+				SupportsCreateDelete: true,
+				SupportsPublish:      true,
+				SupportsSnapshot:     true,
+				SupportsExpand:       true,
+				SupportsListVolumes:  true,
+			},
+		},
+		{
+			Name: "parses a partial capability set",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{
+					HasPublishUnpublishVolume: true,
+				}
+			},
+			Request: &structs.ClientCSIControllerGetCapabilitiesRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+			},
+			ExpectedResponse: &structs.ClientCSIControllerGetCapabilitiesResponse{
+				SupportsPublish: true,
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -260,8 +1579,8 @@ func TestCSIController_ValidateVolume(t *testing.T) {
 			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
 			require.Nil(err)
 
-			var resp structs.ClientCSIControllerValidateVolumeResponse
-			err = client.ClientRPC("CSI.ControllerValidateVolume", tc.Request, &resp)
+			var resp structs.ClientCSIControllerGetCapabilitiesResponse
+			err = client.ClientRPC("CSI.GetCapabilities", tc.Request, &resp)
 			require.Equal(tc.ExpectedErr, err)
 			if tc.ExpectedResponse != nil {
 				require.Equal(tc.ExpectedResponse, &resp)
@@ -270,57 +1589,145 @@ func TestCSIController_ValidateVolume(t *testing.T) {
 	}
 }
 
-func TestCSIController_DetachVolume(t *testing.T) {
+// TestCSIController_GetCapabilities_Cached asserts that a second
+// GetCapabilities call for the same plugin is served from cache rather than
+// calling the plugin again.
+func TestCSIController_GetCapabilities_Cached(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	fakeClient := &fake.Client{
+		NextControllerGetCapabilitiesResponse: &csi.ControllerCapabilitySet{
+			HasCreateDeleteVolume: true,
+		},
+	}
+	dispenserFunc := func(*dynamicplugins.PluginInfo) (interface{}, error) {
+		return fakeClient, nil
+	}
+	client.dynamicRegistry.StubDispenserForType(dynamicplugins.PluginTypeCSIController, dispenserFunc)
+	require.NoError(client.dynamicRegistry.RegisterPlugin(fakePlugin))
+
+	req := &structs.ClientCSIControllerGetCapabilitiesRequest{
+		CSIControllerQuery: structs.CSIControllerQuery{
+			PluginID: fakePlugin.Name,
+		},
+	}
+
+	var resp structs.ClientCSIControllerGetCapabilitiesResponse
+	require.NoError(client.ClientRPC("CSI.GetCapabilities", req, &resp))
+	require.True(resp.SupportsCreateDelete)
+	require.EqualValues(1, fakeClient.ControllerGetCapabilitiesCallCount)
+
+	// A plugin-side change shouldn't be observed until the cache expires.
+	fakeClient.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{}
+
+	var resp2 structs.ClientCSIControllerGetCapabilitiesResponse
+	require.NoError(client.ClientRPC("CSI.GetCapabilities", req, &resp2))
+	require.True(resp2.SupportsCreateDelete)
+	require.EqualValues(1, fakeClient.ControllerGetCapabilitiesCallCount)
+}
+
+func TestCSIController_CreateVolumeGroupSnapshot(t *testing.T) {
 	t.Parallel()
 
+	capableFakeClient := func() *fake.Client {
+		return &fake.Client{
+			NextControllerGetCapabilitiesResponse: &csi.ControllerCapabilitySet{
+				HasCreateDeleteSnapshot: true,
+			},
+		}
+	}
+
 	cases := []struct {
 		Name             string
 		ClientSetupFunc  func(*fake.Client)
-		Request          *structs.ClientCSIControllerDetachVolumeRequest
+		Request          *structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest
 		ExpectedErr      error
-		ExpectedResponse *structs.ClientCSIControllerDetachVolumeResponse
+		ExpectedResponse *structs.ClientCSIControllerCreateVolumeGroupSnapshotResponse
 	}{
 		{
 			Name: "returns plugin not found errors",
-			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: "some-garbage",
 				},
+				GroupSnapshotID: "group1",
+				VolumeIDs:       []string{"vol1"},
 			},
 			ExpectedErr: errors.New("CSI client error (retryable): plugin some-garbage for type csi-controller not found"),
 		},
 		{
-			Name: "validates volumeid is not empty",
-			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+			Name: "validates GroupSnapshotID is not empty",
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
+				VolumeIDs: []string{"vol1"},
 			},
-			ExpectedErr: errors.New("VolumeID is required"),
+			ExpectedErr: errors.New("missing GroupSnapshotID"),
 		},
 		{
-			Name: "validates nodeid is not empty",
-			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+			Name: "validates VolumeIDs is not empty",
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
-				VolumeID: "1234-4321-1234-4321",
+				GroupSnapshotID: "group1",
 			},
-			ExpectedErr: errors.New("ClientCSINodeID is required"),
+			ExpectedErr: errors.New("missing VolumeIDs"),
+		},
+		{
+			Name: "returns unsupported error when plugin lacks the capability",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{}
+			},
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				GroupSnapshotID: "group1",
+				VolumeIDs:       []string{"vol1"},
+			},
+			ExpectedErr: errors.New(`CSI client error (unsupported): plugin "test-plugin" does not support creating snapshots`),
 		},
 		{
 			Name: "returns transitive errors",
 			ClientSetupFunc: func(fc *fake.Client) {
-				fc.NextControllerUnpublishVolumeErr = errors.New("hello")
+				fc.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{HasCreateDeleteSnapshot: true}
+				fc.NextControllerCreateSnapshotErr = errors.New("hello")
 			},
-			Request: &structs.ClientCSIControllerDetachVolumeRequest{
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
 				CSIControllerQuery: structs.CSIControllerQuery{
 					PluginID: fakePlugin.Name,
 				},
-				VolumeID:        "1234-4321-1234-4321",
-				ClientCSINodeID: "abcde",
+				GroupSnapshotID: "group1",
+				VolumeIDs:       []string{"vol1"},
+			},
+			ExpectedErr: errors.New(`failed to snapshot volume "vol1" in group "group1": hello`),
+		},
+		{
+			Name: "creates a snapshot per member volume",
+			ClientSetupFunc: func(fc *fake.Client) {
+				fc.NextControllerGetCapabilitiesResponse = &csi.ControllerCapabilitySet{HasCreateDeleteSnapshot: true}
+				fc.NextControllerCreateSnapshotResponse = &csi.ControllerCreateSnapshotResponse{ID: "snap-for-vol"}
+			},
+			Request: &structs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
+				CSIControllerQuery: structs.CSIControllerQuery{
+					PluginID: fakePlugin.Name,
+				},
+				GroupSnapshotID: "group1",
+				VolumeIDs:       []string{"vol1", "vol2"},
+			},
+			ExpectedResponse: &structs.ClientCSIControllerCreateVolumeGroupSnapshotResponse{
+				GroupSnapshotID: "group1",
+				SnapshotIDs: map[string]string{
+					"vol1": "snap-for-vol",
+					"vol2": "snap-for-vol",
+				},
 			},
-			ExpectedErr: errors.New("hello"),
 		},
 	}
 
@@ -330,7 +1737,7 @@ func TestCSIController_DetachVolume(t *testing.T) {
 			client, cleanup := TestClient(t, nil)
 			defer cleanup()
 
-			fakeClient := &fake.Client{}
+			fakeClient := capableFakeClient()
 			if tc.ClientSetupFunc != nil {
 				tc.ClientSetupFunc(fakeClient)
 			}
@@ -343,8 +1750,8 @@ func TestCSIController_DetachVolume(t *testing.T) {
 			err := client.dynamicRegistry.RegisterPlugin(fakePlugin)
 			require.Nil(err)
 
-			var resp structs.ClientCSIControllerDetachVolumeResponse
-			err = client.ClientRPC("CSI.ControllerDetachVolume", tc.Request, &resp)
+			var resp structs.ClientCSIControllerCreateVolumeGroupSnapshotResponse
+			err = client.ClientRPC("CSI.CreateVolumeGroupSnapshot", tc.Request, &resp)
 			require.Equal(tc.ExpectedErr, err)
 			if tc.ExpectedResponse != nil {
 				require.Equal(tc.ExpectedResponse, &resp)
@@ -433,3 +1840,32 @@ func TestCSINode_DetachVolume(t *testing.T) {
 		})
 	}
 }
+
+// TestCSI_ListInFlight asserts that ListInFlight reports whatever the
+// client's in-flight registry currently holds.
+func TestCSI_ListInFlight(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, cleanup := TestClient(t, nil)
+	defer cleanup()
+
+	var resp structs.ClientCSIListInFlightResponse
+	err := client.ClientRPC("CSI.ListInFlight", &structs.ClientCSIListInFlightRequest{}, &resp)
+	require.NoError(err)
+	require.Empty(resp.InFlight)
+
+	done := client.endpoints.CSI.inFlight.Start("NodeDetachVolume", "vol-1", "test-plugin", "node-1")
+
+	err = client.ClientRPC("CSI.ListInFlight", &structs.ClientCSIListInFlightRequest{}, &resp)
+	require.NoError(err)
+	require.Len(resp.InFlight, 1)
+	require.Equal("NodeDetachVolume", resp.InFlight[0].Op)
+	require.Equal("vol-1", resp.InFlight[0].VolumeID)
+
+	done()
+
+	err = client.ClientRPC("CSI.ListInFlight", &structs.ClientCSIListInFlightRequest{}, &resp)
+	require.NoError(err)
+	require.Empty(resp.InFlight)
+}
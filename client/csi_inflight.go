@@ -0,0 +1,59 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/client/structs"
+	"github.com/hashicorp/nomad/helper/uuid"
+)
+
+// csiInFlightRegistry tracks CSI operations that are currently running
+// against plugins on this client, so that operators can query what's
+// in-flight during an incident. Entries are removed as soon as the
+// operation they describe completes.
+type csiInFlightRegistry struct {
+	lock sync.Mutex
+	ops  map[string]structs.CSIInFlightOperation
+}
+
+func newCSIInFlightRegistry() *csiInFlightRegistry {
+	return &csiInFlightRegistry{
+		ops: make(map[string]structs.CSIInFlightOperation),
+	}
+}
+
+// Start records the start of a CSI operation and returns a function the
+// caller must invoke when the operation completes to remove it from the
+// registry.
+func (r *csiInFlightRegistry) Start(op, volID, pluginID, nodeID string) func() {
+	id := uuid.Generate()
+
+	r.lock.Lock()
+	r.ops[id] = structs.CSIInFlightOperation{
+		Op:        op,
+		VolumeID:  volID,
+		PluginID:  pluginID,
+		NodeID:    nodeID,
+		StartTime: time.Now(),
+	}
+	r.lock.Unlock()
+
+	return func() {
+		r.lock.Lock()
+		delete(r.ops, id)
+		r.lock.Unlock()
+	}
+}
+
+// List returns every CSI operation currently in-flight on this client.
+func (r *csiInFlightRegistry) List() []structs.CSIInFlightOperation {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ops := make([]structs.CSIInFlightOperation, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	return ops
+}
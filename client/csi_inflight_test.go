@@ -0,0 +1,54 @@
+package client
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/nomad/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCSIInFlightRegistry_SlowOperation exercises the bookkeeping a slow CSI
+// operation should leave behind in the registry: present while running,
+// gone as soon as it completes.
+func TestCSIInFlightRegistry_SlowOperation(t *testing.T) {
+	t.Parallel()
+
+	registry := newCSIInFlightRegistry()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		done := registry.Start("NodeDetachVolume", "vol-1", "test-plugin", "node-1")
+		close(started)
+		<-finish
+		done()
+	}()
+
+	<-started
+	testutil.WaitForResult(func() (bool, error) {
+		return len(registry.List()) == 1, nil
+	}, func(err error) {
+		t.Fatalf("expected operation to appear in the in-flight list")
+	})
+
+	ops := registry.List()
+	require.Len(t, ops, 1)
+	require.Equal(t, "NodeDetachVolume", ops[0].Op)
+	require.Equal(t, "vol-1", ops[0].VolumeID)
+	require.Equal(t, "test-plugin", ops[0].PluginID)
+	require.Equal(t, "node-1", ops[0].NodeID)
+	require.False(t, ops[0].StartTime.IsZero())
+
+	close(finish)
+	wg.Wait()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return len(registry.List()) == 0, nil
+	}, func(err error) {
+		t.Fatalf("expected operation to disappear from the in-flight list")
+	})
+}
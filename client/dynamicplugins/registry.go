@@ -8,6 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/nomad/structs"
 )
 
 const (
@@ -22,10 +25,17 @@ type Registry interface {
 	DeregisterPlugin(ptype, name string) error
 
 	ListPlugins(ptype string) []*PluginInfo
-	DispensePlugin(ptype, name string) (interface{}, error)
+	DispensePlugin(ptype, name string, opts ...DispenseOpt) (interface{}, error)
 
 	PluginsUpdatedCh(ctx context.Context, ptype string) <-chan *PluginUpdateEvent
 
+	// Reconcile deregisters any registered plugin whose owning allocation is
+	// not in runningAllocIDs, returning the number of plugins pruned. It's
+	// intended to be called once after restoring the registry from state on
+	// agent restart, to drop plugins left behind by allocations that exited
+	// while the client was down.
+	Reconcile(runningAllocIDs map[string]bool) int
+
 	Shutdown()
 
 	StubDispenserForType(ptype string, dispenser PluginDispenser)
@@ -39,15 +49,59 @@ type RegistryState struct {
 
 type PluginDispenser func(info *PluginInfo) (interface{}, error)
 
+// DispenseOpt is applied to a single DispensePlugin call to adjust its
+// behavior without affecting any other in-flight or future dispenses.
+type DispenseOpt func(*dispenseConfig)
+
+type dispenseConfig struct {
+	dispenser PluginDispenser
+}
+
+// WithDispenser overrides the dispenser used for a single DispensePlugin
+// call, bypassing both the registered dispenser and any dispenser set with
+// StubDispenserForType. It's intended for tests and for routing one-off
+// requests (e.g. a canary plugin build) without changing global behavior
+// for concurrent dispenses of the same plugin type.
+func WithDispenser(dispenser PluginDispenser) DispenseOpt {
+	return func(c *dispenseConfig) {
+		c.dispenser = dispenser
+	}
+}
+
+// RegistryOption is applied to a Registry at construction time to adjust its
+// behavior.
+type RegistryOption func(*dynamicRegistry)
+
+// WithFallbackDispenser sets a dispenser to use for a plugin type that has no
+// type-specific entry in the dispensers map passed to NewRegistry, instead of
+// failing the dispense with "no plugin dispenser found". It never shadows a
+// type-specific dispenser, whether registered, stubbed, or passed as a
+// per-call DispenseOpt. The default is no fallback, matching prior behavior.
+func WithFallbackDispenser(dispenser PluginDispenser) RegistryOption {
+	return func(d *dynamicRegistry) {
+		d.fallbackDispenser = dispenser
+	}
+}
+
 // NewRegistry takes a map of `plugintype` to PluginDispenser functions
-// that should be used to vend clients for plugins to be used.
-func NewRegistry(state StateStorage, dispensers map[string]PluginDispenser) Registry {
+// that should be used to vend clients for plugins to be used. A nil logger
+// discards restore-time validation warnings; callers that care about those
+// (i.e. everything but tests) should pass a real one.
+func NewRegistry(state StateStorage, dispensers map[string]PluginDispenser, logger log.Logger, opts ...RegistryOption) Registry {
+	if logger == nil {
+		logger = log.NewNullLogger()
+	}
 
 	registry := &dynamicRegistry{
 		plugins:      make(map[string]map[string]*PluginInfo),
 		broadcasters: make(map[string]*pluginEventBroadcaster),
 		dispensers:   dispensers,
 		state:        state,
+		logger:       logger,
+	}
+
+	for _, opt := range opts {
+		opt(registry)
 	}
 
 	// populate the state and initial broadcasters if we have an
@@ -55,7 +109,7 @@ func NewRegistry(state StateStorage, dispensers map[string]PluginDispenser) Regi
 	if state != nil {
 		storedState, err := state.GetDynamicPluginRegistryState()
 		if err == nil && storedState != nil {
-			registry.plugins = storedState.Plugins
+			registry.plugins, _, _ = restorePluginState(storedState, logger)
 			for ptype := range registry.plugins {
 				registry.broadcasterForPluginType(ptype)
 			}
@@ -65,6 +119,63 @@ func NewRegistry(state StateStorage, dispensers map[string]PluginDispenser) Regi
 	return registry
 }
 
+// restorePluginState validates each entry in stored, skipping (and logging)
+// any that are missing required fields or reference a plugin type that was
+// never valid, rather than letting a single corrupt entry fail the whole
+// restore. It returns the restored plugins along with counts of how many
+// entries were restored and skipped.
+func restorePluginState(stored *RegistryState, logger log.Logger) (map[string]map[string]*PluginInfo, int, int) {
+	plugins := make(map[string]map[string]*PluginInfo, len(stored.Plugins))
+	restored, skipped := 0, 0
+
+	for ptype, pmap := range stored.Plugins {
+		for name, info := range pmap {
+			if err := validateRestoredPlugin(ptype, name, info); err != nil {
+				logger.Warn("skipping invalid plugin registry entry on restore",
+					"type", ptype, "name", name, "error", err)
+				skipped++
+				continue
+			}
+
+			restoredMap, ok := plugins[ptype]
+			if !ok {
+				restoredMap = make(map[string]*PluginInfo, 1)
+				plugins[ptype] = restoredMap
+			}
+			restoredMap[name] = info
+			restored++
+		}
+	}
+
+	return plugins, restored, skipped
+}
+
+// validateRestoredPlugin checks that a persisted plugin registry entry is
+// well-formed enough to be used, mirroring the checks RegisterPlugin
+// performs on new registrations.
+func validateRestoredPlugin(ptype, name string, info *PluginInfo) error {
+	if info == nil {
+		return errors.New("entry is nil")
+	}
+	if info.Type == "" {
+		return errors.New("Plugin.Type must not be empty")
+	}
+	if info.Type != ptype {
+		return fmt.Errorf("Plugin.Type %q does not match registry key %q", info.Type, ptype)
+	}
+	if info.Name == "" {
+		return errors.New("Plugin.Name must not be empty")
+	}
+	if info.Name != name {
+		return fmt.Errorf("Plugin.Name %q does not match registry key %q", info.Name, name)
+	}
+	if info.ConnectionInfo == nil {
+		return errors.New("Plugin.ConnectionInfo must not be nil")
+	}
+
+	return nil
+}
+
 // StateStorage is used to persist the dynamic plugin registry's state
 // across agent restarts.
 type StateStorage interface {
@@ -88,6 +199,12 @@ type PluginInfo struct {
 	// AllocID tracks the allocation running the plugin
 	AllocID string
 
+	// AccessibleTopology is the topology this plugin instance was
+	// registered with, for CSI controllers that are scoped to a single
+	// zone/rack/etc. rather than reachable cluster-wide. It's nil for
+	// plugins that aren't topology-scoped.
+	AccessibleTopology *structs.CSITopology
+
 	// Options is used for plugin registrations to pass further metadata along to
 	// other subsystems
 	Options map[string]string
@@ -95,7 +212,8 @@ type PluginInfo struct {
 
 // PluginConnectionInfo is the data required to connect to the plugin.
 // note: We currently only support Unix Domain Sockets, but this may be expanded
-//       to support other connection modes in the future.
+//
+//	to support other connection modes in the future.
 type PluginConnectionInfo struct {
 	// SocketPath is the path to the plugins api socket.
 	SocketPath string
@@ -112,6 +230,13 @@ const (
 	// EventTypeDeregistered is emitted by the Registry when a plugin has been
 	// removed.
 	EventTypeDeregistered EventType = "deregistered"
+	// EventTypeUpdated is emitted by the Registry when a plugin instance
+	// that's already registered (same type, name, and AllocID) re-registers,
+	// for example after a plugin task restarts and re-probes its own
+	// capabilities. The registry updates the existing entry's
+	// ConnectionInfo/Options/Version in place rather than treating this as
+	// a deregister followed by a register.
+	EventTypeUpdated EventType = "updated"
 )
 
 // PluginUpdateEvent is a struct that is sent over a PluginsUpdatedCh when
@@ -128,10 +253,12 @@ type dynamicRegistry struct {
 	broadcasters     map[string]*pluginEventBroadcaster
 	broadcastersLock sync.Mutex
 
-	dispensers     map[string]PluginDispenser
-	stubDispensers map[string]PluginDispenser
+	dispensers        map[string]PluginDispenser
+	stubDispensers    map[string]PluginDispenser
+	fallbackDispenser PluginDispenser
 
-	state StateStorage
+	state  StateStorage
+	logger log.Logger
 }
 
 // StubDispenserForType allows test functions to provide alternative plugin
@@ -184,11 +311,25 @@ func (d *dynamicRegistry) RegisterPlugin(info *PluginInfo) error {
 		d.plugins[info.Type] = pmap
 	}
 
+	// A plugin instance (same type, name, and owning allocation) that
+	// re-registers is an update to the existing entry, not a new
+	// registration: this happens when a plugin task restarts and re-probes
+	// itself, sending its connection info and capabilities again. Treating
+	// it as an update rather than a fresh registration preserves
+	// subscriber notification correctness (one event, not a
+	// deregister+register pair) for watchers that care about the
+	// distinction, e.g. to avoid tearing down and recreating in-flight
+	// operations against the plugin.
+	eventType := EventTypeRegistered
+	if existing, ok := pmap[info.Name]; ok && existing.AllocID == info.AllocID {
+		eventType = EventTypeUpdated
+	}
+
 	pmap[info.Name] = info
 
 	broadcaster := d.broadcasterForPluginType(info.Type)
 	event := &PluginUpdateEvent{
-		EventType: EventTypeRegistered,
+		EventType: eventType,
 		Info:      info,
 	}
 	broadcaster.broadcast(event)
@@ -247,6 +388,32 @@ func (d *dynamicRegistry) DeregisterPlugin(ptype, name string) error {
 	return d.sync()
 }
 
+// Reconcile deregisters any plugin whose AllocID is not present in
+// runningAllocIDs, pruning plugins left behind by allocations that no
+// longer exist after a client restart. Plugins with no AllocID are left
+// alone, as they aren't owned by an allocation.
+func (d *dynamicRegistry) Reconcile(runningAllocIDs map[string]bool) int {
+	d.pluginsLock.RLock()
+	var stale []*PluginInfo
+	for _, pmap := range d.plugins {
+		for _, info := range pmap {
+			if info.AllocID != "" && !runningAllocIDs[info.AllocID] {
+				stale = append(stale, info)
+			}
+		}
+	}
+	d.pluginsLock.RUnlock()
+
+	pruned := 0
+	for _, info := range stale {
+		if err := d.DeregisterPlugin(info.Type, info.Name); err == nil {
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
 func (d *dynamicRegistry) ListPlugins(ptype string) []*PluginInfo {
 	d.pluginsLock.RLock()
 	defer d.pluginsLock.RUnlock()
@@ -265,7 +432,7 @@ func (d *dynamicRegistry) ListPlugins(ptype string) []*PluginInfo {
 	return plugins
 }
 
-func (d *dynamicRegistry) DispensePlugin(ptype string, name string) (interface{}, error) {
+func (d *dynamicRegistry) DispensePlugin(ptype string, name string, opts ...DispenseOpt) (interface{}, error) {
 	d.pluginsLock.Lock()
 	defer d.pluginsLock.Unlock()
 
@@ -280,11 +447,19 @@ func (d *dynamicRegistry) DispensePlugin(ptype string, name string) (interface{}
 		return nil, errors.New("must specify plugin name to dispense")
 	}
 
+	var cfg dispenseConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	dispenseFunc, ok := d.dispensers[ptype]
 	if !ok {
-		// This error shouldn't make it to a production cluster and is to aid
-		// developers during the development of new plugin types.
-		return nil, fmt.Errorf("no plugin dispenser found for type: %s", ptype)
+		if d.fallbackDispenser == nil {
+			// This error shouldn't make it to a production cluster and is to aid
+			// developers during the development of new plugin types.
+			return nil, fmt.Errorf("no plugin dispenser found for type: %s", ptype)
+		}
+		dispenseFunc = d.fallbackDispenser
 	}
 
 	// After initially loading the dispenser (to avoid masking missing setup in
@@ -296,6 +471,12 @@ func (d *dynamicRegistry) DispensePlugin(ptype string, name string) (interface{}
 		}
 	}
 
+	// A per-call override takes precedence over both the registered and
+	// stubbed dispensers, and only applies to this call.
+	if cfg.dispenser != nil {
+		dispenseFunc = cfg.dispenser
+	}
+
 	pmap, ok := d.plugins[ptype]
 	if !ok {
 		return nil, fmt.Errorf("no plugins registered for type: %s", ptype)
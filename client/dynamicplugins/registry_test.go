@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/nomad/helper/testlog"
 	"github.com/stretchr/testify/require"
 )
 
@@ -66,7 +67,7 @@ func TestPluginEventBroadcaster_UnsubscribeWorks(t *testing.T) {
 
 func TestDynamicRegistry_RegisterPlugin_SendsUpdateEvents(t *testing.T) {
 	t.Parallel()
-	r := NewRegistry(nil, nil)
+	r := NewRegistry(nil, nil, testlog.HCLogger(t))
 
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
@@ -104,7 +105,7 @@ func TestDynamicRegistry_RegisterPlugin_SendsUpdateEvents(t *testing.T) {
 
 func TestDynamicRegistry_DeregisterPlugin_SendsUpdateEvents(t *testing.T) {
 	t.Parallel()
-	r := NewRegistry(nil, nil)
+	r := NewRegistry(nil, nil, testlog.HCLogger(t))
 
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
@@ -144,12 +145,80 @@ func TestDynamicRegistry_DeregisterPlugin_SendsUpdateEvents(t *testing.T) {
 	}, 1*time.Second, 200*time.Millisecond)
 }
 
+func TestDynamicRegistry_RegisterPlugin_ReregistrationSendsUpdatedEvent(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry(nil, nil, testlog.HCLogger(t))
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	ch := r.PluginsUpdatedCh(ctx, "csi")
+
+	err := r.RegisterPlugin(&PluginInfo{
+		Type:           "csi",
+		Name:           "my-plugin",
+		AllocID:        "alloc-1",
+		Version:        "1.0.0",
+		ConnectionInfo: &PluginConnectionInfo{SocketPath: "/tmp/original.sock"},
+	})
+	require.NoError(t, err)
+
+	// drain the registration event before re-registering
+	select {
+	case e := <-ch:
+		require.Equal(t, EventTypeRegistered, e.EventType)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for registration event")
+	}
+
+	// the same instance (same Type, Name, and AllocID) re-registers, e.g.
+	// after the plugin task restarts and re-probes itself
+	err = r.RegisterPlugin(&PluginInfo{
+		Type:           "csi",
+		Name:           "my-plugin",
+		AllocID:        "alloc-1",
+		Version:        "1.1.0",
+		ConnectionInfo: &PluginConnectionInfo{SocketPath: "/tmp/updated.sock"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case e := <-ch:
+		require.Equal(t, EventTypeUpdated, e.EventType)
+		require.Equal(t, "1.1.0", e.Info.Version)
+		require.Equal(t, "/tmp/updated.sock", e.Info.ConnectionInfo.SocketPath)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for updated event")
+	}
+
+	plugins := r.ListPlugins("csi")
+	require.Len(t, plugins, 1)
+	require.Equal(t, "1.1.0", plugins[0].Version)
+
+	// a different allocation registering under the same Name is a new
+	// instance replacing the old one, not an update
+	err = r.RegisterPlugin(&PluginInfo{
+		Type:           "csi",
+		Name:           "my-plugin",
+		AllocID:        "alloc-2",
+		ConnectionInfo: &PluginConnectionInfo{SocketPath: "/tmp/other.sock"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case e := <-ch:
+		require.Equal(t, EventTypeRegistered, e.EventType)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for registration event")
+	}
+}
+
 func TestDynamicRegistry_DispensePlugin_Works(t *testing.T) {
 	dispenseFn := func(i *PluginInfo) (interface{}, error) {
 		return struct{}{}, nil
 	}
 
-	registry := NewRegistry(nil, map[string]PluginDispenser{"csi": dispenseFn})
+	registry := NewRegistry(nil, map[string]PluginDispenser{"csi": dispenseFn}, testlog.HCLogger(t))
 
 	err := registry.RegisterPlugin(&PluginInfo{
 		Type:           "csi",
@@ -171,9 +240,73 @@ func TestDynamicRegistry_DispensePlugin_Works(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestDynamicRegistry_DispensePlugin_PerCallOverride(t *testing.T) {
+	dispenseFn := func(i *PluginInfo) (interface{}, error) {
+		return "default", nil
+	}
+
+	registry := NewRegistry(nil, map[string]PluginDispenser{"csi": dispenseFn}, testlog.HCLogger(t))
+
+	err := registry.RegisterPlugin(&PluginInfo{
+		Type:           "csi",
+		Name:           "my-plugin",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	// a per-call override takes precedence over the registered dispenser...
+	canaryFn := func(i *PluginInfo) (interface{}, error) {
+		return "canary", nil
+	}
+	result, err := registry.DispensePlugin("csi", "my-plugin", WithDispenser(canaryFn))
+	require.NoError(t, err)
+	require.Equal(t, "canary", result)
+
+	// ...but only for that call, leaving concurrent dispenses unaffected.
+	result, err = registry.DispensePlugin("csi", "my-plugin")
+	require.NoError(t, err)
+	require.Equal(t, "default", result)
+}
+
+func TestDynamicRegistry_DispensePlugin_Fallback(t *testing.T) {
+	csiFn := func(i *PluginInfo) (interface{}, error) {
+		return "csi", nil
+	}
+	fallbackFn := func(i *PluginInfo) (interface{}, error) {
+		return "fallback", nil
+	}
+
+	registry := NewRegistry(nil, map[string]PluginDispenser{"csi": csiFn}, testlog.HCLogger(t),
+		WithFallbackDispenser(fallbackFn))
+
+	err := registry.RegisterPlugin(&PluginInfo{
+		Type:           "csi",
+		Name:           "my-plugin",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	err = registry.RegisterPlugin(&PluginInfo{
+		Type:           "unknown-type",
+		Name:           "my-other-plugin",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	// a type with no registered dispenser uses the fallback...
+	result, err := registry.DispensePlugin("unknown-type", "my-other-plugin")
+	require.NoError(t, err)
+	require.Equal(t, "fallback", result)
+
+	// ...but the fallback never shadows a type-specific dispenser.
+	result, err = registry.DispensePlugin("csi", "my-plugin")
+	require.NoError(t, err)
+	require.Equal(t, "csi", result)
+}
+
 func TestDynamicRegistry_IsolatePluginTypes(t *testing.T) {
 	t.Parallel()
-	r := NewRegistry(nil, nil)
+	r := NewRegistry(nil, nil, testlog.HCLogger(t))
 
 	err := r.RegisterPlugin(&PluginInfo{
 		Type:           PluginTypeCSIController,
@@ -195,6 +328,51 @@ func TestDynamicRegistry_IsolatePluginTypes(t *testing.T) {
 	require.Equal(t, len(r.ListPlugins(PluginTypeCSIController)), 0)
 }
 
+func TestDynamicRegistry_Reconcile_PrunesDeadAllocs(t *testing.T) {
+	t.Parallel()
+
+	memdb := &MemDB{}
+	oldR := NewRegistry(memdb, nil, testlog.HCLogger(t))
+
+	err := oldR.RegisterPlugin(&PluginInfo{
+		Type:           PluginTypeCSINode,
+		Name:           "live-plugin",
+		AllocID:        "alloc-live",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	err = oldR.RegisterPlugin(&PluginInfo{
+		Type:           PluginTypeCSINode,
+		Name:           "dead-plugin",
+		AllocID:        "alloc-dead",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	// a plugin with no owning alloc should never be pruned
+	err = oldR.RegisterPlugin(&PluginInfo{
+		Type:           PluginTypeCSIController,
+		Name:           "unowned-plugin",
+		ConnectionInfo: &PluginConnectionInfo{},
+	})
+	require.NoError(t, err)
+
+	// restore from state, as happens on client restart
+	r := NewRegistry(memdb, nil, testlog.HCLogger(t))
+
+	pruned := r.Reconcile(map[string]bool{"alloc-live": true})
+	require.Equal(t, 1, pruned)
+
+	require.Equal(t, 1, len(r.ListPlugins(PluginTypeCSINode)))
+	require.Equal(t, "live-plugin", r.ListPlugins(PluginTypeCSINode)[0].Name)
+	require.Equal(t, 1, len(r.ListPlugins(PluginTypeCSIController)))
+
+	// reconciling again is a no-op, since the stale plugin is already gone
+	pruned = r.Reconcile(map[string]bool{"alloc-live": true})
+	require.Equal(t, 0, pruned)
+}
+
 func TestDynamicRegistry_StateStore(t *testing.T) {
 	t.Parallel()
 	dispenseFn := func(i *PluginInfo) (interface{}, error) {
@@ -202,7 +380,7 @@ func TestDynamicRegistry_StateStore(t *testing.T) {
 	}
 
 	memdb := &MemDB{}
-	oldR := NewRegistry(memdb, map[string]PluginDispenser{"csi": dispenseFn})
+	oldR := NewRegistry(memdb, map[string]PluginDispenser{"csi": dispenseFn}, testlog.HCLogger(t))
 
 	err := oldR.RegisterPlugin(&PluginInfo{
 		Type:           "csi",
@@ -215,12 +393,78 @@ func TestDynamicRegistry_StateStore(t *testing.T) {
 	require.NoError(t, err)
 
 	// recreate the registry from the state store and query again
-	newR := NewRegistry(memdb, map[string]PluginDispenser{"csi": dispenseFn})
+	newR := NewRegistry(memdb, map[string]PluginDispenser{"csi": dispenseFn}, testlog.HCLogger(t))
 	result, err = newR.DispensePlugin("csi", "my-plugin")
 	require.NotNil(t, result)
 	require.NoError(t, err)
 }
 
+func TestDynamicRegistry_RestorePluginState_SkipsInvalidEntries(t *testing.T) {
+	t.Parallel()
+
+	stored := &RegistryState{
+		Plugins: map[string]map[string]*PluginInfo{
+			PluginTypeCSINode: {
+				"valid-plugin": {
+					Type:           PluginTypeCSINode,
+					Name:           "valid-plugin",
+					ConnectionInfo: &PluginConnectionInfo{SocketPath: "/tmp/valid.sock"},
+				},
+				"missing-connection-info": {
+					Type: PluginTypeCSINode,
+					Name: "missing-connection-info",
+				},
+				"mismatched-name": {
+					Type:           PluginTypeCSINode,
+					Name:           "some-other-name",
+					ConnectionInfo: &PluginConnectionInfo{},
+				},
+			},
+			PluginTypeCSIController: {
+				"": {
+					Type:           PluginTypeCSIController,
+					ConnectionInfo: &PluginConnectionInfo{},
+				},
+			},
+		},
+	}
+
+	plugins, restored, skipped := restorePluginState(stored, testlog.HCLogger(t))
+	require.Equal(t, 1, restored)
+	require.Equal(t, 3, skipped)
+
+	require.Equal(t, 1, len(plugins[PluginTypeCSINode]))
+	require.NotNil(t, plugins[PluginTypeCSINode]["valid-plugin"])
+	require.Equal(t, 0, len(plugins[PluginTypeCSIController]))
+}
+
+func TestDynamicRegistry_NewRegistry_RestoresValidEntriesOnly(t *testing.T) {
+	t.Parallel()
+
+	memdb := &MemDB{
+		dynamicManagerPs: &RegistryState{
+			Plugins: map[string]map[string]*PluginInfo{
+				PluginTypeCSINode: {
+					"valid-plugin": {
+						Type:           PluginTypeCSINode,
+						Name:           "valid-plugin",
+						ConnectionInfo: &PluginConnectionInfo{},
+					},
+					"corrupt-plugin": {
+						Type: PluginTypeCSINode,
+						Name: "corrupt-plugin",
+					},
+				},
+			},
+		},
+	}
+
+	r := NewRegistry(memdb, nil, testlog.HCLogger(t))
+	plugins := r.ListPlugins(PluginTypeCSINode)
+	require.Equal(t, 1, len(plugins))
+	require.Equal(t, "valid-plugin", plugins[0].Name)
+}
+
 // MemDB implements a StateDB that stores data in memory and should only be
 // used for testing. All methods are safe for concurrent use. This is a
 // partial implementation of the MemDB in the client/state package, copied
@@ -96,7 +96,9 @@ func (p *pluginFingerprinter) buildBasicFingerprint(ctx context.Context) (*struc
 		info.NodeInfo = &structs.CSINodeInfo{}
 	}
 	if p.fingerprintController {
-		info.ControllerInfo = &structs.CSIControllerInfo{}
+		info.ControllerInfo = &structs.CSIControllerInfo{
+			AccessibleTopology: p.info.AccessibleTopology,
+		}
 	}
 
 	capabilities, err := p.client.PluginGetCapabilities(ctx)
@@ -2,10 +2,12 @@ package csimanager
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/client/dynamicplugins"
+	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/csi"
 )
 
@@ -41,6 +43,23 @@ type instanceManager struct {
 	volumeManagerSetupCh chan struct{}
 
 	client csi.CSIPlugin
+
+	// refreshCh carries on-demand fingerprint requests into runLoop, so that
+	// callers like the RefreshPluginCapabilities RPC don't race runLoop's
+	// ownership of client and fp.
+	refreshCh chan *instanceRefreshRequest
+}
+
+// instanceRefreshRequest asks runLoop to fingerprint the plugin immediately,
+// rather than waiting for the next managerFingerprintInterval tick.
+type instanceRefreshRequest struct {
+	ctx      context.Context
+	resultCh chan instanceRefreshResult
+}
+
+type instanceRefreshResult struct {
+	info *structs.CSIInfo
+	err  error
 }
 
 func newInstanceManager(logger hclog.Logger, eventer TriggerNodeEvent, updater UpdateNodeCSIInfoFunc, p *dynamicplugins.PluginInfo) *instanceManager {
@@ -66,6 +85,8 @@ func newInstanceManager(logger hclog.Logger, eventer TriggerNodeEvent, updater U
 
 		volumeManagerSetupCh: make(chan struct{}),
 
+		refreshCh: make(chan *instanceRefreshRequest),
+
 		shutdownCtx:         ctx,
 		shutdownCtxCancelFn: cancelFn,
 		shutdownCh:          make(chan struct{}),
@@ -119,6 +140,29 @@ func (i *instanceManager) requestCtxWithTimeout(timeout time.Duration) (context.
 	return context.WithTimeout(i.shutdownCtx, timeout)
 }
 
+// refresh re-queries the plugin's capabilities immediately, instead of
+// waiting for the next managerFingerprintInterval tick, and applies the
+// result through the same updater callback the periodic fingerprint loop
+// uses. It returns the refreshed CSIInfo.
+func (i *instanceManager) refresh(ctx context.Context) (*structs.CSIInfo, error) {
+	req := &instanceRefreshRequest{ctx: ctx, resultCh: make(chan instanceRefreshResult, 1)}
+
+	select {
+	case i.refreshCh <- req:
+	case <-i.shutdownCtx.Done():
+		return nil, errors.New("plugin is shutting down")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case result := <-req.resultCh:
+		return result.info, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (i *instanceManager) runLoop() {
 	timer := time.NewTimer(0)
 	for {
@@ -149,6 +193,15 @@ func (i *instanceManager) runLoop() {
 				i.updater(i.info.Name, info)
 			}
 			timer.Reset(managerFingerprintInterval)
+
+		case req := <-i.refreshCh:
+			info := i.fp.fingerprint(req.ctx)
+			if info != nil {
+				i.updater(i.info.Name, info)
+				req.resultCh <- instanceRefreshResult{info: info}
+			} else {
+				req.resultCh <- instanceRefreshResult{err: errors.New("plugin did not respond to fingerprint request")}
+			}
 		}
 	}
 }
@@ -36,6 +36,28 @@ func setupTestNodeInstanceManager(t *testing.T) (*fake.Client, *instanceManager)
 	}
 }
 
+func setupTestControllerInstanceManager(t *testing.T) (*fake.Client, *instanceManager) {
+	tp := &fake.Client{}
+
+	logger := testlog.HCLogger(t)
+	pinfo := &dynamicplugins.PluginInfo{
+		Name: "test-plugin",
+	}
+
+	return tp, &instanceManager{
+		logger: logger,
+		info:   pinfo,
+		client: tp,
+		fp: &pluginFingerprinter{
+			logger:                          logger.Named("fingerprinter"),
+			info:                            pinfo,
+			client:                          tp,
+			fingerprintController:           true,
+			hadFirstSuccessfulFingerprintCh: make(chan struct{}),
+		},
+	}
+}
+
 func TestInstanceManager_Shutdown(t *testing.T) {
 
 	var pluginHealth bool
@@ -78,3 +100,52 @@ func TestInstanceManager_Shutdown(t *testing.T) {
 	}, 1*time.Second, 10*time.Millisecond)
 
 }
+
+func TestInstanceManager_Refresh_PicksUpCapabilityChange(t *testing.T) {
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	client, im := setupTestNodeInstanceManager(t)
+	im.shutdownCtx = ctx
+	im.shutdownCtxCancelFn = cancelFn
+	im.shutdownCh = make(chan struct{})
+	im.refreshCh = make(chan *instanceRefreshRequest)
+
+	var lock sync.Mutex
+	var lastInfo *structs.CSIInfo
+	im.updater = func(_ string, info *structs.CSIInfo) {
+		lock.Lock()
+		defer lock.Unlock()
+		lastInfo = info
+	}
+
+	client.NextPluginGetCapabilitiesResponse = &csi.PluginCapabilitySet{}
+	client.NextPluginGetCapabilitiesErr = nil
+	client.NextNodeGetInfoResponse = &csi.NodeGetInfoResponse{NodeID: "foo"}
+	client.NextNodeGetInfoErr = nil
+	client.NextNodeGetCapabilitiesResponse = &csi.NodeCapabilitySet{}
+	client.NextNodeGetCapabilitiesErr = nil
+	client.NextPluginProbeResponse = true
+
+	go im.runLoop()
+
+	info, err := im.refresh(context.Background())
+	require.NoError(t, err)
+	require.False(t, info.NodeInfo.RequiresNodeStageVolume)
+
+	lock.Lock()
+	require.False(t, lastInfo.NodeInfo.RequiresNodeStageVolume)
+	lock.Unlock()
+
+	// simulate a plugin upgrade that adds the STAGE_UNSTAGE_VOLUME capability
+	client.NextNodeGetCapabilitiesResponse = &csi.NodeCapabilitySet{HasStageUnstageVolume: true}
+
+	info, err = im.refresh(context.Background())
+	require.NoError(t, err)
+	require.True(t, info.NodeInfo.RequiresNodeStageVolume)
+
+	lock.Lock()
+	require.True(t, lastInfo.NodeInfo.RequiresNodeStageVolume)
+	lock.Unlock()
+}
@@ -40,9 +40,39 @@ func (u *UsageOptions) ToFS() string {
 	return sb.String()
 }
 
+// PublishVolumeRequest bundles the arguments for publishing a single
+// allocation's mount of a volume as part of a VolumeMounter.PublishVolumes
+// batch call.
+type PublishVolumeRequest struct {
+	Volume         *structs.CSIVolume
+	Alloc          *structs.Allocation
+	UsageOptions   *UsageOptions
+	PublishContext map[string]string
+}
+
+// PublishVolumeResult is the per-entry outcome of a
+// VolumeMounter.PublishVolumes batch call.
+type PublishVolumeResult struct {
+	AllocID   string
+	MountInfo *MountInfo
+	Err       error
+}
+
 type VolumeMounter interface {
 	MountVolume(ctx context.Context, vol *structs.CSIVolume, alloc *structs.Allocation, usageOpts *UsageOptions, publishContext map[string]string) (*MountInfo, error)
 	UnmountVolume(ctx context.Context, volID, remoteID, allocID string, usageOpts *UsageOptions) error
+
+	// HasMount reports whether any allocation on this client still has the
+	// volume claimed.
+	HasMount(volID string) bool
+
+	// PublishVolumes publishes a batch of co-located allocations' mounts of
+	// (usually the same) volumes in a single call, reusing a volume's
+	// staging across every entry in the batch that shares it instead of
+	// staging once per allocation. Each entry succeeds or fails
+	// independently, so one invalid entry doesn't block publish for the
+	// rest of the batch.
+	PublishVolumes(ctx context.Context, reqs []PublishVolumeRequest) []PublishVolumeResult
 }
 
 type Manager interface {
@@ -53,6 +83,12 @@ type Manager interface {
 	// with the volume.	Returns an error if this plugin isn't registered.
 	MounterForPlugin(ctx context.Context, pluginID string) (VolumeMounter, error)
 
+	// RefreshPluginCapabilities re-queries the named plugin's capabilities
+	// immediately, rather than waiting for the next periodic fingerprint,
+	// and updates the cached fingerprint reported to servers. Returns an
+	// error if this plugin isn't registered.
+	RefreshPluginCapabilities(ctx context.Context, pluginType, pluginID string) (*structs.CSIInfo, error)
+
 	// Shutdown shuts down the Manager and unmounts any locally attached volumes.
 	Shutdown()
 }
@@ -47,6 +47,8 @@ func New(config *Config) Manager {
 		updateNodeCSIInfoFunc: config.UpdateNodeCSIInfoFunc,
 		pluginResyncPeriod:    config.PluginResyncPeriod,
 
+		refreshCh: make(chan *refreshRequest),
+
 		shutdownCtx:         ctx,
 		shutdownCtxCancelFn: cancelFn,
 		shutdownCh:          make(chan struct{}),
@@ -65,15 +67,61 @@ type csiManager struct {
 
 	updateNodeCSIInfoFunc UpdateNodeCSIInfoFunc
 
+	// refreshCh carries on-demand capability refresh requests into runLoop,
+	// since instances must only be accessed from that goroutine.
+	refreshCh chan *refreshRequest
+
 	shutdownCtx         context.Context
 	shutdownCtxCancelFn context.CancelFunc
 	shutdownCh          chan struct{}
 }
 
+// refreshRequest asks runLoop to look up the instance manager for
+// pluginType/pluginID and trigger an immediate capability refresh on it.
+type refreshRequest struct {
+	ctx        context.Context
+	pluginType string
+	pluginID   string
+	resultCh   chan refreshResult
+}
+
+type refreshResult struct {
+	info *structs.CSIInfo
+	err  error
+}
+
 func (c *csiManager) PluginManager() pluginmanager.PluginManager {
 	return c
 }
 
+// RefreshPluginCapabilities re-queries the named plugin's capabilities
+// immediately, instead of waiting for the next periodic fingerprint, and
+// updates the cached fingerprint that's reported to servers. It returns the
+// refreshed CSIInfo.
+func (c *csiManager) RefreshPluginCapabilities(ctx context.Context, pluginType, pluginID string) (*structs.CSIInfo, error) {
+	req := &refreshRequest{
+		ctx:        ctx,
+		pluginType: pluginType,
+		pluginID:   pluginID,
+		resultCh:   make(chan refreshResult, 1),
+	}
+
+	select {
+	case c.refreshCh <- req:
+	case <-c.shutdownCtx.Done():
+		return nil, fmt.Errorf("plugin manager is shutting down")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case result := <-req.resultCh:
+		return result.info, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (c *csiManager) MounterForPlugin(ctx context.Context, pluginID string) (VolumeMounter, error) {
 	nodePlugins, hasAnyNodePlugins := c.instances["csi-node"]
 	if !hasAnyNodePlugins {
@@ -107,6 +155,8 @@ func (c *csiManager) runLoop() {
 			c.handlePluginEvent(event)
 		case event := <-nodeUpdates:
 			c.handlePluginEvent(event)
+		case req := <-c.refreshCh:
+			c.handleRefreshRequest(req)
 		case <-c.shutdownCtx.Done():
 			close(c.shutdownCh)
 			return
@@ -114,6 +164,26 @@ func (c *csiManager) runLoop() {
 	}
 }
 
+// handleRefreshRequest looks up the instance manager for req's plugin and
+// kicks off its refresh in a goroutine, so that a slow round-trip to the
+// plugin doesn't block this run loop from handling other events. The
+// instance manager pointer itself is safe to hand off: if the plugin is
+// deregistered mid-refresh, the instance manager's own shutdown will make
+// the in-flight refresh return an error.
+func (c *csiManager) handleRefreshRequest(req *refreshRequest) {
+	instances := c.instancesForType(req.pluginType)
+	mgr, ok := instances[req.pluginID]
+	if !ok {
+		req.resultCh <- refreshResult{err: fmt.Errorf("plugin %s for type %s not found", req.pluginID, req.pluginType)}
+		return
+	}
+
+	go func() {
+		info, err := mgr.refresh(req.ctx)
+		req.resultCh <- refreshResult{info: info, err: err}
+	}()
+}
+
 // resyncPluginsFromRegistry does a full sync of the running instance
 // managers against those in the registry. we primarily will use update
 // events from the registry.
@@ -151,7 +221,7 @@ func (c *csiManager) handlePluginEvent(event *dynamicplugins.PluginUpdateEvent)
 		"plugin_alloc_id", event.Info.AllocID)
 
 	switch event.EventType {
-	case dynamicplugins.EventTypeRegistered:
+	case dynamicplugins.EventTypeRegistered, dynamicplugins.EventTypeUpdated:
 		c.ensureInstance(event.Info)
 	case dynamicplugins.EventTypeDeregistered:
 		c.ensureNoInstance(event.Info)
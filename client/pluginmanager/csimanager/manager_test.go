@@ -19,18 +19,18 @@ var fakePlugin = &dynamicplugins.PluginInfo{
 	ConnectionInfo: &dynamicplugins.PluginConnectionInfo{},
 }
 
-func setupRegistry() dynamicplugins.Registry {
+func setupRegistry(t *testing.T) dynamicplugins.Registry {
 	return dynamicplugins.NewRegistry(
 		nil,
 		map[string]dynamicplugins.PluginDispenser{
 			"csi-controller": func(*dynamicplugins.PluginInfo) (interface{}, error) {
 				return nil, nil
 			},
-		})
+		}, testlog.HCLogger(t))
 }
 
 func TestManager_Setup_Shutdown(t *testing.T) {
-	r := setupRegistry()
+	r := setupRegistry(t)
 	defer r.Shutdown()
 
 	cfg := &Config{
@@ -44,7 +44,7 @@ func TestManager_Setup_Shutdown(t *testing.T) {
 }
 
 func TestManager_RegisterPlugin(t *testing.T) {
-	registry := setupRegistry()
+	registry := setupRegistry(t)
 	defer registry.Shutdown()
 
 	require.NotNil(t, registry)
@@ -76,7 +76,7 @@ func TestManager_RegisterPlugin(t *testing.T) {
 }
 
 func TestManager_DeregisterPlugin(t *testing.T) {
-	registry := setupRegistry()
+	registry := setupRegistry(t)
 	defer registry.Shutdown()
 
 	require.NotNil(t, registry)
@@ -115,7 +115,7 @@ func TestManager_DeregisterPlugin(t *testing.T) {
 // name but different types (as found with monolith plugins) don't interfere
 // with each other.
 func TestManager_MultiplePlugins(t *testing.T) {
-	registry := setupRegistry()
+	registry := setupRegistry(t)
 	defer registry.Shutdown()
 
 	require.NotNil(t, registry)
@@ -0,0 +1,67 @@
+package csimanager
+
+import (
+	"sync"
+	"time"
+)
+
+// retryBudget is a token-bucket limiter shared by every CSI operation
+// against a single plugin, so that many concurrent mounts/unmounts can't
+// collectively retry enough to amplify load on a plugin that's already
+// struggling. Each operation that wants to retry on failure must first take
+// a token; when the bucket is empty the caller is expected to make its call
+// without retrying, so the failure surfaces immediately instead of piling
+// on more attempts. Tokens refill at a fixed rate so the budget recovers
+// once the plugin (or the outage affecting it) has had time to settle.
+type retryBudget struct {
+	mu       sync.Mutex
+	tokens   float64
+	max      float64
+	fillRate float64 // tokens restored per second
+	last     time.Time
+
+	// now is overridable in tests so refill can be exercised without
+	// sleeping.
+	now func() time.Time
+}
+
+// newRetryBudget creates a retryBudget starting full, holding up to max
+// tokens and refilling at fillRate tokens per second.
+func newRetryBudget(max int, fillRate float64) *retryBudget {
+	return &retryBudget{
+		tokens:   float64(max),
+		max:      float64(max),
+		fillRate: fillRate,
+		now:      time.Now,
+	}
+}
+
+// Take attempts to consume a single retry token, reporting whether one was
+// available.
+func (b *retryBudget) Take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *retryBudget) refillLocked() {
+	now := b.now()
+	if b.last.IsZero() {
+		b.last = now
+		return
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 && b.fillRate > 0 {
+		b.tokens += elapsed * b.fillRate
+		if b.tokens > b.max {
+			b.tokens = b.max
+		}
+	}
+	b.last = now
+}
@@ -0,0 +1,58 @@
+package csimanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/helper/testlog"
+	"github.com/hashicorp/nomad/nomad/structs"
+	csifake "github.com/hashicorp/nomad/plugins/csi/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBudget_Take(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	budget := newRetryBudget(2, 1.0)
+	budget.now = func() time.Time { return now }
+
+	// Simulate many failing calls in a row, each taking a token: only as
+	// many as the budget holds should succeed before it's exhausted.
+	require.True(t, budget.Take())
+	require.True(t, budget.Take())
+	for i := 0; i < 10; i++ {
+		require.False(t, budget.Take(), "budget should stay exhausted without the passage of time")
+	}
+
+	// Advance the clock enough to refill a single token and confirm
+	// exactly one more Take succeeds before the budget is empty again.
+	now = now.Add(1500 * time.Millisecond)
+	require.True(t, budget.Take())
+	require.False(t, budget.Take())
+}
+
+func TestVolumeManager_retryLimit(t *testing.T) {
+	t.Parallel()
+
+	tmpPath := tmpDir(t)
+	eventer := func(e *structs.NodeEvent) {}
+	csiFake := &csifake.Client{}
+	manager := newVolumeManager(testlog.HCLogger(t), eventer, csiFake, tmpPath, tmpPath, true)
+
+	now := time.Now()
+	manager.retries = newRetryBudget(defaultRetryBudgetTokens, defaultRetryBudgetFillPerSecond)
+	manager.retries.now = func() time.Time { return now }
+
+	// Drain the budget, simulating many failing CSI calls in a row that
+	// each grab a retry token.
+	for i := 0; i < defaultRetryBudgetTokens; i++ {
+		require.Equal(t, uint(csiMaxRetries), manager.retryLimit())
+	}
+
+	// Once exhausted, calls stop retrying entirely rather than piling on
+	// more attempts against a struggling plugin.
+	for i := 0; i < 10; i++ {
+		require.Equal(t, uint(noRetryBudgetMaxRetries), manager.retryLimit())
+	}
+}
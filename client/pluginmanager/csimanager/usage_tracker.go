@@ -56,6 +56,21 @@ func (v *volumeUsageTracker) Claim(allocID, volID string, usage *UsageOptions) {
 	v.appendAlloc(key, allocID)
 }
 
+// HasMount reports whether any allocation on this client currently has the
+// volume claimed, regardless of usage mode. It's used to answer a server's
+// query about whether it's safe to detach the volume at the controller.
+func (v *volumeUsageTracker) HasMount(volID string) bool {
+	v.stateMu.Lock()
+	defer v.stateMu.Unlock()
+
+	for key, allocs := range v.state {
+		if key.id == volID && len(allocs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // Free removes the allocation from the state list for the given alloc. If the
 // alloc is the last allocation for the volume then it returns true.
 func (v *volumeUsageTracker) Free(allocID, volID string, usage *UsageOptions) bool {
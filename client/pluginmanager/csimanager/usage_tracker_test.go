@@ -60,3 +60,16 @@ func TestUsageTracker(t *testing.T) {
 		})
 	}
 }
+
+func TestUsageTracker_HasMount(t *testing.T) {
+	tracker := newVolumeUsageTracker()
+	alloc := mock.Alloc()
+
+	require.False(t, tracker.HasMount("foo"), "unclaimed volume should report no mount")
+
+	tracker.Claim(alloc.ID, "foo", &UsageOptions{})
+	require.True(t, tracker.HasMount("foo"), "claimed volume should report a mount")
+
+	tracker.Free(alloc.ID, "foo", &UsageOptions{})
+	require.False(t, tracker.HasMount("foo"), "volume freed of its last claim should report no mount")
+}
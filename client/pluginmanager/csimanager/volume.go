@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -23,8 +24,63 @@ const (
 	DefaultMountActionTimeout = 2 * time.Minute
 	StagingDirName            = "staging"
 	AllocSpecificDirName      = "per-alloc"
+
+	// defaultRetryBudgetTokens and defaultRetryBudgetFillPerSecond size the
+	// retry budget shared by every mount/unmount operation against a given
+	// plugin. A handful of tokens is enough to ride out a brief blip without
+	// letting a storm of concurrent operations retry unboundedly against a
+	// plugin that's genuinely down.
+	defaultRetryBudgetTokens        = 6
+	defaultRetryBudgetFillPerSecond = 1.0
+
+	// csiMaxRetries is how many attempts a mount/unmount operation is
+	// allowed when it holds a retry budget token; noRetryBudgetMaxRetries
+	// is the fallback when the budget is exhausted, which fails fast
+	// instead of retrying at all.
+	csiMaxRetries           = 3
+	noRetryBudgetMaxRetries = 1
+
+	// reachabilityProbeTimeout bounds how long MountVolume waits on a
+	// pre-attach TCP dial to the storage backend before giving up and
+	// failing fast, rather than letting a slow publish RPC be the first
+	// sign the node can't reach the backend at all.
+	reachabilityProbeTimeout = 5 * time.Second
+)
+
+// Volume Context keys that opt a volume into a pre-attach reachability
+// probe. CSIVolumeContextKeyProbeReachability must be the literal string
+// "true" and CSIVolumeContextKeyReachabilityAddr must be a dial-able
+// "host:port" for the probe to run; either being unset skips the probe
+// entirely, preserving today's behavior.
+const (
+	CSIVolumeContextKeyProbeReachability = "nomad.io/probe-reachability"
+	CSIVolumeContextKeyReachabilityAddr  = "nomad.io/reachability-addr"
 )
 
+// probeReachability performs a best-effort TCP dial to a volume's storage
+// backend, when the volume's Context opts in via
+// CSIVolumeContextKeyProbeReachability. This lets MountVolume fail fast
+// with a clear error instead of waiting out a slow NodeStageVolume/
+// NodePublishVolume RPC timeout against a backend the node simply can't
+// route to.
+func probeReachability(vol *structs.CSIVolume) error {
+	if vol.Context[CSIVolumeContextKeyProbeReachability] != "true" {
+		return nil
+	}
+
+	addr := vol.Context[CSIVolumeContextKeyReachabilityAddr]
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, reachabilityProbeTimeout)
+	if err != nil {
+		return fmt.Errorf("node cannot reach storage backend at %s: %w", addr, err)
+	}
+	conn.Close()
+	return nil
+}
+
 // volumeManager handles the state of attached volumes for a given CSI Plugin.
 //
 // volumeManagers outlive the lifetime of a given allocation as volumes may be
@@ -50,6 +106,12 @@ type volumeManager struct {
 	// requiresStaging shows whether the plugin requires that the volume manager
 	// calls NodeStageVolume and NodeUnstageVolume RPCs during setup and teardown
 	requiresStaging bool
+
+	// retries is a retry budget shared by every stage/unstage/publish/
+	// unpublish call made through this volumeManager, so that concurrent
+	// operations against the same plugin can't collectively retry without
+	// bound.
+	retries *retryBudget
 }
 
 func newVolumeManager(logger hclog.Logger, eventer TriggerNodeEvent, plugin csi.CSIPlugin, rootDir, containerRootDir string, requiresStaging bool) *volumeManager {
@@ -61,7 +123,18 @@ func newVolumeManager(logger hclog.Logger, eventer TriggerNodeEvent, plugin csi.
 		containerMountPoint: containerRootDir,
 		requiresStaging:     requiresStaging,
 		usageTracker:        newVolumeUsageTracker(),
+		retries:             newRetryBudget(defaultRetryBudgetTokens, defaultRetryBudgetFillPerSecond),
+	}
+}
+
+// retryLimit returns the CSI RPC retry count an operation should use: the
+// full budget if a retry token is available, or a single attempt (no
+// retries) if the shared budget is exhausted.
+func (v *volumeManager) retryLimit() uint {
+	if v.retries.Take() {
+		return csiMaxRetries
 	}
+	return noRetryBudgetMaxRetries
 }
 
 func (v *volumeManager) stagingDirForVolume(root string, volID string, usage *UsageOptions) string {
@@ -182,7 +255,7 @@ func (v *volumeManager) stageVolume(ctx context.Context, vol *structs.CSIVolume,
 	// codes.ResourceExhausted are retried; all other errors are fatal.
 	return v.plugin.NodeStageVolume(ctx, req,
 		grpc_retry.WithPerRetryTimeout(DefaultMountActionTimeout),
-		grpc_retry.WithMax(3),
+		grpc_retry.WithMax(v.retryLimit()),
 		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)),
 	)
 }
@@ -223,7 +296,7 @@ func (v *volumeManager) publishVolume(ctx context.Context, vol *structs.CSIVolum
 		VolumeContext:     vol.Context,
 	},
 		grpc_retry.WithPerRetryTimeout(DefaultMountActionTimeout),
-		grpc_retry.WithMax(3),
+		grpc_retry.WithMax(v.retryLimit()),
 		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)),
 	)
 
@@ -240,6 +313,10 @@ func (v *volumeManager) MountVolume(ctx context.Context, vol *structs.CSIVolume,
 	logger := v.logger.With("volume_id", vol.ID, "alloc_id", alloc.ID)
 	ctx = hclog.WithContext(ctx, logger)
 
+	if err := probeReachability(vol); err != nil {
+		return nil, err
+	}
+
 	if v.requiresStaging {
 		err = v.stageVolume(ctx, vol, usage, publishContext)
 	}
@@ -268,6 +345,74 @@ func (v *volumeManager) MountVolume(ctx context.Context, vol *structs.CSIVolume,
 	return mountInfo, err
 }
 
+// PublishVolumes publishes a batch of allocations' mounts in a single call.
+// It's meant for the common case of several allocations on the same node
+// mounting the same staged volume: rather than staging once per allocation
+// (as a loop of MountVolume calls would), each distinct volume/usage pair
+// in the batch is staged at most once and the result is reused for every
+// entry that shares it. Each entry's target path is validated and
+// published independently, so a bad entry only fails that entry rather
+// than the whole batch.
+func (v *volumeManager) PublishVolumes(ctx context.Context, reqs []PublishVolumeRequest) []PublishVolumeResult {
+	results := make([]PublishVolumeResult, len(reqs))
+	staged := make(map[string]error)
+
+	for i, req := range reqs {
+		if req.Alloc != nil {
+			results[i].AllocID = req.Alloc.ID
+		}
+
+		if err := validatePublishVolumeRequest(req); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		logger := v.logger.With("volume_id", req.Volume.ID, "alloc_id", req.Alloc.ID)
+		entryCtx := hclog.WithContext(ctx, logger)
+
+		if v.requiresStaging {
+			stageKey := req.Volume.ID + req.UsageOptions.ToFS()
+			err, ok := staged[stageKey]
+			if !ok {
+				err = v.stageVolume(entryCtx, req.Volume, req.UsageOptions, req.PublishContext)
+				staged[stageKey] = err
+			}
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+
+		mountInfo, err := v.publishVolume(entryCtx, req.Volume, req.Alloc, req.UsageOptions, req.PublishContext)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		v.usageTracker.Claim(req.Alloc.ID, req.Volume.ID, req.UsageOptions)
+		results[i].MountInfo = mountInfo
+	}
+
+	return results
+}
+
+// validatePublishVolumeRequest checks that a PublishVolumeRequest has
+// enough information to compute a target path and capability for publish,
+// so that a malformed batch entry is rejected up front rather than failing
+// deep inside plugin RPC handling.
+func validatePublishVolumeRequest(req PublishVolumeRequest) error {
+	if req.Volume == nil || req.Volume.ID == "" {
+		return fmt.Errorf("missing volume ID")
+	}
+	if req.Alloc == nil || req.Alloc.ID == "" {
+		return fmt.Errorf("missing allocation ID for volume %q", req.Volume.ID)
+	}
+	if req.UsageOptions == nil {
+		return fmt.Errorf("missing usage options for volume %q", req.Volume.ID)
+	}
+	return nil
+}
+
 // unstageVolume is the inverse operation of `stageVolume` and must be called
 // once for each staging path that a volume has been staged under.
 // It is safe to call multiple times and a plugin is required to return OK if
@@ -283,7 +428,7 @@ func (v *volumeManager) unstageVolume(ctx context.Context, volID, remoteID strin
 		remoteID,
 		stagingPath,
 		grpc_retry.WithPerRetryTimeout(DefaultMountActionTimeout),
-		grpc_retry.WithMax(3),
+		grpc_retry.WithMax(v.retryLimit()),
 		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)),
 	)
 }
@@ -308,7 +453,7 @@ func (v *volumeManager) unpublishVolume(ctx context.Context, volID, remoteID, al
 	// codes.ResourceExhausted are retried; all other errors are fatal.
 	rpcErr := v.plugin.NodeUnpublishVolume(ctx, remoteID, pluginTargetPath,
 		grpc_retry.WithPerRetryTimeout(DefaultMountActionTimeout),
-		grpc_retry.WithMax(3),
+		grpc_retry.WithMax(v.retryLimit()),
 		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100*time.Millisecond)),
 	)
 
@@ -337,6 +482,11 @@ func (v *volumeManager) unpublishVolume(ctx context.Context, volID, remoteID, al
 	return fmt.Errorf("%w: %v", structs.ErrCSIClientRPCIgnorable, rpcErr)
 }
 
+// HasMount implements VolumeMounter.
+func (v *volumeManager) HasMount(volID string) bool {
+	return v.usageTracker.HasMount(volID)
+}
+
 func (v *volumeManager) UnmountVolume(ctx context.Context, volID, remoteID, allocID string, usage *UsageOptions) (err error) {
 	logger := v.logger.With("volume_id", volID, "alloc_id", allocID)
 	ctx = hclog.WithContext(ctx, logger)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"runtime"
 	"testing"
@@ -522,3 +523,203 @@ func TestVolumeManager_MountVolumeEvents(t *testing.T) {
 	require.Equal(t, "vol", e.Details["volume_id"])
 	require.Equal(t, "true", e.Details["success"])
 }
+
+// TestVolumeManager_MountVolume_ReachabilityProbe asserts that MountVolume
+// fails fast with a descriptive error when a volume opts into the pre-attach
+// reachability probe and its backend address isn't dialable, and that it
+// proceeds normally when the backend is reachable or the volume hasn't
+// opted in.
+func TestVolumeManager_MountVolume_ReachabilityProbe(t *testing.T) {
+	if !checkMountSupport() {
+		t.Skip("mount point detection not supported for this platform")
+	}
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	cases := []struct {
+		Name        string
+		VolContext  map[string]string
+		ExpectError bool
+	}{
+		{
+			Name:        "probe opted out",
+			VolContext:  map[string]string{},
+			ExpectError: false,
+		},
+		{
+			Name: "reachable backend",
+			VolContext: map[string]string{
+				CSIVolumeContextKeyProbeReachability: "true",
+				CSIVolumeContextKeyReachabilityAddr:  ln.Addr().String(),
+			},
+			ExpectError: false,
+		},
+		{
+			Name: "unreachable backend",
+			VolContext: map[string]string{
+				CSIVolumeContextKeyProbeReachability: "true",
+				CSIVolumeContextKeyReachabilityAddr:  "127.0.0.1:1",
+			},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tmpPath := tmpDir(t)
+			defer os.RemoveAll(tmpPath)
+
+			csiFake := &csifake.Client{}
+			eventer := func(e *structs.NodeEvent) {}
+
+			manager := newVolumeManager(testlog.HCLogger(t), eventer, csiFake, tmpPath, tmpPath, false)
+			ctx := context.Background()
+			vol := &structs.CSIVolume{
+				ID:             "vol",
+				Namespace:      "ns",
+				AccessMode:     structs.CSIVolumeAccessModeMultiNodeMultiWriter,
+				AttachmentMode: structs.CSIVolumeAttachmentModeFilesystem,
+				Context:        tc.VolContext,
+			}
+			alloc := mock.Alloc()
+			usage := &UsageOptions{}
+
+			_, err := manager.MountVolume(ctx, vol, alloc, usage, nil)
+			if tc.ExpectError {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "node cannot reach storage backend")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestVolumeManager_MountVolume_RequiresStaging asserts that MountVolume
+// and UnmountVolume only call the plugin's NodeStageVolume/NodeUnstageVolume
+// RPCs when the plugin was fingerprinted as supporting STAGE_UNSTAGE_VOLUME,
+// since the CSI spec makes staging optional and plugins that don't
+// implement it reject the RPC.
+func TestVolumeManager_MountVolume_RequiresStaging(t *testing.T) {
+	if !checkMountSupport() {
+		t.Skip("mount point detection not supported for this platform")
+	}
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		RequiresStaging bool
+	}{
+		{
+			Name:            "plugin supports staging",
+			RequiresStaging: true,
+		},
+		{
+			Name:            "plugin does not support staging",
+			RequiresStaging: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tmpPath := tmpDir(t)
+			defer os.RemoveAll(tmpPath)
+
+			csiFake := &csifake.Client{}
+			eventer := func(e *structs.NodeEvent) {}
+
+			manager := newVolumeManager(testlog.HCLogger(t), eventer, csiFake, tmpPath, tmpPath, tc.RequiresStaging)
+			ctx := context.Background()
+			vol := &structs.CSIVolume{
+				ID:             "vol",
+				Namespace:      "ns",
+				AccessMode:     structs.CSIVolumeAccessModeMultiNodeMultiWriter,
+				AttachmentMode: structs.CSIVolumeAttachmentModeFilesystem,
+			}
+			alloc := mock.Alloc()
+			usage := &UsageOptions{}
+
+			_, err := manager.MountVolume(ctx, vol, alloc, usage, nil)
+			require.NoError(t, err)
+
+			err = manager.UnmountVolume(ctx, vol.ID, vol.RemoteID(), alloc.ID, usage)
+			require.NoError(t, err)
+
+			if tc.RequiresStaging {
+				require.Equal(t, int64(1), csiFake.NodeStageVolumeCallCount)
+				require.Equal(t, int64(1), csiFake.NodeUnstageVolumeCallCount)
+			} else {
+				require.Equal(t, int64(0), csiFake.NodeStageVolumeCallCount)
+				require.Equal(t, int64(0), csiFake.NodeUnstageVolumeCallCount)
+			}
+		})
+	}
+}
+
+// TestVolumeManager_PublishVolumes_BatchWithInvalidEntry asserts that
+// PublishVolumes stages a shared volume only once for a batch of co-located
+// allocations, that a single malformed entry fails only that entry, and
+// that the valid entries in the same batch still publish successfully.
+func TestVolumeManager_PublishVolumes_BatchWithInvalidEntry(t *testing.T) {
+	if !checkMountSupport() {
+		t.Skip("mount point detection not supported for this platform")
+	}
+	t.Parallel()
+
+	tmpPath := tmpDir(t)
+	defer os.RemoveAll(tmpPath)
+
+	csiFake := &csifake.Client{}
+	eventer := func(e *structs.NodeEvent) {}
+	manager := newVolumeManager(testlog.HCLogger(t), eventer, csiFake, tmpPath, tmpPath, true)
+	ctx := context.Background()
+
+	vol := &structs.CSIVolume{
+		ID:             "vol",
+		Namespace:      "ns",
+		AccessMode:     structs.CSIVolumeAccessModeMultiNodeMultiWriter,
+		AttachmentMode: structs.CSIVolumeAttachmentModeFilesystem,
+	}
+	usage := &UsageOptions{}
+	allocA := mock.Alloc()
+	allocB := mock.Alloc()
+	invalidAlloc := mock.Alloc()
+	invalidAlloc.ID = ""
+
+	reqs := []PublishVolumeRequest{
+		{Volume: vol, Alloc: allocA, UsageOptions: usage},
+		{Volume: vol, Alloc: invalidAlloc, UsageOptions: usage},
+		{Volume: vol, Alloc: allocB, UsageOptions: usage},
+	}
+
+	results := manager.PublishVolumes(ctx, reqs)
+	require.Len(t, results, 3)
+
+	require.Equal(t, allocA.ID, results[0].AllocID)
+	require.NoError(t, results[0].Err)
+	require.NotNil(t, results[0].MountInfo)
+
+	require.Error(t, results[1].Err)
+	require.Nil(t, results[1].MountInfo)
+
+	require.Equal(t, allocB.ID, results[2].AllocID)
+	require.NoError(t, results[2].Err)
+	require.NotNil(t, results[2].MountInfo)
+
+	// the shared volume is staged once for the whole batch, not once per
+	// allocation
+	require.Equal(t, int64(1), csiFake.NodeStageVolumeCallCount)
+	require.Equal(t, int64(2), csiFake.NodePublishVolumeCallCount)
+}
@@ -248,7 +248,7 @@ func (c *Client) streamingRpcConn(server *servers.Server, method string) (net.Co
 func (c *Client) setupClientRpc() {
 	// Initialize the RPC handlers
 	c.endpoints.ClientStats = &ClientStats{c}
-	c.endpoints.CSI = &CSI{c}
+	c.endpoints.CSI = &CSI{c: c, inFlight: newCSIInFlightRegistry(), breaker: newCSICircuitBreaker(), capabilitiesCache: newCSIControllerCapabilitiesCache()}
 	c.endpoints.FileSystem = NewFileSystemEndpoint(c)
 	c.endpoints.Allocations = NewAllocationsEndpoint(c)
 	c.endpoints.Agent = NewAgentEndpoint(c)
@@ -1,6 +1,10 @@
 package structs
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/helper"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/csi"
 )
@@ -28,6 +32,19 @@ type CSIControllerQuery struct {
 
 	// PluginID is the plugin that should be targeted on the given node.
 	PluginID string
+
+	// Timeout bounds how long the client will wait on the controller
+	// plugin's RPC before giving up. If zero, the client's default
+	// timeout is used instead.
+	Timeout time.Duration
+
+	// AccessibleTopology, when set, is used by the server to prefer
+	// routing this request to a controller plugin instance whose
+	// registration topology (see CSIControllerInfo.AccessibleTopology)
+	// matches one of these topologies, falling back to any healthy
+	// instance if none match. This is populated from the volume's
+	// Topologies for zonal controller plugins.
+	AccessibleTopology []*structs.CSITopology
 }
 
 type ClientCSIControllerValidateVolumeRequest struct {
@@ -90,6 +107,14 @@ type ClientCSIControllerAttachVolumeRequest struct {
 	// when providing an AttachmentMode of CSIVolumeAttachmentModeFilesystem
 	MountOptions *CSIVolumeMountOptions
 
+	// RequestedDevicePath is an optional absolute device path the caller
+	// would like the plugin to attach a CSIVolumeAttachmentModeBlockDevice
+	// volume at. The CSI spec has no dedicated field for this, so it's
+	// forwarded to the plugin via VolumeContext; plugins that don't honor
+	// it simply ignore the key, in which case the actual DevicePath
+	// returned in the response may differ.
+	RequestedDevicePath string
+
 	// ReadOnly indicates that the volume will be used in a readonly fashion. This
 	// only works when the Controller has the PublishReadonly capability.
 	ReadOnly bool
@@ -102,6 +127,29 @@ type ClientCSIControllerAttachVolumeRequest struct {
 	// This field is optional.
 	VolumeContext map[string]string
 
+	// TraceID correlates this request with the server-side CSIVolume.Claim
+	// RPC that triggered it, so that operators can cross-reference Nomad's
+	// logs with the CSI plugin's own logs for the same operation.
+	TraceID string
+
+	// Deadline is the absolute wall-clock time by which the attach must
+	// complete. Unlike a relative timeout, it's computed once by the
+	// scheduler and carried through to the client, so that retries and
+	// queueing delays upstream of this RPC count against it. A zero value
+	// means the attach is bounded only by the plugin RPC's own timeout.
+	Deadline time.Time
+
+	// Retries is the number of additional attempts the handler will make
+	// if the plugin returns a retriable error (codes.Unavailable,
+	// codes.DeadlineExceeded, or codes.ResourceExhausted). A zero value
+	// means the attach is not retried. This field is OPTIONAL.
+	Retries int
+
+	// RetryBaseDelay is the delay before the first retry; each subsequent
+	// retry doubles it. This field is OPTIONAL and ignored when Retries is
+	// zero.
+	RetryBaseDelay time.Duration
+
 	CSIControllerQuery
 }
 
@@ -115,13 +163,22 @@ func (c *ClientCSIControllerAttachVolumeRequest) ToCSIRequest() (*csi.Controller
 		return nil, err
 	}
 
+	volumeContext := c.VolumeContext
+	if c.RequestedDevicePath != "" {
+		volumeContext = helper.CopyMapStringString(volumeContext)
+		if volumeContext == nil {
+			volumeContext = make(map[string]string, 1)
+		}
+		volumeContext[csi.RequestedDevicePathContextKey] = c.RequestedDevicePath
+	}
+
 	return &csi.ControllerPublishVolumeRequest{
 		ExternalID:       c.VolumeID,
 		NodeID:           c.ClientCSINodeID,
 		VolumeCapability: caps,
 		ReadOnly:         c.ReadOnly,
 		Secrets:          c.Secrets,
-		VolumeContext:    c.VolumeContext,
+		VolumeContext:    volumeContext,
 	}, nil
 }
 
@@ -143,6 +200,23 @@ type ClientCSIControllerAttachVolumeResponse struct {
 	// This field is OPTIONAL and when present MUST be passed to
 	// subsequent `NodeStageVolume` or `NodePublishVolume` calls
 	PublishContext map[string]string
+
+	// DevicePath is the host device path the volume was published to. It's
+	// only populated when the request's AttachmentMode is
+	// CSIVolumeAttachmentModeBlockDevice, so that block-mode callers don't
+	// need to know the plugin-specific PublishContext key to find it.
+	DevicePath string
+
+	// DevicePaths lists every host device path the volume was published
+	// over, for multipath-aware block plugins backed by HA storage (for
+	// example, iSCSI with multiple sessions to the same target). It's
+	// only populated when the plugin returns a multipath publish context
+	// and the request's AttachmentMode is CSIVolumeAttachmentModeBlockDevice.
+	DevicePaths []string
+
+	// Attempts is the number of calls made to the plugin, including the
+	// initial attempt and any retries, before this response was returned.
+	Attempts int
 }
 
 type ClientCSIControllerDetachVolumeRequest struct {
@@ -159,6 +233,31 @@ type ClientCSIControllerDetachVolumeRequest struct {
 	// volume request. This field is OPTIONAL.
 	Secrets structs.CSISecrets
 
+	// TraceID correlates this request with the server-side CSIVolume.Unpublish
+	// RPC that triggered it, so that operators can cross-reference Nomad's
+	// logs with the CSI plugin's own logs for the same operation.
+	TraceID string
+
+	// NodeCleanup, if set, triggers a best-effort node-side NodeUnpublish
+	// and NodeUnstage for the same volume immediately after the controller
+	// unpublish succeeds. Unlike a direct ClientCSI.NodeDetachVolume call,
+	// failures here -- including the node being unreachable -- are logged
+	// and otherwise ignored, since the controller has already released the
+	// volume and there's nothing left for the caller to retry. This field
+	// is OPTIONAL.
+	NodeCleanup *ClientCSINodeDetachVolumeRequest
+
+	// Retries is the number of additional attempts the handler will make
+	// if the plugin returns a retriable error (codes.Unavailable,
+	// codes.DeadlineExceeded, or codes.ResourceExhausted). A zero value
+	// means the detach is not retried. This field is OPTIONAL.
+	Retries int
+
+	// RetryBaseDelay is the delay before the first retry; each subsequent
+	// retry doubles it. This field is OPTIONAL and ignored when Retries is
+	// zero.
+	RetryBaseDelay time.Duration
+
 	CSIControllerQuery
 }
 
@@ -173,7 +272,218 @@ func (c *ClientCSIControllerDetachVolumeRequest) ToCSIRequest() *csi.ControllerU
 	}
 }
 
-type ClientCSIControllerDetachVolumeResponse struct{}
+type ClientCSIControllerDetachVolumeResponse struct {
+	// Attempts is the number of calls made to the plugin, including the
+	// initial attempt and any retries, before this response was returned.
+	Attempts int
+}
+
+// ClientCSIControllerCreateVolumeRequest is the RPC made from the server to
+// a Nomad client to tell a CSI controller plugin on that client to
+// dynamically provision a new volume, rather than requiring the volume to
+// already exist in the storage provider.
+type ClientCSIControllerCreateVolumeRequest struct {
+	// Name is the suggested name for the volume, used by the CO to achieve
+	// idempotent creates: the plugin must not provision more than one
+	// volume for the same name. This field is REQUIRED.
+	Name string
+
+	// CapacityMinBytes and CapacityMaxBytes bound the capacity the storage
+	// provider may allocate for the volume. Either may be left at 0 to
+	// indicate no bound on that end of the range.
+	CapacityMinBytes int64
+	CapacityMaxBytes int64
+
+	// AttachmentMode and AccessMode together describe the capability the
+	// created volume must support. This field is REQUIRED.
+	AttachmentMode structs.CSIVolumeAttachmentMode
+	AccessMode     structs.CSIVolumeAccessMode
+
+	// Parameters are opaque to Nomad and left for the storage provider to
+	// interpret. This field is OPTIONAL.
+	Parameters map[string]string
+
+	// Secrets required by plugin to complete the controller create volume
+	// request. This field is OPTIONAL.
+	Secrets structs.CSISecrets
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerCreateVolumeRequest) ToCSIRequest() (*csi.ControllerCreateVolumeRequest, error) {
+	if c == nil {
+		return &csi.ControllerCreateVolumeRequest{}, nil
+	}
+
+	vcap, err := csi.VolumeCapabilityFromStructs(c.AttachmentMode, c.AccessMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerCreateVolumeRequest{
+		Name: c.Name,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: c.CapacityMinBytes,
+			LimitBytes:    c.CapacityMaxBytes,
+		},
+		VolumeCapability: vcap,
+		Parameters:       c.Parameters,
+		Secrets:          c.Secrets,
+	}, nil
+}
+
+// ClientCSIControllerCreateVolumeResponse is the result of dynamically
+// provisioning a volume through a CSI controller plugin.
+type ClientCSIControllerCreateVolumeResponse struct {
+	// ExternalVolumeID is the ID by which the storage provider identifies
+	// the newly created volume. Nomad uses this, not Name, for all
+	// subsequent operations against the volume.
+	ExternalVolumeID string
+
+	// CapacityBytes is the actual capacity allocated by the storage
+	// provider, which may be larger than CapacityMinBytes.
+	CapacityBytes int64
+
+	// VolumeContext is opaque metadata returned by the storage provider
+	// that must be passed to subsequent controller and node operations on
+	// this volume.
+	VolumeContext map[string]string
+}
+
+// ClientCSIControllerDeleteVolumeRequest is the RPC made from the server to
+// a Nomad client to tell a CSI controller plugin on that client to reclaim
+// the storage consumed by a dynamically provisioned volume.
+type ClientCSIControllerDeleteVolumeRequest struct {
+	// The external ID of the volume to be deleted.
+	// This field is REQUIRED.
+	VolumeID string
+
+	// Secrets required by plugin to complete the controller delete volume
+	// request. This field is OPTIONAL.
+	Secrets structs.CSISecrets
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerDeleteVolumeRequest) ToCSIRequest() *csi.ControllerDeleteVolumeRequest {
+	if c == nil {
+		return &csi.ControllerDeleteVolumeRequest{}
+	}
+
+	return &csi.ControllerDeleteVolumeRequest{
+		ExternalVolumeID: c.VolumeID,
+		Secrets:          c.Secrets,
+	}
+}
+
+type ClientCSIControllerDeleteVolumeResponse struct{}
+
+// ClientCSIControllerExpandVolumeRequest is the RPC made from the server to
+// a Nomad client to tell a CSI controller plugin on that client to resize a
+// volume, which may still be attached to a node and in use.
+type ClientCSIControllerExpandVolumeRequest struct {
+	// The external ID of the volume to be expanded.
+	// This field is REQUIRED.
+	VolumeID string
+
+	// CapacityMinBytes is the new capacity the volume must be expanded to.
+	// This field is REQUIRED.
+	CapacityMinBytes int64
+
+	// Secrets required by plugin to complete the controller expand volume
+	// request. This field is OPTIONAL.
+	Secrets structs.CSISecrets
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerExpandVolumeRequest) ToCSIRequest() *csi.ControllerExpandVolumeRequest {
+	if c == nil {
+		return &csi.ControllerExpandVolumeRequest{}
+	}
+
+	return &csi.ControllerExpandVolumeRequest{
+		ExternalVolumeID: c.VolumeID,
+		CapacityRange:    &csi.CapacityRange{RequiredBytes: c.CapacityMinBytes},
+		Secrets:          c.Secrets,
+	}
+}
+
+// ClientCSIControllerExpandVolumeResponse is the result of resizing a
+// volume through a CSI controller plugin.
+type ClientCSIControllerExpandVolumeResponse struct {
+	// CapacityBytes is the actual capacity of the volume after expansion,
+	// which may be larger than CapacityMinBytes.
+	CapacityBytes int64
+
+	// NodeExpansionRequired indicates the CO must follow up with
+	// NodeExpandVolume on every node the volume is published to before the
+	// new capacity is usable.
+	NodeExpansionRequired bool
+}
+
+// ClientCSIControllerListVolumesRequest is the RPC made from the server to
+// a Nomad client to ask a CSI controller plugin on that client to list the
+// volumes it knows about, for reconciliation against Nomad's view.
+//
+// A MaxEntries of zero tells the client to follow NextToken internally
+// until every volume has been returned.
+type ClientCSIControllerListVolumesRequest struct {
+	MaxEntries    int32
+	StartingToken string
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerListVolumesRequest) ToCSIRequest() *csi.ControllerListVolumesRequest {
+	if c == nil {
+		return &csi.ControllerListVolumesRequest{}
+	}
+
+	return &csi.ControllerListVolumesRequest{
+		MaxEntries:    c.MaxEntries,
+		StartingToken: c.StartingToken,
+	}
+}
+
+// ClientCSIControllerListVolumesResponse is the result of listing the
+// volumes known to a CSI controller plugin. NextToken is empty once every
+// volume has been returned.
+type ClientCSIControllerListVolumesResponse struct {
+	Entries   []*csi.ControllerListVolumesResponseEntry
+	NextToken string
+}
+
+// ClientCSIControllerGetCapacityRequest is the RPC made from the server to a
+// Nomad client to ask a CSI controller plugin on that client how much
+// storage capacity remains available for volumes matching the (entirely
+// optional) filters, so that provisioning of large volumes can be made
+// capacity-aware.
+type ClientCSIControllerGetCapacityRequest struct {
+	Capabilities       *csi.VolumeCapability
+	Parameters         map[string]string
+	AccessibleTopology *csi.Topology
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerGetCapacityRequest) ToCSIRequest() *csi.ControllerGetCapacityRequest {
+	if c == nil {
+		return &csi.ControllerGetCapacityRequest{}
+	}
+
+	return &csi.ControllerGetCapacityRequest{
+		Capabilities:       c.Capabilities,
+		Parameters:         c.Parameters,
+		AccessibleTopology: c.AccessibleTopology,
+	}
+}
+
+// ClientCSIControllerGetCapacityResponse is the result of querying a CSI
+// controller plugin's remaining storage capacity.
+type ClientCSIControllerGetCapacityResponse struct {
+	AvailableCapacity int64
+}
 
 // ClientCSINodeDetachVolumeRequest is the RPC made from the server to
 // a Nomad client to tell a CSI node plugin on that client to perform
@@ -190,6 +500,151 @@ type ClientCSINodeDetachVolumeRequest struct {
 	AttachmentMode structs.CSIVolumeAttachmentMode
 	AccessMode     structs.CSIVolumeAccessMode
 	ReadOnly       bool
+
+	// TraceID correlates this request with the server-side CSIVolume.Unpublish
+	// RPC that triggered it, so that operators can cross-reference Nomad's
+	// logs with the CSI plugin's own logs for the same operation.
+	TraceID string
 }
 
 type ClientCSINodeDetachVolumeResponse struct{}
+
+// ClientCSINodeHasVolumeMountRequest is the RPC made from the server to a
+// Nomad client to ask whether a volume still has an active mount on that
+// client, so that the server can decide whether it's safe to detach the
+// volume at the controller.
+type ClientCSINodeHasVolumeMountRequest struct {
+	PluginID string // ID of the plugin that manages the volume (required)
+	VolumeID string // ID of the volume to check (required)
+	NodeID   string // ID of the Nomad client targeted
+}
+
+// ClientCSINodeHasVolumeMountResponse reports whether the client still has
+// an allocation using the volume.
+type ClientCSINodeHasVolumeMountResponse struct {
+	HasMount bool
+}
+
+// ClientCSIControllerCreateVolumeGroupSnapshotRequest is the RPC made from
+// the server to a Nomad client to ask a CSI controller plugin to snapshot a
+// set of volumes together as a named group.
+//
+// The CSI plugin transport Nomad speaks predates the CSI spec's
+// GroupControllerService extension, the only sanctioned way for a plugin to
+// guarantee crash-consistency across a set of volumes. Lacking that, this
+// RPC emulates a group snapshot by calling the controller's regular
+// single-volume CreateSnapshot once per member and tagging every resulting
+// snapshot with the same GroupSnapshotID so they can later be found and
+// restored together. Unlike a true group snapshot, the members are not
+// taken atomically: a failure partway through leaves earlier member
+// snapshots in place.
+type ClientCSIControllerCreateVolumeGroupSnapshotRequest struct {
+	// GroupSnapshotID names the group and is used to derive each member
+	// snapshot's idempotency name, so retrying with the same
+	// GroupSnapshotID and VolumeIDs is safe.
+	GroupSnapshotID string
+
+	// VolumeIDs lists the external IDs of the member volumes to snapshot.
+	// This field is REQUIRED and MUST be non-empty.
+	VolumeIDs []string
+
+	// Secrets required by plugin to complete the snapshot requests. This
+	// field is OPTIONAL.
+	Secrets structs.CSISecrets
+
+	// Parameters are opaque, plugin-specific parameters applied to every
+	// member snapshot. This field is OPTIONAL.
+	Parameters map[string]string
+
+	CSIControllerQuery
+}
+
+func (c *ClientCSIControllerCreateVolumeGroupSnapshotRequest) Validate() error {
+	if c.GroupSnapshotID == "" {
+		return fmt.Errorf("missing GroupSnapshotID")
+	}
+	if len(c.VolumeIDs) == 0 {
+		return fmt.Errorf("missing VolumeIDs")
+	}
+	return nil
+}
+
+// ClientCSIControllerCreateVolumeGroupSnapshotResponse is the result of a
+// successful group snapshot: the group's ID as supplied in the request, and
+// the external snapshot ID created for each member volume.
+type ClientCSIControllerCreateVolumeGroupSnapshotResponse struct {
+	GroupSnapshotID string
+	SnapshotIDs     map[string]string // member volume ID -> snapshot ID
+}
+
+// ClientCSIListInFlightRequest is the RPC made from the server to a Nomad
+// client to list the CSI operations currently running against plugins on
+// that client, so operators can see what's in-flight during an incident.
+type ClientCSIListInFlightRequest struct {
+	NodeID string // ID of the Nomad client targeted
+}
+
+// ClientCSIListInFlightResponse reports every CSI operation currently
+// running against a plugin on the client.
+type ClientCSIListInFlightResponse struct {
+	InFlight []CSIInFlightOperation
+}
+
+// CSIInFlightOperation describes a single CSI operation currently running
+// against a plugin on a Nomad client.
+type CSIInFlightOperation struct {
+	Op        string // e.g. "ControllerAttachVolume", "NodeDetachVolume"
+	VolumeID  string
+	PluginID  string
+	NodeID    string // ID of the Nomad client the op targets
+	StartTime time.Time
+}
+
+// ClientCSIRefreshPluginCapabilitiesRequest is the RPC made from the server
+// to a Nomad client to re-query a plugin's capabilities immediately instead
+// of waiting for the next periodic fingerprint, so that operators can pick
+// up a plugin upgrade's new capabilities without re-registering it.
+type ClientCSIRefreshPluginCapabilitiesRequest struct {
+	PluginID string // ID of the plugin to refresh (required)
+	Type     string // dynamicplugins.PluginTypeCSIController or PluginTypeCSINode (required)
+	NodeID   string // ID of the Nomad client targeted
+}
+
+// ClientCSIControllerProbeRequest is the RPC made from the server to a
+// Nomad client to check a CSI controller plugin's liveness via its Probe
+// RPC, so operators can poll plugin health from their own dashboards.
+type ClientCSIControllerProbeRequest struct {
+	CSIControllerQuery
+}
+
+// ClientCSIControllerProbeResponse reports whether the plugin's Probe RPC
+// considers it ready, and when Nomad observed that.
+type ClientCSIControllerProbeResponse struct {
+	Ready bool
+	Time  time.Time
+}
+
+// ClientCSIRefreshPluginCapabilitiesResponse returns the plugin's
+// fingerprint as observed by the refresh, including its up-to-date
+// capabilities.
+type ClientCSIRefreshPluginCapabilitiesResponse struct {
+	CSIInfo *structs.CSIInfo
+}
+
+// ClientCSIControllerGetCapabilitiesRequest is the RPC made from the server
+// to a Nomad client to query a CSI controller plugin's capabilities, so
+// schedulers can check whether an operation (CreateVolume, Snapshot,
+// Expand, ...) is supported before attempting it.
+type ClientCSIControllerGetCapabilitiesRequest struct {
+	CSIControllerQuery
+}
+
+// ClientCSIControllerGetCapabilitiesResponse reports which controller
+// operations the plugin supports.
+type ClientCSIControllerGetCapabilitiesResponse struct {
+	SupportsCreateDelete bool
+	SupportsPublish      bool
+	SupportsSnapshot     bool
+	SupportsExpand       bool
+	SupportsListVolumes  bool
+}
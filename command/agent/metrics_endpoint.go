@@ -6,24 +6,35 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
 )
 
 var (
-	// Only create the prometheus handler once
-	promHandler http.Handler
-	promOnce    sync.Once
+	// Only create the prometheus and openmetrics handlers once
+	promHandler        http.Handler
+	promOnce           sync.Once
+	openMetricsHandler http.Handler
+	openMetricsOnce    sync.Once
 )
 
-// MetricsRequest returns metrics for the agent. Metrics are JSON by default
-// but Prometheus is an optional format.
+// MetricsRequest returns metrics for the agent. Metrics are JSON by default,
+// with Prometheus and OpenMetrics available as optional formats.
 func (s *HTTPServer) MetricsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	if req.Method != "GET" {
 		return nil, CodedError(405, ErrInvalidMethod)
 	}
 
-	if format := req.URL.Query().Get("format"); format == "prometheus" {
+	switch format := req.URL.Query().Get("format"); format {
+	case "prometheus":
 		s.prometheusHandler().ServeHTTP(resp, req)
 		return nil, nil
+	case "openmetrics":
+		// promhttp only emits OpenMetrics framing if the request negotiates
+		// for it via Accept; force that negotiation so ?format=openmetrics
+		// behaves like the explicit opt-in that ?format=prometheus is.
+		req.Header.Set("Accept", string(expfmt.FmtOpenMetrics))
+		s.openMetricsHandler().ServeHTTP(resp, req)
+		return nil, nil
 	}
 
 	return s.agent.InmemSink.DisplayMetrics(resp, req)
@@ -41,3 +52,25 @@ func (s *HTTPServer) prometheusHandler() http.Handler {
 	})
 	return promHandler
 }
+
+// openMetricsHandler returns the same metric families as prometheusHandler,
+// but encoded per the OpenMetrics exposition format (served as
+// application/openmetrics-text, with the "# EOF" terminator and
+// OpenMetrics-specific type annotations) for
+// scrapers that require it rather than the classic Prometheus text format.
+// It reuses the same underlying registry, so any counter, histogram, or
+// gauge already emitted via go-metrics (including the nomad.plan.* family)
+// is surfaced automatically with no separate wiring.
+func (s *HTTPServer) openMetricsHandler() http.Handler {
+	openMetricsOnce.Do(func() {
+		handlerOptions := promhttp.HandlerOpts{
+			ErrorLog:           s.logger.Named("openmetrics_handler").StandardLogger(nil),
+			ErrorHandling:      promhttp.ContinueOnError,
+			DisableCompression: true,
+			EnableOpenMetrics:  true,
+		}
+
+		openMetricsHandler = promhttp.HandlerFor(prometheus.DefaultGatherer, handlerOptions)
+	})
+	return openMetricsHandler
+}
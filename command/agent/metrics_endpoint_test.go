@@ -62,6 +62,25 @@ func TestHTTP_Metrics(t *testing.T) {
 	})
 }
 
+// TestHTTP_Metrics_OpenMetrics asserts that format=openmetrics returns the
+// same underlying metric families as format=prometheus, but encoded with
+// the OpenMetrics-specific "# EOF" terminator and content type.
+func TestHTTP_Metrics_OpenMetrics(t *testing.T) {
+	t.Parallel()
+	httpTest(t, nil, func(s *TestAgent) {
+		req, err := http.NewRequest("GET", "/v1/metrics?format=openmetrics", nil)
+		require.NoError(t, err)
+		respW := httptest.NewRecorder()
+
+		_, err = s.Server.MetricsRequest(respW, req)
+		require.NoError(t, err)
+
+		contentType := respW.Header().Get("Content-Type")
+		require.Contains(t, contentType, "application/openmetrics-text")
+		require.True(t, strings.HasSuffix(strings.TrimSpace(respW.Body.String()), "# EOF"))
+	})
+}
+
 // When emitting metrics, the client should use the local copy of the allocs with
 // updated task states (not the copy submitted by the server).
 //
@@ -0,0 +1,53 @@
+// Package metrics wraps github.com/armon/go-metrics emission calls so that a
+// misbehaving or unavailable metrics sink can never affect the control path
+// of the caller. Sink implementations are plugins configured by operators,
+// so a panic inside one (for example, a buggy third-party statsd client)
+// shouldn't be able to take down an RPC or the scheduler's plan-apply loop.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	log "github.com/hashicorp/go-hclog"
+)
+
+// loggedOnce ensures a noisy sink only produces one log line per process,
+// rather than flooding the log every time a metric is emitted.
+var loggedOnce sync.Once
+
+// MeasureSince is a panic-safe wrapper around metrics.MeasureSince. logger
+// may be nil, in which case a sink failure is swallowed silently.
+func MeasureSince(key []string, start time.Time, logger log.Logger) {
+	defer recoverEmit(logger)
+	metrics.MeasureSince(key, start)
+}
+
+// IncrCounterWithLabels is a panic-safe wrapper around
+// metrics.IncrCounterWithLabels. logger may be nil, in which case a sink
+// failure is swallowed silently.
+func IncrCounterWithLabels(key []string, val float32, labels []metrics.Label, logger log.Logger) {
+	defer recoverEmit(logger)
+	metrics.IncrCounterWithLabels(key, val, labels)
+}
+
+// IncrCounter is a panic-safe wrapper around metrics.IncrCounter. logger may
+// be nil, in which case a sink failure is swallowed silently.
+func IncrCounter(key []string, val float32, logger log.Logger) {
+	defer recoverEmit(logger)
+	metrics.IncrCounter(key, val)
+}
+
+// recoverEmit recovers a panic from the underlying metrics sink and logs it
+// at most once per process, so a persistently broken sink can't turn into a
+// logging storm.
+func recoverEmit(logger log.Logger) {
+	if r := recover(); r != nil {
+		loggedOnce.Do(func() {
+			if logger != nil {
+				logger.Error("metrics sink panicked while emitting a metric; suppressing further occurrences", "error", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	gometrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/nomad/helper/testlog"
+	"github.com/stretchr/testify/require"
+)
+
+// panicSink simulates a broken metrics sink implementation, such as a
+// third-party client that can't reach its collector.
+type panicSink struct{}
+
+func (panicSink) SetGauge(key []string, val float32)                                     {}
+func (panicSink) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {}
+func (panicSink) EmitKey(key []string, val float32)                                      {}
+func (panicSink) IncrCounter(key []string, val float32)                                  {}
+func (panicSink) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	panic("sink unavailable")
+}
+func (panicSink) AddSample(key []string, val float32) {}
+
+// AddSampleWithLabels is where go-metrics' MeasureSince lands on the sink.
+func (panicSink) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	panic("sink unavailable")
+}
+func (panicSink) MeasureSince(key []string, start time.Time) {
+	panic("sink unavailable")
+}
+func (panicSink) MeasureSinceWithLabels(key []string, start time.Time, labels []gometrics.Label) {}
+
+// withPanicSink installs a global sink that panics on every labeled counter
+// and every sample (which is what MeasureSince emits) for the duration of
+// the test, restoring the default sink afterward. Runtime metrics are
+// disabled so that the sink's panics only fire on the test's own emits.
+func withPanicSink(t *testing.T) {
+	t.Helper()
+	conf := gometrics.DefaultConfig("metrics_test")
+	conf.EnableRuntimeMetrics = false
+	_, err := gometrics.NewGlobal(conf, panicSink{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := gometrics.NewGlobal(gometrics.DefaultConfig("metrics_test"), &gometrics.BlackholeSink{})
+		require.NoError(t, err)
+	})
+}
+
+func TestMeasureSince_SurvivesPanickingSink(t *testing.T) {
+	withPanicSink(t)
+
+	require.NotPanics(t, func() {
+		MeasureSince([]string{"test", "measure"}, time.Now(), testlog.HCLogger(t))
+	})
+}
+
+func TestIncrCounterWithLabels_SurvivesPanickingSink(t *testing.T) {
+	withPanicSink(t)
+
+	require.NotPanics(t, func() {
+		IncrCounterWithLabels([]string{"test", "counter"}, 1, []gometrics.Label{{Name: "type", Value: "test"}}, testlog.HCLogger(t))
+	})
+}
+
+func TestRecoverEmit_NilLoggerIsSafe(t *testing.T) {
+	withPanicSink(t)
+
+	require.NotPanics(t, func() {
+		MeasureSince([]string{"test", "measure"}, time.Now(), nil)
+	})
+}
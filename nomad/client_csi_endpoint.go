@@ -3,6 +3,7 @@ package nomad
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 	memdb "github.com/hashicorp/go-memdb"
 	cstructs "github.com/hashicorp/nomad/client/structs"
+	"github.com/hashicorp/nomad/nomad/structs"
 )
 
 // ClientCSI is used to forward RPC requests to the targed Nomad client's
@@ -22,7 +24,7 @@ type ClientCSI struct {
 func (a *ClientCSI) ControllerAttachVolume(args *cstructs.ClientCSIControllerAttachVolumeRequest, reply *cstructs.ClientCSIControllerAttachVolumeResponse) error {
 	defer metrics.MeasureSince([]string{"nomad", "client_csi_controller", "attach_volume"}, time.Now())
 
-	clientIDs, err := a.clientIDsForController(args.PluginID)
+	clientIDs, err := a.clientIDsForController(args.PluginID, args.AccessibleTopology)
 	if err != nil {
 		return fmt.Errorf("controller attach volume: %v", err)
 	}
@@ -44,15 +46,17 @@ func (a *ClientCSI) ControllerAttachVolume(args *cstructs.ClientCSIControllerAtt
 				"nodeID", clientID, "err", err)
 			continue
 		}
+		a.srv.csiVolumeFailures.Record(clientID, args.PluginID, "attach", err)
 		return fmt.Errorf("controller attach volume: %v", err)
 	}
+	a.srv.csiVolumeFailures.Record(args.ControllerNodeID, args.PluginID, "attach", err)
 	return fmt.Errorf("controller attach volume: %v", err)
 }
 
 func (a *ClientCSI) ControllerValidateVolume(args *cstructs.ClientCSIControllerValidateVolumeRequest, reply *cstructs.ClientCSIControllerValidateVolumeResponse) error {
 	defer metrics.MeasureSince([]string{"nomad", "client_csi_controller", "validate_volume"}, time.Now())
 
-	clientIDs, err := a.clientIDsForController(args.PluginID)
+	clientIDs, err := a.clientIDsForController(args.PluginID, args.AccessibleTopology)
 	if err != nil {
 		return fmt.Errorf("validate volume: %v", err)
 	}
@@ -82,7 +86,7 @@ func (a *ClientCSI) ControllerValidateVolume(args *cstructs.ClientCSIControllerV
 func (a *ClientCSI) ControllerDetachVolume(args *cstructs.ClientCSIControllerDetachVolumeRequest, reply *cstructs.ClientCSIControllerDetachVolumeResponse) error {
 	defer metrics.MeasureSince([]string{"nomad", "client_csi_controller", "detach_volume"}, time.Now())
 
-	clientIDs, err := a.clientIDsForController(args.PluginID)
+	clientIDs, err := a.clientIDsForController(args.PluginID, args.AccessibleTopology)
 	if err != nil {
 		return fmt.Errorf("controller detach volume: %v", err)
 	}
@@ -97,6 +101,7 @@ func (a *ClientCSI) ControllerDetachVolume(args *cstructs.ClientCSIControllerDet
 
 		err = NodeRpc(state.Session, "CSI.ControllerDetachVolume", args, reply)
 		if err == nil {
+			a.cleanupNodeStaging(args)
 			return nil
 		}
 		if a.isRetryable(err) {
@@ -104,11 +109,64 @@ func (a *ClientCSI) ControllerDetachVolume(args *cstructs.ClientCSIControllerDet
 				"nodeID", clientID, "err", err)
 			continue
 		}
+		a.srv.csiVolumeFailures.Record(clientID, args.PluginID, "detach", err)
 		return fmt.Errorf("controller detach volume: %v", err)
 	}
+	a.srv.csiVolumeFailures.Record(args.ControllerNodeID, args.PluginID, "detach", err)
 	return fmt.Errorf("controller detach volume: %v", err)
 }
 
+// CreateVolumeGroupSnapshot forwards a request to snapshot a set of volumes
+// under a shared group identifier to a client running the given plugin's
+// controller. See ClientCSIControllerCreateVolumeGroupSnapshotRequest for
+// the limitations of this emulated group snapshot.
+func (a *ClientCSI) CreateVolumeGroupSnapshot(args *cstructs.ClientCSIControllerCreateVolumeGroupSnapshotRequest, reply *cstructs.ClientCSIControllerCreateVolumeGroupSnapshotResponse) error {
+	defer metrics.MeasureSince([]string{"nomad", "client_csi_controller", "create_volume_group_snapshot"}, time.Now())
+
+	clientIDs, err := a.clientIDsForController(args.PluginID, args.AccessibleTopology)
+	if err != nil {
+		return fmt.Errorf("create volume group snapshot: %v", err)
+	}
+
+	for _, clientID := range clientIDs {
+		args.ControllerNodeID = clientID
+		state, ok := a.srv.getNodeConn(clientID)
+		if !ok {
+			return findNodeConnAndForward(a.srv,
+				clientID, "ClientCSI.CreateVolumeGroupSnapshot", args, reply)
+		}
+
+		err = NodeRpc(state.Session, "CSI.CreateVolumeGroupSnapshot", args, reply)
+		if err == nil {
+			return nil
+		}
+		if a.isRetryable(err) {
+			a.logger.Debug("failed to reach controller on client",
+				"nodeID", clientID, "err", err)
+			continue
+		}
+		return fmt.Errorf("create volume group snapshot: %v", err)
+	}
+	return fmt.Errorf("create volume group snapshot: %v", err)
+}
+
+// cleanupNodeStaging performs the best-effort node-side cleanup requested
+// via args.NodeCleanup once a controller unpublish has already succeeded.
+// Errors, including the node being unreachable, are logged rather than
+// returned: the volume is already detached from the controller's point of
+// view, so there's nothing left here for the caller to retry.
+func (a *ClientCSI) cleanupNodeStaging(args *cstructs.ClientCSIControllerDetachVolumeRequest) {
+	if args.NodeCleanup == nil {
+		return
+	}
+
+	var resp cstructs.ClientCSINodeDetachVolumeResponse
+	if err := a.NodeDetachVolume(args.NodeCleanup, &resp); err != nil {
+		a.logger.Warn("failed to clean up node staging after controller detach",
+			"volume_id", args.NodeCleanup.VolumeID, "node_id", args.NodeCleanup.NodeID, "err", err)
+	}
+}
+
 // we can retry the same RPC on a different controller in the cases where the
 // client has stopped and been GC'd, or where the controller has stopped but
 // we don't have the fingerprint update yet
@@ -148,9 +206,106 @@ func (a *ClientCSI) NodeDetachVolume(args *cstructs.ClientCSINodeDetachVolumeReq
 
 }
 
-// clientIDsForController returns a shuffled list of client IDs where the
-// controller plugin is expected to be running.
-func (a *ClientCSI) clientIDsForController(pluginID string) ([]string, error) {
+// NodeHasVolumeMount asks a Nomad client whether it still has an allocation
+// claiming the given volume.
+func (a *ClientCSI) NodeHasVolumeMount(args *cstructs.ClientCSINodeHasVolumeMountRequest, reply *cstructs.ClientCSINodeHasVolumeMountResponse) error {
+	defer metrics.MeasureSince([]string{"nomad", "client_csi_node", "has_volume_mount"}, time.Now())
+
+	// Make sure Node is valid and new enough to support RPC
+	snap, err := a.srv.State().Snapshot()
+	if err != nil {
+		return err
+	}
+
+	_, err = getNodeForRpc(snap, args.NodeID)
+	if err != nil {
+		return err
+	}
+
+	// Get the connection to the client
+	state, ok := a.srv.getNodeConn(args.NodeID)
+	if !ok {
+		return findNodeConnAndForward(a.srv, args.NodeID, "ClientCSI.NodeHasVolumeMount", args, reply)
+	}
+
+	// Make the RPC
+	err = NodeRpc(state.Session, "CSI.NodeHasVolumeMount", args, reply)
+	if err != nil {
+		return fmt.Errorf("node has volume mount: %v", err)
+	}
+	return nil
+}
+
+// RefreshPluginCapabilities asks a Nomad client to re-query a plugin's
+// capabilities immediately, instead of waiting for the next periodic
+// fingerprint, so that operators can pick up a plugin upgrade without
+// re-registering it.
+func (a *ClientCSI) RefreshPluginCapabilities(args *cstructs.ClientCSIRefreshPluginCapabilitiesRequest, reply *cstructs.ClientCSIRefreshPluginCapabilitiesResponse) error {
+	defer metrics.MeasureSince([]string{"nomad", "client_csi", "refresh_plugin_capabilities"}, time.Now())
+
+	// Make sure Node is valid and new enough to support RPC
+	snap, err := a.srv.State().Snapshot()
+	if err != nil {
+		return err
+	}
+
+	_, err = getNodeForRpc(snap, args.NodeID)
+	if err != nil {
+		return err
+	}
+
+	// Get the connection to the client
+	state, ok := a.srv.getNodeConn(args.NodeID)
+	if !ok {
+		return findNodeConnAndForward(a.srv, args.NodeID, "ClientCSI.RefreshPluginCapabilities", args, reply)
+	}
+
+	// Make the RPC
+	err = NodeRpc(state.Session, "CSI.RefreshPluginCapabilities", args, reply)
+	if err != nil {
+		return fmt.Errorf("refresh plugin capabilities: %v", err)
+	}
+	return nil
+}
+
+// ListInFlight asks a Nomad client for the CSI operations currently running
+// against plugins on that client, for use during incident response.
+func (a *ClientCSI) ListInFlight(args *cstructs.ClientCSIListInFlightRequest, reply *cstructs.ClientCSIListInFlightResponse) error {
+	defer metrics.MeasureSince([]string{"nomad", "client_csi", "list_in_flight"}, time.Now())
+
+	// Make sure Node is valid and new enough to support RPC
+	snap, err := a.srv.State().Snapshot()
+	if err != nil {
+		return err
+	}
+
+	_, err = getNodeForRpc(snap, args.NodeID)
+	if err != nil {
+		return err
+	}
+
+	// Get the connection to the client
+	state, ok := a.srv.getNodeConn(args.NodeID)
+	if !ok {
+		return findNodeConnAndForward(a.srv, args.NodeID, "ClientCSI.ListInFlight", args, reply)
+	}
+
+	// Make the RPC
+	err = NodeRpc(state.Session, "CSI.ListInFlight", args, reply)
+	if err != nil {
+		return fmt.Errorf("list in-flight CSI operations: %v", err)
+	}
+	return nil
+}
+
+// clientIDsForController returns a list of client IDs where the controller
+// plugin is expected to be running, ordered with instances whose
+// registration topology matches one of accessibleTopology first, then the
+// healthiest and most recently fingerprinted instances, so that callers
+// retrying down the list hit a flaky or out-of-zone instance last rather
+// than first. accessibleTopology may be nil, in which case topology is
+// ignored and any healthy instance is preferred equally.
+func (a *ClientCSI) clientIDsForController(pluginID string, accessibleTopology []*structs.CSITopology) ([]string, error) {
 
 	snap, err := a.srv.State().Snapshot()
 	if err != nil {
@@ -176,8 +331,13 @@ func (a *ClientCSI) clientIDsForController(pluginID string) ([]string, error) {
 
 	// iterating maps is "random" but unspecified and isn't particularly
 	// random with small maps, so not well-suited for load balancing.
-	// so we shuffle the keys and iterate over them.
-	clientIDs := []string{}
+	// so we shuffle the candidates before ranking them by health.
+	type controllerCandidate struct {
+		clientID     string
+		info         *structs.CSIInfo
+		topologyTier int
+	}
+	candidates := []controllerCandidate{}
 
 	for clientID, controller := range plugin.Controllers {
 		if !controller.IsController() {
@@ -189,16 +349,74 @@ func (a *ClientCSI) clientIDsForController(pluginID string) ([]string, error) {
 		}
 		node, err := getNodeForRpc(snap, clientID)
 		if err == nil && node != nil && node.Ready() {
-			clientIDs = append(clientIDs, clientID)
+			candidates = append(candidates, controllerCandidate{
+				clientID:     clientID,
+				info:         controller,
+				topologyTier: csiTopologyTier(controller.ControllerInfo.AccessibleTopology, accessibleTopology),
+			})
 		}
 	}
-	if len(clientIDs) == 0 {
+	if len(candidates) == 0 {
 		return nil, fmt.Errorf("failed to find clients running controller plugin %q", pluginID)
 	}
 
-	rand.Shuffle(len(clientIDs), func(i, j int) {
-		clientIDs[i], clientIDs[j] = clientIDs[j], clientIDs[i]
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	// prefer controllers registered in a matching zone, then controllers
+	// with no registered topology (unknown, so usable as a fallback), then
+	// healthy controllers, and among controllers with the same health,
+	// prefer the one most recently fingerprinted. the shuffle above keeps
+	// otherwise-tied candidates load balanced.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].topologyTier != candidates[j].topologyTier {
+			return candidates[i].topologyTier < candidates[j].topologyTier
+		}
+		if candidates[i].info.Healthy != candidates[j].info.Healthy {
+			return candidates[i].info.Healthy
+		}
+		return candidates[i].info.UpdateTime.After(candidates[j].info.UpdateTime)
 	})
 
+	clientIDs := make([]string, len(candidates))
+	for i, c := range candidates {
+		clientIDs[i] = c.clientID
+	}
+
 	return clientIDs, nil
 }
+
+// csiTopologyTier ranks a controller plugin instance's registration
+// topology against a volume's requested topologies, lower is preferred:
+//
+//	0: topology unconstrained (no requested topologies), or the controller
+//	   matches one of the requested topologies
+//	1: the controller has no registered topology, so it's not known to be
+//	   outside the requested zone(s); usable as a fallback
+//	2: the controller has a registered topology that matches none of the
+//	   requested topologies
+func csiTopologyTier(controllerTopology *structs.CSITopology, requestedTopologies []*structs.CSITopology) int {
+	if len(requestedTopologies) == 0 {
+		return 0
+	}
+	if controllerTopology == nil {
+		return 1
+	}
+	for _, requested := range requestedTopologies {
+		if requested == nil {
+			continue
+		}
+		matches := true
+		for k, v := range requested.Segments {
+			if controllerTopology.Segments[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return 0
+		}
+	}
+	return 2
+}
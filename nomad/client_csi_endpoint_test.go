@@ -82,6 +82,123 @@ func TestClientCSIController_DetachVolume_Forwarded(t *testing.T) {
 	require.Contains(err.Error(), "no plugins registered for type")
 }
 
+func TestClientCSIController_CreateVolumeGroupSnapshot_Local(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	codec, cleanup := setupLocal(t)
+	defer cleanup()
+
+	req := &cstructs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
+		CSIControllerQuery: cstructs.CSIControllerQuery{PluginID: "minnie"},
+		GroupSnapshotID:    "group1",
+		VolumeIDs:          []string{"vol1"},
+	}
+
+	var resp structs.GenericResponse
+	err := msgpackrpc.CallWithCodec(codec, "ClientCSI.CreateVolumeGroupSnapshot", req, &resp)
+	require.NotNil(err)
+	require.Contains(err.Error(), "no plugins registered for type")
+}
+
+func TestClientCSIController_CreateVolumeGroupSnapshot_Forwarded(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	codec, cleanup := setupForward(t)
+	defer cleanup()
+
+	req := &cstructs.ClientCSIControllerCreateVolumeGroupSnapshotRequest{
+		CSIControllerQuery: cstructs.CSIControllerQuery{PluginID: "minnie"},
+		GroupSnapshotID:    "group1",
+		VolumeIDs:          []string{"vol1"},
+	}
+
+	var resp structs.GenericResponse
+	err := msgpackrpc.CallWithCodec(codec, "ClientCSI.CreateVolumeGroupSnapshot", req, &resp)
+	require.NotNil(err)
+	require.Contains(err.Error(), "no plugins registered for type")
+}
+
+func TestClientCSIController_DetachVolume_CleanupNodeStaging(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	testutil.WaitForLeader(t, srv.RPC)
+	defer shutdown()
+
+	// node is never registered, so the node-side cleanup call fails fast
+	// with an unknown-node error instead of hanging on a connection
+	// attempt. cleanupNodeStaging should swallow that error.
+	args := &cstructs.ClientCSIControllerDetachVolumeRequest{
+		VolumeID: "test-vol",
+		NodeCleanup: &cstructs.ClientCSINodeDetachVolumeRequest{
+			PluginID:   "minnie",
+			VolumeID:   "test-vol",
+			ExternalID: "test-vol",
+			NodeID:     uuid.Generate(),
+		},
+	}
+
+	require.NotPanics(t, func() {
+		srv.staticEndpoints.ClientCSI.cleanupNodeStaging(args)
+	})
+}
+
+func TestClientCSIController_DetachVolume_CleanupNodeStaging_NotRequested(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	testutil.WaitForLeader(t, srv.RPC)
+	defer shutdown()
+
+	// NodeCleanup unset: cleanupNodeStaging is a no-op and must not dial
+	// out to any node.
+	require.NotPanics(t, func() {
+		srv.staticEndpoints.ClientCSI.cleanupNodeStaging(&cstructs.ClientCSIControllerDetachVolumeRequest{})
+	})
+}
+
+func TestClientCSIController_AttachVolume_RecordsFailure(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	codec, srv, nodeID, cleanup := setupLocalWithServer(t)
+	defer cleanup()
+
+	req := &cstructs.ClientCSIControllerAttachVolumeRequest{
+		CSIControllerQuery: cstructs.CSIControllerQuery{PluginID: "minnie"},
+	}
+
+	var resp structs.GenericResponse
+	err := msgpackrpc.CallWithCodec(codec, "ClientCSI.ControllerAttachVolume", req, &resp)
+	require.NotNil(err)
+	require.Contains(err.Error(), "no plugins registered for type")
+
+	failure, ok := srv.CSIVolumeFailures().Get(nodeID, "minnie")
+	require.True(ok)
+	require.Equal("minnie", failure.PluginID)
+	require.Equal("attach", failure.Operation)
+	require.Contains(failure.Reason, "no plugins registered for type")
+}
+
+func TestClientCSIController_DetachVolume_RecordsFailure(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	codec, srv, nodeID, cleanup := setupLocalWithServer(t)
+	defer cleanup()
+
+	req := &cstructs.ClientCSIControllerDetachVolumeRequest{
+		CSIControllerQuery: cstructs.CSIControllerQuery{PluginID: "minnie"},
+	}
+
+	var resp structs.GenericResponse
+	err := msgpackrpc.CallWithCodec(codec, "ClientCSI.ControllerDetachVolume", req, &resp)
+	require.NotNil(err)
+	require.Contains(err.Error(), "no plugins registered for type")
+
+	failure, ok := srv.CSIVolumeFailures().Get(nodeID, "minnie")
+	require.True(ok)
+	require.Equal("minnie", failure.PluginID)
+	require.Equal("detach", failure.Operation)
+	require.Contains(failure.Reason, "no plugins registered for type")
+}
+
 func TestClientCSIController_ValidateVolume_Local(t *testing.T) {
 	t.Parallel()
 	require := require.New(t)
@@ -152,13 +269,133 @@ func TestClientCSI_NodeForControllerPlugin(t *testing.T) {
 
 	plugin, err := state.CSIPluginByID(ws, "minnie")
 	require.NoError(t, err)
-	nodeIDs, err := srv.staticEndpoints.ClientCSI.clientIDsForController(plugin.ID)
+	nodeIDs, err := srv.staticEndpoints.ClientCSI.clientIDsForController(plugin.ID, nil)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(nodeIDs))
 	// only node1 has both the controller and a recent Nomad version
 	require.Equal(t, nodeIDs[0], node1.ID)
 }
 
+func TestClientCSI_NodeForControllerPlugin_PrefersHealthy(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	testutil.WaitForLeader(t, srv.RPC)
+	defer shutdown()
+
+	state := srv.fsm.State()
+
+	// node1's controller instance flaps from healthy to unhealthy after its
+	// most recent fingerprint; node2's is healthy and older. The healthy
+	// instance should still be preferred even though node1's probe is
+	// newer.
+	node1 := mock.Node()
+	node1.Attributes["nomad.version"] = "0.11.0" // client RPCs not supported on early versions
+	node1.CSIControllerPlugins = map[string]*structs.CSIInfo{
+		"minnie": {
+			PluginID:       "minnie",
+			Healthy:        true,
+			ControllerInfo: &structs.CSIControllerInfo{},
+			NodeInfo:       &structs.CSINodeInfo{},
+			UpdateTime:     time.Now().Add(-2 * time.Hour),
+		},
+	}
+	node2 := mock.Node()
+	node2.ID = uuid.Generate()
+	node2.Attributes["nomad.version"] = "0.11.0" // client RPCs not supported on early versions
+	node2.CSIControllerPlugins = map[string]*structs.CSIInfo{
+		"minnie": {
+			PluginID:       "minnie",
+			Healthy:        true,
+			ControllerInfo: &structs.CSIControllerInfo{},
+			NodeInfo:       &structs.CSINodeInfo{},
+			UpdateTime:     time.Now().Add(-1 * time.Hour),
+		},
+	}
+
+	err := state.UpsertNode(structs.MsgTypeTestSetup, 1002, node1)
+	require.NoError(t, err)
+	err = state.UpsertNode(structs.MsgTypeTestSetup, 1003, node2)
+	require.NoError(t, err)
+
+	// node1's instance goes unhealthy on a later fingerprint
+	node1 = node1.Copy()
+	node1.CSIControllerPlugins["minnie"].Healthy = false
+	node1.CSIControllerPlugins["minnie"].UpdateTime = time.Now()
+	err = state.UpsertNode(structs.MsgTypeTestSetup, 1004, node1)
+	require.NoError(t, err)
+
+	ws := memdb.NewWatchSet()
+
+	plugin, err := state.CSIPluginByID(ws, "minnie")
+	require.NoError(t, err)
+	nodeIDs, err := srv.staticEndpoints.ClientCSI.clientIDsForController(plugin.ID, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(nodeIDs))
+	require.Equal(t, node2.ID, nodeIDs[0])
+}
+
+func TestClientCSI_NodeForControllerPlugin_Topology(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	testutil.WaitForLeader(t, srv.RPC)
+	defer shutdown()
+
+	state := srv.fsm.State()
+
+	// node1's controller is registered in zone "us-east-1a"; node2's is
+	// registered in "us-east-1b"; node3's controller didn't report a
+	// topology at all (non-zonal deployment).
+	mkNode := func(zone string) *structs.Node {
+		node := mock.Node()
+		node.ID = uuid.Generate()
+		node.Attributes["nomad.version"] = "0.11.0" // client RPCs not supported on early versions
+		var topology *structs.CSITopology
+		if zone != "" {
+			topology = &structs.CSITopology{Segments: map[string]string{"zone": zone}}
+		}
+		node.CSIControllerPlugins = map[string]*structs.CSIInfo{
+			"minnie": {
+				PluginID: "minnie",
+				Healthy:  true,
+				ControllerInfo: &structs.CSIControllerInfo{
+					AccessibleTopology: topology,
+				},
+				NodeInfo: &structs.CSINodeInfo{},
+			},
+		}
+		return node
+	}
+
+	node1 := mkNode("us-east-1a")
+	node2 := mkNode("us-east-1b")
+	node3 := mkNode("")
+
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1002, node1))
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1003, node2))
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1004, node3))
+
+	ws := memdb.NewWatchSet()
+	plugin, err := state.CSIPluginByID(ws, "minnie")
+	require.NoError(t, err)
+
+	requested := []*structs.CSITopology{{Segments: map[string]string{"zone": "us-east-1a"}}}
+	nodeIDs, err := srv.staticEndpoints.ClientCSI.clientIDsForController(plugin.ID, requested)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(nodeIDs))
+	// the zone-matched controller is preferred...
+	require.Equal(t, node1.ID, nodeIDs[0])
+	// ...and the controller with an unknown (no) topology is a fallback
+	// ahead of the one we know is in the wrong zone.
+	require.Equal(t, node3.ID, nodeIDs[1])
+	require.Equal(t, node2.ID, nodeIDs[2])
+
+	// with no requested topology, zone is irrelevant and all candidates
+	// are equally eligible.
+	nodeIDs, err = srv.staticEndpoints.ClientCSI.clientIDsForController(plugin.ID, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(nodeIDs))
+}
+
 // sets up a pair of servers, each with one client, and registers a plugin to the clients.
 // returns a RPC client to the leader and a cleanup function.
 func setupForward(t *testing.T) (rpc.ClientCodec, func()) {
@@ -280,6 +517,56 @@ func setupLocal(t *testing.T) (rpc.ClientCodec, func()) {
 	return codec, cleanup
 }
 
+// setupLocalWithServer is identical to setupLocal, but also returns the
+// server and the registered client's node ID so that tests can inspect
+// server-side state (such as the CSI volume failure tracker) after making
+// an RPC call.
+func setupLocalWithServer(t *testing.T) (rpc.ClientCodec, *Server, string, func()) {
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) { c.BootstrapExpect = 1 })
+
+	testutil.WaitForLeader(t, s1.RPC)
+	codec := rpcClient(t, s1)
+
+	c1, cleanupC1 := client.TestClient(t, func(c *config.Config) {
+		c.Servers = []string{s1.config.RPCAddr.String()}
+	})
+
+	// Wait for client initialization
+	select {
+	case <-c1.Ready():
+	case <-time.After(10 * time.Second):
+		cleanupS1()
+		cleanupC1()
+		t.Fatal("client timedout on initialize")
+	}
+
+	waitForNodes(t, s1, 1, 1)
+
+	plugins := map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie",
+			Healthy:                  true,
+			ControllerInfo:           &structs.CSIControllerInfo{},
+			NodeInfo:                 &structs.CSINodeInfo{},
+			RequiresControllerPlugin: true,
+		},
+	}
+
+	// update w/ plugin
+	node1 := c1.Node()
+	node1.Attributes["nomad.version"] = "0.11.0" // client RPCs not supported on early versions
+	node1.CSIControllerPlugins = plugins
+
+	s1.fsm.state.UpsertNode(structs.MsgTypeTestSetup, 1000, node1)
+
+	cleanup := func() {
+		cleanupS1()
+		cleanupC1()
+	}
+
+	return codec, s1, node1.ID, cleanup
+}
+
 // waitForNodes waits until the server is connected to connectedNodes
 // clients and totalNodes clients are in the state store
 func waitForNodes(t *testing.T, s *Server, connectedNodes, totalNodes int) {
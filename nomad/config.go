@@ -24,6 +24,11 @@ const (
 	DefaultRegion   = "global"
 	DefaultDC       = "dc1"
 	DefaultSerfPort = 4648
+
+	// PlanEvaluationTimeoutDefault is the default value for
+	// Config.PlanEvaluationTimeout, used when a Config doesn't set one
+	// explicitly.
+	PlanEvaluationTimeoutDefault = 5 * time.Second
 )
 
 // These are the protocol versions that Nomad can understand
@@ -157,6 +162,45 @@ type Config struct {
 	// from doing any scheduling work.
 	NumSchedulers int
 
+	// PlanEvaluationConcurrency is the number of plans the leader's plan
+	// applier will evaluate concurrently. Evaluation (checking a plan
+	// against the current state) can be parallelized across plans; the
+	// actual Raft apply of a plan's result is always serialized to
+	// preserve consistency, and the planner additionally reserves a
+	// plan's candidate nodes for the duration of its evaluation so that
+	// concurrent workers can't both decide the same node has room.
+	// Defaults to 1, which matches the historical strictly-sequential
+	// behavior.
+	PlanEvaluationConcurrency int
+
+	// PlanAllocationDeltaLimit bounds how many more allocations a single
+	// plan may place than it evicts before the plan applier rejects it
+	// with "plan exceeds allocation delta limit". This guards against a
+	// fat-fingered job update (e.g. count=10000) being applied in a single
+	// plan; a scheduler that genuinely intends such a change must set
+	// Plan.AllowAllocationDeltaOverride. Zero (the default) disables the
+	// limit.
+	PlanAllocationDeltaLimit int
+
+	// PlanEvaluationTimeout bounds how long a single pending plan is allowed
+	// to spend in evaluation before the plan applier gives up on it,
+	// responds to the waiter with structs.ErrPlanTimeout, and moves on to
+	// the next pending plan. This protects the leader's plan queue from
+	// stalling indefinitely on a pathological plan or a wedged state
+	// snapshot. The Raft apply that follows a successful evaluation is not
+	// bounded by this timeout. Defaults to PlanEvaluationTimeoutDefault.
+	PlanEvaluationTimeout time.Duration
+
+	// PlanCoalesceMaxPlans bounds how many additional already-pending plans
+	// the plan applier will drain from the queue and attempt to coalesce
+	// with the plan it just evaluated into a single Raft apply. Plans only
+	// coalesce when they touch disjoint sets of nodes and neither has a
+	// deployment, deployment update, or preemption to record; a plan that
+	// can't be coalesced is applied on its own instead. Zero (the default)
+	// disables coalescing and matches the historical one-plan-per-apply
+	// behavior.
+	PlanCoalesceMaxPlans int
+
 	// EnabledSchedulers controls the set of sub-schedulers that are
 	// enabled for this server to handle. This will restrict the evaluations
 	// that the workers dequeue for processing.
@@ -389,6 +433,8 @@ func DefaultConfig() *Config {
 		RPCAddr:                          DefaultRPCAddr(),
 		SerfConfig:                       serf.DefaultConfig(),
 		NumSchedulers:                    1,
+		PlanEvaluationConcurrency:        1,
+		PlanEvaluationTimeout:            PlanEvaluationTimeoutDefault,
 		ReconcileInterval:                60 * time.Second,
 		EvalGCInterval:                   5 * time.Minute,
 		EvalGCThreshold:                  1 * time.Hour,
@@ -1,16 +1,19 @@
 package nomad
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
-	metrics "github.com/armon/go-metrics"
 	log "github.com/hashicorp/go-hclog"
 	memdb "github.com/hashicorp/go-memdb"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/nomad/acl"
 	cstructs "github.com/hashicorp/nomad/client/structs"
+	safemetrics "github.com/hashicorp/nomad/helper/metrics"
+	"github.com/hashicorp/nomad/helper/uuid"
 	"github.com/hashicorp/nomad/nomad/state"
 	"github.com/hashicorp/nomad/nomad/structs"
 )
@@ -19,6 +22,68 @@ import (
 type CSIVolume struct {
 	srv    *Server
 	logger log.Logger
+
+	// volLocks serializes attach and detach operations per volume ID, so
+	// that a concurrent attach and detach of the same volume can't race
+	// with each other; operations on different volumes proceed
+	// independently. It's lazily initialized on first use so zero-value
+	// CSIVolume endpoints (as constructed in tests) still work.
+	volLocks     *volumeLocks
+	volLocksInit sync.Once
+}
+
+// locks returns the endpoint's per-volume lock registry, initializing it on
+// first access.
+func (v *CSIVolume) locks() *volumeLocks {
+	v.volLocksInit.Do(func() {
+		v.volLocks = newVolumeLocks()
+	})
+	return v.volLocks
+}
+
+// volumeOperationTimeout bounds how long an attach or detach operation
+// waits to acquire its volume's lock before giving up, so a wedged
+// operation on a volume can't block every other operation on that same
+// volume indefinitely.
+const volumeOperationTimeout = 30 * time.Second
+
+// volumeLocks hands out a per-volume-ID mutex, implemented as a
+// single-token buffered channel so acquisition can be bounded with a
+// timeout via select.
+type volumeLocks struct {
+	lock    sync.Mutex
+	locks   map[string]chan struct{}
+	timeout time.Duration
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{locks: make(map[string]chan struct{}), timeout: volumeOperationTimeout}
+}
+
+func (v *volumeLocks) tokenFor(volumeID string) chan struct{} {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	ch, ok := v.locks[volumeID]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		ch <- struct{}{}
+		v.locks[volumeID] = ch
+	}
+	return ch
+}
+
+// acquire blocks until volumeID's lock is available or v.timeout elapses,
+// whichever comes first. On success, the returned release func must be
+// called to unlock.
+func (v *volumeLocks) acquire(volumeID string) (release func(), err error) {
+	token := v.tokenFor(volumeID)
+	select {
+	case <-token:
+		return func() { token <- struct{}{} }, nil
+	case <-time.After(v.timeout):
+		return nil, fmt.Errorf("volume operation in progress")
+	}
 }
 
 // QueryACLObj looks up the ACL token in the request and returns the acl.ACL object
@@ -109,7 +174,7 @@ func (v *CSIVolume) List(args *structs.CSIVolumeListRequest, reply *structs.CSIV
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "list"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "list"}, metricsStart, v.logger)
 
 	ns := args.RequestNamespace()
 	opts := blockingOptions{
@@ -190,7 +255,7 @@ func (v *CSIVolume) Get(args *structs.CSIVolumeGetRequest, reply *structs.CSIVol
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "get"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "get"}, metricsStart, v.logger)
 
 	if args.ID == "" {
 		return fmt.Errorf("missing volume ID")
@@ -222,7 +287,7 @@ func (v *CSIVolume) Get(args *structs.CSIVolumeGetRequest, reply *structs.CSIVol
 	return v.srv.blockingRPC(&opts)
 }
 
-func (v *CSIVolume) pluginValidateVolume(req *structs.CSIVolumeRegisterRequest, vol *structs.CSIVolume) (*structs.CSIPlugin, error) {
+func (v *CSIVolume) pluginValidateVolume(vol *structs.CSIVolume) (*structs.CSIPlugin, error) {
 	state := v.srv.fsm.State()
 
 	plugin, err := state.CSIPluginByID(nil, vol.PluginID)
@@ -238,7 +303,11 @@ func (v *CSIVolume) pluginValidateVolume(req *structs.CSIVolumeRegisterRequest,
 	return plugin, nil
 }
 
-func (v *CSIVolume) controllerValidateVolume(req *structs.CSIVolumeRegisterRequest, vol *structs.CSIVolume, plugin *structs.CSIPlugin) error {
+func (v *CSIVolume) controllerValidateVolume(vol *structs.CSIVolume, plugin *structs.CSIPlugin) error {
+
+	if err := structs.ValidateAttachmentMode(vol.VolumeType, vol.AttachmentMode); err != nil {
+		return err
+	}
 
 	if !plugin.ControllerRequired {
 		// The plugin does not require a controller, so for now we won't do any
@@ -274,7 +343,7 @@ func (v *CSIVolume) Register(args *structs.CSIVolumeRegisterRequest, reply *stru
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "register"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "register"}, metricsStart, v.logger)
 
 	if !allowVolume(aclObj, args.RequestNamespace()) || !aclObj.AllowPluginRead() {
 		return structs.ErrPermissionDenied
@@ -293,11 +362,11 @@ func (v *CSIVolume) Register(args *structs.CSIVolumeRegisterRequest, reply *stru
 			return err
 		}
 
-		plugin, err := v.pluginValidateVolume(args, vol)
+		plugin, err := v.pluginValidateVolume(vol)
 		if err != nil {
 			return err
 		}
-		if err := v.controllerValidateVolume(args, vol, plugin); err != nil {
+		if err := v.controllerValidateVolume(vol, plugin); err != nil {
 			return err
 		}
 	}
@@ -316,6 +385,67 @@ func (v *CSIVolume) Register(args *structs.CSIVolumeRegisterRequest, reply *stru
 	return nil
 }
 
+// ValidateVolumes checks that each of a set of volumes is registered with a
+// known plugin and, if the plugin has a controller, that the controller
+// accepts the volume's requested capabilities. Unlike Register, volumes are
+// validated independently of one another: a single volume missing its
+// plugin, or failing controller validation, is reported for that volume
+// alone and does not prevent the remaining volumes in the batch from being
+// validated. This lets a caller checking every volume a job references
+// before the job starts get results for the whole batch in one RPC.
+func (v *CSIVolume) ValidateVolumes(args *structs.CSIVolumeValidateRequest, reply *structs.CSIVolumeValidateResponse) error {
+	if done, err := v.srv.forward("CSIVolume.ValidateVolumes", args, args, reply); done {
+		return err
+	}
+
+	allowVolume := acl.NamespaceValidator(acl.NamespaceCapabilityCSIReadVolume,
+		acl.NamespaceCapabilityCSIMountVolume)
+	aclObj, err := v.srv.WriteACLObj(&args.WriteRequest, false)
+	if err != nil {
+		return err
+	}
+
+	metricsStart := time.Now()
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "validate_volumes"}, metricsStart, v.logger)
+
+	if !allowVolume(aclObj, args.RequestNamespace()) {
+		return structs.ErrPermissionDenied
+	}
+
+	if args.Volumes == nil || len(args.Volumes) == 0 {
+		return fmt.Errorf("missing volume definition")
+	}
+
+	results := make([]*structs.CSIVolumeValidationResult, 0, len(args.Volumes))
+	for _, vol := range args.Volumes {
+		vol.Namespace = args.RequestNamespace()
+		result := &structs.CSIVolumeValidationResult{VolumeID: vol.ID}
+		results = append(results, result)
+
+		if err := vol.Validate(); err != nil {
+			result.Error = err.Error()
+			continue
+		}
+
+		plugin, err := v.pluginValidateVolume(vol)
+		if err != nil {
+			// a missing plugin means none of this volume's capabilities can
+			// be validated, but that's only fatal to this volume's result,
+			// not the rest of the batch.
+			result.Error = err.Error()
+			continue
+		}
+
+		if err := v.controllerValidateVolume(vol, plugin); err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	reply.Results = results
+	v.srv.setQueryMeta(&reply.QueryMeta)
+	return nil
+}
+
 // Deregister removes a set of volumes
 func (v *CSIVolume) Deregister(args *structs.CSIVolumeDeregisterRequest, reply *structs.CSIVolumeDeregisterResponse) error {
 	if done, err := v.srv.forward("CSIVolume.Deregister", args, args, reply); done {
@@ -329,7 +459,7 @@ func (v *CSIVolume) Deregister(args *structs.CSIVolumeDeregisterRequest, reply *
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "deregister"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "deregister"}, metricsStart, v.logger)
 
 	ns := args.RequestNamespace()
 	if !allowVolume(aclObj, ns) {
@@ -367,7 +497,7 @@ func (v *CSIVolume) Claim(args *structs.CSIVolumeClaimRequest, reply *structs.CS
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "claim"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "claim"}, metricsStart, v.logger)
 
 	if !allowVolume(aclObj, args.RequestNamespace()) || !aclObj.AllowPluginRead() {
 		return structs.ErrPermissionDenied
@@ -377,6 +507,12 @@ func (v *CSIVolume) Claim(args *structs.CSIVolumeClaimRequest, reply *structs.CS
 		return fmt.Errorf("missing volume ID")
 	}
 
+	release, err := v.locks().acquire(args.VolumeID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	isNewClaim := args.Claim != structs.CSIVolumeClaimGC &&
 		args.State == structs.CSIVolumeClaimStateTaken
 
@@ -397,10 +533,16 @@ func (v *CSIVolume) Claim(args *structs.CSIVolumeClaimRequest, reply *structs.CS
 		args.NodeID = alloc.NodeID
 	}
 
+	// traceID correlates every log line emitted for this claim operation,
+	// including those logged by the CSI plugin itself, with the response
+	// returned to the caller.
+	traceID := uuid.Generate()
+	reply.TraceID = traceID
+
 	if isNewClaim {
 		// if this is a new claim, add a Volume and PublishContext from the
 		// controller (if any) to the reply
-		err = v.controllerPublishVolume(args, reply)
+		err = v.controllerPublishVolume(args, reply, traceID)
 		if err != nil {
 			return fmt.Errorf("controller publish: %v", err)
 		}
@@ -408,7 +550,7 @@ func (v *CSIVolume) Claim(args *structs.CSIVolumeClaimRequest, reply *structs.CS
 
 	resp, index, err := v.srv.raftApply(structs.CSIVolumeClaimRequestType, args)
 	if err != nil {
-		v.logger.Error("csi raft apply failed", "error", err, "method", "claim")
+		v.logger.Error("csi raft apply failed", "error", err, "method", "claim", "trace_id", traceID)
 		return err
 	}
 	if respErr, ok := resp.(error); ok {
@@ -421,8 +563,12 @@ func (v *CSIVolume) Claim(args *structs.CSIVolumeClaimRequest, reply *structs.CS
 }
 
 // controllerPublishVolume sends publish request to the CSI controller
-// plugin associated with a volume, if any.
-func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest, resp *structs.CSIVolumeClaimResponse) error {
+// plugin associated with a volume, if any. traceID is logged alongside
+// every step of the attach operation so that it can be correlated with
+// the same operation's logs on the CSI plugin.
+func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest, resp *structs.CSIVolumeClaimResponse, traceID string) error {
+	logger := v.logger.With("trace_id", traceID)
+
 	plug, vol, err := v.volAndPluginLookup(req.RequestNamespace(), req.VolumeID)
 	if err != nil {
 		return err
@@ -431,6 +577,10 @@ func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest,
 	// Set the Response volume from the lookup
 	resp.Volume = vol
 
+	if err := structs.ValidateAttachmentMode(vol.VolumeType, vol.AttachmentMode); err != nil {
+		return err
+	}
+
 	// Validate the existence of the allocation, regardless of whether we need it
 	// now.
 	state := v.srv.fsm.State()
@@ -449,6 +599,10 @@ func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest,
 		return nil
 	}
 
+	if err := req.EncryptionContext.ValidateEncryptionContext(plug); err != nil {
+		return err
+	}
+
 	// get Nomad's ID for the client node (not the storage provider's ID)
 	targetNode, err := state.NodeByID(ws, alloc.NodeID)
 	if err != nil {
@@ -461,8 +615,14 @@ func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest,
 	// get the the storage provider's ID for the client node (not
 	// Nomad's ID for the node)
 	targetCSIInfo, ok := targetNode.CSINodePlugins[plug.ID]
-	if !ok {
-		return fmt.Errorf("failed to find storage provider info for client %q, node plugin %q is not running or has not fingerprinted on this client", targetNode.ID, plug.ID)
+	if !ok || !targetCSIInfo.Healthy {
+		if !req.WaitForNodePlugin {
+			return fmt.Errorf("failed to find storage provider info for client %q, node plugin %q is not running or has not fingerprinted on this client", targetNode.ID, plug.ID)
+		}
+		targetCSIInfo, err = v.waitForNodePlugin(targetNode.ID, plug.ID)
+		if err != nil {
+			return err
+		}
 	}
 	externalNodeID := targetCSIInfo.NodeInfo.ID
 	req.ExternalNodeID = externalNodeID // update with the target info
@@ -475,19 +635,182 @@ func (v *CSIVolume) controllerPublishVolume(req *structs.CSIVolumeClaimRequest,
 		AccessMode:      vol.AccessMode,
 		ReadOnly:        req.Claim == structs.CSIVolumeClaimRead,
 		Secrets:         vol.Secrets,
-		VolumeContext:   vol.Context,
+		VolumeContext:   mergeVolumeContext(vol.Context, req.EncryptionContext, req.AttachContext),
+		TraceID:         traceID,
+		Deadline:        req.Deadline,
 	}
 	cReq.PluginID = plug.ID
+	cReq.AccessibleTopology = vol.Topologies
 	cResp := &cstructs.ClientCSIControllerAttachVolumeResponse{}
 
+	logger.Trace("sending controller attach volume", "volume_id", vol.ID, "plugin_id", plug.ID)
+
 	err = v.srv.RPC(method, cReq, cResp)
 	if err != nil {
+		logger.Error("controller attach volume failed", "error", err)
 		return fmt.Errorf("attach volume: %v", err)
 	}
 	resp.PublishContext = cResp.PublishContext
+	resp.DevicePath = cResp.DevicePath
+	resp.DevicePaths = cResp.DevicePaths
 	return nil
 }
 
+// csiNodePluginWaitTimeout bounds how long controllerPublishVolume will wait
+// for a node's CSI node plugin to register and become healthy when the
+// claim sets WaitForNodePlugin, so a node plugin that's wedged rather than
+// merely restarting doesn't block the claim indefinitely. It's a var rather
+// than a const so tests can shrink it.
+var csiNodePluginWaitTimeout = 30 * time.Second
+
+// waitForNodePlugin blocks until the node plugin pluginID is registered and
+// healthy on nodeID, returning its CSIInfo, or returns an error once
+// csiNodePluginWaitTimeout elapses. It exists to cover a node plugin that's
+// mid-restart: the controller attach would otherwise succeed only for the
+// subsequent node publish to fail, wasting the attach.
+func (v *CSIVolume) waitForNodePlugin(nodeID, pluginID string) (*structs.CSIInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), csiNodePluginWaitTimeout)
+	defer cancel()
+
+	for {
+		snap, err := v.srv.fsm.State().Snapshot()
+		if err != nil {
+			return nil, err
+		}
+
+		ws := memdb.NewWatchSet()
+		node, err := snap.NodeByID(ws, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			if info, ok := node.CSINodePlugins[pluginID]; ok && info.Healthy {
+				return info, nil
+			}
+		}
+
+		if err := ws.WatchCtx(ctx); err != nil {
+			return nil, fmt.Errorf("timed out waiting for node plugin %q to become healthy on node %q", pluginID, nodeID)
+		}
+	}
+}
+
+// publishVolumeMultiNode publishes a single MultiNodeReaderOnly volume,
+// read-only, to every node in req.NodeIDs in one call. It's the
+// one-volume, many-node counterpart to the batch claim RPC's many-volumes,
+// one-node batching, for the common case of a volume like a dataset or
+// configuration bundle that's shared read-only across a job's allocations
+// spread over many nodes. traceID is logged alongside every per-node
+// attach so it can be correlated with the same operation's logs on the
+// CSI plugin. Each node's attach succeeds or fails independently, so one
+// unreachable node doesn't block publish to the rest.
+func (v *CSIVolume) publishVolumeMultiNode(req *structs.CSIVolumeMultiNodePublishRequest, traceID string) ([]*structs.CSIVolumeMultiNodePublishResult, error) {
+	logger := v.logger.With("trace_id", traceID)
+
+	plug, vol, err := v.volAndPluginLookup(req.RequestNamespace(), req.VolumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if vol.AccessMode != structs.CSIVolumeAccessModeMultiNodeReader {
+		return nil, fmt.Errorf("volume %q does not support multi-node read-only publish: access mode is %q",
+			vol.ID, vol.AccessMode)
+	}
+
+	if err := structs.ValidateAttachmentMode(vol.VolumeType, vol.AttachmentMode); err != nil {
+		return nil, err
+	}
+
+	results := make([]*structs.CSIVolumeMultiNodePublishResult, len(req.NodeIDs))
+
+	// if no controller plugin is associated with this volume, every node
+	// is trivially ready to publish: node-local publish doesn't require a
+	// prior controller attach.
+	if plug == nil {
+		for i, nodeID := range req.NodeIDs {
+			results[i] = &structs.CSIVolumeMultiNodePublishResult{NodeID: nodeID}
+		}
+		return results, nil
+	}
+
+	state := v.srv.fsm.State()
+	ws := memdb.NewWatchSet()
+
+	for i, nodeID := range req.NodeIDs {
+		result := &structs.CSIVolumeMultiNodePublishResult{NodeID: nodeID}
+		results[i] = result
+
+		targetNode, err := state.NodeByID(ws, nodeID)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		if targetNode == nil {
+			result.Err = fmt.Errorf("%s: %s", structs.ErrUnknownNodePrefix, nodeID)
+			continue
+		}
+
+		targetCSIInfo, ok := targetNode.CSINodePlugins[plug.ID]
+		if !ok {
+			result.Err = fmt.Errorf("failed to find storage provider info for client %q, node plugin %q is not running or has not fingerprinted on this client", targetNode.ID, plug.ID)
+			continue
+		}
+
+		cReq := &cstructs.ClientCSIControllerAttachVolumeRequest{
+			VolumeID:        vol.RemoteID(),
+			ClientCSINodeID: targetCSIInfo.NodeInfo.ID,
+			AttachmentMode:  vol.AttachmentMode,
+			AccessMode:      vol.AccessMode,
+			ReadOnly:        true,
+			Secrets:         vol.Secrets,
+			VolumeContext:   mergeVolumeContext(vol.Context),
+			TraceID:         traceID,
+			Deadline:        req.Deadline,
+		}
+		cReq.PluginID = plug.ID
+		cReq.AccessibleTopology = vol.Topologies
+		cResp := &cstructs.ClientCSIControllerAttachVolumeResponse{}
+
+		logger.Trace("sending multi-node controller attach volume", "volume_id", vol.ID, "plugin_id", plug.ID, "node_id", nodeID)
+
+		if err := v.srv.RPC("ClientCSI.ControllerAttachVolume", cReq, cResp); err != nil {
+			logger.Error("multi-node controller attach volume failed", "error", err, "node_id", nodeID)
+			result.Err = fmt.Errorf("attach volume to node %q: %v", nodeID, err)
+			continue
+		}
+		result.PublishContext = cResp.PublishContext
+	}
+
+	return results, nil
+}
+
+// mergeVolumeContext combines a volume's registered context with zero or
+// more sets of per-claim overrides, such as the request's encryption
+// context or attach context, without mutating the volume's own map. Each
+// override is applied in order, and later overrides take precedence over
+// earlier ones (and over the volume's own context) on key conflict, since
+// they were supplied specifically for this attachment.
+func mergeVolumeContext(volContext map[string]string, overrides ...map[string]string) map[string]string {
+	size := len(volContext)
+	for _, override := range overrides {
+		size += len(override)
+	}
+	if size == len(volContext) {
+		return volContext
+	}
+
+	merged := make(map[string]string, size)
+	for k, v := range volContext {
+		merged[k] = v
+	}
+	for _, override := range overrides {
+		for k, v := range override {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
 func (v *CSIVolume) volAndPluginLookup(namespace, volID string) (*structs.CSIPlugin, *structs.CSIVolume, error) {
 	state := v.srv.fsm.State()
 	vol, err := state.CSIVolumeByID(nil, namespace, volID)
@@ -529,7 +852,7 @@ func (v *CSIVolume) Unpublish(args *structs.CSIVolumeUnpublishRequest, reply *st
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "volume", "unpublish"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "unpublish"}, metricsStart, v.logger)
 
 	allowVolume := acl.NamespaceValidator(acl.NamespaceCapabilityCSIMountVolume)
 	aclObj, err := v.srv.WriteACLObj(&args.WriteRequest, true)
@@ -547,6 +870,12 @@ func (v *CSIVolume) Unpublish(args *structs.CSIVolumeUnpublishRequest, reply *st
 		return fmt.Errorf("missing volume claim")
 	}
 
+	release, err := v.locks().acquire(args.VolumeID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	ws := memdb.NewWatchSet()
 	state := v.srv.fsm.State()
 	vol, err := state.CSIVolumeByID(ws, args.Namespace, args.VolumeID)
@@ -559,6 +888,13 @@ func (v *CSIVolume) Unpublish(args *structs.CSIVolumeUnpublishRequest, reply *st
 
 	claim := args.Claim
 
+	// traceID correlates every log line emitted for this detach operation,
+	// including those logged by the CSI plugin itself, with the response
+	// returned to the caller.
+	traceID := uuid.Generate()
+	reply.TraceID = traceID
+	logger := v.logger.With("trace_id", traceID)
+
 	// previous checkpoints may have set the past claim state already.
 	// in practice we should never see CSIVolumeClaimStateControllerDetached
 	// but having an option for the state makes it easy to add a checkpoint
@@ -571,13 +907,13 @@ func (v *CSIVolume) Unpublish(args *structs.CSIVolumeUnpublishRequest, reply *st
 	case structs.CSIVolumeClaimStateReadyToFree:
 		goto RELEASE_CLAIM
 	}
-	err = v.nodeUnpublishVolume(vol, claim)
+	err = v.nodeUnpublishVolume(vol, claim, traceID)
 	if err != nil {
 		return err
 	}
 
 NODE_DETACHED:
-	err = v.controllerUnpublishVolume(vol, claim)
+	err = v.controllerUnpublishVolume(vol, claim, traceID)
 	if err != nil {
 		return err
 	}
@@ -590,14 +926,15 @@ RELEASE_CLAIM:
 		return err
 	}
 
+	logger.Trace("volume unpublish complete", "volume_id", vol.ID)
 	reply.Index = vol.ModifyIndex
 	v.srv.setQueryMeta(&reply.QueryMeta)
 	return nil
 }
 
-func (v *CSIVolume) nodeUnpublishVolume(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim) error {
+func (v *CSIVolume) nodeUnpublishVolume(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim, traceID string) error {
 	if claim.AllocationID != "" {
-		err := v.nodeUnpublishVolumeImpl(vol, claim)
+		err := v.nodeUnpublishVolumeImpl(vol, claim, traceID)
 		if err != nil {
 			return err
 		}
@@ -637,7 +974,7 @@ func (v *CSIVolume) nodeUnpublishVolume(vol *structs.CSIVolume, claim *structs.C
 	var merr multierror.Error
 	for _, allocID := range allocIDs {
 		claim.AllocationID = allocID
-		err := v.nodeUnpublishVolumeImpl(vol, claim)
+		err := v.nodeUnpublishVolumeImpl(vol, claim, traceID)
 		if err != nil {
 			merr.Errors = append(merr.Errors, err)
 		}
@@ -651,7 +988,7 @@ func (v *CSIVolume) nodeUnpublishVolume(vol *structs.CSIVolume, claim *structs.C
 	return v.checkpointClaim(vol, claim)
 }
 
-func (v *CSIVolume) nodeUnpublishVolumeImpl(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim) error {
+func (v *CSIVolume) nodeUnpublishVolumeImpl(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim, traceID string) error {
 	req := &cstructs.ClientCSINodeDetachVolumeRequest{
 		PluginID:       vol.PluginID,
 		VolumeID:       vol.ID,
@@ -661,7 +998,9 @@ func (v *CSIVolume) nodeUnpublishVolumeImpl(vol *structs.CSIVolume, claim *struc
 		AttachmentMode: vol.AttachmentMode,
 		AccessMode:     vol.AccessMode,
 		ReadOnly:       claim.Mode == structs.CSIVolumeClaimRead,
+		TraceID:        traceID,
 	}
+	v.logger.Trace("sending node detach volume", "trace_id", traceID, "volume_id", vol.ID, "alloc_id", claim.AllocationID)
 	err := v.srv.RPC("ClientCSI.NodeDetachVolume",
 		req, &cstructs.ClientCSINodeDetachVolumeResponse{})
 	if err != nil {
@@ -675,7 +1014,7 @@ func (v *CSIVolume) nodeUnpublishVolumeImpl(vol *structs.CSIVolume, claim *struc
 	return nil
 }
 
-func (v *CSIVolume) controllerUnpublishVolume(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim) error {
+func (v *CSIVolume) controllerUnpublishVolume(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim, traceID string) error {
 
 	if !vol.ControllerRequired {
 		claim.State = structs.CSIVolumeClaimStateReadyToFree
@@ -703,6 +1042,16 @@ func (v *CSIVolume) controllerUnpublishVolume(vol *structs.CSIVolume, claim *str
 		}
 	}
 
+	if claim.RequireUnmounted {
+		mounted, err := v.nodeHasMount(vol, claim, traceID)
+		if err != nil {
+			return err
+		}
+		if err := ensureNotMounted(mounted); err != nil {
+			return err
+		}
+	}
+
 	// if the RPC is sent by a client node, it doesn't know the claim's
 	// external node ID.
 	if claim.ExternalNodeID == "" {
@@ -717,8 +1066,11 @@ func (v *CSIVolume) controllerUnpublishVolume(vol *structs.CSIVolume, claim *str
 		VolumeID:        vol.RemoteID(),
 		ClientCSINodeID: claim.ExternalNodeID,
 		Secrets:         vol.Secrets,
+		TraceID:         traceID,
 	}
 	req.PluginID = vol.PluginID
+	req.AccessibleTopology = vol.Topologies
+	v.logger.Trace("sending controller detach volume", "trace_id", traceID, "volume_id", vol.ID)
 	err = v.srv.RPC("ClientCSI.ControllerDetachVolume", req,
 		&cstructs.ClientCSIControllerDetachVolumeResponse{})
 	if err != nil {
@@ -728,6 +1080,40 @@ func (v *CSIVolume) controllerUnpublishVolume(vol *structs.CSIVolume, claim *str
 	return v.checkpointClaim(vol, claim)
 }
 
+// ensureNotMounted enforces the RequireUnmounted detach precondition given
+// the node's answer to a mount query: it rejects the detach outright when
+// the volume is still mounted, and is a no-op otherwise.
+func ensureNotMounted(mounted bool) error {
+	if mounted {
+		return errors.New("volume still mounted, refusing detach")
+	}
+	return nil
+}
+
+// nodeHasMount asks the claim's node whether it still has the volume
+// mounted. It's used as a detach precondition when the claim has
+// RequireUnmounted set, to avoid detaching a volume out from under a
+// workload that's still writing to it.
+func (v *CSIVolume) nodeHasMount(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim, traceID string) (bool, error) {
+	req := &cstructs.ClientCSINodeHasVolumeMountRequest{
+		PluginID: vol.PluginID,
+		VolumeID: vol.ID,
+		NodeID:   claim.NodeID,
+	}
+	resp := &cstructs.ClientCSINodeHasVolumeMountResponse{}
+	v.logger.Trace("checking node volume mount", "trace_id", traceID, "volume_id", vol.ID, "node_id", claim.NodeID)
+	err := v.srv.RPC("ClientCSI.NodeHasVolumeMount", req, resp)
+	if err != nil {
+		// if the Nomad node has disconnected and been garbage-collected,
+		// there's nothing left for it to have mounted.
+		if errors.Is(err, structs.ErrUnknownNode) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not query node for volume mount: %w", err)
+	}
+	return resp.HasMount, nil
+}
+
 // lookupExternalNodeID gets the CSI plugin's ID for a node.  we look it up in
 // the volume's claims first because it's possible the client has been stopped
 // and GC'd by this point, so looking there is the last resort.
@@ -768,6 +1154,88 @@ func (v *CSIVolume) lookupExternalNodeID(vol *structs.CSIVolume, claim *structs.
 	return targetCSIInfo.NodeInfo.ID, nil
 }
 
+// DetachNodeVolumes unpublishes every volume currently published to a node.
+// This is used when evacuating a node that has failed and isn't expected to
+// come back, so that its volumes can be claimed elsewhere without waiting on
+// the node itself to check in. Each volume is detached independently: a
+// volume that fails to detach is reported in its own result and doesn't
+// block the rest of the node's volumes from being detached.
+func (v *CSIVolume) DetachNodeVolumes(args *structs.CSIVolumeDetachNodeRequest, reply *structs.CSIVolumeDetachNodeResponse) error {
+	if done, err := v.srv.forward("CSIVolume.DetachNodeVolumes", args, args, reply); done {
+		return err
+	}
+
+	allowVolume := acl.NamespaceValidator(acl.NamespaceCapabilityCSIWriteVolume)
+	aclObj, err := v.srv.WriteACLObj(&args.WriteRequest, true)
+	if err != nil {
+		return err
+	}
+
+	metricsStart := time.Now()
+	defer safemetrics.MeasureSince([]string{"nomad", "volume", "detach_node_volumes"}, metricsStart, v.logger)
+
+	if args.NodeID == "" {
+		return fmt.Errorf("missing node ID")
+	}
+
+	ws := memdb.NewWatchSet()
+	snap, err := v.srv.fsm.State().Snapshot()
+	if err != nil {
+		return err
+	}
+
+	iter, err := snap.CSIVolumesByNodeID(ws, args.NodeID)
+	if err != nil {
+		return err
+	}
+
+	var results []*structs.CSIVolumeDetachNodeResult
+	for {
+		raw := iter.Next()
+		if raw == nil {
+			break
+		}
+		vol := raw.(*structs.CSIVolume)
+
+		if args.PluginID != "" && args.PluginID != vol.PluginID {
+			continue
+		}
+		if !allowVolume(aclObj, vol.Namespace) {
+			continue
+		}
+
+		result := &structs.CSIVolumeDetachNodeResult{VolumeID: vol.ID}
+		results = append(results, result)
+
+		if err := v.detachNodeVolume(vol, args.NodeID); err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	reply.Results = results
+	v.srv.setQueryMeta(&reply.QueryMeta)
+	return nil
+}
+
+// detachNodeVolume unpublishes a single volume from a node, following the
+// same node-then-controller detach sequence as Unpublish. The claim has no
+// AllocationID, so nodeUnpublishVolume detaches every terminal or invalid
+// alloc this node still holds a claim for.
+func (v *CSIVolume) detachNodeVolume(vol *structs.CSIVolume, nodeID string) error {
+	traceID := uuid.Generate()
+	claim := &structs.CSIVolumeClaim{NodeID: nodeID}
+
+	if err := v.nodeUnpublishVolume(vol, claim, traceID); err != nil {
+		return err
+	}
+	if err := v.controllerUnpublishVolume(vol, claim, traceID); err != nil {
+		return err
+	}
+
+	claim.State = structs.CSIVolumeClaimStateReadyToFree
+	return v.checkpointClaim(vol, claim)
+}
+
 func (v *CSIVolume) checkpointClaim(vol *structs.CSIVolume, claim *structs.CSIVolumeClaim) error {
 	v.logger.Trace("checkpointing claim")
 	req := structs.CSIVolumeClaimRequest{
@@ -814,7 +1282,7 @@ func (v *CSIPlugin) List(args *structs.CSIPluginListRequest, reply *structs.CSIP
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "plugin", "list"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "plugin", "list"}, metricsStart, v.logger)
 
 	opts := blockingOptions{
 		queryOpts: &args.QueryOptions,
@@ -863,7 +1331,7 @@ func (v *CSIPlugin) Get(args *structs.CSIPluginGetRequest, reply *structs.CSIPlu
 		aclObj.AllowNsOp(args.RequestNamespace(), acl.NamespaceCapabilityReadJob)
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "plugin", "get"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "plugin", "get"}, metricsStart, v.logger)
 
 	if args.ID == "" {
 		return fmt.Errorf("missing plugin ID")
@@ -924,7 +1392,7 @@ func (v *CSIPlugin) Delete(args *structs.CSIPluginDeleteRequest, reply *structs.
 	}
 
 	metricsStart := time.Now()
-	defer metrics.MeasureSince([]string{"nomad", "plugin", "delete"}, metricsStart)
+	defer safemetrics.MeasureSince([]string{"nomad", "plugin", "delete"}, metricsStart, v.logger)
 
 	if args.ID == "" {
 		return fmt.Errorf("missing plugin ID")
@@ -3,7 +3,10 @@ package nomad
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	msgpackrpc "github.com/hashicorp/net-rpc-msgpackrpc"
 	"github.com/hashicorp/nomad/acl"
@@ -108,6 +111,80 @@ func TestCSIVolumeEndpoint_Get_ACL(t *testing.T) {
 	require.Equal(t, vols[0].ID, resp.Volume.ID)
 }
 
+func TestCSIVolumeEndpoint_ValidateVolumes(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {
+		c.NumSchedulers = 0 // Prevent automatic dequeue
+	})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	ns := structs.DefaultNamespace
+
+	state := srv.fsm.State()
+	codec := rpcClient(t, srv)
+
+	// Register a plugin for the valid volume to reference. "adam" is left
+	// unregistered so that volumes naming it fail with a missing-plugin error.
+	node := mock.Node()
+	node.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie",
+			Healthy: true,
+			// Registers as node plugin that does not require a controller to skip
+			// the client RPC during validation.
+			NodeInfo: &structs.CSINodeInfo{},
+		},
+	}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	validID := uuid.Generate()
+	missingPluginID := uuid.Generate()
+	badAttachmentID := uuid.Generate()
+
+	vols := []*structs.CSIVolume{
+		{
+			ID:             validID,
+			PluginID:       "minnie",
+			AccessMode:     structs.CSIVolumeAccessModeMultiNodeReader,
+			AttachmentMode: structs.CSIVolumeAttachmentModeFilesystem,
+		},
+		{
+			ID:             missingPluginID,
+			PluginID:       "adam",
+			AccessMode:     structs.CSIVolumeAccessModeMultiNodeReader,
+			AttachmentMode: structs.CSIVolumeAttachmentModeFilesystem,
+		},
+		{
+			ID:             badAttachmentID,
+			PluginID:       "minnie",
+			AccessMode:     structs.CSIVolumeAccessModeMultiNodeReader,
+			AttachmentMode: structs.CSIVolumeAttachmentModeBlockDevice,
+			MountOptions:   &structs.CSIMountOptions{FSType: "ext4"},
+		},
+	}
+
+	req := &structs.CSIVolumeValidateRequest{
+		Volumes: vols,
+		WriteRequest: structs.WriteRequest{
+			Region:    "global",
+			Namespace: ns,
+		},
+	}
+	resp := &structs.CSIVolumeValidateResponse{}
+	err := msgpackrpc.CallWithCodec(codec, "CSIVolume.ValidateVolumes", req, resp)
+	require.NoError(t, err, "a per-volume error should not fail the whole RPC")
+	require.Len(t, resp.Results, 3)
+
+	results := make(map[string]*structs.CSIVolumeValidationResult, len(resp.Results))
+	for _, result := range resp.Results {
+		results[result.VolumeID] = result
+	}
+
+	require.Empty(t, results[validID].Error)
+	require.Contains(t, results[missingPluginID].Error, "no CSI plugin named")
+	require.Contains(t, results[badAttachmentID].Error, "mount options not allowed for block-device")
+}
+
 func TestCSIVolumeEndpoint_Register(t *testing.T) {
 	t.Parallel()
 	srv, shutdown := TestServer(t, func(c *Config) {
@@ -297,6 +374,8 @@ func TestCSIVolumeEndpoint_Claim(t *testing.T) {
 	// Now our claim should succeed
 	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Claim", claimReq, claimResp)
 	require.NoError(t, err)
+	require.NotEmpty(t, claimResp.TraceID, "expected a trace ID for the claim operation")
+	firstTraceID := claimResp.TraceID
 
 	// Verify the claim was set
 	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Get", volGetReq, volGetResp)
@@ -322,6 +401,9 @@ func TestCSIVolumeEndpoint_Claim(t *testing.T) {
 	claimReq.Claim = structs.CSIVolumeClaimRead
 	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Claim", claimReq, claimResp)
 	require.NoError(t, err)
+	require.NotEmpty(t, claimResp.TraceID)
+	require.NotEqual(t, firstTraceID, claimResp.TraceID,
+		"expected a fresh trace ID for each claim operation")
 
 	// Verify the new claim was set
 	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Get", volGetReq, volGetResp)
@@ -445,6 +527,179 @@ func TestCSIVolumeEndpoint_ClaimWithController(t *testing.T) {
 	require.EqualError(t, err, "controller publish: attach volume: No path to node")
 }
 
+func TestCSIVolumeEndpoint_ClaimWithController_EncryptionContext(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {
+		c.ACLEnabled = true
+		c.NumSchedulers = 0 // Prevent automatic dequeue
+	})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	ns := structs.DefaultNamespace
+	state := srv.fsm.State()
+	state.BootstrapACLTokens(structs.MsgTypeTestSetup, 1, 0, mock.ACLManagementToken())
+
+	codec := rpcClient(t, srv)
+	id0 := uuid.Generate()
+
+	// Create a client node with a controller plugin that requires an
+	// encryption context, plus a node plugin and volume to claim.
+	node := mock.Node()
+	node.Attributes["nomad.version"] = "0.11.0" // client RPCs not supported on early version
+	node.CSIControllerPlugins = map[string]*structs.CSIInfo{
+		"minnie": {
+			PluginID: "minnie",
+			Healthy:  true,
+			ControllerInfo: &structs.CSIControllerInfo{
+				SupportsAttachDetach:          true,
+				RequiresEncryptionContext:     true,
+				RequiredEncryptionContextKeys: []string{"key_id"},
+			},
+			RequiresControllerPlugin: true,
+		},
+	}
+	node.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {
+			PluginID: "minnie",
+			Healthy:  true,
+			NodeInfo: &structs.CSINodeInfo{},
+		},
+	}
+	err := state.UpsertNode(structs.MsgTypeTestSetup, 1002, node)
+	require.NoError(t, err)
+	vols := []*structs.CSIVolume{{
+		ID:                 id0,
+		Namespace:          ns,
+		PluginID:           "minnie",
+		ControllerRequired: true,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeSingleWriter,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+	}}
+	err = state.CSIVolumeRegister(1003, vols)
+	require.NoError(t, err)
+
+	alloc := mock.BatchAlloc()
+	alloc.NodeID = node.ID
+	summary := mock.JobSummary(alloc.JobID)
+	require.NoError(t, state.UpsertJobSummary(1004, summary))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1005, []*structs.Allocation{alloc}))
+
+	// Claiming without the required encryption context key is rejected
+	// before any attempt to reach the client.
+	claimReq := &structs.CSIVolumeClaimRequest{
+		VolumeID:     id0,
+		AllocationID: alloc.ID,
+		Claim:        structs.CSIVolumeClaimWrite,
+		WriteRequest: structs.WriteRequest{
+			Region:    "global",
+			Namespace: ns,
+			AuthToken: node.SecretID,
+		},
+	}
+	claimResp := &structs.CSIVolumeClaimResponse{}
+	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Claim", claimReq, claimResp)
+	require.EqualError(t, err, "controller publish: missing required encryption context keys: key_id")
+
+	// Supplying the required key clears validation and proceeds to the
+	// (unreachable in this test) client RPC.
+	claimReq.EncryptionContext = structs.CSIEncryptionContext{"key_id": "my-key"}
+	claimResp = &structs.CSIVolumeClaimResponse{}
+	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Claim", claimReq, claimResp)
+	require.EqualError(t, err, "controller publish: attach volume: No path to node")
+}
+
+func TestMergeVolumeContext(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, mergeVolumeContext(nil, nil))
+	require.Equal(t, map[string]string{"a": "1"}, mergeVolumeContext(map[string]string{"a": "1"}, nil))
+
+	merged := mergeVolumeContext(
+		map[string]string{"a": "1", "b": "2"},
+		structs.CSIEncryptionContext{"b": "overridden", "c": "3"},
+	)
+	require.Equal(t, map[string]string{"a": "1", "b": "overridden", "c": "3"}, merged)
+
+	// A non-conflicting union of registration-time context and attach
+	// context keeps every key.
+	union := mergeVolumeContext(
+		map[string]string{"a": "1"},
+		map[string]string{"d": "4"},
+	)
+	require.Equal(t, map[string]string{"a": "1", "d": "4"}, union)
+
+	// When both encryption context and attach context are supplied, later
+	// overrides win on conflict, including over each other.
+	merged = mergeVolumeContext(
+		map[string]string{"a": "1", "b": "2"},
+		structs.CSIEncryptionContext{"b": "from-encryption", "c": "3"},
+		map[string]string{"c": "from-attach", "d": "4"},
+	)
+	require.Equal(t, map[string]string{"a": "1", "b": "from-encryption", "c": "from-attach", "d": "4"}, merged)
+}
+
+func TestCSIVolumeEndpoint_WaitForNodePlugin_Ready(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	state := srv.fsm.State()
+	node := mock.Node()
+	node.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie", Healthy: false},
+	}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	c := srv.staticEndpoints.CSIVolume
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.waitForNodePlugin(node.ID, "minnie")
+		errCh <- err
+	}()
+
+	// The plugin isn't healthy yet, so waitForNodePlugin should still be
+	// blocked on the node's next update.
+	select {
+	case err := <-errCh:
+		t.Fatalf("waitForNodePlugin returned before plugin was healthy: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	node = node.Copy()
+	node.CSINodePlugins["minnie"].Healthy = true
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, node))
+
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForNodePlugin did not return after plugin became healthy")
+	}
+}
+
+func TestCSIVolumeEndpoint_WaitForNodePlugin_Timeout(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	state := srv.fsm.State()
+	node := mock.Node()
+	node.CSINodePlugins = map[string]*structs.CSIInfo{}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	orig := csiNodePluginWaitTimeout
+	csiNodePluginWaitTimeout = 50 * time.Millisecond
+	defer func() { csiNodePluginWaitTimeout = orig }()
+
+	c := srv.staticEndpoints.CSIVolume
+	_, err := c.waitForNodePlugin(node.ID, "minnie")
+	require.EqualError(t, err,
+		fmt.Sprintf("timed out waiting for node plugin %q to become healthy on node %q", "minnie", node.ID))
+}
+
 func TestCSIVolumeEndpoint_Unpublish(t *testing.T) {
 	t.Parallel()
 	srv, shutdown := TestServer(t, func(c *Config) { c.NumSchedulers = 0 })
@@ -556,11 +811,12 @@ func TestCSIVolumeEndpoint_Unpublish(t *testing.T) {
 				},
 			}
 
-			err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Unpublish", req,
-				&structs.CSIVolumeUnpublishResponse{})
+			unpublishResp := &structs.CSIVolumeUnpublishResponse{}
+			err = msgpackrpc.CallWithCodec(codec, "CSIVolume.Unpublish", req, unpublishResp)
 
 			if tc.expectedErrMsg == "" {
 				require.NoError(t, err)
+				require.NotEmpty(t, unpublishResp.TraceID, "expected a trace ID for the unpublish operation")
 				vol, err = state.CSIVolumeByID(nil, ns, volID)
 				require.NoError(t, err)
 				require.NotNil(t, vol)
@@ -575,6 +831,111 @@ func TestCSIVolumeEndpoint_Unpublish(t *testing.T) {
 
 }
 
+func TestCSIVolumeEndpoint_DetachNodeVolumes(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) { c.NumSchedulers = 0 })
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	var err error
+	index := uint64(1000)
+	ns := structs.DefaultNamespace
+	state := srv.fsm.State()
+	state.BootstrapACLTokens(structs.MsgTypeTestSetup, 1, 0, mock.ACLManagementToken())
+
+	policy := mock.NamespacePolicy(ns, "", []string{acl.NamespaceCapabilityCSIWriteVolume}) +
+		mock.PluginPolicy("read")
+	index++
+	accessToken := mock.CreatePolicyAndToken(t, state, index, "detach", policy)
+
+	codec := rpcClient(t, srv)
+
+	node := mock.Node()
+	node.Attributes["nomad.version"] = "0.11.0"
+	node.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie", Healthy: true, NodeInfo: &structs.CSINodeInfo{}},
+	}
+	index++
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, index, node))
+
+	// okVolID has no outstanding claims, so detaching it doesn't require
+	// contacting any plugin and succeeds immediately.
+	okVolID := uuid.Generate()
+	okVol := &structs.CSIVolume{
+		ID:                 okVolID,
+		Namespace:          ns,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeReader,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+		PluginID:           "minnie",
+		ControllerRequired: false,
+	}
+
+	// failVolID has a claim that still needs a node detach RPC, which fails
+	// because there's no real client listening for it in this test.
+	failVolID := uuid.Generate()
+	failVol := &structs.CSIVolume{
+		ID:                 failVolID,
+		Namespace:          ns,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeSingleWriter,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+		PluginID:           "minnie",
+		ControllerRequired: false,
+	}
+
+	index++
+	require.NoError(t, state.CSIVolumeRegister(index, []*structs.CSIVolume{okVol, failVol}))
+
+	// both volumes need an alloc on the node that references them and is
+	// desired to run, so that CSIVolumesByNodeID will discover them.
+	okAlloc := mock.BatchAlloc()
+	okAlloc.NodeID = node.ID
+	okAlloc.Job.TaskGroups[0].Volumes = map[string]*structs.VolumeRequest{
+		"data": {Name: "data", Type: structs.VolumeTypeCSI, Source: okVolID},
+	}
+	failAlloc := mock.BatchAlloc()
+	failAlloc.NodeID = node.ID
+	failAlloc.ClientStatus = structs.AllocClientStatusFailed
+	failAlloc.Job.TaskGroups[0].Volumes = map[string]*structs.VolumeRequest{
+		"data": {Name: "data", Type: structs.VolumeTypeCSI, Source: failVolID},
+	}
+
+	index++
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, index, []*structs.Allocation{okAlloc, failAlloc}))
+
+	// claim failVol for the terminal alloc, so detaching it has to attempt a
+	// real node-detach RPC.
+	claim := &structs.CSIVolumeClaim{
+		AllocationID:   failAlloc.ID,
+		NodeID:         node.ID,
+		ExternalNodeID: "i-example",
+		Mode:           structs.CSIVolumeClaimRead,
+		State:          structs.CSIVolumeClaimStateTaken,
+	}
+	index++
+	require.NoError(t, state.CSIVolumeClaim(index, ns, failVolID, claim))
+
+	req := &structs.CSIVolumeDetachNodeRequest{
+		NodeID: node.ID,
+		WriteRequest: structs.WriteRequest{
+			Region:    "global",
+			Namespace: ns,
+			AuthToken: accessToken.SecretID,
+		},
+	}
+	resp := &structs.CSIVolumeDetachNodeResponse{}
+	err = msgpackrpc.CallWithCodec(codec, "CSIVolume.DetachNodeVolumes", req, resp)
+	require.NoError(t, err, "the RPC itself should succeed even though one volume failed to detach")
+	require.Len(t, resp.Results, 2)
+
+	results := make(map[string]*structs.CSIVolumeDetachNodeResult, len(resp.Results))
+	for _, result := range resp.Results {
+		results[result.VolumeID] = result
+	}
+
+	require.Empty(t, results[okVolID].Error)
+	require.Contains(t, results[failVolID].Error, "No path to node")
+}
+
 func TestCSIVolumeEndpoint_List(t *testing.T) {
 	t.Parallel()
 	srv, shutdown := TestServer(t, func(c *Config) {
@@ -947,3 +1308,273 @@ func TestCSI_RPCVolumeAndPluginLookup(t *testing.T) {
 	require.Nil(t, vol)
 	require.EqualError(t, err, fmt.Sprintf("volume not found: %s", id2))
 }
+
+func TestCSIVolumeEndpoint_PublishVolumeMultiNode(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	state := srv.fsm.State()
+	id0 := uuid.Generate()
+
+	node1 := mock.Node()
+	node1.CSIControllerPlugins = map[string]*structs.CSIInfo{
+		"minnie": {
+			PluginID: "minnie",
+			Healthy:  true,
+			ControllerInfo: &structs.CSIControllerInfo{
+				SupportsAttachDetach: true,
+			},
+			RequiresControllerPlugin: true,
+		},
+	}
+	node1.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie", Healthy: true, NodeInfo: &structs.CSINodeInfo{ID: "minnie-node-1"}},
+	}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node1))
+
+	node2 := mock.Node()
+	node2.CSINodePlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie", Healthy: true, NodeInfo: &structs.CSINodeInfo{ID: "minnie-node-2"}},
+	}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2))
+
+	vols := []*structs.CSIVolume{{
+		ID:                 id0,
+		Namespace:          structs.DefaultNamespace,
+		PluginID:           "minnie",
+		ControllerRequired: true,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeReader,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+	}}
+	require.NoError(t, state.CSIVolumeRegister(1002, vols))
+
+	c := srv.staticEndpoints.CSIVolume
+	req := &structs.CSIVolumeMultiNodePublishRequest{
+		VolumeID: id0,
+		NodeIDs:  []string{node1.ID, node2.ID, uuid.Generate()},
+		WriteRequest: structs.WriteRequest{
+			Region:    "global",
+			Namespace: structs.DefaultNamespace,
+		},
+	}
+
+	results, err := c.publishVolumeMultiNode(req, uuid.Generate())
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	// the node plugin is registered but there's no real controller plugin
+	// to serve the RPC, so every node fails the same way -- independently,
+	// not as a single whole-batch failure
+	require.Equal(t, node1.ID, results[0].NodeID)
+	require.Error(t, results[0].Err)
+	require.Contains(t, results[0].Err.Error(), "failed to find clients running controller plugin")
+
+	require.Equal(t, node2.ID, results[1].NodeID)
+	require.Error(t, results[1].Err)
+	require.Contains(t, results[1].Err.Error(), "failed to find clients running controller plugin")
+
+	// the unregistered node fails lookup rather than attach
+	require.Error(t, results[2].Err)
+	require.Contains(t, results[2].Err.Error(), structs.ErrUnknownNodePrefix)
+}
+
+func TestCSIVolumeEndpoint_PublishVolumeMultiNode_RejectsWriteAccessMode(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) {})
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	state := srv.fsm.State()
+	id0 := uuid.Generate()
+
+	vols := []*structs.CSIVolume{{
+		ID:                 id0,
+		Namespace:          structs.DefaultNamespace,
+		PluginID:           "minnie",
+		ControllerRequired: false,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeSingleWriter,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+	}}
+	require.NoError(t, state.CSIVolumeRegister(1000, vols))
+
+	c := srv.staticEndpoints.CSIVolume
+	req := &structs.CSIVolumeMultiNodePublishRequest{
+		VolumeID: id0,
+		NodeIDs:  []string{uuid.Generate()},
+		WriteRequest: structs.WriteRequest{
+			Region:    "global",
+			Namespace: structs.DefaultNamespace,
+		},
+	}
+
+	results, err := c.publishVolumeMultiNode(req, uuid.Generate())
+	require.Nil(t, results)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not support multi-node read-only publish")
+}
+
+func TestEnsureNotMounted(t *testing.T) {
+	t.Parallel()
+
+	err := ensureNotMounted(true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "volume still mounted, refusing detach")
+
+	require.NoError(t, ensureNotMounted(false))
+}
+
+// Test that controllerUnpublishVolume only consults the node's mount status
+// when RequireUnmounted is set on the claim; with it unset, the existing
+// detach flow runs unchanged.
+func TestCSIVolumeEndpoint_ControllerUnpublish_RequireUnmounted(t *testing.T) {
+	t.Parallel()
+	srv, shutdown := TestServer(t, func(c *Config) { c.NumSchedulers = 0 })
+	defer shutdown()
+	testutil.WaitForLeader(t, srv.RPC)
+
+	ns := structs.DefaultNamespace
+	state := srv.fsm.State()
+
+	node := mock.Node()
+	node.Attributes["nomad.version"] = "0.11.0"
+	node.CSIControllerPlugins = map[string]*structs.CSIInfo{
+		"minnie": {PluginID: "minnie",
+			Healthy:                  true,
+			ControllerInfo:           &structs.CSIControllerInfo{SupportsAttachDetach: true},
+			RequiresControllerPlugin: true,
+		},
+	}
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	volID := uuid.Generate()
+	vol := &structs.CSIVolume{
+		ID:                 volID,
+		Namespace:          ns,
+		AccessMode:         structs.CSIVolumeAccessModeMultiNodeSingleWriter,
+		AttachmentMode:     structs.CSIVolumeAttachmentModeFilesystem,
+		PluginID:           "minnie",
+		ControllerRequired: true,
+	}
+	require.NoError(t, state.CSIVolumeRegister(1001, []*structs.CSIVolume{vol}))
+
+	snapVol, err := state.CSIVolumeByID(nil, ns, volID)
+	require.NoError(t, err)
+
+	endpoint := srv.staticEndpoints.CSIVolume
+
+	claim := &structs.CSIVolumeClaim{
+		AllocationID:     uuid.Generate(),
+		NodeID:           node.ID,
+		ExternalNodeID:   "i-example",
+		Mode:             structs.CSIVolumeClaimRead,
+		State:            structs.CSIVolumeClaimStateNodeDetached,
+		RequireUnmounted: true,
+	}
+
+	// with RequireUnmounted set, there's no client listening to answer the
+	// mount query, so the detach fails at the mount check rather than ever
+	// reaching the controller detach RPC.
+	err = endpoint.controllerUnpublishVolume(snapVol, claim, uuid.Generate())
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "could not detach from controller",
+		"should fail the mount check before ever reaching the controller detach RPC")
+
+	// without RequireUnmounted, the existing detach flow runs unchanged and
+	// reaches the controller detach RPC.
+	claim.RequireUnmounted = false
+	err = endpoint.controllerUnpublishVolume(snapVol, claim, uuid.Generate())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not detach from controller")
+}
+
+// TestCSIVolumeEndpoint_VolumeLocks_Serializes asserts that concurrent
+// attach and detach operations against the same volume ID serialize via the
+// per-volume lock, never executing at the same time.
+func TestCSIVolumeEndpoint_VolumeLocks_Serializes(t *testing.T) {
+	t.Parallel()
+
+	v := &CSIVolume{}
+
+	var (
+		current int32
+		maxSeen int32
+		wg      sync.WaitGroup
+	)
+
+	hold := func() {
+		release, err := v.locks().acquire("vol-1")
+		require.NoError(t, err)
+		defer release()
+
+		n := atomic.AddInt32(&current, 1)
+		for {
+			old := atomic.LoadInt32(&maxSeen)
+			if n <= old || atomic.CompareAndSwapInt32(&maxSeen, old, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&current, -1)
+	}
+
+	// simulate concurrent attach (Claim) and detach (Unpublish) calls racing
+	// for the same volume
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hold()
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 1, maxSeen, "operations on the same volume should never run concurrently")
+}
+
+// TestCSIVolumeEndpoint_VolumeLocks_DifferentVolumesConcurrent asserts that
+// operations on different volume IDs don't contend with each other.
+func TestCSIVolumeEndpoint_VolumeLocks_DifferentVolumesConcurrent(t *testing.T) {
+	t.Parallel()
+
+	v := &CSIVolume{}
+
+	releaseA, err := v.locks().acquire("vol-a")
+	require.NoError(t, err)
+	defer releaseA()
+
+	done := make(chan struct{})
+	go func() {
+		releaseB, err := v.locks().acquire("vol-b")
+		require.NoError(t, err)
+		releaseB()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("operation on a different volume was blocked by an unrelated volume's lock")
+	}
+}
+
+// TestCSIVolumeEndpoint_VolumeLocks_ContentionTimesOut asserts that an
+// operation contending for an already-locked volume gives up with "volume
+// operation in progress" once the bounded wait elapses, rather than
+// blocking forever.
+func TestCSIVolumeEndpoint_VolumeLocks_ContentionTimesOut(t *testing.T) {
+	t.Parallel()
+
+	v := &CSIVolume{}
+	locks := v.locks()
+	locks.timeout = 10 * time.Millisecond
+
+	release, err := locks.acquire("vol-1")
+	require.NoError(t, err)
+	defer release()
+
+	_, err = locks.acquire("vol-1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "volume operation in progress")
+}
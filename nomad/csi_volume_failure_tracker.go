@@ -0,0 +1,68 @@
+package nomad
+
+import (
+	"sync"
+	"time"
+)
+
+// CSIVolumeFailure records the most recent CSI controller operation failure
+// observed for a given node and plugin pairing.
+type CSIVolumeFailure struct {
+	NodeID    string
+	PluginID  string
+	Operation string // e.g. "attach", "detach"
+	Reason    string
+	Time      time.Time
+}
+
+// CSIVolumeFailureTracker is an in-memory, best-effort record of recent CSI
+// controller attach/detach failures, keyed by node and plugin, so the
+// scheduler can query it to bias placement away from node/plugin
+// combinations that have recently failed. It's not persisted via Raft: a
+// server restart or leadership transfer simply loses the history, which
+// only ever biases scheduling and never affects correctness.
+type CSIVolumeFailureTracker struct {
+	lock     sync.RWMutex
+	failures map[string]*CSIVolumeFailure
+}
+
+// NewCSIVolumeFailureTracker returns a tracker with no recorded failures.
+func NewCSIVolumeFailureTracker() *CSIVolumeFailureTracker {
+	return &CSIVolumeFailureTracker{
+		failures: make(map[string]*CSIVolumeFailure),
+	}
+}
+
+func csiVolumeFailureKey(nodeID, pluginID string) string {
+	return nodeID + "|" + pluginID
+}
+
+// Record stores the given operation as the most recent failure for
+// nodeID/pluginID, overwriting any prior failure recorded for the same
+// pairing. A nil reason is a no-op.
+func (t *CSIVolumeFailureTracker) Record(nodeID, pluginID, operation string, reason error) {
+	if reason == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.failures[csiVolumeFailureKey(nodeID, pluginID)] = &CSIVolumeFailure{
+		NodeID:    nodeID,
+		PluginID:  pluginID,
+		Operation: operation,
+		Reason:    reason.Error(),
+		Time:      time.Now(),
+	}
+}
+
+// Get returns the most recently recorded failure for nodeID/pluginID, and
+// whether one was found.
+func (t *CSIVolumeFailureTracker) Get(nodeID, pluginID string) (*CSIVolumeFailure, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	failure, ok := t.failures[csiVolumeFailureKey(nodeID, pluginID)]
+	return failure, ok
+}
@@ -0,0 +1,44 @@
+package nomad
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSIVolumeFailureTracker_RecordAndGet(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewCSIVolumeFailureTracker()
+
+	// No failure recorded yet
+	_, ok := tracker.Get("node1", "plugin1")
+	require.False(t, ok)
+
+	tracker.Record("node1", "plugin1", "attach", errors.New("attach failed"))
+
+	failure, ok := tracker.Get("node1", "plugin1")
+	require.True(t, ok)
+	require.Equal(t, "node1", failure.NodeID)
+	require.Equal(t, "plugin1", failure.PluginID)
+	require.Equal(t, "attach", failure.Operation)
+	require.Equal(t, "attach failed", failure.Reason)
+	require.False(t, failure.Time.IsZero())
+
+	// A different node/plugin pairing is unaffected
+	_, ok = tracker.Get("node2", "plugin1")
+	require.False(t, ok)
+
+	// Recording again for the same pairing overwrites the prior failure
+	tracker.Record("node1", "plugin1", "detach", errors.New("detach failed"))
+	failure, ok = tracker.Get("node1", "plugin1")
+	require.True(t, ok)
+	require.Equal(t, "detach", failure.Operation)
+	require.Equal(t, "detach failed", failure.Reason)
+
+	// A nil reason is a no-op
+	tracker.Record("node3", "plugin1", "attach", nil)
+	_, ok = tracker.Get("node3", "plugin1")
+	require.False(t, ok)
+}
@@ -235,6 +235,8 @@ func (n *nomadFSM) Apply(log *raft.Log) interface{} {
 		return n.applyDeregisterVaultAccessor(buf[1:], log.Index)
 	case structs.ApplyPlanResultsRequestType:
 		return n.applyPlanResults(msgType, buf[1:], log.Index)
+	case structs.BatchedApplyPlanResultsRequestType:
+		return n.applyBatchedPlanResults(msgType, buf[1:], log.Index)
 	case structs.DeploymentStatusUpdateRequestType:
 		return n.applyDeploymentStatusUpdate(msgType, buf[1:], log.Index)
 	case structs.DeploymentPromoteRequestType:
@@ -985,6 +987,45 @@ func (n *nomadFSM) applyPlanResults(msgType structs.MessageType, buf []byte, ind
 	return nil
 }
 
+// batchedPlanResultsError reports that a BatchedApplyPlanResultsRequest
+// partially applied: sub-requests before FailedIndex committed to the state
+// store, the one at FailedIndex failed with Err, and any sub-requests after
+// it were never attempted. The planner uses FailedIndex to tell its caller
+// which plans actually committed instead of treating the whole batch as
+// rejected.
+type batchedPlanResultsError struct {
+	FailedIndex int
+	Err         error
+}
+
+func (e *batchedPlanResultsError) Error() string { return e.Err.Error() }
+func (e *batchedPlanResultsError) Unwrap() error { return e.Err }
+
+// applyBatchedPlanResults applies each of several plan results, coalesced by
+// the planner because they were evaluated against the same snapshot and
+// confirmed to touch disjoint nodes, within a single Raft log entry. Each
+// sub-request is applied with the same semantics as applyPlanResults; a
+// failure partway through still commits the preceding sub-requests and
+// returns a *batchedPlanResultsError identifying which sub-request failed so
+// the planner can respond to each plan accordingly instead of treating every
+// plan in the batch as rejected.
+func (n *nomadFSM) applyBatchedPlanResults(msgType structs.MessageType, buf []byte, index uint64) interface{} {
+	defer metrics.MeasureSince([]string{"nomad", "fsm", "apply_batched_plan_results"}, time.Now())
+	var req structs.BatchedApplyPlanResultsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	for i, sub := range req.Requests {
+		if err := n.state.UpsertPlanResults(msgType, index, sub); err != nil {
+			n.logger.Error("ApplyPlan failed", "error", err, "batch_index", i)
+			return &batchedPlanResultsError{FailedIndex: i, Err: err}
+		}
+		n.handleUpsertedEvals(sub.PreemptionEvals)
+	}
+	return nil
+}
+
 // applyDeploymentStatusUpdate is used to update the status of an existing
 // deployment
 func (n *nomadFSM) applyDeploymentStatusUpdate(msgType structs.MessageType, buf []byte, index uint64) interface{} {
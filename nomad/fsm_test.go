@@ -3,6 +3,7 @@ package nomad
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -1943,6 +1944,78 @@ func TestFSM_ApplyPlanResults(t *testing.T) {
 
 }
 
+// TestFSM_ApplyBatchedPlanResults_PartialFailure asserts that when one
+// sub-request in a coalesced batch conflicts with a snapshot, the
+// sub-requests ahead of it still commit and the FSM reports exactly which
+// index failed, instead of the whole batch silently rolling back or the
+// caller being unable to tell which plans actually landed.
+func TestFSM_ApplyBatchedPlanResults_PartialFailure(t *testing.T) {
+	t.Parallel()
+	fsm := testFSM(t)
+
+	// Seed an allocation and then bump its ModifyIndex past the snapshot
+	// index the second sub-request below was evaluated against, simulating
+	// a conflicting update that landed between evaluation and apply.
+	staleAlloc := mock.Alloc()
+	require.NoError(t, fsm.State().UpsertJobSummary(1, mock.JobSummary(staleAlloc.JobID)))
+	require.NoError(t, fsm.State().UpsertAllocs(structs.MsgTypeTestSetup, 1, []*structs.Allocation{staleAlloc}))
+	require.NoError(t, fsm.State().UpsertAllocs(structs.MsgTypeTestSetup, 5, []*structs.Allocation{staleAlloc}))
+
+	// The first sub-request places a brand new allocation with no
+	// dependency on staleAlloc, so it should commit cleanly.
+	okAlloc := mock.Alloc()
+	okAlloc.Resources = &structs.Resources{} // COMPAT(0.11): bypass resource creation in state store
+	okJob := okAlloc.Job
+	okAlloc.Job = nil
+	require.NoError(t, fsm.State().UpsertJobSummary(1, mock.JobSummary(okAlloc.JobID)))
+
+	okEval := mock.Eval()
+	okEval.JobID = okJob.ID
+	require.NoError(t, fsm.State().UpsertEvals(structs.MsgTypeTestSetup, 1, []*structs.Evaluation{okEval}))
+
+	okReq := structs.ApplyPlanResultsRequest{
+		AllocUpdateRequest: structs.AllocUpdateRequest{
+			Job:   okJob,
+			Alloc: []*structs.Allocation{okAlloc},
+		},
+		EvalID: okEval.ID,
+	}
+
+	// The second sub-request tries to stop staleAlloc against a snapshot
+	// index that predates the bump above, so checkPlanSnapshotConflict
+	// rejects it.
+	staleReq := structs.ApplyPlanResultsRequest{
+		AllocUpdateRequest: structs.AllocUpdateRequest{
+			AllocsStopped: []*structs.AllocationDiff{
+				{ID: staleAlloc.ID, DesiredDescription: "stopped"},
+			},
+		},
+		SnapshotIndex: 1,
+	}
+
+	req := structs.BatchedApplyPlanResultsRequest{
+		Requests: []*structs.ApplyPlanResultsRequest{&okReq, &staleReq},
+	}
+	buf, err := structs.Encode(structs.BatchedApplyPlanResultsRequestType, req)
+	require.NoError(t, err)
+
+	resp := fsm.Apply(makeLog(buf))
+	batchErr, ok := resp.(*batchedPlanResultsError)
+	require.True(t, ok, "expected a *batchedPlanResultsError, got %T: %v", resp, resp)
+	require.Equal(t, 1, batchErr.FailedIndex)
+	require.True(t, errors.Is(batchErr.Err, structs.ErrPlanStale))
+
+	// The first sub-request still committed despite the second failing.
+	out, err := fsm.State().AllocByID(nil, okAlloc.ID)
+	require.NoError(t, err)
+	require.NotNil(t, out, "sub-requests preceding the failure should still commit")
+
+	// The second sub-request's stop was never applied.
+	stillRunning, err := fsm.State().AllocByID(nil, staleAlloc.ID)
+	require.NoError(t, err)
+	require.Empty(t, stillRunning.DesiredDescription)
+}
+
 func TestFSM_DeploymentStatusUpdate(t *testing.T) {
 	t.Parallel()
 	fsm := testFSM(t)
@@ -243,6 +243,10 @@ func (s *Server) establishLeadership(stopCh chan struct{}) error {
 	_, _ = s.ClusterID()
 	// todo: use cluster ID for stuff, later!
 
+	// Detect any plans left in flight by a leader that crashed mid-apply,
+	// before accepting new plan submissions.
+	s.recoverPlanWAL()
+
 	// Enable the plan queue, since we are now the leader
 	s.planQueue.SetEnabled(true)
 
@@ -2,20 +2,99 @@ package nomad
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
+	"sort"
+	"sync"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
 	log "github.com/hashicorp/go-hclog"
 	memdb "github.com/hashicorp/go-memdb"
 	multierror "github.com/hashicorp/go-multierror"
+	safemetrics "github.com/hashicorp/nomad/helper/metrics"
 	"github.com/hashicorp/nomad/helper/uuid"
 	"github.com/hashicorp/nomad/nomad/state"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/raft"
 )
 
+const (
+	// planOutcomeApplied is recorded when every allocation proposed by a
+	// plan was committed.
+	planOutcomeApplied = "applied"
+
+	// planOutcomePartial is recorded when some, but not all, of the
+	// allocations proposed by a plan were committed.
+	planOutcomePartial = "partial"
+
+	// planOutcomeRejected is recorded when none of the allocations
+	// proposed by a plan were committed.
+	planOutcomeRejected = "rejected"
+
+	// planOutcomeErrored is recorded when a plan failed during evaluation
+	// or application, before an outcome could be determined.
+	planOutcomeErrored = "errored"
+
+	// relaxedEnqueueLimit caps how long a plan apply with
+	// structs.PlanWriteConsistencyRelaxed will wait to enqueue into Raft.
+	// It's shorter than enqueueLimit so that batch-job plans, which
+	// operators have opted into trading durability for throughput on, fail
+	// fast under apply-pipeline contention instead of queuing behind
+	// strict-consistency work.
+	relaxedEnqueueLimit = 2 * time.Second
+)
+
+// planApplyEnqueueTimeout returns how long a plan's Raft apply should wait
+// to enqueue, based on the plan's write-consistency hint.
+func planApplyEnqueueTimeout(consistency structs.PlanWriteConsistency) time.Duration {
+	if consistency == structs.PlanWriteConsistencyRelaxed {
+		return relaxedEnqueueLimit
+	}
+	return enqueueLimit
+}
+
+// emitPlanOutcomeMetric records a nomad.plan.outcome counter labeled by
+// outcome, giving operators a scheduling health breakdown of how often
+// plans are fully applied, partially applied, fully rejected, or error out.
+// It's called exactly once per plan, at whichever point its outcome
+// becomes final: an error at any stage is recorded as errored, and a
+// successfully applied plan is classified by how much of it was committed.
+func emitPlanOutcomeMetric(plan *structs.Plan, result *structs.PlanResult, err error, logger log.Logger) {
+	outcome := planOutcomeErrored
+	if err == nil {
+		outcome = planOutcome(plan, result)
+	}
+
+	safemetrics.IncrCounterWithLabels([]string{"nomad", "plan", "outcome"}, 1,
+		[]metrics.Label{{Name: "type", Value: outcome}}, logger)
+}
+
+// emitPlanSizeMetrics records how large a single plan application was, so
+// operators can correlate scheduling latency (nomad.plan.evaluate) with how
+// much work the plan actually did.
+func emitPlanSizeMetrics(nodesEvaluated, nodesSkipped, allocsPlaced, evictions int, logger log.Logger) {
+	safemetrics.IncrCounter([]string{"nomad", "plan", "node_count"}, float32(nodesEvaluated), logger)
+	safemetrics.IncrCounter([]string{"nomad", "plan", "nodes_skipped"}, float32(nodesSkipped), logger)
+	safemetrics.IncrCounter([]string{"nomad", "plan", "alloc_placed"}, float32(allocsPlaced), logger)
+	safemetrics.IncrCounter([]string{"nomad", "plan", "alloc_evicted"}, float32(evictions), logger)
+}
+
+// planOutcome classifies a successfully evaluated plan by how many of its
+// proposed allocations were committed in the result.
+func planOutcome(plan *structs.Plan, result *structs.PlanResult) string {
+	full, expected, actual := result.FullCommit(plan)
+	switch {
+	case full:
+		return planOutcomeApplied
+	case actual == 0 && expected > 0:
+		return planOutcomeRejected
+	default:
+		return planOutcomePartial
+	}
+}
+
 // planner is used to manage the submitted allocation plans that are waiting
 // to be accessed by the leader
 type planner struct {
@@ -25,6 +104,112 @@ type planner struct {
 	// planQueue is used to manage the submitted allocation
 	// plans that are waiting to be assessed by the leader
 	planQueue *PlanQueue
+
+	// applyLock serializes the Raft apply step of plan application across
+	// planApply's evaluation workers. Evaluation may happen concurrently,
+	// but committing a plan's result to Raft is always done one at a time.
+	applyLock sync.Mutex
+
+	// nodeReservations provides admission control across planApply's
+	// evaluation workers: a plan's candidate nodes are held from just
+	// before it's evaluated until its outcome is final, so two workers
+	// can never evaluate plans against the same node off of independent,
+	// equally-stale snapshots and both believe the node has room.
+	nodeReservations *nodeReservations
+
+	// breaker fast-fails plan applies once Raft has failed them
+	// consecutively too many times, rather than letting every queued plan
+	// pay for its own apply timeout against a Raft cluster that's unlikely
+	// to recover mid-queue.
+	breaker *planApplyCircuitBreaker
+
+	// applyRate tracks the rolling rate of successful plan applies, exposed
+	// as the nomad.plan.apply_rate gauge and via Server.Stats for capacity
+	// planning.
+	applyRate *planApplyRateTracker
+
+	// hooksLock guards hooks, which may be registered at any time but is
+	// read concurrently by every plan evaluation worker.
+	hooksLock sync.RWMutex
+
+	// hooks holds the plan-apply hooks registered via RegisterPlanApplyHook,
+	// keyed by the job type they're scoped to.
+	hooks map[string][]PlanApplyHook
+
+	// wal is an optional write-ahead log of dequeued-but-unapplied plans,
+	// used to detect plans abandoned by a leader that crashed mid-apply.
+	// It's nil when the server has no DataDir (e.g. an in-memory test
+	// server), in which case this durability enhancement is simply
+	// disabled.
+	wal *planWAL
+}
+
+// PlanApplyHook is invoked against a plan immediately before it's
+// evaluated, scoped to the job type it was registered under. Returning an
+// error rejects the plan before any node fit-evaluation or Raft apply
+// occurs.
+type PlanApplyHook func(plan *structs.Plan) error
+
+// RegisterPlanApplyHook registers a hook to run, in registration order,
+// against every plan whose job is of the given type. It's intended to be
+// called during server setup, before the plan apply loop starts processing
+// plans concurrently.
+func (p *planner) RegisterPlanApplyHook(jobType string, hook PlanApplyHook) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+
+	if p.hooks == nil {
+		p.hooks = make(map[string][]PlanApplyHook)
+	}
+	p.hooks[jobType] = append(p.hooks[jobType], hook)
+}
+
+// runPlanApplyHooks runs the hooks registered for plan's job type, in
+// registration order, stopping at and returning the first error
+// encountered. Plans with no Job, or whose job type has no registered
+// hooks, are left untouched.
+func (p *planner) runPlanApplyHooks(plan *structs.Plan) error {
+	if plan.Job == nil {
+		return nil
+	}
+
+	p.hooksLock.RLock()
+	hooks := p.hooks[plan.Job.Type]
+	p.hooksLock.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAllocationDeltaLimit enforces the server's PlanAllocationDeltaLimit:
+// it rejects plan whose net increase in allocation count (placements minus
+// evictions) exceeds the configured threshold, unless the plan opted out
+// via AllowAllocationDeltaOverride. A zero (the default) or negative limit
+// disables the check.
+func (p *planner) checkAllocationDeltaLimit(plan *structs.Plan) error {
+	limit := p.config.PlanAllocationDeltaLimit
+	if limit <= 0 || plan.AllowAllocationDeltaOverride {
+		return nil
+	}
+
+	var placed, evicted int
+	for _, allocs := range plan.NodeAllocation {
+		placed += len(allocs)
+	}
+	for _, allocs := range plan.NodeUpdate {
+		evicted += len(allocs)
+	}
+
+	delta := placed - evicted
+	if delta > limit {
+		return fmt.Errorf("%w: plan exceeds allocation delta limit (delta=%d, limit=%d)",
+			structs.ErrPlanRejected, delta, limit)
+	}
+	return nil
 }
 
 // newPlanner returns a new planner to be used for managing allocation plans.
@@ -35,11 +220,67 @@ func newPlanner(s *Server) (*planner, error) {
 		return nil, err
 	}
 
-	return &planner{
-		Server:    s,
-		log:       s.logger.Named("planner"),
-		planQueue: planQueue,
-	}, nil
+	p := &planner{
+		Server:           s,
+		log:              s.logger.Named("planner"),
+		planQueue:        planQueue,
+		breaker:          newPlanApplyCircuitBreaker(planApplyCircuitBreakerThreshold, planApplyCircuitBreakerCooldown),
+		applyRate:        newPlanApplyRateTracker(),
+		nodeReservations: newNodeReservations(),
+	}
+
+	if s.config.DataDir != "" {
+		wal, err := newPlanWAL(s.config.DataDir, p.log)
+		if err != nil {
+			return nil, err
+		}
+		p.wal = wal
+		p.wal.attach(p.planQueue)
+	}
+
+	return p, nil
+}
+
+// recoverPlanWAL logs any plans that were dequeued by a previous leader but
+// never reached a terminal outcome before that leader's process exited, so
+// operators have visibility into work lost to a mid-apply crash. The evals
+// named by those plans will be redelivered to a scheduler worker on their
+// own, via the normal RPC-failure handling in the worker that submitted
+// them; this is purely a detection and observability aid. It's a no-op if
+// the write-ahead log is disabled.
+func (p *planner) recoverPlanWAL() {
+	if p.wal == nil {
+		return
+	}
+
+	evalIDs, err := p.wal.Recover()
+	if err != nil {
+		p.log.Warn("failed to recover plan write-ahead log", "error", err)
+		return
+	}
+
+	for _, evalID := range evalIDs {
+		p.log.Warn("recovered plan abandoned by a previous leader mid-apply", "eval_id", evalID)
+		metrics.IncrCounter([]string{"nomad", "plan", "wal_recovered"}, 1)
+	}
+}
+
+// PausePlanApply temporarily stops the plan apply loop from dequeuing new
+// plans, without disabling the queue or stepping down leadership. Plans
+// already queued, or submitted while paused, remain queued up to a
+// threshold; submissions beyond that threshold are rejected with a
+// retryable error so callers back off instead of piling up indefinitely.
+// It's meant for brief maintenance windows, such as applying an upgrade
+// to plan evaluation logic, where a full leadership transfer would be
+// disruptive.
+func (p *planner) PausePlanApply() {
+	p.planQueue.Pause()
+}
+
+// ResumePlanApply allows the plan apply loop to resume dequeuing plans
+// queued during a prior PausePlanApply, draining them normally.
+func (p *planner) ResumePlanApply() {
+	p.planQueue.Resume()
 }
 
 // planApply is a long lived goroutine that reads plan allocations from
@@ -68,7 +309,40 @@ func newPlanner(s *Server) (*planner, error) {
 // the Raft log is updated. This means our schedulers will stall,
 // but there are many of those and only a single plan verifier.
 //
+// When Config.PlanEvaluationConcurrency is greater than 1, multiple
+// instances of this pipeline run concurrently, each dequeuing and
+// evaluating its own stream of plans. This is useful when evaluation
+// (which runs against a potentially large state snapshot) rather than
+// apply is the bottleneck. The actual Raft apply of a plan's result is
+// always serialized via applyLock, but that alone doesn't prevent two
+// workers from evaluating plans against the same node at once: before
+// evaluating, a worker reserves every node its plan may touch via
+// nodeReservations, so a conflicting plan held by another worker blocks
+// until that worker's outcome (response or Raft commit) is final, and
+// a worker that had to wait refreshes its snapshot before evaluating so
+// it observes whatever that worker just committed.
 func (p *planner) planApply() {
+	concurrency := p.config.PlanEvaluationConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.planApplyWorker()
+		}()
+	}
+	wg.Wait()
+}
+
+// planApplyWorker pulls plans off the plan queue and runs them through the
+// evaluate/apply pipeline described in planApply's comment. It returns once
+// the plan queue reports it is no longer enabled, i.e. once leadership is
+// lost.
+func (p *planner) planApplyWorker() {
 	// planIndexCh is used to track an outstanding application and receive
 	// its committed index while snap holds an optimistic state which
 	// includes that plan application.
@@ -100,6 +374,24 @@ func (p *planner) planApply() {
 			return
 		}
 
+		// logger carries this plan's correlation ID on every log line
+		// emitted while processing it, so its enqueue->evaluate->apply
+		// journey can be traced across log aggregation.
+		logger := p.logger.With("correlation_id", pending.plan.CorrelationID)
+
+		// Reserve every node this plan may touch before evaluating it, so
+		// no other worker can evaluate a conflicting plan against the same
+		// node until this one reaches a final outcome. If another worker
+		// held one of these nodes, our snapshot may not reflect whatever
+		// it just committed, so force a refresh against the latest applied
+		// index rather than trusting prevPlanResultIndex, which only
+		// tracks plans this worker itself applied.
+		planNodes := planCandidateNodes(pending.plan)
+		if p.nodeReservations.acquire(planNodes) {
+			prevPlanResultIndex = max(prevPlanResultIndex, p.raft.AppliedIndex())
+			snap = nil
+		}
+
 		// If last plan has completed get a new snapshot
 		select {
 		case idx := <-planIndexCh:
@@ -130,23 +422,61 @@ func (p *planner) planApply() {
 		if planIndexCh == nil || snap == nil {
 			snap, err = p.snapshotMinIndex(prevPlanResultIndex, pending.plan.SnapshotIndex)
 			if err != nil {
-				p.logger.Error("failed to snapshot state", "error", err)
-				pending.respond(nil, err)
+				logger.Error("failed to snapshot state", "error", err)
+				emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+				p.respond(pending, nil, err)
+				p.nodeReservations.release(planNodes)
 				continue
 			}
 		}
 
+		// Run any job-type-scoped plan-apply hooks before evaluating the
+		// plan against node capacity.
+		if err := p.runPlanApplyHooks(pending.plan); err != nil {
+			err = fmt.Errorf("%w: %v", structs.ErrPlanRejected, err)
+			logger.Error("plan rejected by plan-apply hook", "error", err)
+			emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+			p.respond(pending, nil, err)
+			p.nodeReservations.release(planNodes)
+			continue
+		}
+
+		// Reject plans whose net allocation increase is implausibly large
+		// before spending any evaluation work on them.
+		if err := p.checkAllocationDeltaLimit(pending.plan); err != nil {
+			logger.Error("plan rejected by allocation delta limit", "error", err)
+			emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+			p.respond(pending, nil, err)
+			p.nodeReservations.release(planNodes)
+			continue
+		}
+
 		// Evaluate the plan
-		result, err := evaluatePlan(pool, snap, pending.plan, p.logger)
+		result, err := p.evaluatePlanWithTimeout(pool, snap, pending.plan, logger)
 		if err != nil {
-			p.logger.Error("failed to evaluate plan", "error", err)
-			pending.respond(nil, err)
+			logger.Error("failed to evaluate plan", "error", err)
+			emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+			p.respond(pending, nil, err)
+			p.nodeReservations.release(planNodes)
 			continue
 		}
 
 		// Fast-path the response if there is nothing to do
 		if result.IsNoOp() {
-			pending.respond(result, nil)
+			emitPlanOutcomeMetric(pending.plan, result, nil, p.logger)
+			p.respond(pending, result, nil)
+			p.nodeReservations.release(planNodes)
+			continue
+		}
+
+		// DryRun plans report their full evaluation result without ever
+		// reaching applyPlan, so the submitter sees the same placement and
+		// eviction decisions a real plan would have made without anything
+		// being committed.
+		if pending.plan.DryRun {
+			emitPlanOutcomeMetric(pending.plan, result, nil, p.logger)
+			p.respond(pending, result, nil)
+			p.nodeReservations.release(planNodes)
 			continue
 		}
 
@@ -157,24 +487,297 @@ func (p *planner) planApply() {
 			prevPlanResultIndex = max(prevPlanResultIndex, idx)
 			snap, err = p.snapshotMinIndex(prevPlanResultIndex, pending.plan.SnapshotIndex)
 			if err != nil {
-				p.logger.Error("failed to update snapshot state", "error", err)
-				pending.respond(nil, err)
+				logger.Error("failed to update snapshot state", "error", err)
+				emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+				p.respond(pending, nil, err)
+				p.nodeReservations.release(planNodes)
 				continue
 			}
 		}
 
-		// Dispatch the Raft transaction for the plan
-		future, err := p.applyPlan(pending.plan, result, snap)
+		// batchNodes accumulates every node newly reserved by a plan that
+		// ends up part of this batch's Raft apply, so they can all be
+		// released together once the batch's outcome is final. heldNodes
+		// tracks every node this worker holds a reservation on this round,
+		// including ones applied individually below, so that a later extra
+		// touching the same node (e.g. an update and an evict on the node
+		// the main plan already reserved) reuses this worker's existing
+		// reservation instead of acquiring it again and deadlocking against
+		// itself.
+		batchNodes := append([]string(nil), planNodes...)
+		heldNodes := make(map[string]struct{}, len(planNodes))
+		for _, nodeID := range planNodes {
+			heldNodes[nodeID] = struct{}{}
+		}
+
+		// Opportunistically drain and coalesce additional already-pending
+		// plans that touch disjoint nodes, so they share a single Raft
+		// apply instead of each paying for their own. This only matters
+		// under heavy scheduling load, where TryDequeue will usually find
+		// nothing and the batch degrades to the single-plan case below.
+		batch := []*pendingPlan{pending}
+		results := []*structs.PlanResult{result}
+		if maxCoalesce := p.config.PlanCoalesceMaxPlans; maxCoalesce > 0 && isCoalescable(result) {
+			touched := planTouchedNodes(result)
+			for i := 0; i < maxCoalesce; i++ {
+				extra, err := p.planQueue.TryDequeue()
+				if err != nil || extra == nil {
+					break
+				}
+				extraLogger := p.logger.With("correlation_id", extra.plan.CorrelationID)
+
+				// extra was already dequeued, so it must either be
+				// reserved, evaluated, and resolved to a final outcome
+				// here, or handed back to the queue below for some other
+				// worker to pick up. Nodes this worker already holds this
+				// round don't need to be acquired again.
+				extraNodes := planCandidateNodes(extra.plan)
+				newNodes := make([]string, 0, len(extraNodes))
+				for _, nodeID := range extraNodes {
+					if _, ok := heldNodes[nodeID]; !ok {
+						newNodes = append(newNodes, nodeID)
+					}
+				}
+
+				// Use a non-blocking try-acquire here, not acquire: this
+				// worker is still holding its main plan's (and any earlier
+				// extras') reservations, so a blocking acquire for newNodes
+				// can form a circular wait against another worker doing the
+				// same thing in the opposite order (worker A holds n1 and
+				// wants n2, worker B holds n2 and wants n1) with nothing to
+				// break the deadlock. Coalescing is purely an optimization,
+				// so on conflict give up on it for this round and return
+				// the plan to the queue instead of blocking.
+				acquired := p.nodeReservations.tryAcquire(newNodes)
+				if !acquired {
+					p.planQueue.Requeue(extra)
+					break
+				}
+				for _, nodeID := range extraNodes {
+					heldNodes[nodeID] = struct{}{}
+				}
+
+				extraSnap := snap
+				extraResult, err := p.evaluatePlanWithTimeout(pool, extraSnap, extra.plan, extraLogger)
+				if err != nil {
+					extraLogger.Error("failed to evaluate plan", "error", err)
+					emitPlanOutcomeMetric(extra.plan, nil, err, p.logger)
+					p.respond(extra, nil, err)
+					p.nodeReservations.release(newNodes)
+					continue
+				}
+				if extraResult.IsNoOp() {
+					emitPlanOutcomeMetric(extra.plan, extraResult, nil, p.logger)
+					p.respond(extra, extraResult, nil)
+					p.nodeReservations.release(newNodes)
+					continue
+				}
+
+				extraTouched := planTouchedNodes(extraResult)
+				if !isCoalescable(extraResult) || nodeSetsOverlap(touched, extraTouched) {
+					// Conflicting or non-coalescable plans fall back to
+					// individual application rather than blocking the batch.
+					// Any node extra shares with the main batch stays held
+					// until the batch itself releases it below, since Raft
+					// commits plans in submission order and this apply is
+					// always submitted first.
+					p.applyLock.Lock()
+					future, err := p.applyPlan(extra.plan, extraResult, extraSnap)
+					p.applyLock.Unlock()
+					if err != nil {
+						extraLogger.Error("failed to submit plan", "error", err)
+						emitPlanOutcomeMetric(extra.plan, nil, err, p.logger)
+						p.respond(extra, nil, err)
+						p.nodeReservations.release(newNodes)
+						continue
+					}
+					extraIndexCh := make(chan uint64, 1)
+					go func() {
+						p.asyncPlanWait(extraIndexCh, future, extraResult, extra)
+						p.nodeReservations.release(newNodes)
+					}()
+					continue
+				}
+
+				for node := range extraTouched {
+					touched[node] = struct{}{}
+				}
+				batch = append(batch, extra)
+				results = append(results, extraResult)
+				batchNodes = append(batchNodes, newNodes...)
+			}
+		}
+
+		// Dispatch the Raft transaction for the plan (or coalesced batch of
+		// plans). This is serialized across all evaluation workers so that
+		// plans are always committed in a consistent order, even though
+		// their evaluation may have happened concurrently.
+		p.applyLock.Lock()
+		future, err := p.applyPlanBatch(batch, results, snap)
+		p.applyLock.Unlock()
 		if err != nil {
-			p.logger.Error("failed to submit plan", "error", err)
-			pending.respond(nil, err)
+			logger.Error("failed to submit plan", "error", err)
+			for _, pp := range batch {
+				emitPlanOutcomeMetric(pp.plan, nil, err, p.logger)
+				p.respond(pp, nil, err)
+			}
+			p.nodeReservations.release(batchNodes)
 			continue
 		}
 
-		// Respond to the plan in async; receive plan's committed index via chan
+		// Respond to the plan(s) in async; receive the batch's committed
+		// index via chan
 		planIndexCh = make(chan uint64, 1)
-		go p.asyncPlanWait(planIndexCh, future, result, pending)
+		go func() {
+			p.asyncBatchPlanWait(planIndexCh, future, batch, results)
+			p.nodeReservations.release(batchNodes)
+		}()
+	}
+}
+
+// isCoalescable reports whether a plan result is simple enough to be
+// coalesced with other plans into a single Raft apply. Plans that manage a
+// deployment or preempt other allocations carry per-plan follow-up work
+// (deployment status updates, preemption evals) that the batched FSM apply
+// doesn't attempt to merge across plans, so those are always applied alone.
+func isCoalescable(result *structs.PlanResult) bool {
+	return result.Deployment == nil && len(result.DeploymentUpdates) == 0 && len(result.NodePreemptions) == 0
+}
+
+// planTouchedNodes returns the set of node IDs a plan result places
+// allocations on or evicts allocations from.
+func planTouchedNodes(result *structs.PlanResult) map[string]struct{} {
+	touched := make(map[string]struct{}, len(result.NodeAllocation)+len(result.NodeUpdate))
+	for nodeID := range result.NodeAllocation {
+		touched[nodeID] = struct{}{}
+	}
+	for nodeID := range result.NodeUpdate {
+		touched[nodeID] = struct{}{}
+	}
+	return touched
+}
+
+// nodeSetsOverlap reports whether a and b share any node ID.
+func nodeSetsOverlap(a, b map[string]struct{}) bool {
+	small, big := a, b
+	if len(big) < len(small) {
+		small, big = big, small
+	}
+	for node := range small {
+		if _, ok := big[node]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// planCandidateNodes returns the node IDs a plan may place allocations on or
+// evict allocations from, before evaluation has decided which of those
+// actually happen. It's used to reserve a plan's nodes for cross-worker
+// admission control prior to evaluating it.
+func planCandidateNodes(plan *structs.Plan) []string {
+	seen := make(map[string]struct{}, len(plan.NodeAllocation)+len(plan.NodeUpdate))
+	for nodeID := range plan.NodeAllocation {
+		seen[nodeID] = struct{}{}
+	}
+	for nodeID := range plan.NodeUpdate {
+		seen[nodeID] = struct{}{}
+	}
+
+	nodeIDs := make([]string, 0, len(seen))
+	for nodeID := range seen {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	return nodeIDs
+}
+
+// nodeReservations provides the cross-worker admission control that
+// planApply's evaluation workers rely on: a plan's candidate nodes are held
+// from just before it's evaluated until its outcome is final, so two
+// workers can never evaluate plans against the same node off of
+// independent, equally-stale snapshots and both conclude the node has
+// room.
+type nodeReservations struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	held map[string]struct{}
+}
+
+func newNodeReservations() *nodeReservations {
+	r := &nodeReservations{held: make(map[string]struct{})}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// acquire blocks until none of nodeIDs are held by another reservation,
+// then holds all of them. It reports whether it had to wait for a
+// conflicting reservation to clear; callers use that to decide whether
+// their state snapshot might already be stale relative to whatever the
+// conflicting worker just committed.
+func (r *nodeReservations) acquire(nodeIDs []string) (waited bool) {
+	if len(nodeIDs) == 0 {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.conflictsLocked(nodeIDs) {
+		waited = true
+		r.cond.Wait()
+	}
+	for _, nodeID := range nodeIDs {
+		r.held[nodeID] = struct{}{}
+	}
+	return waited
+}
+
+// tryAcquire is the non-blocking counterpart to acquire: if none of
+// nodeIDs are currently held, it acquires all of them and reports success;
+// if any conflict, it acquires none of them and reports failure without
+// blocking. Use this instead of acquire whenever the caller may itself
+// already be holding other reservations, since a second blocking acquire
+// in that situation can form a circular wait against another caller doing
+// the same thing in the opposite order.
+func (r *nodeReservations) tryAcquire(nodeIDs []string) (acquired bool) {
+	if len(nodeIDs) == 0 {
+		return true
 	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conflictsLocked(nodeIDs) {
+		return false
+	}
+	for _, nodeID := range nodeIDs {
+		r.held[nodeID] = struct{}{}
+	}
+	return true
+}
+
+func (r *nodeReservations) conflictsLocked(nodeIDs []string) bool {
+	for _, nodeID := range nodeIDs {
+		if _, ok := r.held[nodeID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// release clears nodeIDs' reservations and wakes any workers waiting on
+// them.
+func (r *nodeReservations) release(nodeIDs []string) {
+	if len(nodeIDs) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	for _, nodeID := range nodeIDs {
+		delete(r.held, nodeID)
+	}
+	r.mu.Unlock()
+	r.cond.Broadcast()
 }
 
 // snapshotMinIndex wraps SnapshotAfter with a 5s timeout and converts timeout
@@ -182,7 +785,7 @@ func (p *planner) planApply() {
 // include both the previous plan and all objects referenced by the plan or
 // return an error.
 func (p *planner) snapshotMinIndex(prevPlanResultIndex, planSnapshotIndex uint64) (*state.StateSnapshot, error) {
-	defer metrics.MeasureSince([]string{"nomad", "plan", "wait_for_index"}, time.Now())
+	defer safemetrics.MeasureSince([]string{"nomad", "plan", "wait_for_index"}, time.Now(), p.logger)
 
 	// Minimum index the snapshot must include is the max of the previous
 	// plan result's and current plan's snapshot index.
@@ -193,16 +796,126 @@ func (p *planner) snapshotMinIndex(prevPlanResultIndex, planSnapshotIndex uint64
 	snap, err := p.fsm.State().SnapshotMinIndex(ctx, minIndex)
 	cancel()
 	if err == context.DeadlineExceeded {
-		return nil, fmt.Errorf("timed out after %s waiting for index=%d (previous plan result index=%d; plan snapshot index=%d)",
-			timeout, minIndex, prevPlanResultIndex, planSnapshotIndex)
+		return nil, fmt.Errorf("%w: timed out after %s waiting for index=%d (previous plan result index=%d; plan snapshot index=%d)",
+			structs.ErrPlanTimeout, timeout, minIndex, prevPlanResultIndex, planSnapshotIndex)
 	}
 
 	return snap, err
 }
 
+// evaluatePlanWithTimeout runs evaluatePlan but gives up after
+// p.config.PlanEvaluationTimeout, converting a timeout into a
+// structs.ErrPlanTimeout-wrapped error. This protects the worker from
+// stalling indefinitely on a pathological plan or a wedged state snapshot;
+// without it, one bad plan could block every plan behind it in the queue.
+// The abandoned evaluation goroutine runs to completion in the background
+// and its result is discarded.
+func (p *planner) evaluatePlanWithTimeout(pool *EvaluatePool, snap *state.StateSnapshot, plan *structs.Plan, logger log.Logger) (*structs.PlanResult, error) {
+	timeout := p.config.PlanEvaluationTimeout
+	if timeout <= 0 {
+		timeout = PlanEvaluationTimeoutDefault
+	}
+
+	resultCh := make(chan *structs.PlanResult, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		result, err := evaluatePlan(pool, snap, plan, logger)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- result
+	}()
+
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case err := <-errCh:
+		return nil, err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%w: evaluation exceeded %s", structs.ErrPlanTimeout, timeout)
+	}
+}
+
 // applyPlan is used to apply the plan result and to return the alloc index
 func (p *planner) applyPlan(plan *structs.Plan, result *structs.PlanResult, snap *state.StateSnapshot) (raft.ApplyFuture, error) {
-	// Setup the update request
+	if !p.breaker.allow() {
+		return nil, fmt.Errorf("%w: plan apply circuit open", structs.ErrPlanTimeout)
+	}
+
+	req := p.buildApplyPlanResultsRequest(plan, result)
+
+	// Dispatch the Raft transaction, honoring the plan's write-consistency
+	// hint for how long to wait to enqueue behind other in-flight applies.
+	enqueueTimeout := planApplyEnqueueTimeout(plan.WriteConsistency)
+	future, err := p.raftApplyFutureWithEnqueueTimeout(structs.ApplyPlanResultsRequestType, &req, enqueueTimeout)
+	if err != nil {
+		p.breaker.recordFailure()
+		return nil, classifyPlanApplyErr(err)
+	}
+
+	// Optimistically apply to our state view
+	if snap != nil {
+		nextIdx := p.raft.AppliedIndex() + 1
+		if err := snap.UpsertPlanResults(structs.ApplyPlanResultsRequestType, nextIdx, &req); err != nil {
+			return future, fmt.Errorf("%w: %v", structs.ErrPlanInvalid, err)
+		}
+	}
+	return future, nil
+}
+
+// applyPlanBatch is used to apply one or more already-evaluated,
+// mutually-disjoint plans as a single Raft transaction. With a single plan
+// it behaves exactly like applyPlan; with more than one, it submits a
+// structs.BatchedApplyPlanResultsRequest instead, so the plans share a
+// single Raft apply.
+func (p *planner) applyPlanBatch(batch []*pendingPlan, results []*structs.PlanResult, snap *state.StateSnapshot) (raft.ApplyFuture, error) {
+	if len(batch) == 1 {
+		return p.applyPlan(batch[0].plan, results[0], snap)
+	}
+
+	if !p.breaker.allow() {
+		return nil, fmt.Errorf("%w: plan apply circuit open", structs.ErrPlanTimeout)
+	}
+
+	req := structs.BatchedApplyPlanResultsRequest{
+		Requests: make([]*structs.ApplyPlanResultsRequest, 0, len(batch)),
+	}
+	for i, pending := range batch {
+		sub := p.buildApplyPlanResultsRequest(pending.plan, results[i])
+		req.Requests = append(req.Requests, &sub)
+	}
+
+	// The most conservative (strictest) write-consistency among the
+	// coalesced plans governs how long the combined apply waits to enqueue.
+	enqueueTimeout := planApplyEnqueueTimeout(structs.PlanWriteConsistencyRelaxed)
+	for _, pending := range batch {
+		if t := planApplyEnqueueTimeout(pending.plan.WriteConsistency); t > enqueueTimeout {
+			enqueueTimeout = t
+		}
+	}
+
+	future, err := p.raftApplyFutureWithEnqueueTimeout(structs.BatchedApplyPlanResultsRequestType, &req, enqueueTimeout)
+	if err != nil {
+		p.breaker.recordFailure()
+		return nil, classifyPlanApplyErr(err)
+	}
+
+	if snap != nil {
+		nextIdx := p.raft.AppliedIndex() + 1
+		for _, sub := range req.Requests {
+			if err := snap.UpsertPlanResults(structs.ApplyPlanResultsRequestType, nextIdx, sub); err != nil {
+				return future, fmt.Errorf("%w: %v", structs.ErrPlanInvalid, err)
+			}
+		}
+	}
+	return future, nil
+}
+
+// buildApplyPlanResultsRequest normalizes an evaluated plan's result into
+// the request the FSM applies, including the namespaced follow-up evals
+// for any allocations the plan preempted.
+func (p *planner) buildApplyPlanResultsRequest(plan *structs.Plan, result *structs.PlanResult) structs.ApplyPlanResultsRequest {
 	req := structs.ApplyPlanResultsRequest{
 		AllocUpdateRequest: structs.AllocUpdateRequest{
 			Job: plan.Job,
@@ -210,11 +923,14 @@ func (p *planner) applyPlan(plan *structs.Plan, result *structs.PlanResult, snap
 		Deployment:        result.Deployment,
 		DeploymentUpdates: result.DeploymentUpdates,
 		EvalID:            plan.EvalID,
+		SnapshotIndex:     plan.SnapshotIndex,
 	}
 
 	preemptedJobIDs := make(map[structs.NamespacedID]struct{})
 	now := time.Now().UTC().UnixNano()
 
+	setMigrationHints(result)
+
 	if ServersMeetMinimumVersion(p.Members(), MinVersionPlanNormalization, true) {
 		// Initialize the allocs request using the new optimized log entry format.
 		// Determine the minimum number of updates, could be more if there
@@ -301,20 +1017,23 @@ func (p *planner) applyPlan(plan *structs.Plan, result *structs.PlanResult, snap
 	}
 	req.PreemptionEvals = evals
 
-	// Dispatch the Raft transaction
-	future, err := p.raftApplyFuture(structs.ApplyPlanResultsRequestType, &req)
-	if err != nil {
-		return nil, err
-	}
+	return req
+}
 
-	// Optimistically apply to our state view
-	if snap != nil {
-		nextIdx := p.raft.AppliedIndex() + 1
-		if err := snap.UpsertPlanResults(structs.ApplyPlanResultsRequestType, nextIdx, &req); err != nil {
-			return future, err
-		}
+// classifyPlanApplyErr maps an error returned by a Raft apply into the plan
+// error taxonomy, so callers can branch on errors.Is instead of matching
+// strings. Leadership errors are distinguished from other Raft failures
+// because they mean the scheduler should simply retry once a new leader is
+// elected, rather than back off or give up.
+func classifyPlanApplyErr(err error) error {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost):
+		return fmt.Errorf("%w: %v", structs.ErrNotLeader, err)
+	case errors.Is(err, raft.ErrEnqueueTimeout):
+		return fmt.Errorf("%w: %v", structs.ErrPlanTimeout, err)
+	default:
+		return fmt.Errorf("%w: %v", structs.ErrPlanInvalid, err)
 	}
-	return future, nil
 }
 
 // normalizePreemptedAlloc removes redundant fields from a preempted allocation and
@@ -340,6 +1059,36 @@ func normalizeStoppedAlloc(stoppedAlloc *structs.Allocation, now int64) *structs
 		ClientStatus:       stoppedAlloc.ClientStatus,
 		ModifyTime:         now,
 		FollowupEvalID:     stoppedAlloc.FollowupEvalID,
+		MigrationHint:      stoppedAlloc.MigrationHint,
+	}
+}
+
+// setMigrationHints annotates evicted allocations in result.NodeUpdate with a
+// MigrationHint describing where the scheduler intends to move them. If a
+// new allocation in result.NodeAllocation points back to the evicted alloc
+// via PreviousAllocation, the hint is set to that allocation's target node.
+// Otherwise, if the evicted alloc's DesiredTransition indicates a migration
+// is desired but no same-plan replacement was found, the hint is set to the
+// literal string "any". This is purely informational and is left empty for
+// evictions that aren't migrations.
+func setMigrationHints(result *structs.PlanResult) {
+	targets := make(map[string]string)
+	for _, allocList := range result.NodeAllocation {
+		for _, alloc := range allocList {
+			if alloc.PreviousAllocation != "" {
+				targets[alloc.PreviousAllocation] = alloc.NodeID
+			}
+		}
+	}
+
+	for _, updateList := range result.NodeUpdate {
+		for _, stoppedAlloc := range updateList {
+			if target, ok := targets[stoppedAlloc.ID]; ok {
+				stoppedAlloc.MigrationHint = target
+			} else if stoppedAlloc.DesiredTransition.ShouldMigrate() {
+				stoppedAlloc.MigrationHint = "any"
+			}
+		}
 	}
 }
 
@@ -367,18 +1116,45 @@ func updateAllocTimestamps(allocations []*structs.Allocation, timestamp int64) {
 // closed.
 func (p *planner) asyncPlanWait(indexCh chan<- uint64, future raft.ApplyFuture,
 	result *structs.PlanResult, pending *pendingPlan) {
-	defer metrics.MeasureSince([]string{"nomad", "plan", "apply"}, time.Now())
+	defer safemetrics.MeasureSince([]string{"nomad", "plan", "apply"}, time.Now(), p.logger)
+	logger := p.logger.With("correlation_id", pending.plan.CorrelationID)
 
 	// Wait for the plan to apply
 	if err := future.Error(); err != nil {
-		p.logger.Error("failed to apply plan", "error", err)
-		pending.respond(nil, err)
+		p.breaker.recordFailure()
+		err = classifyPlanApplyErr(err)
+		logger.Error("failed to apply plan", "error", err)
+		emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+		p.respond(pending, nil, err)
 
 		// Close indexCh on error
 		close(indexCh)
 		return
 	}
 
+	// A non-nil FSM response means applyPlanResults rejected the write
+	// itself, most commonly because checkPlanSnapshotConflict detected that
+	// an allocation the plan wanted to stop or preempt was modified after
+	// the plan's snapshot was taken. That's expected scheduler-retry
+	// backpressure rather than an operational failure, so it doesn't trip
+	// the circuit breaker the way a Raft-level failure does.
+	if resp := future.Response(); resp != nil {
+		if fsmErr, ok := resp.(error); ok && fsmErr != nil {
+			if !errors.Is(fsmErr, structs.ErrPlanStale) {
+				p.breaker.recordFailure()
+			}
+			logger.Error("plan application rejected", "error", fsmErr)
+			emitPlanOutcomeMetric(pending.plan, nil, fsmErr, p.logger)
+			p.respond(pending, nil, fsmErr)
+
+			close(indexCh)
+			return
+		}
+	}
+	p.breaker.recordSuccess()
+	p.applyRate.markApply(time.Now())
+	emitPlanOutcomeMetric(pending.plan, result, nil, p.logger)
+
 	// Respond to the plan
 	index := future.Index()
 	result.AllocIndex = index
@@ -390,42 +1166,135 @@ func (p *planner) asyncPlanWait(indexCh chan<- uint64, future raft.ApplyFuture,
 	if result.RefreshIndex != 0 {
 		result.RefreshIndex = maxUint64(result.RefreshIndex, result.AllocIndex)
 	}
-	pending.respond(result, nil)
+	p.respond(pending, result, nil)
+	indexCh <- index
+}
+
+// asyncBatchPlanWait is the coalesced-apply counterpart to asyncPlanWait: it
+// waits on a single future shared by every plan in batch and fans the
+// outcome back out to each one. A Raft-level failure (e.g. lost leadership)
+// means none of the batch was committed, so every plan is rejected. An
+// FSM-level failure can be partial: applyBatchedPlanResults commits
+// sub-requests in order and stops at the first conflict, so a
+// *batchedPlanResultsError identifies which plans before it actually
+// committed. Those are reported as applied; the failing plan and everything
+// after it, which was never attempted, are reported as rejected so their
+// schedulers re-evaluate against a fresh snapshot rather than assuming they
+// committed.
+func (p *planner) asyncBatchPlanWait(indexCh chan<- uint64, future raft.ApplyFuture,
+	batch []*pendingPlan, results []*structs.PlanResult) {
+	if len(batch) == 1 {
+		p.asyncPlanWait(indexCh, future, results[0], batch[0])
+		return
+	}
+
+	defer safemetrics.MeasureSince([]string{"nomad", "plan", "apply"}, time.Now(), p.logger)
+
+	if err := future.Error(); err != nil {
+		p.breaker.recordFailure()
+		err = classifyPlanApplyErr(err)
+		for _, pending := range batch {
+			p.logger.Error("failed to apply coalesced plan", "error", err, "correlation_id", pending.plan.CorrelationID)
+			emitPlanOutcomeMetric(pending.plan, nil, err, p.logger)
+			p.respond(pending, nil, err)
+		}
+		close(indexCh)
+		return
+	}
+
+	committed := len(batch)
+	var fsmErr error
+	if resp := future.Response(); resp != nil {
+		if batchErr, ok := resp.(*batchedPlanResultsError); ok {
+			committed = batchErr.FailedIndex
+			fsmErr = batchErr.Err
+		} else if genericErr, ok := resp.(error); ok && genericErr != nil {
+			fsmErr = genericErr
+		}
+	}
+
+	if fsmErr != nil {
+		if !errors.Is(fsmErr, structs.ErrPlanStale) {
+			p.breaker.recordFailure()
+		}
+		for i := committed; i < len(batch); i++ {
+			pending := batch[i]
+			p.logger.Error("coalesced plan application rejected", "error", fsmErr, "correlation_id", pending.plan.CorrelationID)
+			emitPlanOutcomeMetric(pending.plan, nil, fsmErr, p.logger)
+			p.respond(pending, nil, fsmErr)
+		}
+	} else {
+		p.breaker.recordSuccess()
+		p.applyRate.markApply(time.Now())
+	}
+
+	if committed == 0 {
+		close(indexCh)
+		return
+	}
+
+	index := future.Index()
+	for i := 0; i < committed; i++ {
+		pending := batch[i]
+		result := results[i]
+		result.AllocIndex = index
+		if result.RefreshIndex != 0 {
+			result.RefreshIndex = maxUint64(result.RefreshIndex, result.AllocIndex)
+		}
+		emitPlanOutcomeMetric(pending.plan, result, nil, p.logger)
+		p.respond(pending, result, nil)
+	}
 	indexCh <- index
 }
 
+// respond records the plan's result or error on its future and emits the
+// corresponding lifecycle event to any PlanQueue subscribers.
+func (p *planner) respond(pending *pendingPlan, result *structs.PlanResult, err error) {
+	pending.respond(result, err)
+
+	eventType := PlanQueueEventApplied
+	if err != nil {
+		eventType = PlanQueueEventRejected
+	}
+	p.planQueue.emit(eventType, pending.plan.EvalID)
+}
+
 // evaluatePlan is used to determine what portions of a plan
 // can be applied if any. Returns if there should be a plan application
 // which may be partial or if there was an error
 func evaluatePlan(pool *EvaluatePool, snap *state.StateSnapshot, plan *structs.Plan, logger log.Logger) (*structs.PlanResult, error) {
-	defer metrics.MeasureSince([]string{"nomad", "plan", "evaluate"}, time.Now())
+	defer safemetrics.MeasureSince([]string{"nomad", "plan", "evaluate"}, time.Now(), logger)
 
-	// Denormalize without the job
+	// Denormalize without the job. A failure here means the snapshot we
+	// evaluated against no longer has an allocation the plan references,
+	// so the scheduler should retry against a fresher snapshot rather
+	// than treat this plan as rejected.
 	err := snap.DenormalizeAllocationsMap(plan.NodeUpdate)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", structs.ErrPlanStale, err)
 	}
 	// Denormalize without the job
 	err = snap.DenormalizeAllocationsMap(plan.NodePreemptions)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", structs.ErrPlanStale, err)
 	}
 
 	// Check if the plan exceeds quota
 	overQuota, err := evaluatePlanQuota(snap, plan)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", structs.ErrPlanInvalid, err)
 	}
 
 	// Reject the plan and force the scheduler to refresh
 	if overQuota {
 		index, err := refreshIndex(snap)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: %v", structs.ErrPlanStale, err)
 		}
 
-		logger.Debug("plan for evaluation exceeds quota limit. Forcing state refresh", "eval_id", plan.EvalID, "refresh_index", index)
-		return &structs.PlanResult{RefreshIndex: index}, nil
+		logger.Debug("plan for evaluation exceeds quota limit. Forcing state refresh",
+			"eval_id", plan.EvalID, "correlation_id", plan.CorrelationID, "refresh_index", index)
+		return &structs.PlanResult{CorrelationID: plan.CorrelationID, RefreshIndex: index}, nil
 	}
 
 	return evaluatePlanPlacements(pool, snap, plan, logger)
@@ -433,10 +1302,13 @@ func evaluatePlan(pool *EvaluatePool, snap *state.StateSnapshot, plan *structs.P
 
 // evaluatePlanPlacements is used to determine what portions of a plan can be
 // applied if any, looking for node over commitment. Returns if there should be
-// a plan application which may be partial or if there was an error
+// a plan application which may be partial or if there was an error. If the
+// plan declares a SatisfyCount, evaluation stops as soon as that many nodes
+// have been confirmed to fit, leaving any remaining nodes unevaluated.
 func evaluatePlanPlacements(pool *EvaluatePool, snap *state.StateSnapshot, plan *structs.Plan, logger log.Logger) (*structs.PlanResult, error) {
 	// Create a result holder for the plan
 	result := &structs.PlanResult{
+		CorrelationID:     plan.CorrelationID,
 		NodeUpdate:        make(map[string][]*structs.Allocation),
 		NodeAllocation:    make(map[string][]*structs.Allocation),
 		Deployment:        plan.Deployment.Copy(),
@@ -460,26 +1332,54 @@ func evaluatePlanPlacements(pool *EvaluatePool, snap *state.StateSnapshot, plan
 		}
 	}
 
+	if plan.ReconcileOrphanedAllocs {
+		if err := reconcileOrphanedAllocs(snap, nodeIDList, result, logger); err != nil {
+			return nil, fmt.Errorf("%w: %v", structs.ErrPlanStale, err)
+		}
+	}
+
+	if plan.NodeScoring != "" {
+		nodeIDList = sortNodesByUtilization(snap, nodeIDList, plan.NodeScoring)
+	} else if plan.HeadroomOrdering {
+		nodeIDList = sortNodesByHeadroom(snap, nodeIDList)
+	}
+
 	// Setup a multierror to handle potentially getting many
 	// errors since we are processing in parallel.
 	var mErr multierror.Error
 	partialCommit := false
+	fitCount := 0
+	nodesSkipped := 0
 
 	// handleResult is used to process the result of evaluateNodePlan
-	handleResult := func(nodeID string, fit bool, reason string, err error) (cancel bool) {
+	handleResult := func(nodeID string, fit bool, reason string, available *structs.ComparableResources, preempted []*structs.Allocation, err error) (cancel bool) {
 		// Evaluate the plan for this node
 		if err != nil {
 			mErr.Errors = append(mErr.Errors, err)
 			return true
 		}
 		if !fit {
+			nodesSkipped++
+
 			// Log the reason why the node's allocations could not be made
 			if reason != "" {
 				logger.Debug("plan for node rejected", "node_id", nodeID, "reason", reason, "eval_id", plan.EvalID)
+
+				if result.RejectedNodes == nil {
+					result.RejectedNodes = make(map[string]string)
+				}
+				result.RejectedNodes[nodeID] = reason
 			}
 			// Set that this is a partial commit
 			partialCommit = true
 
+			if available != nil {
+				if result.RefreshReasons == nil {
+					result.RefreshReasons = make(map[string]*structs.ComparableResources)
+				}
+				result.RefreshReasons[nodeID] = available
+			}
+
 			// If we require all-at-once scheduling, there is no point
 			// to continue the evaluation, as we've already failed.
 			if plan.AllAtOnce {
@@ -495,14 +1395,60 @@ func evaluatePlanPlacements(pool *EvaluatePool, snap *state.StateSnapshot, plan
 			return
 		}
 
+		// Defer nodes whose combined eviction+placement count for this
+		// plan exceeds the configured churn limit, the same way a node
+		// that fails its fit check is deferred: the scheduler will pick
+		// the excess back up in a later round against a refreshed state.
+		if churn := len(plan.NodeUpdate[nodeID]) + len(plan.NodeAllocation[nodeID]); plan.NodeChurnLimit > 0 && churn > plan.NodeChurnLimit {
+			nodesSkipped++
+
+			reason := fmt.Sprintf("node churn limit exceeded: %d evictions+placements over limit of %d", churn, plan.NodeChurnLimit)
+			logger.Debug("plan for node deferred", "node_id", nodeID, "reason", reason, "eval_id", plan.EvalID)
+
+			if result.RejectedNodes == nil {
+				result.RejectedNodes = make(map[string]string)
+			}
+			result.RejectedNodes[nodeID] = reason
+
+			partialCommit = true
+
+			if plan.AllAtOnce {
+				result.NodeUpdate = nil
+				result.NodeAllocation = nil
+				result.DeploymentUpdates = nil
+				result.Deployment = nil
+				result.NodePreemptions = nil
+				return true
+			}
+
+			return
+		}
+
 		// Add this to the plan result
 		if nodeUpdate := plan.NodeUpdate[nodeID]; len(nodeUpdate) > 0 {
-			result.NodeUpdate[nodeID] = nodeUpdate
+			result.NodeUpdate[nodeID] = sortNodeEvictions(nodeUpdate, plan.EvictionOrder)
 		}
 		if nodeAlloc := plan.NodeAllocation[nodeID]; len(nodeAlloc) > 0 {
 			result.NodeAllocation[nodeID] = nodeAlloc
 		}
 
+		// The node only fit because evaluateNodePlan preempted some existing
+		// allocations on our behalf (plan.Preempt); fold them into the
+		// plan's preemptions attributed to the first new placement, same as
+		// the scheduler does for preemptions it decides on its own.
+		if len(preempted) > 0 {
+			preemptingAllocID := ""
+			if allocs := plan.NodeAllocation[nodeID]; len(allocs) > 0 {
+				preemptingAllocID = allocs[0].ID
+			}
+			if plan.NodePreemptions == nil {
+				plan.NodePreemptions = make(map[string][]*structs.Allocation)
+			}
+			for _, preemptedAlloc := range preempted {
+				plan.AppendPreemptedAlloc(preemptedAlloc, preemptingAllocID)
+			}
+		}
+
 		if nodePreemptions := plan.NodePreemptions[nodeID]; nodePreemptions != nil {
 
 			// Do a pass over preempted allocs in the plan to check
@@ -522,9 +1468,39 @@ func evaluatePlanPlacements(pool *EvaluatePool, snap *state.StateSnapshot, plan
 			result.NodePreemptions[nodeID] = filteredNodePreemptions
 		}
 
+		// Record the net resource delta this node's commit represents: new
+		// placements add, evictions and preemptions subtract.
+		delta := new(structs.ComparableResources)
+		for _, alloc := range result.NodeAllocation[nodeID] {
+			delta.Add(alloc.ComparableResources())
+		}
+		for _, alloc := range result.NodeUpdate[nodeID] {
+			delta.Subtract(alloc.ComparableResources())
+		}
+		for _, alloc := range result.NodePreemptions[nodeID] {
+			delta.Subtract(alloc.ComparableResources())
+		}
+		if result.ResourceDelta == nil {
+			result.ResourceDelta = make(map[string]*structs.ComparableResources)
+		}
+		result.ResourceDelta[nodeID] = delta
+
+		// If the plan opted in to a satisfy count and we've now fit enough
+		// nodes to meet it, there's no value in evaluating the remaining
+		// nodes in the plan. StrictValidation needs every node evaluated
+		// before it can decide whether to commit, so it disables this
+		// short-circuit.
+		fitCount++
+		if plan.SatisfyCount > 0 && fitCount >= plan.SatisfyCount && !plan.StrictValidation {
+			return true
+		}
+
 		return
 	}
 
+	// Capture the node count before nodeIDList is consumed below.
+	nodesEvaluated := len(nodeIDList)
+
 	// Get the pool channels
 	req := pool.RequestCh()
 	resp := pool.ResultCh()
@@ -545,7 +1521,7 @@ OUTER:
 
 			// Handle a result that allows us to cancel evaluation,
 			// which may save time processing additional entries.
-			if cancel := handleResult(r.nodeID, r.fit, r.reason, r.err); cancel {
+			if cancel := handleResult(r.nodeID, r.fit, r.reason, r.available, r.preempted, r.err); cancel {
 				didCancel = true
 				break OUTER
 			}
@@ -556,7 +1532,7 @@ OUTER:
 	for outstanding > 0 {
 		r := <-resp
 		if !didCancel {
-			if cancel := handleResult(r.nodeID, r.fit, r.reason, r.err); cancel {
+			if cancel := handleResult(r.nodeID, r.fit, r.reason, r.available, r.preempted, r.err); cancel {
 				didCancel = true
 			}
 		}
@@ -567,6 +1543,20 @@ OUTER:
 	// a minimum refresh index to force the scheduler to work on a more
 	// up-to-date state to avoid the failures.
 	if partialCommit {
+		result.Refreshed = true
+
+		// StrictValidation asks for all-or-nothing commits without the
+		// gang semantics of AllAtOnce: every node was still evaluated
+		// independently above, but since at least one of them didn't fit
+		// we refuse to apply the ones that did.
+		if plan.StrictValidation {
+			result.NodeUpdate = nil
+			result.NodeAllocation = nil
+			result.DeploymentUpdates = nil
+			result.Deployment = nil
+			result.NodePreemptions = nil
+		}
+
 		index, err := refreshIndex(snap)
 		if err != nil {
 			mErr.Errors = append(mErr.Errors, err)
@@ -583,7 +1573,305 @@ OUTER:
 		// placed but wasn't actually placed
 		correctDeploymentCanaries(result)
 	}
-	return result, mErr.ErrorOrNil()
+
+	allocsPlaced := 0
+	for _, allocs := range result.NodeAllocation {
+		allocsPlaced += len(allocs)
+	}
+	evictions := 0
+	for _, allocs := range result.NodeUpdate {
+		evictions += len(allocs)
+	}
+	for _, allocs := range result.NodePreemptions {
+		evictions += len(allocs)
+	}
+	emitPlanSizeMetrics(nodesEvaluated, nodesSkipped, allocsPlaced, evictions, logger)
+
+	if err := mErr.ErrorOrNil(); err != nil {
+		return result, fmt.Errorf("%w: %v", structs.ErrPlanRejected, err)
+	}
+	return result, nil
+}
+
+// EstimatePlan runs only the per-node fit check portion of evaluatePlan
+// against snap and reports how many of the plan's target nodes would fit.
+// It skips denormalization, quota enforcement, and orphan reconciliation,
+// so it's cheap enough for a scheduler to call before submitting a plan it's
+// unsure about, and since it doesn't touch the apply lock or mutate any
+// state, it's safe to run against a stale snapshot read on a follower.
+func EstimatePlan(pool *EvaluatePool, snap *state.StateSnapshot, plan *structs.Plan) (*structs.PlanEstimate, error) {
+	nodeIDs := make(map[string]struct{}, len(plan.NodeUpdate)+len(plan.NodeAllocation))
+	for nodeID := range plan.NodeUpdate {
+		nodeIDs[nodeID] = struct{}{}
+	}
+	for nodeID := range plan.NodeAllocation {
+		nodeIDs[nodeID] = struct{}{}
+	}
+	if len(nodeIDs) == 0 {
+		return &structs.PlanEstimate{Confidence: 1}, nil
+	}
+
+	nodeIDList := make([]string, 0, len(nodeIDs))
+	for nodeID := range nodeIDs {
+		nodeIDList = append(nodeIDList, nodeID)
+	}
+
+	var mErr multierror.Error
+	fitCount := 0
+
+	req := pool.RequestCh()
+	resp := pool.ResultCh()
+	outstanding := 0
+
+	handleResult := func(fit bool, err error) {
+		if err != nil {
+			mErr.Errors = append(mErr.Errors, err)
+			return
+		}
+		if fit {
+			fitCount++
+		}
+	}
+
+	for len(nodeIDList) > 0 {
+		select {
+		case req <- evaluateRequest{snap, plan, nodeIDList[0]}:
+			outstanding++
+			nodeIDList = nodeIDList[1:]
+		case r := <-resp:
+			outstanding--
+			handleResult(r.fit, r.err)
+		}
+	}
+	for outstanding > 0 {
+		r := <-resp
+		outstanding--
+		handleResult(r.fit, r.err)
+	}
+
+	if err := mErr.ErrorOrNil(); err != nil {
+		return nil, fmt.Errorf("%w: %v", structs.ErrPlanRejected, err)
+	}
+
+	total := len(nodeIDs)
+	return &structs.PlanEstimate{
+		FitNodes:   fitCount,
+		TotalNodes: total,
+		Confidence: float64(fitCount) / float64(total),
+	}, nil
+}
+
+// sortNodesByUtilization reorders nodeIDList by how packed each node
+// currently is, placing the fullest nodes first for
+// structs.SchedulerAlgorithmBinpack or the emptiest nodes first for
+// structs.SchedulerAlgorithmSpread, so that nodeIDList's caller applies
+// allocations to higher-ranked nodes first. Nodes whose utilization can't
+// be determined (for example, a node that's since been removed) sort last
+// in their original relative order, since evaluateNodePlan will reject
+// them as a fit failure anyway.
+func sortNodesByUtilization(snap *state.StateSnapshot, nodeIDList []string, algorithm structs.SchedulerAlgorithm) []string {
+	ws := memdb.NewWatchSet()
+
+	type scoredNode struct {
+		nodeID string
+		score  float64
+		scored bool
+	}
+
+	scored := make([]scoredNode, len(nodeIDList))
+	for i, nodeID := range nodeIDList {
+		score, ok := nodeUtilization(snap, ws, nodeID)
+		scored[i] = scoredNode{nodeID: nodeID, score: score, scored: ok}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].scored != scored[j].scored {
+			return scored[i].scored
+		}
+		if !scored[i].scored {
+			return false
+		}
+		if algorithm == structs.SchedulerAlgorithmSpread {
+			return scored[i].score < scored[j].score
+		}
+		return scored[i].score > scored[j].score
+	})
+
+	sorted := make([]string, len(scored))
+	for i, s := range scored {
+		sorted[i] = s.nodeID
+	}
+	return sorted
+}
+
+// sortNodesByHeadroom reorders nodeIDList so that nodes with the most spare
+// capacity relative to their own size come first, reducing fragmentation
+// when a plan targets more candidate nodes than it needs to place on.
+// Headroom is the inverse of nodeUtilization's packed fraction; nodes whose
+// capacity can't be determined sort last in their original relative order,
+// since evaluateNodePlan will reject them as a fit failure anyway.
+func sortNodesByHeadroom(snap *state.StateSnapshot, nodeIDList []string) []string {
+	ws := memdb.NewWatchSet()
+
+	type scoredNode struct {
+		nodeID string
+		score  float64
+		scored bool
+	}
+
+	scored := make([]scoredNode, len(nodeIDList))
+	for i, nodeID := range nodeIDList {
+		utilization, ok := nodeUtilization(snap, ws, nodeID)
+		scored[i] = scoredNode{nodeID: nodeID, score: 1 - utilization, scored: ok}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].scored != scored[j].scored {
+			return scored[i].scored
+		}
+		if !scored[i].scored {
+			return false
+		}
+		return scored[i].score > scored[j].score
+	})
+
+	sorted := make([]string, len(scored))
+	for i, s := range scored {
+		sorted[i] = s.nodeID
+	}
+	return sorted
+}
+
+// nodeUtilization scores a node by the average fraction of its CPU and
+// memory capacity already consumed by non-terminal allocations. It returns
+// ok=false if the node or its resource capacity can't be determined, in
+// which case the score should be ignored rather than treated as idle.
+func nodeUtilization(snap *state.StateSnapshot, ws memdb.WatchSet, nodeID string) (score float64, ok bool) {
+	node, err := snap.NodeByID(ws, nodeID)
+	if err != nil || node == nil || node.NodeResources == nil {
+		return 0, false
+	}
+
+	total := node.ComparableResources()
+	if total.Flattened.Cpu.CpuShares == 0 || total.Flattened.Memory.MemoryMB == 0 {
+		return 0, false
+	}
+
+	allocs, err := snap.AllocsByNodeTerminal(ws, nodeID, false)
+	if err != nil {
+		return 0, false
+	}
+
+	used := new(structs.ComparableResources)
+	for _, alloc := range allocs {
+		used.Add(alloc.ComparableResources())
+	}
+
+	cpuFrac := float64(used.Flattened.Cpu.CpuShares) / float64(total.Flattened.Cpu.CpuShares)
+	memFrac := float64(used.Flattened.Memory.MemoryMB) / float64(total.Flattened.Memory.MemoryMB)
+	return (cpuFrac + memFrac) / 2, true
+}
+
+// evaluatePlanDryRun computes, for every node referenced by the plan's
+// NodeUpdate or NodeAllocation, the utilization that node would have across
+// CPU, memory, and disk if the plan's proposed changes for that node were
+// applied. Unlike evaluatePlanPlacements, it never calls evaluateNodePlan
+// and never mutates anything; it exists to give capacity planners a
+// numeric projection instead of a pass/fail answer.
+func evaluatePlanDryRun(snap *state.StateSnapshot, plan *structs.Plan) (map[string]*structs.NodeUtilizationProjection, error) {
+	ws := memdb.NewWatchSet()
+
+	nodeIDs := make(map[string]struct{}, len(plan.NodeUpdate)+len(plan.NodeAllocation))
+	for nodeID := range plan.NodeUpdate {
+		nodeIDs[nodeID] = struct{}{}
+	}
+	for nodeID := range plan.NodeAllocation {
+		nodeIDs[nodeID] = struct{}{}
+	}
+
+	result := make(map[string]*structs.NodeUtilizationProjection, len(nodeIDs))
+	for nodeID := range nodeIDs {
+		node, err := snap.NodeByID(ws, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil || node.NodeResources == nil {
+			continue
+		}
+		total := node.ComparableResources()
+
+		existing, err := snap.AllocsByNodeTerminal(ws, nodeID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		stopping := make(map[string]struct{}, len(plan.NodeUpdate[nodeID]))
+		for _, alloc := range plan.NodeUpdate[nodeID] {
+			stopping[alloc.ID] = struct{}{}
+		}
+
+		used := new(structs.ComparableResources)
+		for _, alloc := range existing {
+			if _, ok := stopping[alloc.ID]; ok {
+				continue
+			}
+			used.Add(alloc.ComparableResources())
+		}
+		for _, alloc := range plan.NodeAllocation[nodeID] {
+			used.Add(alloc.ComparableResources())
+		}
+
+		result[nodeID] = &structs.NodeUtilizationProjection{
+			CPUPercent:    percentOf(used.Flattened.Cpu.CpuShares, total.Flattened.Cpu.CpuShares),
+			MemoryPercent: percentOf(used.Flattened.Memory.MemoryMB, total.Flattened.Memory.MemoryMB),
+			DiskPercent:   percentOf(used.Shared.DiskMB, total.Shared.DiskMB),
+		}
+	}
+
+	return result, nil
+}
+
+// percentOf returns used as a percentage of total, or 0 if total is 0.
+func percentOf(used, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
+}
+
+// reconcileOrphanedAllocs checks each node the plan references for removal
+// from the cluster, and proposes stop evictions for any non-terminal
+// allocations left behind on a node that no longer exists. It only inspects
+// nodes the plan already touches, rather than scanning the whole cluster,
+// so the cost is bounded by the size of the plan being evaluated.
+func reconcileOrphanedAllocs(snap *state.StateSnapshot, nodeIDList []string, result *structs.PlanResult, logger log.Logger) error {
+	ws := memdb.NewWatchSet()
+	for _, nodeID := range nodeIDList {
+		node, err := snap.NodeByID(ws, nodeID)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			continue
+		}
+
+		allocs, err := snap.AllocsByNodeTerminal(ws, nodeID, false)
+		if err != nil {
+			return err
+		}
+		if len(allocs) == 0 {
+			continue
+		}
+
+		logger.Debug("proposing cleanup evictions for allocations on removed node", "node_id", nodeID, "num_allocs", len(allocs))
+		for _, alloc := range allocs {
+			cleanup := alloc.Copy()
+			cleanup.DesiredStatus = structs.AllocDesiredStatusStop
+			cleanup.DesiredDescription = "cleanup: node no longer exists"
+			result.NodeUpdate[nodeID] = append(result.NodeUpdate[nodeID], cleanup)
+		}
+	}
+	return nil
 }
 
 // correctDeploymentCanaries ensures that the deployment object doesn't list any
@@ -625,38 +1913,54 @@ func correctDeploymentCanaries(result *structs.PlanResult) {
 }
 
 // evaluateNodePlan is used to evaluate the plan for a single node,
-// returning if the plan is valid or if an error is encountered
-func evaluateNodePlan(snap *state.StateSnapshot, plan *structs.Plan, nodeID string) (bool, string, error) {
+// returning if the plan is valid or if an error is encountered. If the plan
+// does not fit, the node's currently available resources are also returned
+// so the caller can report why the fit failed. If the plan doesn't fit but
+// plan.Preempt is set, evaluateNodePlan also attempts to make it fit by
+// preempting lower-priority existing allocations; any allocations it decided
+// to preempt to do so are returned so the caller can fold them into the
+// node's evictions.
+func evaluateNodePlan(snap *state.StateSnapshot, plan *structs.Plan, nodeID string) (bool, string, *structs.ComparableResources, []*structs.Allocation, error) {
 	// If this is an evict-only plan, it always 'fits' since we are removing things.
 	if len(plan.NodeAllocation[nodeID]) == 0 {
-		return true, "", nil
+		return true, "", nil, nil, nil
 	}
 
 	// Get the node itself
 	ws := memdb.NewWatchSet()
 	node, err := snap.NodeByID(ws, nodeID)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to get node '%s': %v", nodeID, err)
+		return false, "", nil, nil, fmt.Errorf("failed to get node '%s': %v", nodeID, err)
 	}
 
 	// If the node does not exist or is not ready for scheduling it is not fit
 	// XXX: There is a potential race between when we do this check and when
 	// the Raft commit happens.
 	if node == nil {
-		return false, "node does not exist", nil
+		return false, "node does not exist", nil, nil, nil
 	} else if node.Status != structs.NodeStatusReady {
-		return false, "node is not ready for placements", nil
+		return false, "node is not ready for placements", nil, nil, nil
 	} else if node.SchedulingEligibility == structs.NodeSchedulingIneligible {
-		return false, "node is not eligible for draining", nil
+		return false, "node is not eligible for draining", nil, nil, nil
 	} else if node.Drain {
 		// Deprecate in favor of scheduling eligibility and remove post-0.8
-		return false, "node is draining", nil
+		return false, "node is draining", nil, nil, nil
+	}
+
+	if plan.ValidateCSIPlugins {
+		reason, err := missingCSIPluginReason(snap, ws, plan.NodeAllocation[nodeID])
+		if err != nil {
+			return false, "", nil, nil, err
+		}
+		if reason != "" {
+			return false, reason, nil, nil, nil
+		}
 	}
 
 	// Get the existing allocations that are non-terminal
 	existingAlloc, err := snap.AllocsByNodeTerminal(ws, nodeID, false)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to get existing allocations for '%s': %v", nodeID, err)
+		return false, "", nil, nil, fmt.Errorf("failed to get existing allocations for '%s': %v", nodeID, err)
 	}
 
 	// Determine the proposed allocation by first removing allocations
@@ -666,20 +1970,289 @@ func evaluateNodePlan(snap *state.StateSnapshot, plan *structs.Plan, nodeID stri
 		remove = append(remove, update...)
 	}
 
-	// Remove any preempted allocs
+	// Remove any preempted allocs, but only once we've confirmed every
+	// preemption target this node's placements depend on is still present
+	// and non-terminal. A placement that only fits because of a preemption
+	// must commit atomically with that preemption: if a target has already
+	// terminated or been removed out from under us, the resources the
+	// scheduler planned to free were never actually reserved, and applying
+	// the placement without them would overcommit the node. Rejecting the
+	// whole node here forces a refresh against a fresher snapshot instead.
 	if preempted := plan.NodePreemptions[nodeID]; len(preempted) > 0 {
+		for _, preemptedAlloc := range preempted {
+			current, err := snap.AllocByID(ws, preemptedAlloc.ID)
+			if err != nil {
+				return false, "", nil, nil, fmt.Errorf("failed to get preempted alloc '%s': %v", preemptedAlloc.ID, err)
+			}
+			if current == nil || current.TerminalStatus() {
+				return false, "preemption target is stale", nil, nil, nil
+			}
+		}
 		remove = append(remove, preempted...)
 	}
 
 	if updated := plan.NodeAllocation[nodeID]; len(updated) > 0 {
 		remove = append(remove, updated...)
 	}
-	proposed := structs.RemoveAllocs(existingAlloc, remove)
-	proposed = append(proposed, plan.NodeAllocation[nodeID]...)
+	// RemoveAllocs mutates the slice it's given in place, so pass it a copy:
+	// existingAlloc is still needed below, untouched, if the allocations
+	// don't fit.
+	proposed := structs.RemoveAllocs(append([]*structs.Allocation{}, existingAlloc...), remove)
+
+	// Everything the plan is adding to this node: the new placements plus
+	// any forecasted allocations that haven't been committed to the state
+	// store yet, so the fit check doesn't over-commit against soon-to-start
+	// work.
+	additional := append([]*structs.Allocation{}, plan.NodeAllocation[nodeID]...)
+	additional = append(additional, plan.ForecastedAllocs[nodeID]...)
+
+	if reason := exceedsMaxCSIVolumesReason(node, append(append([]*structs.Allocation{}, proposed...), additional...)); reason != "" {
+		return false, reason, nil, nil, nil
+	}
+
+	// Check if these allocations fit. The capacity cache precomputes proposed's
+	// resource aggregate once from the snapshot's existing allocations, and
+	// the fit check below incrementally extends that aggregate by additional
+	// rather than re-summing proposed from scratch.
+	cache := structs.NewNodeCapacityCache(node, proposed)
+	fit, reason, used, err := cache.AllocsFit(additional, nil, true)
+	if err != nil {
+		return fit, reason, nil, nil, err
+	}
+
+	if !fit && reason == "cpu" && plan.RelaxedSystemJobFit &&
+		plan.Job != nil && plan.Job.Type == structs.JobTypeSystem {
+		if systemJobFitsWithRelaxedCPU(node, used) {
+			fit, reason = true, ""
+		}
+	}
+
+	if fit {
+		return fit, reason, nil, nil, err
+	}
 
-	// Check if these allocations fit
-	fit, reason, _, err := structs.AllocsFit(node, proposed, nil, true)
-	return fit, reason, err
+	// If the plan opted into preemption, see whether evicting some
+	// lower-priority existing allocations would let the placement fit
+	// before giving up on this node.
+	if plan.Preempt && plan.Job != nil {
+		if preempted := preemptForFit(node, proposed, additional, plan.Job.Priority); len(preempted) > 0 {
+			return true, "", nil, preempted, nil
+		}
+	}
+
+	// The allocations didn't fit, so capture the node's currently available
+	// resources (ignoring this plan's proposed placements) from the same
+	// snapshot so the caller can report it alongside the failure.
+	available := nodeAvailableResources(node, existingAlloc)
+	return fit, reason, available, nil, nil
+}
+
+// preemptionPriorityDelta is the minimum priority gap required before an
+// existing allocation is eligible to be preempted to make room for a new
+// placement. It matches the delta the scheduler itself uses when selecting
+// preemption candidates (see scheduler/preemption.go), which also has the
+// effect of excluding allocations belonging to the job being placed.
+const preemptionPriorityDelta = 10
+
+// preemptForFit attempts to free enough capacity on node to fit additional
+// by evicting existing allocations from proposed, starting with the
+// lowest-priority eligible candidate and re-checking fit after each
+// eviction, so it stops as soon as it has a workable (not necessarily
+// globally optimal) minimal set. It returns the allocations that must be
+// evicted to make additional fit, or nil if evicting every eligible
+// candidate still isn't enough.
+func preemptForFit(node *structs.Node, proposed []*structs.Allocation, additional []*structs.Allocation, jobPriority int) []*structs.Allocation {
+	candidates := make([]*structs.Allocation, 0, len(proposed))
+	for _, alloc := range proposed {
+		if alloc.Job == nil || jobPriority-alloc.Job.Priority < preemptionPriorityDelta {
+			continue
+		}
+		candidates = append(candidates, alloc)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Job.Priority < candidates[j].Job.Priority
+	})
+
+	// Build the cache once from the full proposed set and evict candidates
+	// from it incrementally, so re-checking fit after each eviction only
+	// costs the fit check itself rather than resumming the node's entire
+	// remaining allocation set on every candidate.
+	cache := structs.NewNodeCapacityCache(node, proposed)
+	evicted := make([]*structs.Allocation, 0, len(candidates))
+	for _, candidate := range candidates {
+		cache.Evict(candidate)
+		evicted = append(evicted, candidate)
+
+		if fit, _, _, err := cache.AllocsFit(additional, nil, true); err == nil && fit {
+			return evicted
+		}
+	}
+
+	// Preempting every eligible candidate still wasn't enough.
+	return nil
+}
+
+// systemJobRelaxedCPUMarginPct is the fraction of extra CPU headroom a
+// system job's placement is allowed to exceed a node's available CPU by
+// when Plan.RelaxedSystemJobFit is set.
+const systemJobRelaxedCPUMarginPct = 0.10
+
+// systemJobFitsWithRelaxedCPU re-checks a CPU-only fit failure against a
+// margin above node's available CPU, for use only when evaluating a system
+// job's placement under Plan.RelaxedSystemJobFit. used is the full proposed
+// CPU/memory/disk usage already computed by the strict fit check. Memory
+// and disk must still be a strict superset; only CPU is relaxed, since CPU
+// is the dimension small system daemons are most often bursty on, where a
+// memory or disk overcommit risks an OOM or an out-of-space error instead
+// of just a slower daemon.
+func systemJobFitsWithRelaxedCPU(node *structs.Node, used *structs.ComparableResources) bool {
+	available := node.ComparableResources()
+	available.Subtract(node.ComparableReservedResources())
+
+	if used.Flattened.Memory.MemoryMB > available.Flattened.Memory.MemoryMB {
+		return false
+	}
+	if used.Shared.DiskMB > available.Shared.DiskMB {
+		return false
+	}
+
+	margin := int64(float64(available.Flattened.Cpu.CpuShares) * systemJobRelaxedCPUMarginPct)
+	return used.Flattened.Cpu.CpuShares <= available.Flattened.Cpu.CpuShares+margin
+}
+
+// nodeAvailableResources returns the resources still free on node, given its
+// already-reserved resources and the set of non-terminal allocations
+// currently placed on it.
+func nodeAvailableResources(node *structs.Node, existingAlloc []*structs.Allocation) *structs.ComparableResources {
+	used := new(structs.ComparableResources)
+	for _, alloc := range existingAlloc {
+		if alloc.TerminalStatus() {
+			continue
+		}
+		used.Add(alloc.ComparableResources())
+	}
+
+	available := node.ComparableResources()
+	available.Subtract(node.ComparableReservedResources())
+	available.Subtract(used)
+	return available
+}
+
+// missingCSIPluginReason checks that every CSI volume referenced by allocs
+// is registered and that its controller plugin is also registered. It
+// returns a descriptive reason if either is missing, or an empty string if
+// all of the allocations' CSI volumes are backed by a present plugin.
+func missingCSIPluginReason(snap *state.StateSnapshot, ws memdb.WatchSet, allocs []*structs.Allocation) (string, error) {
+	for _, alloc := range allocs {
+		tg := alloc.Job.LookupTaskGroup(alloc.TaskGroup)
+		if tg == nil {
+			continue
+		}
+
+		for _, volReq := range tg.Volumes {
+			if volReq.Type != structs.VolumeTypeCSI {
+				continue
+			}
+
+			vol, err := snap.CSIVolumeByID(ws, alloc.Namespace, volReq.Source)
+			if err != nil {
+				return "", fmt.Errorf("failed to get CSI volume '%s': %v", volReq.Source, err)
+			}
+			if vol == nil {
+				return fmt.Sprintf("missing CSI volume %q required by alloc %q", volReq.Source, alloc.ID), nil
+			}
+
+			plugin, err := snap.CSIPluginByID(ws, vol.PluginID)
+			if err != nil {
+				return "", fmt.Errorf("failed to get CSI plugin '%s': %v", vol.PluginID, err)
+			}
+			if plugin == nil {
+				return fmt.Sprintf("missing CSI plugin %q required by volume %q", vol.PluginID, vol.ID), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// exceedsMaxCSIVolumesReason checks the number of CSI volumes claimed by
+// proposed's non-terminal allocations against the lowest max-volumes limit
+// reported by any of the node's fingerprinted CSI node plugins, returning a
+// descriptive reason if the count would exceed it. A plugin's MaxVolumes of
+// 0 means "unlimited" per the CSI spec, and a node with no fingerprinted CSI
+// node plugins has no known limit to check, so either case is skipped.
+func exceedsMaxCSIVolumesReason(node *structs.Node, proposed []*structs.Allocation) string {
+	var maxVolumes int64
+	for _, info := range node.CSINodePlugins {
+		if info.NodeInfo == nil || info.NodeInfo.MaxVolumes == 0 {
+			continue
+		}
+		if maxVolumes == 0 || info.NodeInfo.MaxVolumes < maxVolumes {
+			maxVolumes = info.NodeInfo.MaxVolumes
+		}
+	}
+	if maxVolumes == 0 {
+		return ""
+	}
+
+	var claimed int64
+	for _, alloc := range proposed {
+		if alloc.TerminalStatus() || alloc.Job == nil {
+			continue
+		}
+		tg := alloc.Job.LookupTaskGroup(alloc.TaskGroup)
+		if tg == nil {
+			continue
+		}
+		for _, volReq := range tg.Volumes {
+			if volReq.Type == structs.VolumeTypeCSI {
+				claimed++
+			}
+		}
+	}
+
+	if claimed > maxVolumes {
+		return "node at max CSI volume capacity"
+	}
+	return ""
+}
+
+// sortNodeEvictions orders a node's NodeUpdate list of evicted/stopped
+// allocations according to order, without mutating the plan's original
+// slice. An unrecognized order is treated the same as the default and
+// leaves the submitted order untouched.
+func sortNodeEvictions(allocs []*structs.Allocation, order string) []*structs.Allocation {
+	if order == structs.EvictionOrderDefault {
+		return allocs
+	}
+
+	sorted := make([]*structs.Allocation, len(allocs))
+	copy(sorted, allocs)
+
+	switch order {
+	case structs.EvictionOrderPriorityAscending:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return allocPriority(sorted[i]) < allocPriority(sorted[j])
+		})
+	case structs.EvictionOrderOldestFirst:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].CreateTime < sorted[j].CreateTime
+		})
+	}
+
+	return sorted
+}
+
+// allocPriority returns the priority of the job that produced alloc, or 0 if
+// the allocation's job is unknown.
+func allocPriority(alloc *structs.Allocation) int {
+	if alloc.Job == nil {
+		return 0
+	}
+	return alloc.Job.Priority
 }
 
 func max(a, b uint64) uint64 {
@@ -0,0 +1,87 @@
+package nomad
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// planApplyCircuitBreakerThreshold is the number of consecutive
+	// raftApply failures required to trip the plan apply circuit breaker.
+	planApplyCircuitBreakerThreshold = 5
+
+	// planApplyCircuitBreakerCooldown is how long the circuit breaker stays
+	// open, fast-failing plans, before it allows a probe apply through.
+	planApplyCircuitBreakerCooldown = 30 * time.Second
+)
+
+// planApplyCircuitBreaker is used to stop attempting to apply plans via Raft
+// once enough consecutive attempts have failed. Without it, a persistently
+// unhealthy Raft cluster would leave applyPlan retrying (and timing out)
+// every plan in the queue, each after paying the same apply latency. Once
+// tripped, the breaker fast-fails plans for a cool-down period and then
+// allows a single probe apply through to test for recovery.
+type planApplyCircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lock                sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time
+
+	// probing is true while a single post-cooldown probe apply is
+	// outstanding, so concurrent callers don't all rush through at once.
+	probing bool
+}
+
+// newPlanApplyCircuitBreaker returns a circuit breaker that trips after
+// threshold consecutive failures and stays open for cooldown.
+func newPlanApplyCircuitBreaker(threshold int, cooldown time.Duration) *planApplyCircuitBreaker {
+	return &planApplyCircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a plan apply should be attempted. If the breaker is
+// open and the cool-down period hasn't elapsed, it returns false. Once the
+// cool-down has elapsed, it allows a single probe apply through and holds
+// the breaker open for any other caller until that probe's outcome is
+// recorded via recordSuccess or recordFailure.
+func (b *planApplyCircuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// recordSuccess clears the failure count and closes the breaker.
+func (b *planApplyCircuitBreaker) recordSuccess() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.consecutiveFailures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// recordFailure counts a raftApply failure, tripping the breaker once
+// threshold consecutive failures have been recorded.
+func (b *planApplyCircuitBreaker) recordFailure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
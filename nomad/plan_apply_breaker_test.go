@@ -0,0 +1,61 @@
+package nomad
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanApplyCircuitBreaker_Trip(t *testing.T) {
+	t.Parallel()
+	b := newPlanApplyCircuitBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		require.True(t, b.allow())
+		b.recordFailure()
+	}
+
+	// the breaker hasn't tripped yet; fewer than 3 consecutive failures
+	require.True(t, b.allow())
+
+	b.recordFailure()
+
+	// the third consecutive failure trips the breaker
+	require.False(t, b.allow())
+}
+
+func TestPlanApplyCircuitBreaker_Cooldown(t *testing.T) {
+	t.Parallel()
+	b := newPlanApplyCircuitBreaker(1, 50*time.Millisecond)
+
+	require.True(t, b.allow())
+	b.recordFailure()
+	require.False(t, b.allow())
+
+	time.Sleep(75 * time.Millisecond)
+
+	// the cool-down has elapsed, so a single probe apply is allowed through
+	require.True(t, b.allow())
+
+	// but the breaker is still considered open until that probe succeeds
+	require.False(t, b.allow())
+}
+
+func TestPlanApplyCircuitBreaker_Recovery(t *testing.T) {
+	t.Parallel()
+	b := newPlanApplyCircuitBreaker(1, 50*time.Millisecond)
+
+	require.True(t, b.allow())
+	b.recordFailure()
+	require.False(t, b.allow())
+
+	time.Sleep(75 * time.Millisecond)
+
+	require.True(t, b.allow())
+	b.recordSuccess()
+
+	// a successful probe closes the breaker entirely
+	require.True(t, b.allow())
+	require.True(t, b.allow())
+}
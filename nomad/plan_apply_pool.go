@@ -29,10 +29,12 @@ type evaluateRequest struct {
 }
 
 type evaluateResult struct {
-	nodeID string
-	fit    bool
-	reason string
-	err    error
+	nodeID    string
+	fit       bool
+	reason    string
+	available *structs.ComparableResources
+	preempted []*structs.Allocation
+	err       error
 }
 
 // NewEvaluatePool returns a pool of the given size.
@@ -103,8 +105,8 @@ func (p *EvaluatePool) run(stopCh chan struct{}) {
 	for {
 		select {
 		case req := <-p.req:
-			fit, reason, err := evaluateNodePlan(req.snap, req.plan, req.nodeID)
-			p.res <- evaluateResult{req.nodeID, fit, reason, err}
+			fit, reason, available, preempted, err := evaluateNodePlan(req.snap, req.plan, req.nodeID)
+			p.res <- evaluateResult{req.nodeID, fit, reason, available, preempted, err}
 
 		case <-stopCh:
 			return
@@ -0,0 +1,73 @@
+package nomad
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// planApplyRateTau is the time constant used to decay the plan apply rate
+// estimate: it's roughly how long a burst or lull in apply activity takes to
+// fade out of the average, so the exported rate tracks sustained throughput
+// rather than jittering with every single apply.
+const planApplyRateTau = 10 * time.Second
+
+// planApplyRateTracker maintains an exponentially-weighted estimate of how
+// many plans per second the leader is applying via Raft. Unlike a
+// fixed-tick EWMA, each sample's weight is derived from the elapsed time
+// since the previous mark, so the estimate stays meaningful even though
+// plan applies don't happen at a fixed cadence.
+type planApplyRateTracker struct {
+	lock     sync.Mutex
+	lastMark time.Time
+	rate     float64
+}
+
+// newPlanApplyRateTracker returns a rate tracker with no samples yet.
+func newPlanApplyRateTracker() *planApplyRateTracker {
+	return &planApplyRateTracker{}
+}
+
+// markApply records a single plan application at now, folding it into the
+// exponentially-weighted rate estimate. The first call only seeds the
+// tracker's clock, since a rate can't be computed from a single point.
+func (t *planApplyRateTracker) markApply(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.lastMark.IsZero() {
+		t.lastMark = now
+		return
+	}
+
+	elapsed := now.Sub(t.lastMark)
+	t.lastMark = now
+	if elapsed <= 0 {
+		return
+	}
+
+	instant := 1 / elapsed.Seconds()
+	alpha := 1 - math.Exp(-elapsed.Seconds()/planApplyRateTau.Seconds())
+	t.rate += alpha * (instant - t.rate)
+}
+
+// Rate returns the current exponentially-weighted plans/sec estimate.
+func (t *planApplyRateTracker) Rate() float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.rate
+}
+
+// EmitStats is used to export the plan apply rate metric while enabled.
+func (t *planApplyRateTracker) EmitStats(period time.Duration, stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(period):
+			metrics.SetGauge([]string{"nomad", "plan", "apply_rate"}, float32(t.Rate()))
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package nomad
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanApplyRateTracker_NoSamples(t *testing.T) {
+	t.Parallel()
+	r := newPlanApplyRateTracker()
+	require.Zero(t, r.Rate())
+}
+
+func TestPlanApplyRateTracker_FirstMarkSeedsOnly(t *testing.T) {
+	t.Parallel()
+	r := newPlanApplyRateTracker()
+	r.markApply(time.Now())
+
+	// a single sample can't yet produce a rate
+	require.Zero(t, r.Rate())
+}
+
+func TestPlanApplyRateTracker_ConvergesToSteadyRate(t *testing.T) {
+	t.Parallel()
+	r := newPlanApplyRateTracker()
+
+	// feed a steady cadence of one plan apply per 100ms (10/sec) using
+	// synthetic timestamps so the test doesn't depend on real wall-clock
+	// sleeps, and run it long enough relative to planApplyRateTau for the
+	// EWMA to converge.
+	now := time.Now()
+	const interval = 100 * time.Millisecond
+	const samples = 600
+	for i := 0; i < samples; i++ {
+		now = now.Add(interval)
+		r.markApply(now)
+	}
+
+	rate := r.Rate()
+	require.InDelta(t, 10.0, rate, 0.5)
+}
+
+func TestPlanApplyRateTracker_AdaptsToCadenceChange(t *testing.T) {
+	t.Parallel()
+	r := newPlanApplyRateTracker()
+
+	now := time.Now()
+	for i := 0; i < 600; i++ {
+		now = now.Add(100 * time.Millisecond)
+		r.markApply(now)
+	}
+	require.InDelta(t, 10.0, r.Rate(), 0.5)
+
+	// slow down to one apply per second (1/sec) and confirm the rate
+	// decays toward the new, slower cadence rather than staying pinned.
+	for i := 0; i < 60; i++ {
+		now = now.Add(time.Second)
+		r.markApply(now)
+	}
+	require.InDelta(t, 1.0, r.Rate(), 0.5)
+}
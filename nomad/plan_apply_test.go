@@ -1,14 +1,21 @@
 package nomad
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	gometrics "github.com/armon/go-metrics"
 	memdb "github.com/hashicorp/go-memdb"
+	"github.com/hashicorp/nomad/helper"
 	"github.com/hashicorp/nomad/helper/testlog"
 	"github.com/hashicorp/nomad/helper/uuid"
 	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/state"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/testutil"
 	"github.com/hashicorp/raft"
@@ -236,6 +243,66 @@ func TestPlanApply_applyPlan(t *testing.T) {
 	assert.Equal(index, evalOut.ModifyIndex)
 }
 
+func TestPlanApply_planApplyEnqueueTimeout(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, enqueueLimit, planApplyEnqueueTimeout(structs.PlanWriteConsistencyStrict))
+	require.Equal(t, enqueueLimit, planApplyEnqueueTimeout(""))
+	require.Equal(t, relaxedEnqueueLimit, planApplyEnqueueTimeout(structs.PlanWriteConsistencyRelaxed))
+}
+
+// TestPlanApply_applyPlan_WriteConsistency asserts that applyPlan forwards
+// the plan's WriteConsistency hint to the Raft apply call per job type,
+// rather than always using the strict default.
+func TestPlanApply_applyPlan_WriteConsistency(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	cases := []struct {
+		jobType          string
+		writeConsistency structs.PlanWriteConsistency
+		expectedTimeout  time.Duration
+	}{
+		{structs.JobTypeService, structs.PlanWriteConsistencyStrict, enqueueLimit},
+		{structs.JobTypeBatch, structs.PlanWriteConsistencyRelaxed, relaxedEnqueueLimit},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.jobType, func(t *testing.T) {
+			alloc := mock.Alloc()
+			alloc.Job.Type = tc.jobType
+			s1.State().UpsertJobSummary(1000, mock.JobSummary(alloc.JobID))
+			eval := mock.Eval()
+			eval.JobID = alloc.JobID
+			require.NoError(t, s1.State().UpsertEvals(structs.MsgTypeTestSetup, 1, []*structs.Evaluation{eval}))
+
+			planRes := &structs.PlanResult{
+				NodeAllocation: map[string][]*structs.Allocation{
+					alloc.NodeID: {alloc},
+				},
+			}
+
+			snap, err := s1.State().Snapshot()
+			require.NoError(t, err)
+
+			plan := eval.MakePlan(alloc.Job)
+			plan.WriteConsistency = tc.writeConsistency
+			plan.NodeAllocation[alloc.NodeID] = []*structs.Allocation{alloc}
+
+			require.Equal(t, tc.expectedTimeout, planApplyEnqueueTimeout(plan.WriteConsistency))
+
+			future, err := s1.applyPlan(plan, planRes, snap)
+			require.NoError(t, err)
+
+			_, err = planWaitFuture(future)
+			require.NoError(t, err)
+		})
+	}
+}
+
 // Verifies that applyPlan properly updates the constituent objects in MemDB,
 // when the plan contains normalized allocs.
 func TestPlanApply_applyPlanWithNormalizedAllocs(t *testing.T) {
@@ -389,6 +456,88 @@ func TestPlanApply_applyPlanWithNormalizedAllocs(t *testing.T) {
 	assert.Equal(index, evalOut.ModifyIndex)
 }
 
+func TestPlanApply_applyPlan_MigrationHint(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	node := mock.Node()
+	testRegisterNode(t, s1, node)
+	targetNode := mock.Node()
+	testRegisterNode(t, s1, targetNode)
+
+	// evictedWithTarget is evicted and replaced by a same-plan placement,
+	// so it should get a concrete node hint.
+	evictedWithTarget := mock.Alloc()
+	evictedWithTarget.DesiredTransition = structs.DesiredTransition{Migrate: helper.BoolToPtr(true)}
+
+	// evictedWithoutTarget is evicted as part of a migration, but its
+	// replacement isn't part of this plan, so it should get the "any" hint.
+	evictedWithoutTarget := mock.Alloc()
+	evictedWithoutTarget.NodeID = node.ID
+	evictedWithoutTarget.DesiredTransition = structs.DesiredTransition{Migrate: helper.BoolToPtr(true)}
+
+	s1.State().UpsertJobSummary(999, mock.JobSummary(evictedWithTarget.JobID))
+	s1.State().UpsertJobSummary(1000, mock.JobSummary(evictedWithoutTarget.JobID))
+	require.NoError(s1.State().UpsertAllocs(structs.MsgTypeTestSetup, 1100,
+		[]*structs.Allocation{evictedWithTarget, evictedWithoutTarget}))
+
+	replacement := mock.Alloc()
+	replacement.JobID = evictedWithTarget.JobID
+	replacement.Job = evictedWithTarget.Job
+	replacement.NodeID = targetNode.ID
+	replacement.PreviousAllocation = evictedWithTarget.ID
+
+	stoppedWithTarget := evictedWithTarget.Copy()
+	stoppedWithTarget.DesiredDescription = "draining node"
+
+	stoppedWithoutTarget := evictedWithoutTarget.Copy()
+	stoppedWithoutTarget.DesiredDescription = "draining node"
+
+	eval := mock.Eval()
+	eval.JobID = evictedWithTarget.JobID
+	require.NoError(s1.State().UpsertEvals(structs.MsgTypeTestSetup, 1, []*structs.Evaluation{eval}))
+
+	planRes := &structs.PlanResult{
+		NodeAllocation: map[string][]*structs.Allocation{
+			targetNode.ID: {replacement},
+		},
+		NodeUpdate: map[string][]*structs.Allocation{
+			evictedWithTarget.NodeID:    {stoppedWithTarget},
+			evictedWithoutTarget.NodeID: {stoppedWithoutTarget},
+		},
+	}
+
+	snap, err := s1.State().Snapshot()
+	require.NoError(err)
+
+	plan := &structs.Plan{
+		Job:    evictedWithTarget.Job,
+		EvalID: eval.ID,
+	}
+
+	future, err := s1.applyPlan(plan, planRes, snap)
+	require.NoError(err)
+	_, err = planWaitFuture(future)
+	require.NoError(err)
+
+	fsmState := s1.fsm.State()
+	ws := memdb.NewWatchSet()
+
+	outWithTarget, err := fsmState.AllocByID(ws, evictedWithTarget.ID)
+	require.NoError(err)
+	require.NotNil(outWithTarget)
+	require.Equal(targetNode.ID, outWithTarget.MigrationHint)
+
+	outWithoutTarget, err := fsmState.AllocByID(ws, evictedWithoutTarget.ID)
+	require.NoError(err)
+	require.NotNil(outWithoutTarget)
+	require.Equal("any", outWithoutTarget.MigrationHint)
+}
+
 func TestPlanApply_EvalPlan_Simple(t *testing.T) {
 	t.Parallel()
 	state := testStateStore(t)
@@ -547,114 +696,1409 @@ func TestPlanApply_EvalPlan_Preemption(t *testing.T) {
 
 }
 
-func TestPlanApply_EvalPlan_Partial(t *testing.T) {
+// TestPlanApply_EvalPlan_Preemption_Insufficient exercises a placement that
+// depends on a preemption which doesn't free enough resources by itself: the
+// node still doesn't fit, so neither the placement nor the preemption should
+// be applied.
+func TestPlanApply_EvalPlan_Preemption_Insufficient(t *testing.T) {
 	t.Parallel()
 	state := testStateStore(t)
 	node := mock.Node()
+	node.NodeResources = &structs.NodeResources{
+		Cpu: structs.NodeCpuResources{
+			CpuShares: 2000,
+		},
+		Memory: structs.NodeMemoryResources{
+			MemoryMB: 4192,
+		},
+		Disk: structs.NodeDiskResources{
+			DiskMB: 30 * 1024,
+		},
+		Networks: []*structs.NetworkResource{
+			{
+				Device: "eth0",
+				CIDR:   "192.168.0.100/32",
+				MBits:  1000,
+			},
+		},
+	}
 	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
-	node2 := mock.Node()
-	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
-	snap, _ := state.Snapshot()
 
-	alloc := mock.Alloc()
-	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
-	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+	// An unrelated alloc that isn't being preempted and stays put, occupying
+	// most of the node.
+	otherAlloc := mock.Alloc()
+	otherAlloc.NodeID = node.ID
+	otherAlloc.AllocatedResources = &structs.AllocatedResources{
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 20 * 1024,
+		},
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu: structs.AllocatedCpuResources{
+					CpuShares: 1600,
+				},
+				Memory: structs.AllocatedMemoryResources{
+					MemoryMB: 3500,
+				},
+			},
+		},
+	}
 
-	// Create a deployment where the allocs are markeda as canaries
-	d := mock.Deployment()
-	d.TaskGroups["web"].PlacedCanaries = []string{alloc.ID, alloc2.ID}
+	// Only a small preempted alloc, not enough to free room for the new
+	// placement below once otherAlloc's usage is accounted for.
+	preemptedAlloc := mock.Alloc()
+	preemptedAlloc.NodeID = node.ID
+	preemptedAlloc.AllocatedResources = &structs.AllocatedResources{
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 1 * 1024,
+		},
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu: structs.AllocatedCpuResources{
+					CpuShares: 100,
+				},
+				Memory: structs.AllocatedMemoryResources{
+					MemoryMB: 100,
+				},
+			},
+		},
+	}
+	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{otherAlloc, preemptedAlloc})
 
+	alloc := mock.Alloc()
+	alloc.AllocatedResources = &structs.AllocatedResources{
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 24 * 1024,
+		},
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu: structs.AllocatedCpuResources{
+					CpuShares: 1500,
+				},
+				Memory: structs.AllocatedMemoryResources{
+					MemoryMB: 3200,
+				},
+				Networks: []*structs.NetworkResource{
+					{
+						Device:        "eth0",
+						IP:            "192.168.0.100",
+						ReservedPorts: []structs.Port{{Label: "admin", Value: 5000}},
+						MBits:         800,
+						DynamicPorts:  []structs.Port{{Label: "http", Value: 9876}},
+					},
+				},
+			},
+		},
+	}
 	plan := &structs.Plan{
 		Job: alloc.Job,
 		NodeAllocation: map[string][]*structs.Allocation{
-			node.ID:  {alloc},
-			node2.ID: {alloc2},
+			node.ID: {alloc},
+		},
+		NodePreemptions: map[string][]*structs.Allocation{
+			node.ID: {preemptedAlloc},
 		},
-		Deployment: d,
 	}
+	snap, _ := state.Snapshot()
 
 	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
 	defer pool.Shutdown()
 
 	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	if result == nil {
-		t.Fatalf("missing result")
-	}
-
-	if _, ok := result.NodeAllocation[node.ID]; !ok {
-		t.Fatalf("should allow alloc")
-	}
-	if _, ok := result.NodeAllocation[node2.ID]; ok {
-		t.Fatalf("should not allow alloc2")
-	}
-
-	// Check the deployment was updated
-	if result.Deployment == nil || len(result.Deployment.TaskGroups) == 0 {
-		t.Fatalf("bad: %v", result.Deployment)
-	}
-	placedCanaries := result.Deployment.TaskGroups["web"].PlacedCanaries
-	if len(placedCanaries) != 1 || placedCanaries[0] != alloc.ID {
-		t.Fatalf("bad: %v", placedCanaries)
-	}
 
-	if result.RefreshIndex != 1001 {
-		t.Fatalf("bad: %d", result.RefreshIndex)
-	}
+	require := require.New(t)
+	require.NoError(err)
+	require.NotNil(result)
+	require.Empty(result.NodeAllocation[node.ID])
+	require.Empty(result.NodePreemptions[node.ID])
 }
 
-func TestPlanApply_EvalPlan_Partial_AllAtOnce(t *testing.T) {
+// TestPlanApply_EvalPlan_Preemption_StaleTarget exercises a placement that
+// depends on a preemption whose target has already terminated by the time
+// the plan is evaluated: since the resources it would have freed were never
+// actually reserved, the placement must not be applied without it.
+func TestPlanApply_EvalPlan_Preemption_StaleTarget(t *testing.T) {
 	t.Parallel()
 	state := testStateStore(t)
 	node := mock.Node()
-	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
-	node2 := mock.Node()
-	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
-	snap, _ := state.Snapshot()
-
-	alloc := mock.Alloc()
-	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
-	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
-	plan := &structs.Plan{
-		Job:       alloc.Job,
-		AllAtOnce: true, // Require all to make progress
-		NodeAllocation: map[string][]*structs.Allocation{
-			node.ID:  {alloc},
-			node2.ID: {alloc2},
+	node.NodeResources = &structs.NodeResources{
+		Cpu: structs.NodeCpuResources{
+			CpuShares: 2000,
 		},
-		Deployment: mock.Deployment(),
-		DeploymentUpdates: []*structs.DeploymentStatusUpdate{
+		Memory: structs.NodeMemoryResources{
+			MemoryMB: 4192,
+		},
+		Disk: structs.NodeDiskResources{
+			DiskMB: 30 * 1024,
+		},
+		Networks: []*structs.NetworkResource{
 			{
-				DeploymentID:      uuid.Generate(),
-				Status:            "foo",
-				StatusDescription: "bar",
+				Device: "eth0",
+				CIDR:   "192.168.0.100/32",
+				MBits:  1000,
 			},
 		},
 	}
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
 
-	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
-	defer pool.Shutdown()
-
-	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	if result == nil {
-		t.Fatalf("missing result")
+	preemptedAlloc := mock.Alloc()
+	preemptedAlloc.NodeID = node.ID
+	preemptedAlloc.AllocatedResources = &structs.AllocatedResources{
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 25 * 1024,
+		},
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu: structs.AllocatedCpuResources{
+					CpuShares: 1500,
+				},
+				Memory: structs.AllocatedMemoryResources{
+					MemoryMB: 4000,
+				},
+				Networks: []*structs.NetworkResource{
+					{
+						Device:        "eth0",
+						IP:            "192.168.0.100",
+						ReservedPorts: []structs.Port{{Label: "admin", Value: 5000}},
+						MBits:         800,
+						DynamicPorts:  []structs.Port{{Label: "http", Value: 9876}},
+					},
+				},
+			},
+		},
 	}
 
-	if len(result.NodeAllocation) != 0 {
-		t.Fatalf("should not alloc: %v", result.NodeAllocation)
-	}
-	if result.RefreshIndex != 1001 {
-		t.Fatalf("bad: %d", result.RefreshIndex)
-	}
-	if result.Deployment != nil || len(result.DeploymentUpdates) != 0 {
-		t.Fatalf("bad: %v", result)
-	}
-}
+	// The preemption target has already been marked terminal by the time
+	// the snapshot was taken, e.g. because another evaluation raced ahead
+	// of this one.
+	preemptedAlloc.DesiredStatus = structs.AllocDesiredStatusStop
+	preemptedAlloc.ClientStatus = structs.AllocClientStatusComplete
+	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{preemptedAlloc})
+
+	alloc := mock.Alloc()
+	alloc.AllocatedResources = &structs.AllocatedResources{
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 24 * 1024,
+		},
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu: structs.AllocatedCpuResources{
+					CpuShares: 1500,
+				},
+				Memory: structs.AllocatedMemoryResources{
+					MemoryMB: 3200,
+				},
+				Networks: []*structs.NetworkResource{
+					{
+						Device:        "eth0",
+						IP:            "192.168.0.100",
+						ReservedPorts: []structs.Port{{Label: "admin", Value: 5000}},
+						MBits:         800,
+						DynamicPorts:  []structs.Port{{Label: "http", Value: 9876}},
+					},
+				},
+			},
+		},
+	}
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+		NodePreemptions: map[string][]*structs.Allocation{
+			node.ID: {preemptedAlloc},
+		},
+	}
+	snap, _ := state.Snapshot()
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+
+	require := require.New(t)
+	require.NoError(err)
+	require.NotNil(result)
+	require.Empty(result.NodeAllocation[node.ID])
+	require.Empty(result.NodePreemptions[node.ID])
+	require.NotZero(result.RefreshIndex)
+}
+
+func TestPlanApply_EvalPlan_Partial(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
+	snap, _ := state.Snapshot()
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	// Create a deployment where the allocs are markeda as canaries
+	d := mock.Deployment()
+	d.TaskGroups["web"].PlacedCanaries = []string{alloc.ID, alloc2.ID}
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+		Deployment: d,
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result == nil {
+		t.Fatalf("missing result")
+	}
+
+	if _, ok := result.NodeAllocation[node.ID]; !ok {
+		t.Fatalf("should allow alloc")
+	}
+	if _, ok := result.NodeAllocation[node2.ID]; ok {
+		t.Fatalf("should not allow alloc2")
+	}
+
+	// Check the deployment was updated
+	if result.Deployment == nil || len(result.Deployment.TaskGroups) == 0 {
+		t.Fatalf("bad: %v", result.Deployment)
+	}
+	placedCanaries := result.Deployment.TaskGroups["web"].PlacedCanaries
+	if len(placedCanaries) != 1 || placedCanaries[0] != alloc.ID {
+		t.Fatalf("bad: %v", placedCanaries)
+	}
+
+	if result.RefreshIndex != 1001 {
+		t.Fatalf("bad: %d", result.RefreshIndex)
+	}
+}
+
+// TestPlanApply_EvalPlan_Partial_RefreshedZeroIndex asserts that
+// result.Refreshed is set whenever a partial commit triggers a refresh, even
+// in the degenerate case where the computed RefreshIndex is itself 0 (e.g.
+// early in a cluster's life, before the nodes/allocs tables have advanced
+// past their zero value). Callers must be able to tell a refresh happened
+// without relying on RefreshIndex != 0.
+func TestPlanApply_EvalPlan_Partial_RefreshedZeroIndex(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 0, node)
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 0, node2)
+	snap, _ := state.Snapshot()
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, _ := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	if result == nil {
+		t.Fatalf("missing result")
+	}
+
+	if result.RefreshIndex != 0 {
+		t.Fatalf("expected a zero computed refresh index, got: %d", result.RefreshIndex)
+	}
+	if !result.Refreshed {
+		t.Fatalf("expected Refreshed to be true even though RefreshIndex is 0")
+	}
+}
+
+// TestPlanApply_EvalPlan_NodeChurnLimit asserts that a plan with
+// NodeChurnLimit set commits a node's placements when its combined
+// eviction+placement count is at or below the limit, and defers the whole
+// node (reporting it in RejectedNodes and forcing a refresh) when the count
+// exceeds the limit.
+func TestPlanApply_EvalPlan_NodeChurnLimit(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	belowNode := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, belowNode)
+	atNode := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1001, atNode)
+	aboveNode := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1002, aboveNode)
+	snap, _ := state.Snapshot()
+
+	allocsFor := func(node *structs.Node, n int) []*structs.Allocation {
+		allocs := make([]*structs.Allocation, 0, n)
+		for i := 0; i < n; i++ {
+			alloc := mock.Alloc()
+			alloc.NodeID = node.ID
+			// Strip the reserved network ports mock.Alloc() sets by
+			// default, so that multiple allocs on the same node don't
+			// spuriously collide on the same port.
+			alloc.Resources.Networks = nil
+			alloc.TaskResources["web"].Networks = nil
+			alloc.AllocatedResources.Tasks["web"].Networks = nil
+			allocs = append(allocs, alloc)
+		}
+		return allocs
+	}
+
+	belowAllocs := allocsFor(belowNode, 1) // below the limit of 2
+	atAllocs := allocsFor(atNode, 2)       // exactly at the limit of 2
+	aboveAllocs := allocsFor(aboveNode, 3) // above the limit of 2
+
+	plan := &structs.Plan{
+		Job:            belowAllocs[0].Job,
+		NodeChurnLimit: 2,
+		NodeAllocation: map[string][]*structs.Allocation{
+			belowNode.ID: belowAllocs,
+			atNode.ID:    atAllocs,
+			aboveNode.ID: aboveAllocs,
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Len(t, result.NodeAllocation[belowNode.ID], 1)
+	require.Len(t, result.NodeAllocation[atNode.ID], 2)
+
+	require.NotContains(t, result.NodeAllocation, aboveNode.ID)
+	require.Contains(t, result.RejectedNodes, aboveNode.ID)
+	require.NotContains(t, result.RejectedNodes, belowNode.ID)
+	require.NotContains(t, result.RejectedNodes, atNode.ID)
+
+	require.True(t, result.Refreshed)
+}
+
+// TestPlanApply_EvalPlan_RejectedNodes asserts that when a node can't fit
+// its planned allocations, the result's RejectedNodes map records the
+// resource dimension that was exhausted, so callers don't have to re-derive
+// it from logs.
+func TestPlanApply_EvalPlan_RejectedNodes(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	node := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
+	snap, _ := state.Snapshot()
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // consumes all of node2's resources, so it won't fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.NotContains(t, result.RejectedNodes, node.ID)
+	require.Contains(t, result.RejectedNodes, node2.ID)
+	require.Equal(t, "cpu", result.RejectedNodes[node2.ID])
+}
+
+// TestPlanApply_EvaluatePlan_EmitsSizeMetrics asserts that evaluating a plan
+// emits nomad.plan.node_count, nomad.plan.nodes_skipped,
+// nomad.plan.alloc_placed, and nomad.plan.alloc_evicted with values matching
+// a plan that evicts one allocation, places one allocation, and is rejected
+// from one node for not fitting.
+func TestPlanApply_EvaluatePlan_EmitsSizeMetrics(t *testing.T) {
+	sink := gometrics.NewInmemSink(10*time.Second, time.Minute)
+	_, err := gometrics.NewGlobal(&gometrics.Config{FilterDefault: true}, sink)
+	require.NoError(t, err)
+	defer gometrics.NewGlobal(gometrics.DefaultConfig("plan_apply_test"), &gometrics.BlackholeSink{})
+
+	state := testStateStore(t)
+
+	// node already has an allocation consuming all of its resources; the
+	// plan evicts it and places a new allocation in its place.
+	existing := mock.Alloc()
+	node := mock.Node()
+	node.ReservedResources = nil
+	existing.NodeID = node.ID
+	existing.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{existing})
+
+	// node2 is empty, but the plan's placement on it asks for all of node2's
+	// resources, which don't fit beside node2's reserved resources, so it's
+	// rejected.
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1002, node2)
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	evicted := new(structs.Allocation)
+	*evicted = *existing
+	evicted.DesiredStatus = structs.AllocDesiredStatusEvict
+
+	placed := mock.Alloc()
+	placed.Job = existing.Job
+
+	rejected := mock.Alloc()
+	rejected.Job = existing.Job
+	rejected.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	plan := &structs.Plan{
+		Job: existing.Job,
+		NodeUpdate: map[string][]*structs.Allocation{
+			node.ID: {evicted},
+		},
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {placed},
+			node2.ID: {rejected},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	_, err = evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+
+	require.Len(t, sink.Data(), 1)
+	counters := sink.Data()[0].Counters
+
+	require.EqualValues(t, 2, counters["nomad.plan.node_count"].Sum)
+	require.EqualValues(t, 1, counters["nomad.plan.nodes_skipped"].Sum)
+	require.EqualValues(t, 1, counters["nomad.plan.alloc_placed"].Sum)
+	require.EqualValues(t, 1, counters["nomad.plan.alloc_evicted"].Sum)
+}
+
+// TestPlanApply_EvaluatePlan_ResourceDelta asserts that evaluating a plan
+// that evicts one allocation and places another on the same node attaches a
+// ResourceDelta for that node equal to the hand-computed net change: the
+// placed allocation's resources minus the evicted allocation's resources.
+func TestPlanApply_EvaluatePlan_ResourceDelta(t *testing.T) {
+	t.Parallel()
+
+	state := testStateStore(t)
+
+	// existing consumes all of node's resources (cpu=4000, memory=8192,
+	// disk=102400) and is evicted; placed (a default mock.Alloc: cpu=500,
+	// memory=256, disk=150) takes its place.
+	existing := mock.Alloc()
+	node := mock.Node()
+	node.ReservedResources = nil
+	existing.NodeID = node.ID
+	existing.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{existing}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	evicted := new(structs.Allocation)
+	*evicted = *existing
+	evicted.DesiredStatus = structs.AllocDesiredStatusEvict
+
+	placed := mock.Alloc()
+	placed.Job = existing.Job
+
+	plan := &structs.Plan{
+		Job: existing.Job,
+		NodeUpdate: map[string][]*structs.Allocation{
+			node.ID: {evicted},
+		},
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {placed},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	// Hand-computed expectation: placed's resources minus existing's.
+	expected := &structs.ComparableResources{
+		Flattened: structs.AllocatedTaskResources{
+			Cpu:      structs.AllocatedCpuResources{CpuShares: 500 - 4000},
+			Memory:   structs.AllocatedMemoryResources{MemoryMB: 256 - 8192},
+			Networks: placed.AllocatedResources.Tasks["web"].Networks,
+		},
+		Shared: structs.AllocatedSharedResources{
+			DiskMB: 150 - 102400,
+		},
+	}
+	require.Equal(t, expected, result.ResourceDelta[node.ID])
+}
+
+// TestPlanApply_EvalPlan_Preempt asserts that a plan opted into Preempt can
+// place a high-priority allocation that only fits once a lower-priority
+// existing allocation is evicted, and that the eviction list is minimal:
+// only the one low-priority allocation holding the node's resources is
+// preempted, not the unrelated one that isn't in the way.
+func TestPlanApply_EvalPlan_Preempt(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	// lowPriorityAlloc consumes all of the node's resources, so the new
+	// placement can't fit unless it's preempted.
+	lowPriorityAlloc := mock.Alloc()
+	lowPriorityAlloc.NodeID = node.ID
+	lowPriorityAlloc.Job.Priority = 10
+	lowPriorityAlloc.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+
+	// anotherLowPriorityAlloc's priority is close enough to newAlloc's that
+	// it's not a preemption candidate at all (same rule the scheduler uses:
+	// a priority delta under 10 is left alone), so it must be left alone
+	// for the eviction list to be minimal. Its networks are cleared so it
+	// doesn't collide with newAlloc's default reserved ports on the same
+	// node.
+	anotherLowPriorityAlloc := mock.Alloc()
+	anotherLowPriorityAlloc.NodeID = node.ID
+	anotherLowPriorityAlloc.Job.Priority = 45
+	anotherLowPriorityAlloc.AllocatedResources.Tasks["web"].Networks = nil
+
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1001,
+		[]*structs.Allocation{lowPriorityAlloc, anotherLowPriorityAlloc}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	newAlloc := mock.Alloc()
+	newAlloc.NodeID = node.ID
+	newAlloc.Job.Priority = 50
+
+	plan := &structs.Plan{
+		Job:     newAlloc.Job,
+		Preempt: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {newAlloc},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Contains(t, result.NodeAllocation, node.ID)
+	require.Equal(t, []*structs.Allocation{newAlloc}, result.NodeAllocation[node.ID])
+
+	require.Len(t, result.NodePreemptions[node.ID], 1)
+	preempted := result.NodePreemptions[node.ID][0]
+	require.Equal(t, lowPriorityAlloc.ID, preempted.ID)
+	require.Equal(t, structs.AllocDesiredStatusEvict, preempted.DesiredStatus)
+	require.Equal(t, newAlloc.ID, preempted.PreemptedByAllocation)
+}
+
+// TestPlanApply_EvalNodePlan_ErrorsReferenceNodeID guards against a
+// regression where evaluateNodePlan's "failed to get node" and "failed to
+// get existing allocations" error messages would format the (at that point
+// nil) node lookup result rather than the requested nodeID,
+// producing an unhelpful "failed to get node '<nil>'". Both paths must
+// reference the requested nodeID so operators can tell which node lookup
+// failed.
+func TestPlanApply_EvalNodePlan_ErrorsReferenceNodeID(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	nodeID := uuid.Generate()
+	plan := &structs.Plan{
+		Job: mock.Job(),
+		NodeAllocation: map[string][]*structs.Allocation{
+			nodeID: {mock.Alloc()},
+		},
+	}
+
+	// The node doesn't exist, so this doesn't exercise the NodeByID error
+	// branch directly (NodeByID returns nil, nil for a missing node, not an
+	// error), but it does confirm the reason string is well-formed and
+	// never leaks a nil node representation.
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, nodeID)
+	require.NoError(t, err)
+	require.False(t, fit)
+	require.NotContains(t, reason, "<nil>")
+	require.Equal(t, "node does not exist", reason)
+}
+
+func TestPlanApply_EvalPlan_Partial_AllAtOnce(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
+	snap, _ := state.Snapshot()
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+	plan := &structs.Plan{
+		Job:       alloc.Job,
+		AllAtOnce: true, // Require all to make progress
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+		Deployment: mock.Deployment(),
+		DeploymentUpdates: []*structs.DeploymentStatusUpdate{
+			{
+				DeploymentID:      uuid.Generate(),
+				Status:            "foo",
+				StatusDescription: "bar",
+			},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result == nil {
+		t.Fatalf("missing result")
+	}
+
+	if len(result.NodeAllocation) != 0 {
+		t.Fatalf("should not alloc: %v", result.NodeAllocation)
+	}
+	if result.RefreshIndex != 1001 {
+		t.Fatalf("bad: %d", result.RefreshIndex)
+	}
+	if result.Deployment != nil || len(result.DeploymentUpdates) != 0 {
+		t.Fatalf("bad: %v", result)
+	}
+}
+
+// TestPlanApply_EvalPlan_AllAtOnce_RejectedNodeReported asserts that when
+// plan.AllAtOnce short-circuits evaluation because a node doesn't fit, the
+// failing node's ID and fit-failure reason are still recorded in
+// result.RejectedNodes, even though the gang semantics of AllAtOnce mean
+// not every node gets evaluated.
+func TestPlanApply_EvalPlan_AllAtOnce_RejectedNodeReported(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	node2 := mock.Node()
+	state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2)
+	snap, _ := state.Snapshot()
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // consumes all of node2's resources, so it won't fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	plan := &structs.Plan{
+		Job:       alloc.Job,
+		AllAtOnce: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Empty(t, result.NodeAllocation, "AllAtOnce should have discarded all placements")
+	require.NotContains(t, result.RejectedNodes, node.ID)
+	require.Contains(t, result.RejectedNodes, node2.ID)
+	require.Equal(t, "cpu", result.RejectedNodes[node2.ID])
+}
+
+// TestPlanApply_EvalPlan_SatisfyCount asserts that evaluatePlanPlacements
+// stops evaluating nodes once a plan's SatisfyCount has been met, even
+// though the plan includes allocations on additional nodes that fit.
+func TestPlanApply_EvalPlan_SatisfyCount(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	const numNodes = 4
+	nodeIDs := make([]string, numNodes)
+	for i := 0; i < numNodes; i++ {
+		node := mock.Node()
+		require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, uint64(1000+i), node))
+		nodeIDs[i] = node.ID
+	}
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	alloc := mock.Alloc()
+	nodeAllocation := make(map[string][]*structs.Allocation, numNodes)
+	for _, nodeID := range nodeIDs {
+		a := alloc.Copy()
+		a.NodeID = nodeID
+		nodeAllocation[nodeID] = []*structs.Allocation{a}
+	}
+
+	plan := &structs.Plan{
+		Job:            alloc.Job,
+		NodeAllocation: nodeAllocation,
+		SatisfyCount:   2,
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.NodeAllocation, 2,
+		"expected evaluation to stop once SatisfyCount nodes had fit")
+
+	// A plan that was short-circuited isn't a partial commit: the nodes that
+	// were never evaluated simply weren't needed.
+	require.Zero(t, result.RefreshIndex)
+}
+
+// TestPlanApply_EvalPlan_CorrelationID asserts that a plan's correlation
+// ID, as set by PlanQueue.Enqueue, survives unchanged from the pending
+// plan through to the evaluated result, whether or not the plan actually
+// commits anything.
+func TestPlanApply_EvalPlan_CorrelationID(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	node := mock.Node()
+	alloc := mock.Alloc()
+	alloc.NodeID = node.ID
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	_, err := pq.Enqueue(plan)
+	require.NoError(t, err)
+	pending, err := pq.Dequeue(time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, pending.plan.CorrelationID)
+
+	state := testStateStore(t)
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, pending.plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.Equal(t, pending.plan.CorrelationID, result.CorrelationID)
+}
+
+// TestPlanApply_EvalPlan_StrictValidation asserts that a plan with
+// StrictValidation set refuses to apply any allocations once any node fails
+// to fit, even though (unlike AllAtOnce) every node is still independently
+// evaluated rather than short-circuiting on the first failure.
+func TestPlanApply_EvalPlan_StrictValidation(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+	node2 := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, node2))
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	alloc := mock.Alloc()
+	alloc2 := mock.Alloc() // Ensure alloc2 does not fit
+	alloc2.AllocatedResources = structs.NodeResourcesToAllocatedResources(node2.NodeResources)
+
+	plan := &structs.Plan{
+		Job:              alloc.Job,
+		StrictValidation: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID:  {alloc},
+			node2.ID: {alloc2},
+		},
+		Deployment: mock.Deployment(),
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Empty(t, result.NodeAllocation, "strict validation should reject the whole plan")
+	require.Nil(t, result.Deployment)
+	require.Equal(t, uint64(1001), result.RefreshIndex)
+}
+
+// TestPlanApply_EvalPlan_StrictValidation_AllFit asserts that a plan with
+// StrictValidation set still applies normally when every node fits.
+func TestPlanApply_EvalPlan_StrictValidation_AllFit(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	alloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job:              alloc.Job,
+		StrictValidation: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Len(t, result.NodeAllocation, 1)
+	require.Zero(t, result.RefreshIndex)
+}
+
+// TestPlanApply_EvalPlan_StrictValidation_DisablesSatisfyCount asserts that
+// StrictValidation disables the SatisfyCount short-circuit, since strict
+// validation needs every node evaluated before deciding whether to commit.
+func TestPlanApply_EvalPlan_StrictValidation_DisablesSatisfyCount(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	const numNodes = 4
+	nodeIDs := make([]string, numNodes)
+	for i := 0; i < numNodes; i++ {
+		node := mock.Node()
+		require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, uint64(1000+i), node))
+		nodeIDs[i] = node.ID
+	}
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	alloc := mock.Alloc()
+	nodeAllocation := make(map[string][]*structs.Allocation, numNodes)
+	for _, nodeID := range nodeIDs {
+		a := alloc.Copy()
+		a.NodeID = nodeID
+		nodeAllocation[nodeID] = []*structs.Allocation{a}
+	}
+
+	plan := &structs.Plan{
+		Job:              alloc.Job,
+		NodeAllocation:   nodeAllocation,
+		SatisfyCount:     2,
+		StrictValidation: true,
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.NodeAllocation, numNodes,
+		"StrictValidation should disable the SatisfyCount short-circuit and evaluate every node")
+}
+
+// Test that an allocation left behind on a node that no longer exists gets
+// a cleanup eviction proposed when the plan opts in via
+// ReconcileOrphanedAllocs, and is left alone otherwise.
+func TestPlanApply_EvalPlan_ReconcileOrphanedAllocs(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	orphan := mock.Alloc()
+	orphan.NodeID = uuid.Generate()
+	require.NoError(t, state.UpsertJobSummary(999, mock.JobSummary(orphan.JobID)))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1000, []*structs.Allocation{orphan}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job: orphan.Job,
+		NodeUpdate: map[string][]*structs.Allocation{
+			orphan.NodeID: {},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Empty(t, result.NodeUpdate[orphan.NodeID],
+		"orphaned allocs should be left alone unless the plan opts in")
+
+	plan.ReconcileOrphanedAllocs = true
+	result, err = evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.NodeUpdate[orphan.NodeID], 1)
+	require.Equal(t, orphan.ID, result.NodeUpdate[orphan.NodeID][0].ID)
+	require.Equal(t, structs.AllocDesiredStatusStop, result.NodeUpdate[orphan.NodeID][0].DesiredStatus)
+}
+
+func TestPlanApply_EvalPlan_NodeScoring(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	packedNode := mock.Node()
+	emptyNode := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, packedNode))
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, emptyNode))
+
+	// Fill packedNode with an allocation that consumes half of its
+	// resources, leaving emptyNode idle. Both nodes still have plenty of
+	// room left for the new allocation, so NodeScoring is the only thing
+	// that decides which one SatisfyCount picks.
+	existing := mock.Alloc()
+	existing.NodeID = packedNode.ID
+	existing.AllocatedResources = &structs.AllocatedResources{
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu:    structs.AllocatedCpuResources{CpuShares: packedNode.NodeResources.Cpu.CpuShares / 2},
+				Memory: structs.AllocatedMemoryResources{MemoryMB: packedNode.NodeResources.Memory.MemoryMB / 2},
+			},
+		},
+	}
+	require.NoError(t, state.UpsertJobSummary(999, mock.JobSummary(existing.JobID)))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1002, []*structs.Allocation{existing}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	planFor := func(algorithm structs.SchedulerAlgorithm) *structs.Plan {
+		newAlloc := mock.Alloc()
+		return &structs.Plan{
+			Job:          newAlloc.Job,
+			SatisfyCount: 1,
+			NodeScoring:  algorithm,
+			NodeAllocation: map[string][]*structs.Allocation{
+				packedNode.ID: {newAlloc},
+				emptyNode.ID:  {newAlloc},
+			},
+		}
+	}
+
+	// A single worker keeps evaluation order deterministic so SatisfyCount's
+	// short-circuit reliably reflects NodeScoring's reordering.
+	pool := NewEvaluatePool(1, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, planFor(structs.SchedulerAlgorithmBinpack), testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Contains(t, result.NodeAllocation, packedNode.ID, "binpack should favor the already-packed node")
+	require.NotContains(t, result.NodeAllocation, emptyNode.ID)
+
+	result, err = evaluatePlan(pool, snap, planFor(structs.SchedulerAlgorithmSpread), testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Contains(t, result.NodeAllocation, emptyNode.ID, "spread should favor the idle node")
+	require.NotContains(t, result.NodeAllocation, packedNode.ID)
+}
+
+// TestPlanApply_EvalPlan_HeadroomOrdering asserts that HeadroomOrdering
+// favors the node with the most spare capacity first, so that a plan
+// targeting more candidate nodes than it needs fills the roomiest node
+// rather than fragmenting capacity across both.
+func TestPlanApply_EvalPlan_HeadroomOrdering(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	packedNode := mock.Node()
+	emptyNode := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, packedNode))
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, emptyNode))
+
+	// Fill packedNode with an allocation that consumes half of its
+	// resources, leaving emptyNode idle. Both nodes still have plenty of
+	// room left for the new allocation, so HeadroomOrdering is the only
+	// thing that decides which one SatisfyCount picks.
+	existing := mock.Alloc()
+	existing.NodeID = packedNode.ID
+	existing.AllocatedResources = &structs.AllocatedResources{
+		Tasks: map[string]*structs.AllocatedTaskResources{
+			"web": {
+				Cpu:    structs.AllocatedCpuResources{CpuShares: packedNode.NodeResources.Cpu.CpuShares / 2},
+				Memory: structs.AllocatedMemoryResources{MemoryMB: packedNode.NodeResources.Memory.MemoryMB / 2},
+			},
+		},
+	}
+	require.NoError(t, state.UpsertJobSummary(999, mock.JobSummary(existing.JobID)))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1002, []*structs.Allocation{existing}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	newAlloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job:              newAlloc.Job,
+		SatisfyCount:     1,
+		HeadroomOrdering: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			packedNode.ID: {newAlloc},
+			emptyNode.ID:  {newAlloc},
+		},
+	}
+
+	// A single worker keeps evaluation order deterministic so SatisfyCount's
+	// short-circuit reliably reflects HeadroomOrdering's reordering.
+	pool := NewEvaluatePool(1, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Contains(t, result.NodeAllocation, emptyNode.ID, "headroom ordering should favor the roomier node")
+	require.NotContains(t, result.NodeAllocation, packedNode.ID)
+}
+
+// TestPlanApply_EvalPlanDryRun asserts that evaluatePlanDryRun reports the
+// utilization a node would have if a plan's proposed changes were applied,
+// without placing or evicting anything.
+func TestPlanApply_EvalPlanDryRun(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	existing := mock.Alloc()
+	existing.NodeID = node.ID
+	require.NoError(t, state.UpsertJobSummary(999, mock.JobSummary(existing.JobID)))
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{existing}))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	newAlloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job: newAlloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {newAlloc},
+		},
+	}
+
+	result, err := evaluatePlanDryRun(snap, plan)
+	require.NoError(t, err)
+	require.Contains(t, result, node.ID)
+
+	// existing and newAlloc are both default mock.Alloc()s, so the node
+	// ends up with 2x their resources committed against its mock.Node()
+	// capacity of 4000 CPU shares, 8192 MB memory, and 102400 MB disk.
+	projection := result[node.ID]
+	require.Equal(t, float64(1000)/4000*100, projection.CPUPercent)
+	require.Equal(t, float64(512)/8192*100, projection.MemoryPercent)
+	require.Equal(t, float64(300)/102400*100, projection.DiskPercent)
+}
+
+func TestPlanApply_EstimatePlan(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	fitNode := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, fitNode))
+
+	full := mock.Node()
+	full.NodeResources.Cpu.CpuShares = 500
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1001, full))
+
+	alloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			fitNode.ID: {alloc},
+			full.ID:    {alloc},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	estimate, err := EstimatePlan(pool, snap, plan)
+	require.NoError(t, err)
+	require.Equal(t, 2, estimate.TotalNodes)
+	require.Equal(t, 1, estimate.FitNodes)
+	require.Equal(t, 0.5, estimate.Confidence)
+
+	// A subsequent real evaluation should agree with the estimate: only the
+	// node with enough CPU share capacity ends up in the committed result.
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.Contains(t, result.NodeAllocation, fitNode.ID)
+	require.NotContains(t, result.NodeAllocation, full.ID)
+}
+
+func TestPlanApply_EstimatePlan_NoTargetNodes(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	estimate, err := EstimatePlan(pool, snap, &structs.Plan{})
+	require.NoError(t, err)
+	require.Equal(t, float64(1), estimate.Confidence)
+}
+
+func TestClassifyPlanApplyErr(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		err    error
+		target error
+	}{
+		{"not leader", raft.ErrNotLeader, structs.ErrNotLeader},
+		{"leadership lost", raft.ErrLeadershipLost, structs.ErrNotLeader},
+		{"enqueue timeout", raft.ErrEnqueueTimeout, structs.ErrPlanTimeout},
+		{"other", fmt.Errorf("boom"), structs.ErrPlanInvalid},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := classifyPlanApplyErr(c.err)
+			require.True(t, errors.Is(err, c.target))
+		})
+	}
+}
+
+func TestPlanApply_EvalPlan_StaleOnMissingAlloc(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	plan := &structs.Plan{
+		Job: mock.Job(),
+		NodeUpdate: map[string][]*structs.Allocation{
+			uuid.Generate(): {{ID: uuid.Generate()}},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	_, err = evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.Error(t, err)
+	require.True(t, errors.Is(err, structs.ErrPlanStale))
+}
+
+func TestPlanApply_EvalPlan_RejectedOnNonexistentNode(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+
+	alloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			uuid.Generate(): {alloc},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	_, err = evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.Error(t, err)
+	require.True(t, errors.Is(err, structs.ErrPlanRejected))
+}
+
+func TestPlanApply_ApplyPlan_BreakerOpenIsTimeout(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	s1.planner.breaker = newPlanApplyCircuitBreaker(1, time.Hour)
+	s1.planner.breaker.recordFailure()
+
+	snap, err := s1.fsm.State().Snapshot()
+	require.NoError(t, err)
+
+	_, err = s1.planner.applyPlan(&structs.Plan{}, &structs.PlanResult{}, snap)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, structs.ErrPlanTimeout))
+}
+
+func TestPlanOutcome(t *testing.T) {
+	t.Parallel()
+
+	allocA := mock.Alloc()
+	allocB := mock.Alloc()
+	plan := &structs.Plan{
+		NodeAllocation: map[string][]*structs.Allocation{
+			"node1": {allocA},
+			"node2": {allocB},
+		},
+	}
+
+	t.Run("fully applied", func(t *testing.T) {
+		result := &structs.PlanResult{
+			NodeAllocation: map[string][]*structs.Allocation{
+				"node1": {allocA},
+				"node2": {allocB},
+			},
+		}
+		require.Equal(t, planOutcomeApplied, planOutcome(plan, result))
+	})
+
+	t.Run("partially applied", func(t *testing.T) {
+		result := &structs.PlanResult{
+			NodeAllocation: map[string][]*structs.Allocation{
+				"node1": {allocA},
+			},
+		}
+		require.Equal(t, planOutcomePartial, planOutcome(plan, result))
+	})
+
+	t.Run("fully rejected", func(t *testing.T) {
+		result := &structs.PlanResult{}
+		require.Equal(t, planOutcomeRejected, planOutcome(plan, result))
+	})
+}
+
+func TestEmitPlanOutcomeMetric_ErrorOverridesFitState(t *testing.T) {
+	t.Parallel()
+
+	// A plan that would otherwise be fully committed is still recorded as
+	// errored when an error is supplied, since the error means the commit
+	// never actually went through.
+	plan := &structs.Plan{}
+	result := &structs.PlanResult{}
+
+	// emitPlanOutcomeMetric has no return value to assert on; this just
+	// exercises the err != nil path (which skips calling planOutcome) to
+	// guard against a future refactor re-introducing a nil-result panic.
+	emitPlanOutcomeMetric(plan, result, fmt.Errorf("raft apply failed"), testlog.HCLogger(t))
+	emitPlanOutcomeMetric(plan, nil, fmt.Errorf("raft apply failed"), testlog.HCLogger(t))
+}
+
+func TestPlanApply_EvalPlan_EvictionOrder_Priority(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	low := mock.Alloc()
+	low.Job.Priority = 10
+	low.NodeID = node.ID
+	high := mock.Alloc()
+	high.Job.Priority = 80
+	high.NodeID = node.ID
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{low, high}))
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job:           low.Job,
+		EvictionOrder: structs.EvictionOrderPriorityAscending,
+		NodeUpdate: map[string][]*structs.Allocation{
+			node.ID: {{ID: high.ID}, {ID: low.ID}},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.NodeUpdate[node.ID], 2)
+	require.Equal(t, low.ID, result.NodeUpdate[node.ID][0].ID)
+	require.Equal(t, high.ID, result.NodeUpdate[node.ID][1].ID)
+}
+
+func TestPlanApply_EvalPlan_EvictionOrder_Age(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	older := mock.Alloc()
+	older.CreateTime = 100
+	older.NodeID = node.ID
+	newer := mock.Alloc()
+	newer.CreateTime = 200
+	newer.NodeID = node.ID
+	require.NoError(t, state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{older, newer}))
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job:           older.Job,
+		EvictionOrder: structs.EvictionOrderOldestFirst,
+		NodeUpdate: map[string][]*structs.Allocation{
+			node.ID: {{ID: newer.ID}, {ID: older.ID}},
+		},
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	result, err := evaluatePlan(pool, snap, plan, testlog.HCLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.NodeUpdate[node.ID], 2)
+	require.Equal(t, older.ID, result.NodeUpdate[node.ID][0].ID)
+	require.Equal(t, newer.ID, result.NodeUpdate[node.ID][1].ID)
+}
 
 func TestPlanApply_EvalNodePlan_Simple(t *testing.T) {
 	t.Parallel()
@@ -671,7 +2115,7 @@ func TestPlanApply_EvalNodePlan_Simple(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -699,7 +2143,7 @@ func TestPlanApply_EvalNodePlan_NodeNotReady(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -727,7 +2171,7 @@ func TestPlanApply_EvalNodePlan_NodeDrain(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -749,55 +2193,361 @@ func TestPlanApply_EvalNodePlan_NodeNotExist(t *testing.T) {
 	plan := &structs.Plan{
 		Job: alloc.Job,
 		NodeAllocation: map[string][]*structs.Allocation{
-			nodeID: {alloc},
+			nodeID: {alloc},
+		},
+	}
+
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, nodeID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fit {
+		t.Fatalf("bad")
+	}
+	if reason == "" {
+		t.Fatalf("bad")
+	}
+}
+
+func TestPlanApply_EvalNodePlan_NodeFull(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	state := testStateStore(t)
+	node := mock.Node()
+	node.ReservedResources = nil
+	alloc.NodeID = node.ID
+	alloc.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+	state.UpsertJobSummary(999, mock.JobSummary(alloc.JobID))
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{alloc})
+
+	alloc2 := mock.Alloc()
+	alloc2.NodeID = node.ID
+	state.UpsertJobSummary(1200, mock.JobSummary(alloc2.JobID))
+
+	snap, _ := state.Snapshot()
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc2},
+		},
+	}
+
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fit {
+		t.Fatalf("bad")
+	}
+	if reason == "" {
+		t.Fatalf("bad")
+	}
+}
+
+// Test that a system job's CPU-only fit shortfall is rejected by default
+// (RelaxedSystemJobFit unset), but accepted once RelaxedSystemJobFit is set
+// and the shortfall is within the relaxed CPU margin.
+func TestPlanApply_EvalNodePlan_RelaxedSystemJobFit(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	node.ReservedResources = nil
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	// node has 4000 CPU shares available; place a system alloc that needs
+	// 4200 (a 5% overage, within the 10% relaxed margin) and fits memory
+	// and disk comfortably.
+	alloc := mock.SystemAlloc()
+	alloc.NodeID = node.ID
+	alloc.AllocatedResources.Tasks["web"].Cpu.CpuShares = 4200
+	require.NoError(t, state.UpsertJobSummary(1001, mock.JobSummary(alloc.JobID)))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit, "strict fit check should reject a CPU overage by default")
+	require.Equal(t, "cpu", reason)
+
+	plan.RelaxedSystemJobFit = true
+	fit, _, _, _, err = evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.True(t, fit, "relaxed fit check should accept a marginal CPU overage for a system job")
+}
+
+// Test that RelaxedSystemJobFit doesn't relax a CPU shortfall beyond its
+// margin, and doesn't relax memory or disk shortfalls at all.
+func TestPlanApply_EvalNodePlan_RelaxedSystemJobFit_BeyondMargin(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	node.ReservedResources = nil
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	// 4000 available CPU, a 50% overage is well beyond the 10% margin.
+	cpuAlloc := mock.SystemAlloc()
+	cpuAlloc.NodeID = node.ID
+	cpuAlloc.AllocatedResources.Tasks["web"].Cpu.CpuShares = 6000
+	require.NoError(t, state.UpsertJobSummary(1001, mock.JobSummary(cpuAlloc.JobID)))
+
+	// 8192 available memory; this allocation exceeds it, which is never
+	// relaxed regardless of the RelaxedSystemJobFit setting.
+	memAlloc := mock.SystemAlloc()
+	memAlloc.NodeID = node.ID
+	memAlloc.AllocatedResources.Tasks["web"].Memory.MemoryMB = 9000
+	require.NoError(t, state.UpsertJobSummary(1002, mock.JobSummary(memAlloc.JobID)))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	cpuPlan := &structs.Plan{
+		Job:                 cpuAlloc.Job,
+		RelaxedSystemJobFit: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {cpuAlloc},
+		},
+	}
+	fit, reason, _, _, err := evaluateNodePlan(snap, cpuPlan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit, "relaxed fit check should still reject a CPU shortfall beyond its margin")
+	require.Equal(t, "cpu", reason)
+
+	memPlan := &structs.Plan{
+		Job:                 memAlloc.Job,
+		RelaxedSystemJobFit: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {memAlloc},
+		},
+	}
+	fit, reason, _, _, err = evaluateNodePlan(snap, memPlan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit, "relaxed fit check should never relax a memory shortfall")
+	require.Equal(t, "memory", reason)
+}
+
+// Test that a node's forecasted (not-yet-committed) allocations are ignored
+// by default, but counted against capacity when the plan sets
+// ForecastedAllocs for that node.
+func TestPlanApply_EvalNodePlan_ForecastedAllocs(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	node.ReservedResources = nil
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+
+	forecasted := mock.Alloc()
+	forecasted.NodeID = node.ID
+	forecasted.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+
+	alloc := mock.Alloc()
+	alloc.NodeID = node.ID
+	state.UpsertJobSummary(1200, mock.JobSummary(alloc.JobID))
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	fit, _, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.True(t, fit, "forecasted allocs should be ignored unless the plan sets them")
+
+	plan.ForecastedAllocs = map[string][]*structs.Allocation{
+		node.ID: {forecasted},
+	}
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit, "forecasted allocs should count against capacity when set")
+	require.NotEmpty(t, reason)
+}
+
+// Test that a failed fit reports the node's currently available resources,
+// which are computed from the allocations already on the node rather than
+// from the plan's proposed (and rejected) placement.
+func TestPlanApply_EvalNodePlan_NodeFull_AvailableResources(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	state := testStateStore(t)
+	node := mock.Node()
+	node.ReservedResources = nil
+	alloc.NodeID = node.ID
+	alloc.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
+	state.UpsertJobSummary(999, mock.JobSummary(alloc.JobID))
+	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
+	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{alloc})
+
+	alloc2 := mock.Alloc()
+	alloc2.NodeID = node.ID
+	state.UpsertJobSummary(1200, mock.JobSummary(alloc2.JobID))
+
+	snap, _ := state.Snapshot()
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc2},
+		},
+	}
+
+	fit, _, available, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit)
+	require.NotNil(t, available)
+
+	// The existing alloc consumed the entire node, so there's no room left.
+	require.LessOrEqual(t, available.Flattened.Cpu.CpuShares, int64(0))
+	require.LessOrEqual(t, available.Flattened.Memory.MemoryMB, int64(0))
+}
+
+// Test that ValidateCSIPlugins rejects an allocation whose task group
+// references a CSI volume whose plugin isn't registered.
+func TestPlanApply_EvalNodePlan_CSIPluginMissing(t *testing.T) {
+	t.Parallel()
+	state := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+	plugin := mock.CSIPlugin()
+	vol := mock.CSIVolume(plugin)
+	require.NoError(t, state.CSIVolumeRegister(1001, []*structs.CSIVolume{vol}))
+	// Note: the plugin itself is never registered with UpsertCSIPlugin.
+
+	alloc := mock.Alloc()
+	alloc.Job.TaskGroups[0].Volumes = map[string]*structs.VolumeRequest{
+		"data": {Name: "data", Type: structs.VolumeTypeCSI, Source: vol.ID},
+	}
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
+
+	plan := &structs.Plan{
+		Job:                alloc.Job,
+		ValidateCSIPlugins: true,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, nodeID)
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	if fit {
-		t.Fatalf("bad")
-	}
-	if reason == "" {
-		t.Fatalf("bad")
-	}
+	fit, reason, available, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.False(t, fit)
+	require.Contains(t, reason, plugin.ID)
+	require.Nil(t, available)
 }
 
-func TestPlanApply_EvalNodePlan_NodeFull(t *testing.T) {
+// Test that ValidateCSIPlugins allows an allocation whose task group
+// references a CSI volume whose plugin is registered.
+func TestPlanApply_EvalNodePlan_CSIPluginPresent(t *testing.T) {
 	t.Parallel()
-	alloc := mock.Alloc()
 	state := testStateStore(t)
 	node := mock.Node()
-	node.ReservedResources = nil
-	alloc.NodeID = node.ID
-	alloc.AllocatedResources = structs.NodeResourcesToAllocatedResources(node.NodeResources)
-	state.UpsertJobSummary(999, mock.JobSummary(alloc.JobID))
-	state.UpsertNode(structs.MsgTypeTestSetup, 1000, node)
-	state.UpsertAllocs(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{alloc})
+	require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
 
-	alloc2 := mock.Alloc()
-	alloc2.NodeID = node.ID
-	state.UpsertJobSummary(1200, mock.JobSummary(alloc2.JobID))
+	plugin := mock.CSIPlugin()
+	require.NoError(t, state.UpsertCSIPlugin(1001, plugin))
+	vol := mock.CSIVolume(plugin)
+	require.NoError(t, state.CSIVolumeRegister(1002, []*structs.CSIVolume{vol}))
+
+	alloc := mock.Alloc()
+	alloc.Job.TaskGroups[0].Volumes = map[string]*structs.VolumeRequest{
+		"data": {Name: "data", Type: structs.VolumeTypeCSI, Source: vol.ID},
+	}
+
+	snap, err := state.Snapshot()
+	require.NoError(t, err)
 
-	snap, _ := state.Snapshot()
 	plan := &structs.Plan{
-		Job: alloc.Job,
+		Job:                alloc.Job,
+		ValidateCSIPlugins: true,
 		NodeAllocation: map[string][]*structs.Allocation{
-			node.ID: {alloc2},
+			node.ID: {alloc},
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	if fit {
-		t.Fatalf("bad")
-	}
-	if reason == "" {
-		t.Fatalf("bad")
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+	require.NoError(t, err)
+	require.True(t, fit)
+	require.Empty(t, reason)
+}
+
+// Test that evaluateNodePlan rejects a plan that would claim more CSI
+// volumes on a node than the node's most restrictive fingerprinted CSI node
+// plugin reports it can attach, and allows plans at or below that limit.
+func TestPlanApply_EvalNodePlan_CSIMaxVolumes(t *testing.T) {
+	t.Parallel()
+
+	allocWithCSIVolume := func() *structs.Allocation {
+		alloc := mock.Alloc()
+		// Each alloc in the batch otherwise claims the same reserved port,
+		// which would make AllocsFit reject the plan for a reason unrelated
+		// to CSI volume capacity, so clear the networking resources that
+		// aren't relevant to this test.
+		alloc.Resources.Networks = nil
+		alloc.TaskResources["web"].Networks = nil
+		alloc.AllocatedResources.Tasks["web"].Networks = nil
+		alloc.Job.TaskGroups[0].Volumes = map[string]*structs.VolumeRequest{
+			"data": {Name: "data", Type: structs.VolumeTypeCSI, Source: "test-vol"},
+		}
+		return alloc
+	}
+
+	cases := []struct {
+		Name        string
+		AllocCount  int
+		ExpectedFit bool
+	}{
+		{Name: "below the limit", AllocCount: 1, ExpectedFit: true},
+		{Name: "at the limit", AllocCount: 2, ExpectedFit: true},
+		{Name: "above the limit", AllocCount: 3, ExpectedFit: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			state := testStateStore(t)
+			node := mock.Node()
+			node.CSINodePlugins = map[string]*structs.CSIInfo{
+				"test-plugin": {
+					PluginID: "test-plugin",
+					NodeInfo: &structs.CSINodeInfo{MaxVolumes: 2},
+				},
+			}
+			require.NoError(t, state.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+
+			allocs := make([]*structs.Allocation, 0, tc.AllocCount)
+			for i := 0; i < tc.AllocCount; i++ {
+				allocs = append(allocs, allocWithCSIVolume())
+			}
+
+			snap, err := state.Snapshot()
+			require.NoError(t, err)
+
+			plan := &structs.Plan{
+				Job: allocs[0].Job,
+				NodeAllocation: map[string][]*structs.Allocation{
+					node.ID: allocs,
+				},
+			}
+
+			fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
+			require.NoError(t, err)
+			require.Equal(t, tc.ExpectedFit, fit)
+			if !tc.ExpectedFit {
+				require.Equal(t, "node at max CSI volume capacity", reason)
+			}
+		})
 	}
 }
 
@@ -849,7 +2599,7 @@ func TestPlanApply_EvalNodePlan_NodeFull_Device(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	require.NoError(err)
 	require.False(fit)
 	require.Equal("device oversubscribed", reason)
@@ -875,7 +2625,7 @@ func TestPlanApply_EvalNodePlan_UpdateExisting(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -913,7 +2663,7 @@ func TestPlanApply_EvalNodePlan_NodeFull_Evict(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -946,7 +2696,7 @@ func TestPlanApply_EvalNodePlan_NodeFull_AllocEvict(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -958,6 +2708,587 @@ func TestPlanApply_EvalNodePlan_NodeFull_AllocEvict(t *testing.T) {
 	}
 }
 
+// TestPlanApply_ConcurrentEvaluation_SerializesApply verifies that raising
+// PlanEvaluationConcurrency allows multiple plans to be evaluated at once
+// while their Raft applies remain serialized: every plan still commits
+// successfully and is assigned a distinct Raft index.
+func TestPlanApply_ConcurrentEvaluation_SerializesApply(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanEvaluationConcurrency = 4
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	const numPlans = 8
+	futures := make([]PlanFuture, numPlans)
+
+	for i := 0; i < numPlans; i++ {
+		node := mock.Node()
+		testRegisterNode(t, s1, node)
+
+		alloc := mock.Alloc()
+		alloc.NodeID = node.ID
+		s1.State().UpsertJobSummary(uint64(1000+i), mock.JobSummary(alloc.JobID))
+
+		eval := mock.Eval()
+		eval.JobID = alloc.JobID
+		require.NoError(t, s1.State().UpsertEvals(
+			structs.MsgTypeTestSetup, uint64(1000+i), []*structs.Evaluation{eval}))
+
+		plan := &structs.Plan{
+			Job:    alloc.Job,
+			EvalID: eval.ID,
+			NodeAllocation: map[string][]*structs.Allocation{
+				node.ID: {alloc},
+			},
+		}
+
+		future, err := s1.planQueue.Enqueue(plan)
+		require.NoError(t, err)
+		futures[i] = future
+	}
+
+	seenIndexes := make(map[uint64]bool, numPlans)
+	for _, future := range futures {
+		result, err := future.Wait()
+		require.NoError(t, err)
+		require.NotNil(t, result)
+		require.False(t, seenIndexes[result.AllocIndex],
+			"expected each concurrently-evaluated plan to commit at a distinct Raft index")
+		seenIndexes[result.AllocIndex] = true
+	}
+}
+
+// TestPlanApply_ConcurrentEvaluation_ReservesNodes verifies that raising
+// PlanEvaluationConcurrency never lets two plans that target the same node
+// be evaluated against it without coordination. It registers a single node
+// with only enough capacity for one of the allocations below, then submits
+// many plans that each place such an allocation on that node. If workers
+// evaluated plans against that node independently, every plan would see
+// the node's pre-either-apply capacity and pass its fit check, overcommitting
+// it; with node reservations in place, only as many plans as actually fit
+// are ever placed.
+func TestPlanApply_ConcurrentEvaluation_ReservesNodes(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanEvaluationConcurrency = 8
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	node := mock.Node()
+	node.NodeResources.Cpu.CpuShares = 600
+	node.Reserved = nil
+	node.ReservedResources = nil
+	testRegisterNode(t, s1, node)
+
+	const numPlans = 10
+	const allocCPU = 500
+	futures := make([]PlanFuture, numPlans)
+
+	for i := 0; i < numPlans; i++ {
+		alloc := mock.Alloc()
+		alloc.NodeID = node.ID
+		alloc.Resources.Networks = nil
+		alloc.TaskResources["web"].Networks = nil
+		alloc.TaskResources["web"].CPU = allocCPU
+		alloc.AllocatedResources.Tasks["web"].Networks = nil
+		alloc.AllocatedResources.Tasks["web"].Cpu.CpuShares = allocCPU
+		s1.State().UpsertJobSummary(uint64(2000+i), mock.JobSummary(alloc.JobID))
+
+		eval := mock.Eval()
+		eval.JobID = alloc.JobID
+		require.NoError(t, s1.State().UpsertEvals(
+			structs.MsgTypeTestSetup, uint64(2000+i), []*structs.Evaluation{eval}))
+
+		plan := &structs.Plan{
+			Job:    alloc.Job,
+			EvalID: eval.ID,
+			NodeAllocation: map[string][]*structs.Allocation{
+				node.ID: {alloc},
+			},
+		}
+
+		future, err := s1.planQueue.Enqueue(plan)
+		require.NoError(t, err)
+		futures[i] = future
+	}
+
+	var placedCPU int64
+	for _, future := range futures {
+		result, err := future.Wait()
+		require.NoError(t, err)
+		require.NotNil(t, result)
+		for _, allocs := range result.NodeAllocation {
+			for _, alloc := range allocs {
+				placedCPU += alloc.AllocatedResources.Tasks["web"].Cpu.CpuShares
+			}
+		}
+	}
+
+	require.LessOrEqual(t, placedCPU, node.NodeResources.Cpu.CpuShares,
+		"plans concurrently evaluated against the same node overcommitted its capacity")
+}
+
+// TestNodeReservations_TryAcquire_AvoidsCircularWait reproduces, directly
+// against nodeReservations' acquire/release logic, the circular wait a
+// worker that already holds one reservation can form if it blocks trying to
+// acquire a second: goroutine A holds n1 and wants n2, goroutine B holds n2
+// and wants n1, and with a blocking acquire for the second request neither
+// would ever release its first reservation to unblock the other. Using
+// tryAcquire for the second request must resolve immediately instead.
+func TestNodeReservations_TryAcquire_AvoidsCircularWait(t *testing.T) {
+	t.Parallel()
+
+	r := newNodeReservations()
+
+	// readyA/readyB barrier the two goroutines so both have acquired their
+	// own node before either attempts the cross acquire; otherwise one side
+	// could race ahead and grab the other's node before it's ever taken,
+	// masking the conflict this test exists to exercise.
+	readyA := make(chan struct{})
+	readyB := make(chan struct{})
+	resultA := make(chan bool, 1)
+	resultB := make(chan bool, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.acquire([]string{"n1"})
+		close(readyA)
+		<-readyB
+		resultA <- r.tryAcquire([]string{"n2"})
+	}()
+	go func() {
+		defer wg.Done()
+		r.acquire([]string{"n2"})
+		close(readyB)
+		<-readyA
+		resultB <- r.tryAcquire([]string{"n1"})
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tryAcquire formed a circular wait instead of returning immediately on conflict")
+	}
+
+	acquiredA, acquiredB := <-resultA, <-resultB
+	require.False(t, acquiredA && acquiredB,
+		"both sides of a conflicting cross-acquire cannot both succeed")
+}
+
+// TestPlanApply_ConcurrentEvaluation_CoalesceNoDeadlock exercises
+// PlanEvaluationConcurrency and PlanCoalesceMaxPlans together, which the
+// previous concurrency test didn't: many plans are spread across a handful
+// of nodes so that workers' opportunistic coalescing attempts frequently
+// cross another worker's already-held nodes. It asserts every plan resolves
+// within a bounded time (a regression would hang instead of failing) and
+// that capacity is never overcommitted on any node.
+func TestPlanApply_ConcurrentEvaluation_CoalesceNoDeadlock(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanEvaluationConcurrency = 4
+		c.PlanCoalesceMaxPlans = 4
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	const numNodes = 4
+	const allocCPU = 100
+	nodes := make([]*structs.Node, numNodes)
+	for i := range nodes {
+		nodes[i] = mock.Node()
+		nodes[i].NodeResources.Cpu.CpuShares = 1000
+		nodes[i].Reserved = nil
+		nodes[i].ReservedResources = nil
+		testRegisterNode(t, s1, nodes[i])
+	}
+
+	const numPlans = 40
+	futures := make([]PlanFuture, numPlans)
+	for i := 0; i < numPlans; i++ {
+		alloc := mock.Alloc()
+		alloc.NodeID = nodes[i%numNodes].ID
+		alloc.Resources.Networks = nil
+		alloc.TaskResources["web"].Networks = nil
+		alloc.TaskResources["web"].CPU = allocCPU
+		alloc.AllocatedResources.Tasks["web"].Networks = nil
+		alloc.AllocatedResources.Tasks["web"].Cpu.CpuShares = allocCPU
+		require.NoError(t, s1.State().UpsertJobSummary(uint64(3000+i), mock.JobSummary(alloc.JobID)))
+
+		eval := mock.Eval()
+		eval.JobID = alloc.JobID
+		require.NoError(t, s1.State().UpsertEvals(
+			structs.MsgTypeTestSetup, uint64(3000+i), []*structs.Evaluation{eval}))
+
+		plan := &structs.Plan{
+			Job:    alloc.Job,
+			EvalID: eval.ID,
+			NodeAllocation: map[string][]*structs.Allocation{
+				nodes[i%numNodes].ID: {alloc},
+			},
+		}
+
+		future, err := s1.planQueue.Enqueue(plan)
+		require.NoError(t, err)
+		futures[i] = future
+	}
+
+	placedCPU := make(map[string]int64, numNodes)
+	for _, future := range futures {
+		resultCh := make(chan *structs.PlanResult, 1)
+		errCh := make(chan error, 1)
+		go func(f PlanFuture) {
+			result, err := f.Wait()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			resultCh <- result
+		}(future)
+
+		select {
+		case result := <-resultCh:
+			for nodeID, allocs := range result.NodeAllocation {
+				for _, alloc := range allocs {
+					placedCPU[nodeID] += alloc.AllocatedResources.Tasks["web"].Cpu.CpuShares
+				}
+			}
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-time.After(30 * time.Second):
+			t.Fatal("plan never resolved; a coalescing worker likely deadlocked acquiring another worker's reserved nodes")
+		}
+	}
+
+	for _, node := range nodes {
+		require.LessOrEqual(t, placedCPU[node.ID], node.NodeResources.Cpu.CpuShares,
+			"plans concurrently evaluated and coalesced against node %s overcommitted its capacity", node.ID)
+	}
+}
+
+// TestPlanApply_PlanApplyHooks_ScopedByJobType registers hooks for two
+// distinct job types and asserts each only fires for plans whose job
+// matches that type, and that a hook's error rejects the plan without
+// applying it.
+func TestPlanApply_PlanApplyHooks_ScopedByJobType(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	var batchCalls, serviceCalls int32
+	s1.planner.RegisterPlanApplyHook(structs.JobTypeBatch, func(plan *structs.Plan) error {
+		atomic.AddInt32(&batchCalls, 1)
+		return nil
+	})
+	s1.planner.RegisterPlanApplyHook(structs.JobTypeService, func(plan *structs.Plan) error {
+		atomic.AddInt32(&serviceCalls, 1)
+		return fmt.Errorf("service plans rejected by test hook")
+	})
+
+	submit := func(jobType string) (PlanFuture, *structs.Allocation) {
+		node := mock.Node()
+		testRegisterNode(t, s1, node)
+
+		alloc := mock.Alloc()
+		alloc.NodeID = node.ID
+		alloc.Job.Type = jobType
+		require.NoError(t, s1.State().UpsertJobSummary(1000, mock.JobSummary(alloc.JobID)))
+
+		eval := mock.Eval()
+		eval.JobID = alloc.JobID
+		require.NoError(t, s1.State().UpsertEvals(
+			structs.MsgTypeTestSetup, 1001, []*structs.Evaluation{eval}))
+
+		plan := &structs.Plan{
+			Job:    alloc.Job,
+			EvalID: eval.ID,
+			NodeAllocation: map[string][]*structs.Allocation{
+				node.ID: {alloc},
+			},
+		}
+
+		future, err := s1.planQueue.Enqueue(plan)
+		require.NoError(t, err)
+		return future, alloc
+	}
+
+	batchFuture, _ := submit(structs.JobTypeBatch)
+	result, err := batchFuture.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	serviceFuture, _ := submit(structs.JobTypeService)
+	_, err = serviceFuture.Wait()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service plans rejected by test hook")
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&batchCalls))
+	require.Equal(t, int32(1), atomic.LoadInt32(&serviceCalls))
+}
+
+// TestPlanApply_AllocationDeltaLimit asserts that a plan whose net increase
+// in allocation count exceeds the server's configured
+// PlanAllocationDeltaLimit is rejected, that a plan within the limit is
+// applied normally, and that a plan exceeding the limit still succeeds when
+// it sets AllowAllocationDeltaOverride.
+func TestPlanApply_AllocationDeltaLimit(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanAllocationDeltaLimit = 1
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	submit := func(numAllocs int, override bool) (PlanFuture, error) {
+		nodeAllocation := make(map[string][]*structs.Allocation, numAllocs)
+		var job *structs.Job
+		for i := 0; i < numAllocs; i++ {
+			node := mock.Node()
+			testRegisterNode(t, s1, node)
+
+			alloc := mock.Alloc()
+			alloc.NodeID = node.ID
+			job = alloc.Job
+			require.NoError(t, s1.State().UpsertJobSummary(1000, mock.JobSummary(alloc.JobID)))
+			nodeAllocation[node.ID] = []*structs.Allocation{alloc}
+		}
+
+		eval := mock.Eval()
+		eval.JobID = job.ID
+		require.NoError(t, s1.State().UpsertEvals(
+			structs.MsgTypeTestSetup, 1001, []*structs.Evaluation{eval}))
+
+		plan := &structs.Plan{
+			Job:                          job,
+			EvalID:                       eval.ID,
+			NodeAllocation:               nodeAllocation,
+			AllowAllocationDeltaOverride: override,
+		}
+
+		future, err := s1.planQueue.Enqueue(plan)
+		require.NoError(t, err)
+		return future, nil
+	}
+
+	// Within the limit: succeeds.
+	withinFuture, _ := submit(1, false)
+	result, err := withinFuture.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	// Over the limit, no override: rejected.
+	overFuture, _ := submit(2, false)
+	_, err = overFuture.Wait()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "plan exceeds allocation delta limit")
+
+	// Over the limit, with override: succeeds.
+	overrideFuture, _ := submit(2, true)
+	result, err = overrideFuture.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result)
+}
+
+// TestPlanApply_ApplyLockSerializesAccess is a focused unit test on the
+// mutex planApplyWorker uses to guard the Raft apply step: no matter how
+// many evaluation workers race to acquire it, only one may hold it at a
+// time.
+func TestPlanApply_ApplyLockSerializesAccess(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+
+	var (
+		current int32
+		maxSeen int32
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s1.applyLock.Lock()
+			defer s1.applyLock.Unlock()
+
+			n := atomic.AddInt32(&current, 1)
+			for {
+				old := atomic.LoadInt32(&maxSeen)
+				if n <= old || atomic.CompareAndSwapInt32(&maxSeen, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&current, -1)
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 1, maxSeen, "applyLock should never be held concurrently")
+}
+
+// TestPlanApply_EvaluatePlanWithTimeout asserts that evaluatePlanWithTimeout
+// gives up and returns a structs.ErrPlanTimeout-wrapped error once
+// p.config.PlanEvaluationTimeout elapses, rather than blocking forever on a
+// wedged evaluation. It then confirms a subsequent plan evaluated against
+// the same planner still completes normally, so one stuck plan can't stall
+// every plan behind it.
+func TestPlanApply_EvaluatePlanWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	store := testStateStore(t)
+	node := mock.Node()
+	require.NoError(t, store.UpsertNode(structs.MsgTypeTestSetup, 1000, node))
+	snap, err := store.Snapshot()
+	require.NoError(t, err)
+
+	alloc := mock.Alloc()
+	alloc.NodeID = node.ID
+	plan := &structs.Plan{
+		Job: alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	p := &planner{
+		Server: &Server{config: &Config{PlanEvaluationTimeout: 50 * time.Millisecond}},
+		log:    testlog.HCLogger(t),
+	}
+
+	// A pool with no workers never drains the evaluation request, standing
+	// in for a pathologically slow or wedged state snapshot.
+	wedgedPool := NewEvaluatePool(0, workerPoolBufferSize)
+	defer wedgedPool.Shutdown()
+
+	_, err = p.evaluatePlanWithTimeout(wedgedPool, snap, plan, p.log)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, structs.ErrPlanTimeout))
+
+	// A healthy pool still evaluates the next plan normally; the earlier
+	// timeout didn't leave the planner stuck.
+	healthyPool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer healthyPool.Shutdown()
+
+	result, err := p.evaluatePlanWithTimeout(healthyPool, snap, plan, p.log)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Equal(t, plan.NodeAllocation, result.NodeAllocation)
+}
+
+// BenchmarkPlanApply_EvaluatePlan measures the cost of evaluating a plan
+// against a large cluster snapshot, the step that PlanEvaluationConcurrency
+// allows to run in parallel across multiple plans.
+func BenchmarkPlanApply_EvaluatePlan(b *testing.B) {
+	store := state.TestStateStore(b)
+
+	const numNodes = 200
+	nodeIDs := make([]string, numNodes)
+	for i := 0; i < numNodes; i++ {
+		node := mock.Node()
+		require.NoError(b, store.UpsertNode(structs.MsgTypeTestSetup, uint64(1000+i), node))
+		nodeIDs[i] = node.ID
+	}
+
+	alloc := mock.Alloc()
+	nodeAllocation := make(map[string][]*structs.Allocation, numNodes)
+	for _, nodeID := range nodeIDs {
+		a := alloc.Copy()
+		a.NodeID = nodeID
+		nodeAllocation[nodeID] = []*structs.Allocation{a}
+	}
+
+	plan := &structs.Plan{
+		Job:            alloc.Job,
+		NodeAllocation: nodeAllocation,
+	}
+
+	pool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer pool.Shutdown()
+
+	logger := testlog.HCLogger(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		snap, err := store.Snapshot()
+		require.NoError(b, err)
+
+		_, err = evaluatePlan(pool, snap, plan, logger)
+		require.NoError(b, err)
+	}
+}
+
+// TestPlanApply_EvaluatePlan_PoolSizeConsistency asserts that evaluatePlan's
+// per-node feasibility checks, which run concurrently across an
+// EvaluatePool's workers, produce identical results regardless of how many
+// workers are available to do that work. A pool of size 1 evaluates nodes
+// one at a time, equivalent to a serial implementation; a larger pool
+// evaluates them concurrently. Both must reach the same placement decision.
+func TestPlanApply_EvaluatePlan_PoolSizeConsistency(t *testing.T) {
+	t.Parallel()
+
+	store := state.TestStateStore(t)
+
+	const numNodes = 50
+	nodeIDs := make([]string, numNodes)
+	for i := 0; i < numNodes; i++ {
+		node := mock.Node()
+		require.NoError(t, store.UpsertNode(structs.MsgTypeTestSetup, uint64(1000+i), node))
+		nodeIDs[i] = node.ID
+	}
+
+	alloc := mock.Alloc()
+	nodeAllocation := make(map[string][]*structs.Allocation, numNodes)
+	for _, nodeID := range nodeIDs {
+		a := alloc.Copy()
+		a.NodeID = nodeID
+		nodeAllocation[nodeID] = []*structs.Allocation{a}
+	}
+
+	plan := &structs.Plan{
+		Job:            alloc.Job,
+		NodeAllocation: nodeAllocation,
+	}
+
+	logger := testlog.HCLogger(t)
+
+	serialPool := NewEvaluatePool(1, workerPoolBufferSize)
+	defer serialPool.Shutdown()
+	snap, err := store.Snapshot()
+	require.NoError(t, err)
+	serialResult, err := evaluatePlan(serialPool, snap, plan, logger)
+	require.NoError(t, err)
+
+	parallelPool := NewEvaluatePool(workerPoolSize, workerPoolBufferSize)
+	defer parallelPool.Shutdown()
+	snap, err = store.Snapshot()
+	require.NoError(t, err)
+	parallelResult, err := evaluatePlan(parallelPool, snap, plan, logger)
+	require.NoError(t, err)
+
+	require.Equal(t, serialResult.NodeAllocation, parallelResult.NodeAllocation)
+	require.Equal(t, serialResult.NodeUpdate, parallelResult.NodeUpdate)
+	require.Equal(t, serialResult.RefreshIndex, parallelResult.RefreshIndex)
+}
+
 func TestPlanApply_EvalNodePlan_NodeDown_EvictOnly(t *testing.T) {
 	t.Parallel()
 	alloc := mock.Alloc()
@@ -981,7 +3312,7 @@ func TestPlanApply_EvalNodePlan_NodeDown_EvictOnly(t *testing.T) {
 		},
 	}
 
-	fit, reason, err := evaluateNodePlan(snap, plan, node.ID)
+	fit, reason, _, _, err := evaluateNodePlan(snap, plan, node.ID)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -992,3 +3323,189 @@ func TestPlanApply_EvalNodePlan_NodeDown_EvictOnly(t *testing.T) {
 		t.Fatalf("bad")
 	}
 }
+
+// submitSimplePlan enqueues a single-allocation plan targeting node and
+// returns its future, for use by the coalescing tests below.
+func submitSimplePlan(t testing.TB, s1 *Server, node *structs.Node, idx uint64) PlanFuture {
+	alloc := mock.Alloc()
+	alloc.NodeID = node.ID
+	require.NoError(t, s1.State().UpsertJobSummary(idx, mock.JobSummary(alloc.JobID)))
+
+	eval := mock.Eval()
+	eval.JobID = alloc.JobID
+	require.NoError(t, s1.State().UpsertEvals(
+		structs.MsgTypeTestSetup, idx+1, []*structs.Evaluation{eval}))
+
+	plan := &structs.Plan{
+		Job:    alloc.Job,
+		EvalID: eval.ID,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+	}
+
+	future, err := s1.planQueue.Enqueue(plan)
+	require.NoError(t, err)
+	return future
+}
+
+// TestPlanApply_Coalesce_DisjointPlansShareIndex asserts that when
+// Config.PlanCoalesceMaxPlans is set, two plans targeting different nodes
+// that are enqueued back to back commit at the same Raft index, i.e. they
+// were applied as a single coalesced transaction rather than two.
+func TestPlanApply_Coalesce_DisjointPlansShareIndex(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanCoalesceMaxPlans = 4
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	node1 := mock.Node()
+	testRegisterNode(t, s1, node1)
+	node2 := mock.Node()
+	testRegisterNode(t, s1, node2)
+
+	// Pause the queue while both plans are enqueued so the worker dequeues
+	// them together instead of racing the first one through before the
+	// second is ready to be coalesced with it.
+	s1.planQueue.Pause()
+	future1 := submitSimplePlan(t, s1, node1, 1000)
+	future2 := submitSimplePlan(t, s1, node2, 1002)
+	s1.planQueue.Resume()
+
+	result1, err := future1.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result1)
+
+	result2, err := future2.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result2)
+
+	require.Equal(t, result1.AllocIndex, result2.AllocIndex,
+		"expected disjoint plans to coalesce into a single Raft apply")
+}
+
+// TestPlanApply_Coalesce_OverlappingPlansApplySeparately asserts that two
+// plans which both touch the same node are never coalesced into a single
+// apply, even when PlanCoalesceMaxPlans is set: the second plan's conflict
+// with the first forces it to fall back to an individual application, so
+// each commits at its own Raft index.
+func TestPlanApply_Coalesce_OverlappingPlansApplySeparately(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, func(c *Config) {
+		c.PlanCoalesceMaxPlans = 4
+	})
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	node := mock.Node()
+	testRegisterNode(t, s1, node)
+
+	s1.planQueue.Pause()
+	future1 := submitSimplePlan(t, s1, node, 1000)
+	future2 := submitSimplePlan(t, s1, node, 1002)
+	s1.planQueue.Resume()
+
+	result1, err := future1.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result1)
+
+	result2, err := future2.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result2)
+
+	require.NotEqual(t, result1.AllocIndex, result2.AllocIndex,
+		"expected plans touching the same node to apply individually, not coalesce")
+}
+
+// TestPlanApply_DryRun asserts that a plan submitted with DryRun set is
+// fully evaluated (feasibility decisions reflected in the result) but never
+// reaches Raft: the returned AllocIndex is 0 and the allocation it would
+// have placed is never written to the state store.
+func TestPlanApply_DryRun(t *testing.T) {
+	t.Parallel()
+
+	s1, cleanupS1 := TestServer(t, nil)
+	defer cleanupS1()
+	testutil.WaitForLeader(t, s1.RPC)
+
+	node := mock.Node()
+	testRegisterNode(t, s1, node)
+
+	alloc := mock.Alloc()
+	alloc.NodeID = node.ID
+	require.NoError(t, s1.State().UpsertJobSummary(1000, mock.JobSummary(alloc.JobID)))
+
+	eval := mock.Eval()
+	eval.JobID = alloc.JobID
+	require.NoError(t, s1.State().UpsertEvals(
+		structs.MsgTypeTestSetup, 1001, []*structs.Evaluation{eval}))
+
+	plan := &structs.Plan{
+		Job:    alloc.Job,
+		EvalID: eval.ID,
+		NodeAllocation: map[string][]*structs.Allocation{
+			node.ID: {alloc},
+		},
+		DryRun: true,
+	}
+
+	future, err := s1.planQueue.Enqueue(plan)
+	require.NoError(t, err)
+
+	result, err := future.Wait()
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	require.Contains(t, result.NodeAllocation, node.ID)
+	require.Zero(t, result.AllocIndex, "dry-run plan should never commit to Raft")
+
+	got, err := s1.State().AllocByID(nil, alloc.ID)
+	require.NoError(t, err)
+	require.Nil(t, got, "dry-run plan should not write its allocation to the state store")
+}
+
+// BenchmarkPlanApply_Coalesce measures the end-to-end enqueue-to-commit cost
+// of applying numPlans disjoint plans, with and without coalescing enabled,
+// to quantify the Raft-apply savings coalescing is meant to provide.
+func BenchmarkPlanApply_Coalesce(b *testing.B) {
+	const numPlans = 8
+
+	run := func(b *testing.B, coalesce bool) {
+		s1, cleanupS1 := TestServer(b, func(c *Config) {
+			if coalesce {
+				c.PlanCoalesceMaxPlans = numPlans
+			}
+		})
+		defer cleanupS1()
+		testutil.WaitForLeader(b, s1.RPC)
+
+		nodes := make([]*structs.Node, numPlans)
+		for i := range nodes {
+			nodes[i] = mock.Node()
+			require.NoError(b, s1.State().UpsertNode(structs.MsgTypeTestSetup, uint64(1000+i), nodes[i]))
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s1.planQueue.Pause()
+			futures := make([]PlanFuture, numPlans)
+			for j, node := range nodes {
+				futures[j] = submitSimplePlan(b, s1, node, uint64(2000+i*numPlans*2+j*2))
+			}
+			s1.planQueue.Resume()
+
+			for _, future := range futures {
+				if _, err := future.Wait(); err != nil {
+					b.Fatalf("err: %v", err)
+				}
+			}
+		}
+	}
+
+	b.Run("uncoalesced", func(b *testing.B) { run(b, false) })
+	b.Run("coalesced", func(b *testing.B) { run(b, true) })
+}
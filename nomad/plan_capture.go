@@ -0,0 +1,73 @@
+package nomad
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// PlanCaptureFormat selects the serialization used by CapturePlan and
+// DecodePlanCapture.
+type PlanCaptureFormat string
+
+const (
+	// PlanCaptureFormatMsgpack is the default, compact format used for
+	// normal plan replay.
+	PlanCaptureFormatMsgpack PlanCaptureFormat = "msgpack"
+
+	// PlanCaptureFormatJSON trades size for readability, so that a captured
+	// plan can be attached to a support ticket and inspected without
+	// tooling.
+	PlanCaptureFormatJSON PlanCaptureFormat = "json"
+)
+
+// PlanCapture bundles a plan with the result of applying it, so the pair
+// can be replayed or inspected together.
+type PlanCapture struct {
+	Plan   *structs.Plan
+	Result *structs.PlanResult
+}
+
+// CapturePlan serializes a plan and its result in the given format, for
+// later replay via DecodePlanCapture.
+func CapturePlan(format PlanCaptureFormat, plan *structs.Plan, result *structs.PlanResult) ([]byte, error) {
+	handle, err := planCaptureHandle(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	capture := &PlanCapture{Plan: plan, Result: result}
+	if err := codec.NewEncoder(&buf, handle).Encode(capture); err != nil {
+		return nil, fmt.Errorf("failed to encode plan capture: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodePlanCapture reverses CapturePlan, returning the plan and result as
+// captured.
+func DecodePlanCapture(format PlanCaptureFormat, data []byte) (*PlanCapture, error) {
+	handle, err := planCaptureHandle(format)
+	if err != nil {
+		return nil, err
+	}
+
+	capture := &PlanCapture{}
+	if err := codec.NewDecoder(bytes.NewReader(data), handle).Decode(capture); err != nil {
+		return nil, fmt.Errorf("failed to decode plan capture: %v", err)
+	}
+	return capture, nil
+}
+
+func planCaptureHandle(format PlanCaptureFormat) (codec.Handle, error) {
+	switch format {
+	case PlanCaptureFormatJSON:
+		return structs.JsonHandle, nil
+	case PlanCaptureFormatMsgpack, "":
+		return structs.MsgpackHandle, nil
+	default:
+		return nil, fmt.Errorf("unknown plan capture format: %q", format)
+	}
+}
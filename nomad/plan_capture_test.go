@@ -0,0 +1,49 @@
+package nomad
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCapture_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	plan := &structs.Plan{
+		Job:            alloc.Job,
+		NodeAllocation: map[string][]*structs.Allocation{"node1": {alloc}},
+		NodeScoring:    structs.SchedulerAlgorithmBinpack,
+	}
+	result := &structs.PlanResult{
+		NodeAllocation: map[string][]*structs.Allocation{"node1": {alloc}},
+		RefreshIndex:   100,
+		AllocIndex:     101,
+	}
+
+	formats := []PlanCaptureFormat{PlanCaptureFormatMsgpack, PlanCaptureFormatJSON}
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := CapturePlan(format, plan, result)
+			require.NoError(t, err)
+			require.NotEmpty(t, data)
+
+			capture, err := DecodePlanCapture(format, data)
+			require.NoError(t, err)
+			require.Equal(t, plan, capture.Plan)
+			require.Equal(t, result, capture.Result)
+		})
+	}
+}
+
+func TestPlanCapture_UnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := CapturePlan("xml", &structs.Plan{}, &structs.PlanResult{})
+	require.Error(t, err)
+
+	_, err = DecodePlanCapture("xml", []byte("whatever"))
+	require.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/nomad/helper/uuid"
 	"github.com/hashicorp/nomad/nomad/structs"
 )
 
@@ -14,8 +15,25 @@ var (
 	// planQueueFlushed is the error used for all pending plans
 	// when the queue is flushed or disabled
 	planQueueFlushed = fmt.Errorf("plan queue flushed")
+
+	// errPlanQueuePaused is returned by Enqueue once a paused queue has
+	// reached planQueuePauseThreshold. It's marked recoverable so
+	// callers, such as schedulers submitting plans via RPC, know to retry
+	// rather than treat the plan as rejected.
+	errPlanQueuePaused = fmt.Errorf("plan apply paused")
+
+	// errPlanDeferredCanceled is returned to the waiter of a deferred plan
+	// that was canceled via CancelDeferred before its ApplyAfter time
+	// arrived.
+	errPlanDeferredCanceled = fmt.Errorf("deferred plan canceled")
 )
 
+// planQueuePauseThreshold is how many plans a paused queue will continue
+// to accept before it starts rejecting new submissions. It bounds memory
+// growth during a maintenance pause without requiring operators to predict
+// exactly how long the pause will last.
+const planQueuePauseThreshold = 100
+
 // PlanFuture is used to return a future for an enqueue
 type PlanFuture interface {
 	Wait() (*structs.PlanResult, error)
@@ -28,25 +46,114 @@ type PlanFuture interface {
 // may be partially applied if allowed, or completely rejected (gang commit).
 type PlanQueue struct {
 	enabled bool
-	stats   *QueueStats
+
+	// paused is true while plan application has been temporarily
+	// suspended via Pause, without disabling the queue. Dequeue stops
+	// handing out work while paused, but Enqueue keeps accepting plans
+	// until the queue reaches planQueuePauseThreshold.
+	paused bool
+
+	stats *QueueStats
 
 	ready  PendingPlans
 	waitCh chan struct{}
 
+	// deferred holds plans submitted with a future ApplyAfter, keyed by
+	// CorrelationID, that haven't yet been moved into ready. A per-plan
+	// timer moves each one into ready once its ApplyAfter time arrives.
+	deferred map[string]*pendingPlan
+
 	l sync.RWMutex
+
+	subscribers     map[chan *PlanQueueEvent]struct{}
+	subscribersLock sync.Mutex
 }
 
 // NewPlanQueue is used to construct and return a new plan queue
 func NewPlanQueue() (*PlanQueue, error) {
 	q := &PlanQueue{
-		enabled: false,
-		stats:   new(QueueStats),
-		ready:   make([]*pendingPlan, 0, 16),
-		waitCh:  make(chan struct{}, 1),
+		enabled:     false,
+		stats:       new(QueueStats),
+		ready:       make([]*pendingPlan, 0, 16),
+		waitCh:      make(chan struct{}, 1),
+		deferred:    make(map[string]*pendingPlan),
+		subscribers: make(map[chan *PlanQueueEvent]struct{}),
 	}
 	return q, nil
 }
 
+// PlanQueueEventType enumerates the plan lifecycle transitions that are
+// published to PlanQueue subscribers.
+type PlanQueueEventType string
+
+const (
+	// PlanQueueEventEnqueued is emitted when a plan is added to the queue.
+	PlanQueueEventEnqueued PlanQueueEventType = "enqueued"
+	// PlanQueueEventEvaluating is emitted when a plan is dequeued by the
+	// apply loop and handed to evaluatePlan.
+	PlanQueueEventEvaluating PlanQueueEventType = "evaluating"
+	// PlanQueueEventApplied is emitted once a plan has been successfully
+	// evaluated and (if it had work to do) committed via Raft.
+	PlanQueueEventApplied PlanQueueEventType = "applied"
+	// PlanQueueEventRejected is emitted when a plan fails evaluation or
+	// apply.
+	PlanQueueEventRejected PlanQueueEventType = "rejected"
+)
+
+// PlanQueueEvent describes a single plan lifecycle transition, for use by
+// external observers such as real-time scheduling dashboards.
+type PlanQueueEvent struct {
+	Type   PlanQueueEventType
+	EvalID string
+	Time   time.Time
+}
+
+// Subscribe registers a new observer for plan queue lifecycle events and
+// returns a channel of events along with a func to unsubscribe. The channel
+// is buffered; if a subscriber falls behind, events are dropped for that
+// subscriber so a slow consumer can never block the apply loop. Callers
+// must call the returned unsubscribe func when they're done listening.
+func (q *PlanQueue) Subscribe() (<-chan *PlanQueueEvent, func()) {
+	ch := make(chan *PlanQueueEvent, 64)
+
+	q.subscribersLock.Lock()
+	q.subscribers[ch] = struct{}{}
+	q.subscribersLock.Unlock()
+
+	unsubscribeOnce := sync.Once{}
+	unsubscribe := func() {
+		unsubscribeOnce.Do(func() {
+			q.subscribersLock.Lock()
+			defer q.subscribersLock.Unlock()
+			if _, ok := q.subscribers[ch]; ok {
+				delete(q.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe
+}
+
+// emit publishes an event to all current subscribers. A subscriber whose
+// channel is full has the event dropped rather than blocking the caller,
+// which is always the apply loop or one of its callers.
+func (q *PlanQueue) emit(eventType PlanQueueEventType, evalID string) {
+	q.subscribersLock.Lock()
+	defer q.subscribersLock.Unlock()
+
+	if len(q.subscribers) == 0 {
+		return
+	}
+
+	event := &PlanQueueEvent{Type: eventType, EvalID: evalID, Time: time.Now()}
+	for ch := range q.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
 // pendingPlan is used to wrap a plan that is enqueued
 // so that we can re-use it as a future.
 type pendingPlan struct {
@@ -54,6 +161,10 @@ type pendingPlan struct {
 	enqueueTime time.Time
 	result      *structs.PlanResult
 	errCh       chan error
+
+	// deferredTimer fires releaseDeferred once the plan's ApplyAfter time
+	// arrives. It's nil for plans that weren't deferred.
+	deferredTimer *time.Timer
 }
 
 // Wait is used to block for the plan result or potential error
@@ -91,6 +202,37 @@ func (q *PlanQueue) SetEnabled(enabled bool) {
 	}
 }
 
+// Pause stops Dequeue from handing out new work, while leaving the queue
+// enabled and its contents intact, so pending plans stay queued rather
+// than being flushed with an error. It's meant for brief maintenance
+// windows where stepping down leadership would be overkill.
+func (q *PlanQueue) Pause() {
+	q.l.Lock()
+	q.paused = true
+	q.l.Unlock()
+}
+
+// Resume allows Dequeue to resume handing out work after a prior Pause,
+// and wakes any worker already blocked in Dequeue so it notices the
+// change immediately rather than waiting for the next Enqueue.
+func (q *PlanQueue) Resume() {
+	q.l.Lock()
+	q.paused = false
+	q.l.Unlock()
+
+	select {
+	case q.waitCh <- struct{}{}:
+	default:
+	}
+}
+
+// Paused reports whether the queue is currently paused.
+func (q *PlanQueue) Paused() bool {
+	q.l.RLock()
+	defer q.l.RUnlock()
+	return q.paused
+}
+
 // Enqueue is used to enqueue a plan
 func (q *PlanQueue) Enqueue(plan *structs.Plan) (PlanFuture, error) {
 	q.l.Lock()
@@ -101,6 +243,20 @@ func (q *PlanQueue) Enqueue(plan *structs.Plan) (PlanFuture, error) {
 		return nil, fmt.Errorf("plan queue is disabled")
 	}
 
+	// While paused, keep accepting plans up to a threshold so a brief
+	// pause doesn't start failing submissions, but reject beyond it so
+	// the queue can't grow without bound for the duration of the pause.
+	if q.paused && q.stats.Depth >= planQueuePauseThreshold {
+		return nil, structs.NewRecoverableError(errPlanQueuePaused, true)
+	}
+
+	// Generate a correlation ID if the submitter didn't already set one,
+	// so every plan can be traced through the queue even if the scheduler
+	// that submitted it doesn't care to set its own.
+	if plan.CorrelationID == "" {
+		plan.CorrelationID = uuid.Generate()
+	}
+
 	// Wrap the pending plan
 	pending := &pendingPlan{
 		plan:        plan,
@@ -108,6 +264,19 @@ func (q *PlanQueue) Enqueue(plan *structs.Plan) (PlanFuture, error) {
 		errCh:       make(chan error, 1),
 	}
 
+	// A plan with a future ApplyAfter is held rather than made available
+	// to Dequeue; a timer moves it into the ready heap once it's due.
+	if delay := time.Until(plan.ApplyAfter); !plan.ApplyAfter.IsZero() && delay > 0 {
+		correlationID := plan.CorrelationID
+		pending.deferredTimer = time.AfterFunc(delay, func() {
+			q.releaseDeferred(correlationID)
+		})
+		q.deferred[correlationID] = pending
+
+		q.emit(PlanQueueEventEnqueued, plan.EvalID)
+		return pending, nil
+	}
+
 	// Push onto the heap
 	heap.Push(&q.ready, pending)
 
@@ -119,9 +288,118 @@ func (q *PlanQueue) Enqueue(plan *structs.Plan) (PlanFuture, error) {
 	case q.waitCh <- struct{}{}:
 	default:
 	}
+
+	q.emit(PlanQueueEventEnqueued, plan.EvalID)
+	return pending, nil
+}
+
+// releaseDeferred moves a deferred plan whose ApplyAfter time has arrived
+// out of q.deferred and into the ready heap so Dequeue can hand it out. It's
+// a no-op if the plan was already canceled or released.
+func (q *PlanQueue) releaseDeferred(correlationID string) {
+	q.l.Lock()
+	pending, ok := q.deferred[correlationID]
+	if !ok {
+		q.l.Unlock()
+		return
+	}
+	delete(q.deferred, correlationID)
+
+	heap.Push(&q.ready, pending)
+	q.stats.Depth += 1
+	q.l.Unlock()
+
+	select {
+	case q.waitCh <- struct{}{}:
+	default:
+	}
+}
+
+// CancelDeferred cancels a plan that was enqueued with a future ApplyAfter
+// and hasn't yet become ready, identified by its CorrelationID. The plan's
+// waiter receives errPlanDeferredCanceled. It returns false if no matching
+// deferred plan was found, for example because it already became ready or
+// was never deferred in the first place.
+func (q *PlanQueue) CancelDeferred(correlationID string) bool {
+	q.l.Lock()
+	pending, ok := q.deferred[correlationID]
+	if !ok {
+		q.l.Unlock()
+		return false
+	}
+	delete(q.deferred, correlationID)
+	q.l.Unlock()
+
+	pending.deferredTimer.Stop()
+	pending.respond(nil, errPlanDeferredCanceled)
+	return true
+}
+
+// TryDequeue is a non-blocking dequeue: it returns the next ready plan if
+// one is immediately available, or (nil, nil) if the queue is empty or
+// paused. It's used by the plan applier to opportunistically drain
+// additional already-pending plans for coalescing without waiting for more
+// to arrive.
+func (q *PlanQueue) TryDequeue() (*pendingPlan, error) {
+	q.l.Lock()
+
+	if !q.enabled {
+		q.l.Unlock()
+		return nil, fmt.Errorf("plan queue is disabled")
+	}
+
+	if q.paused || len(q.ready) == 0 {
+		q.l.Unlock()
+		return nil, nil
+	}
+
+	raw := heap.Pop(&q.ready)
+	pending := raw.(*pendingPlan)
+	q.stats.Depth -= 1
+	depth := q.stats.Depth
+	q.l.Unlock()
+	recordDequeueMetrics(pending, depth)
+	q.emit(PlanQueueEventEvaluating, pending.plan.EvalID)
 	return pending, nil
 }
 
+// Requeue returns a previously dequeued plan to the ready heap so another
+// Dequeue/TryDequeue can hand it out again, for a caller that pulled it via
+// TryDequeue for opportunistic coalescing but then found it couldn't
+// actually take on the plan right now. pending's original enqueueTime and
+// errCh are preserved, so its waiter is unaffected and queue-wait metrics
+// still reflect the time since it first arrived. If the queue was disabled
+// or flushed out from under the caller in the meantime, pending is resolved
+// with planQueueFlushed instead of being requeued, so its waiter doesn't
+// block forever on a queue that will never hand it out again.
+func (q *PlanQueue) Requeue(pending *pendingPlan) {
+	q.l.Lock()
+
+	if !q.enabled {
+		q.l.Unlock()
+		pending.respond(nil, planQueueFlushed)
+		return
+	}
+
+	heap.Push(&q.ready, pending)
+	q.stats.Depth += 1
+	q.l.Unlock()
+
+	select {
+	case q.waitCh <- struct{}{}:
+	default:
+	}
+}
+
+// recordDequeueMetrics emits the "nomad.plan.queue_wait" timing metric for
+// how long pending sat in the queue before being handed to a caller, and
+// refreshes the "nomad.plan.queue_depth" gauge to depth so it reflects the
+// queue immediately rather than waiting for EmitStats' next periodic tick.
+func recordDequeueMetrics(pending *pendingPlan, depth int) {
+	metrics.MeasureSince([]string{"nomad", "plan", "queue_wait"}, pending.enqueueTime)
+	metrics.SetGauge([]string{"nomad", "plan", "queue_depth"}, float32(depth))
+}
+
 // Dequeue is used to perform a blocking dequeue
 func (q *PlanQueue) Dequeue(timeout time.Duration) (*pendingPlan, error) {
 SCAN:
@@ -133,12 +411,16 @@ SCAN:
 		return nil, fmt.Errorf("plan queue is disabled")
 	}
 
-	// Look for available work
-	if len(q.ready) > 0 {
+	// Look for available work. While paused, leave any ready plans in
+	// place rather than handing them out.
+	if !q.paused && len(q.ready) > 0 {
 		raw := heap.Pop(&q.ready)
 		pending := raw.(*pendingPlan)
 		q.stats.Depth -= 1
+		depth := q.stats.Depth
 		q.l.Unlock()
+		recordDequeueMetrics(pending, depth)
+		q.emit(PlanQueueEventEvaluating, pending.plan.EvalID)
 		return pending, nil
 	}
 	q.l.Unlock()
@@ -169,6 +451,11 @@ func (q *PlanQueue) Flush() {
 	for _, pending := range q.ready {
 		pending.respond(nil, planQueueFlushed)
 	}
+	for correlationID, pending := range q.deferred {
+		pending.deferredTimer.Stop()
+		pending.respond(nil, planQueueFlushed)
+		delete(q.deferred, correlationID)
+	}
 
 	// Reset the broker
 	q.stats.Depth = 0
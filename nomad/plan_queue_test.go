@@ -4,8 +4,10 @@ import (
 	"testing"
 	"time"
 
+	gometrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/nomad/nomad/mock"
 	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/stretchr/testify/require"
 )
 
 func testPlanQueue(t *testing.T) *PlanQueue {
@@ -135,6 +137,64 @@ func TestPlanQueue_Dequeue_Timeout(t *testing.T) {
 	}
 }
 
+func TestPlanQueue_Pause_Resume(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	plan := mock.Plan()
+	if _, err := pq.Enqueue(plan); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pq.Pause()
+	if !pq.Paused() {
+		t.Fatalf("should be paused")
+	}
+
+	// Dequeue should not hand out the already-queued plan while paused.
+	out, err := pq.Dequeue(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("unexpected: %#v", out)
+	}
+	if stats := pq.Stats(); stats.Depth != 1 {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	// Submissions continue to be accepted up to the pause threshold.
+	for i := 0; i < planQueuePauseThreshold-1; i++ {
+		if _, err := pq.Enqueue(mock.Plan()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The next submission pushes the queue past the threshold and
+	// should be rejected with a retryable error.
+	_, err = pq.Enqueue(mock.Plan())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if !structs.IsRecoverable(err) {
+		t.Fatalf("expected a recoverable error, got: %v", err)
+	}
+
+	pq.Resume()
+	if pq.Paused() {
+		t.Fatalf("should not be paused")
+	}
+
+	pending, err := pq.Dequeue(time.Second)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pending == nil || pending.plan != plan {
+		t.Fatalf("bad: %#v", pending)
+	}
+}
+
 // Ensure higher priority dequeued first
 func TestPlanQueue_Dequeue_Priority(t *testing.T) {
 	t.Parallel()
@@ -169,6 +229,71 @@ func TestPlanQueue_Dequeue_Priority(t *testing.T) {
 	}
 }
 
+// Ensure a subscriber observes the expected event sequence for a single
+// plan's lifecycle: enqueued, evaluating, and finally applied or rejected.
+func TestPlanQueue_Subscribe_EventSequence(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	events, unsubscribe := pq.Subscribe()
+	defer unsubscribe()
+
+	plan := mock.Plan()
+	if _, err := pq.Enqueue(plan); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pending, err := pq.Dequeue(time.Second)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the apply loop itself emits the terminal event via planner.respond; here
+	// we simulate a successful apply to exercise the full sequence.
+	pq.emit(PlanQueueEventApplied, pending.plan.EvalID)
+
+	wantSequence := []PlanQueueEventType{
+		PlanQueueEventEnqueued, PlanQueueEventEvaluating, PlanQueueEventApplied,
+	}
+	for i, want := range wantSequence {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Fatalf("event %d: expected %s, got %s", i, want, event.Type)
+			}
+			if event.EvalID != plan.EvalID {
+				t.Fatalf("event %d: expected eval id %s, got %s", i, plan.EvalID, event.EvalID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event %d (%s)", i, want)
+		}
+	}
+}
+
+// Ensure an unsubscribed and a full subscriber channel never block emit.
+func TestPlanQueue_Subscribe_SlowConsumerDropsEvents(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	events, unsubscribe := pq.Subscribe()
+
+	// fill the subscriber's buffer without ever draining it
+	for i := 0; i < 100; i++ {
+		pq.emit(PlanQueueEventEnqueued, "eval")
+	}
+
+	unsubscribe()
+
+	// further emits after unsubscribe must not panic or block
+	pq.emit(PlanQueueEventEnqueued, "eval")
+
+	if _, ok := <-events; !ok {
+		// channel closed by unsubscribe, as expected
+	}
+}
+
 // Ensure FIFO at fixed priority
 func TestPlanQueue_Dequeue_FIFO(t *testing.T) {
 	t.Parallel()
@@ -196,3 +321,118 @@ func TestPlanQueue_Dequeue_FIFO(t *testing.T) {
 		prev = out
 	}
 }
+
+func TestPlanQueue_Enqueue_GeneratesCorrelationID(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	plan := mock.Plan()
+	require.Empty(t, plan.CorrelationID)
+
+	_, err := pq.Enqueue(plan)
+	require.NoError(t, err)
+	require.NotEmpty(t, plan.CorrelationID, "Enqueue should generate a correlation ID when none is set")
+
+	// a submitter-provided correlation ID is preserved rather than
+	// overwritten
+	other := mock.Plan()
+	other.CorrelationID = "my-correlation-id"
+	_, err = pq.Enqueue(other)
+	require.NoError(t, err)
+	require.Equal(t, "my-correlation-id", other.CorrelationID)
+}
+
+func TestPlanQueue_Enqueue_Deferred(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	plan := mock.Plan()
+	plan.ApplyAfter = time.Now().Add(50 * time.Millisecond)
+
+	future, err := pq.Enqueue(plan)
+	require.NoError(t, err)
+
+	// the plan isn't available to Dequeue before its ApplyAfter time
+	pending, err := pq.Dequeue(10 * time.Millisecond)
+	require.NoError(t, err)
+	require.Nil(t, pending, "deferred plan was dequeued before its ApplyAfter time")
+
+	// ...but becomes available once that time arrives
+	pending, err = pq.Dequeue(time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	require.Equal(t, plan, pending.plan)
+
+	result := mock.PlanResult()
+	pending.respond(result, nil)
+
+	res, err := future.Wait()
+	require.NoError(t, err)
+	require.Equal(t, result, res)
+}
+
+func TestPlanQueue_CancelDeferred(t *testing.T) {
+	t.Parallel()
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	plan := mock.Plan()
+	plan.ApplyAfter = time.Now().Add(time.Hour)
+
+	future, err := pq.Enqueue(plan)
+	require.NoError(t, err)
+
+	require.True(t, pq.CancelDeferred(plan.CorrelationID))
+
+	_, err = future.Wait()
+	require.EqualError(t, err, "deferred plan canceled")
+
+	// canceling again, or canceling a plan that was never deferred, reports
+	// that there was nothing to cancel
+	require.False(t, pq.CancelDeferred(plan.CorrelationID))
+	require.False(t, pq.CancelDeferred("unknown-correlation-id"))
+
+	// the canceled plan never becomes available to Dequeue
+	pending, err := pq.Dequeue(10 * time.Millisecond)
+	require.NoError(t, err)
+	require.Nil(t, pending)
+}
+
+// TestPlanQueue_Dequeue_EmitsQueueMetrics asserts that Dequeue emits a
+// nomad.plan.queue_wait timing sample reflecting how long each plan waited,
+// and a nomad.plan.queue_depth gauge reflecting the queue's depth
+// immediately after each plan is removed.
+func TestPlanQueue_Dequeue_EmitsQueueMetrics(t *testing.T) {
+	sink := gometrics.NewInmemSink(10*time.Second, time.Minute)
+	_, err := gometrics.NewGlobal(&gometrics.Config{FilterDefault: true}, sink)
+	require.NoError(t, err)
+	defer gometrics.NewGlobal(gometrics.DefaultConfig("plan_queue_test"), &gometrics.BlackholeSink{})
+
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+
+	_, err = pq.Enqueue(mock.Plan())
+	require.NoError(t, err)
+	_, err = pq.Enqueue(mock.Plan())
+	require.NoError(t, err)
+	require.EqualValues(t, 2, pq.Stats().Depth)
+
+	_, err = pq.Dequeue(0)
+	require.NoError(t, err)
+	_, err = pq.Dequeue(0)
+	require.NoError(t, err)
+
+	require.Len(t, sink.Data(), 1)
+	samples := sink.Data()[0].Samples
+	waitSample, ok := samples["nomad.plan.queue_wait"]
+	require.True(t, ok, "expected a nomad.plan.queue_wait sample")
+	require.EqualValues(t, 2, waitSample.Count)
+	require.GreaterOrEqual(t, waitSample.Sum, float64(0))
+
+	gauges := sink.Data()[0].Gauges
+	depthGauge, ok := gauges["nomad.plan.queue_depth"]
+	require.True(t, ok, "expected a nomad.plan.queue_depth gauge")
+	require.EqualValues(t, 0, depthGauge.Value, "queue should be empty after both plans are dequeued")
+}
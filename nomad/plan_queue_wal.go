@@ -0,0 +1,137 @@
+package nomad
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+// planWALDir is the subdirectory of the server's data dir where in-flight
+// plan WAL entries are stored.
+const planWALDir = "plan_wal"
+
+// planWALEntry records that a plan has been dequeued from the plan queue
+// and handed to the apply pipeline, but hasn't yet reached a terminal
+// outcome (applied or rejected). If the leader crashes while an entry is
+// present on disk, the eval it names was abandoned mid-apply rather than
+// explicitly failed, so a new leader should treat it as lost work instead
+// of assuming it completed.
+type planWALEntry struct {
+	EvalID string
+}
+
+// planWAL is an optional write-ahead log of dequeued-but-unapplied plans,
+// kept so a server that becomes leader after a crash can detect plans that
+// were in flight when the previous leader died. It subscribes to the
+// PlanQueue's own lifecycle events rather than being wired into Dequeue
+// directly, so attaching or detaching it never changes the apply loop.
+type planWAL struct {
+	dir    string
+	logger log.Logger
+
+	l sync.Mutex
+}
+
+// newPlanWAL creates a planWAL rooted at dataDir/plan_wal, creating the
+// directory if it doesn't already exist.
+func newPlanWAL(dataDir string, logger log.Logger) (*planWAL, error) {
+	dir := filepath.Join(dataDir, planWALDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create plan WAL directory: %w", err)
+	}
+	return &planWAL{dir: dir, logger: logger.Named("plan_wal")}, nil
+}
+
+func (w *planWAL) path(evalID string) string {
+	return filepath.Join(w.dir, evalID+".json")
+}
+
+// record durably marks evalID's plan as dequeued but not yet applied.
+// Failures are logged rather than returned, since the WAL is a best-effort
+// durability enhancement and must never block the apply loop.
+func (w *planWAL) record(evalID string) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	buf, err := json.Marshal(&planWALEntry{EvalID: evalID})
+	if err != nil {
+		w.logger.Warn("failed to marshal plan WAL entry", "eval_id", evalID, "error", err)
+		return
+	}
+	if err := os.WriteFile(w.path(evalID), buf, 0600); err != nil {
+		w.logger.Warn("failed to write plan WAL entry", "eval_id", evalID, "error", err)
+	}
+}
+
+// remove clears evalID's WAL entry once its plan reaches a terminal outcome.
+func (w *planWAL) remove(evalID string) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	if err := os.Remove(w.path(evalID)); err != nil && !os.IsNotExist(err) {
+		w.logger.Warn("failed to remove plan WAL entry", "eval_id", evalID, "error", err)
+	}
+}
+
+// Recover returns the eval IDs left over from a previous leader's WAL --
+// plans that were dequeued but never reached a terminal outcome before the
+// process exited -- and clears their entries so a later Recover call
+// doesn't report them again.
+func (w *planWAL) Recover() ([]string, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan WAL directory: %w", err)
+	}
+
+	var evalIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(w.dir, entry.Name())
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			w.logger.Warn("failed to read plan WAL entry", "path", path, "error", err)
+			continue
+		}
+		var walEntry planWALEntry
+		if err := json.Unmarshal(buf, &walEntry); err != nil {
+			w.logger.Warn("failed to decode plan WAL entry", "path", path, "error", err)
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			w.logger.Warn("failed to remove recovered plan WAL entry", "path", path, "error", err)
+		}
+		evalIDs = append(evalIDs, walEntry.EvalID)
+	}
+
+	return evalIDs, nil
+}
+
+// attach subscribes the WAL to q's lifecycle events, recording each plan
+// when it's dequeued for evaluation and clearing the record once it
+// reaches a terminal outcome. The returned unsubscribe func stops the WAL
+// from observing further events; it does not remove any entries already
+// recorded, since those still need to be seen by a future Recover call.
+func (w *planWAL) attach(q *PlanQueue) func() {
+	events, unsubscribe := q.Subscribe()
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case PlanQueueEventEvaluating:
+				w.record(event.EvalID)
+			case PlanQueueEventApplied, PlanQueueEventRejected:
+				w.remove(event.EvalID)
+			}
+		}
+	}()
+	return unsubscribe
+}
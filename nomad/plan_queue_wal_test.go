@@ -0,0 +1,117 @@
+package nomad
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/helper/testlog"
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPlanWAL_RecordAndRemove exercises the ordinary lifecycle: a plan
+// recorded on dequeue is cleared once it reaches a terminal outcome, so a
+// clean leader never accumulates WAL entries.
+func TestPlanWAL_RecordAndRemove(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	wal, err := newPlanWAL(dir, testlog.HCLogger(t))
+	require.NoError(t, err)
+
+	evalID := mock.Plan().EvalID
+	wal.record(evalID)
+
+	entries, err := newPlanWAL(dir, testlog.HCLogger(t))
+	require.NoError(t, err)
+	recovered, err := entries.Recover()
+	require.NoError(t, err)
+	require.Equal(t, []string{evalID}, recovered)
+
+	// Recover drains what it finds, so a second recovery against a clean
+	// WAL (the normal case, after the plan reaches a terminal outcome)
+	// reports nothing.
+	wal.record(evalID)
+	wal.remove(evalID)
+	recovered, err = wal.Recover()
+	require.NoError(t, err)
+	require.Empty(t, recovered)
+}
+
+// TestPlanWAL_AttachRecordsAndClearsViaQueueEvents exercises the WAL as
+// it's actually wired up in newPlanner: attached to a PlanQueue's
+// lifecycle events rather than called directly.
+func TestPlanWAL_AttachRecordsAndClearsViaQueueEvents(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	wal, err := newPlanWAL(dir, testlog.HCLogger(t))
+	require.NoError(t, err)
+
+	pq := testPlanQueue(t)
+	pq.SetEnabled(true)
+	unsubscribe := wal.attach(pq)
+	defer unsubscribe()
+
+	plan := mock.Plan()
+	_, err = pq.Enqueue(plan)
+	require.NoError(t, err)
+
+	pending, err := pq.Dequeue(0)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		recovered, err := wal.Recover()
+		require.NoError(t, err)
+		if len(recovered) == 0 {
+			return false
+		}
+		require.Equal(t, []string{pending.plan.EvalID}, recovered)
+		// Recover already drained the entry; restore it so the next
+		// assertion (the normal apply path) has something to clear.
+		wal.record(pending.plan.EvalID)
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	pq.emit(PlanQueueEventApplied, pending.plan.EvalID)
+
+	require.Eventually(t, func() bool {
+		recovered, err := wal.Recover()
+		require.NoError(t, err)
+		return len(recovered) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestPlanWAL_RecoversPlanAbandonedMidApply simulates a leader crash
+// between dequeue and apply: the WAL entry written on dequeue is never
+// cleared because the process exits before a terminal event is emitted. A
+// fresh planWAL opened against the same directory, standing in for the new
+// leader, must recover the abandoned eval ID.
+func TestPlanWAL_RecoversPlanAbandonedMidApply(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	logger := testlog.HCLogger(t)
+
+	crashedLeaderWAL, err := newPlanWAL(dir, logger)
+	require.NoError(t, err)
+
+	evalID := mock.Plan().EvalID
+	crashedLeaderWAL.record(evalID)
+	// no PlanQueueEventApplied/Rejected is ever emitted: the process dies
+	// here, leaving the entry on disk.
+
+	newLeaderWAL, err := newPlanWAL(dir, logger)
+	require.NoError(t, err)
+
+	recovered, err := newLeaderWAL.Recover()
+	require.NoError(t, err)
+	require.Equal(t, []string{evalID}, recovered)
+
+	// recovery is one-shot: the entry is gone once reported, so a second
+	// crash detection pass (e.g. a subsequent leadership transition)
+	// doesn't keep re-reporting the same abandoned plan.
+	recovered, err = newLeaderWAL.Recover()
+	require.NoError(t, err)
+	require.Empty(t, recovered)
+}
@@ -713,6 +713,13 @@ func (r *rpcHandler) streamingRpcImpl(conn net.Conn, method string) (net.Conn, e
 
 // raftApplyFuture is used to encode a message, run it through raft, and return the Raft future.
 func (s *Server) raftApplyFuture(t structs.MessageType, msg interface{}) (raft.ApplyFuture, error) {
+	return s.raftApplyFutureWithEnqueueTimeout(t, msg, enqueueLimit)
+}
+
+// raftApplyFutureWithEnqueueTimeout is raftApplyFuture with a caller-supplied
+// enqueue timeout, so that a caller like applyPlan can honor a per-request
+// write-consistency hint instead of always waiting up to enqueueLimit.
+func (s *Server) raftApplyFutureWithEnqueueTimeout(t structs.MessageType, msg interface{}, enqueueTimeout time.Duration) (raft.ApplyFuture, error) {
 	buf, err := structs.Encode(t, msg)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to encode request: %v", err)
@@ -723,7 +730,7 @@ func (s *Server) raftApplyFuture(t structs.MessageType, msg interface{}) (raft.A
 		s.logger.Warn("attempting to apply large raft entry", "raft_type", t, "bytes", n)
 	}
 
-	future := s.raft.Apply(buf, enqueueLimit)
+	future := s.raft.Apply(buf, enqueueTimeout)
 	return future, nil
 }
 
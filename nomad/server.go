@@ -188,6 +188,11 @@ type Server struct {
 	// capacity changes.
 	blockedEvals *BlockedEvals
 
+	// csiVolumeFailures records recent CSI controller attach/detach
+	// failures, tagged by node and plugin, so the scheduler can query them
+	// to bias placement away from combinations that have been failing.
+	csiVolumeFailures *CSIVolumeFailureTracker
+
 	// deploymentWatcher is used to watch deployments and their allocations and
 	// make the required calls to continue to transition the deployment.
 	deploymentWatcher *deploymentwatcher.Watcher
@@ -325,23 +330,24 @@ func NewServer(config *Config, consulCatalog consul.CatalogAPI, consulConfigEntr
 
 	// Create the server
 	s := &Server{
-		config:           config,
-		consulCatalog:    consulCatalog,
-		connPool:         pool.NewPool(logger, serverRPCCache, serverMaxStreams, tlsWrap),
-		logger:           logger,
-		tlsWrap:          tlsWrap,
-		rpcServer:        rpc.NewServer(),
-		streamingRpcs:    structs.NewStreamingRpcRegistry(),
-		nodeConns:        make(map[string][]*nodeConnState),
-		peers:            make(map[string][]*serverParts),
-		localPeers:       make(map[raft.ServerAddress]*serverParts),
-		reassertLeaderCh: make(chan chan error),
-		reconcileCh:      make(chan serf.Member, 32),
-		eventCh:          make(chan serf.Event, 256),
-		evalBroker:       evalBroker,
-		blockedEvals:     NewBlockedEvals(evalBroker, logger),
-		rpcTLS:           incomingTLS,
-		aclCache:         aclCache,
+		config:            config,
+		consulCatalog:     consulCatalog,
+		connPool:          pool.NewPool(logger, serverRPCCache, serverMaxStreams, tlsWrap),
+		logger:            logger,
+		tlsWrap:           tlsWrap,
+		rpcServer:         rpc.NewServer(),
+		streamingRpcs:     structs.NewStreamingRpcRegistry(),
+		nodeConns:         make(map[string][]*nodeConnState),
+		peers:             make(map[string][]*serverParts),
+		localPeers:        make(map[raft.ServerAddress]*serverParts),
+		reassertLeaderCh:  make(chan chan error),
+		reconcileCh:       make(chan serf.Member, 32),
+		eventCh:           make(chan serf.Event, 256),
+		evalBroker:        evalBroker,
+		blockedEvals:      NewBlockedEvals(evalBroker, logger),
+		csiVolumeFailures: NewCSIVolumeFailureTracker(),
+		rpcTLS:            incomingTLS,
+		aclCache:          aclCache,
 	}
 
 	s.shutdownCtx, s.shutdownCancel = context.WithCancel(context.Background())
@@ -452,6 +458,9 @@ func NewServer(config *Config, consulCatalog consul.CatalogAPI, consulConfigEntr
 	// Emit metrics for the Vault client.
 	go s.vault.EmitStats(time.Second, s.shutdownCh)
 
+	// Emit metrics for the plan apply rate.
+	go s.applyRate.EmitStats(time.Second, s.shutdownCh)
+
 	// Emit metrics
 	go s.heartbeatStats()
 
@@ -1516,6 +1525,13 @@ func (s *Server) State() *state.StateStore {
 	return s.fsm.State()
 }
 
+// CSIVolumeFailures returns the server's tracker of recent CSI controller
+// attach/detach failures, which the scheduler can query to bias placement
+// away from node/plugin combinations that have recently failed.
+func (s *Server) CSIVolumeFailures() *CSIVolumeFailureTracker {
+	return s.csiVolumeFailures
+}
+
 // setLeaderAcl stores the given ACL token as the current leader's ACL token.
 func (s *Server) setLeaderAcl(token string) {
 	s.leaderAclLock.Lock()
@@ -1584,11 +1600,12 @@ func (s *Server) Stats() map[string]map[string]string {
 	}
 	stats := map[string]map[string]string{
 		"nomad": {
-			"server":        "true",
-			"leader":        fmt.Sprintf("%v", s.IsLeader()),
-			"leader_addr":   string(s.raft.Leader()),
-			"bootstrap":     fmt.Sprintf("%v", s.isSingleServerCluster()),
-			"known_regions": toString(uint64(len(s.peers))),
+			"server":          "true",
+			"leader":          fmt.Sprintf("%v", s.IsLeader()),
+			"leader_addr":     string(s.raft.Leader()),
+			"bootstrap":       fmt.Sprintf("%v", s.isSingleServerCluster()),
+			"known_regions":   toString(uint64(len(s.peers))),
+			"plan_apply_rate": fmt.Sprintf("%.2f", s.applyRate.Rate()),
 		},
 		"raft":    s.raft.Stats(),
 		"serf":    s.serf.Stats(),
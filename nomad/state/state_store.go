@@ -337,6 +337,10 @@ func (s *StateStore) UpsertPlanResults(msgType structs.MessageType, index uint64
 		return err
 	}
 
+	if err := checkPlanSnapshotConflict(results.SnapshotIndex, allocsStopped, allocsPreempted); err != nil {
+		return err
+	}
+
 	txn := s.db.WriteTxnMsgT(msgType, index)
 	defer txn.Abort()
 
@@ -404,6 +408,30 @@ func (s *StateStore) UpsertPlanResults(msgType structs.MessageType, index uint64
 	return txn.Commit()
 }
 
+// checkPlanSnapshotConflict returns an error wrapping structs.ErrPlanStale if
+// any allocation the plan assumes it can stop or preempt was modified by a
+// different, later Raft entry after the snapshot the plan was evaluated
+// against. Without this, two plans racing to apply could both believe the
+// same allocation is still eligible for eviction or preemption, and the
+// loser would silently clobber the winner's decision. A zero snapshotIndex
+// skips the check, for plans applied before it was tracked.
+func checkPlanSnapshotConflict(snapshotIndex uint64, allocLists ...[]*structs.Allocation) error {
+	if snapshotIndex == 0 {
+		return nil
+	}
+
+	for _, allocs := range allocLists {
+		for _, alloc := range allocs {
+			if alloc.ModifyIndex > snapshotIndex {
+				return fmt.Errorf("%w: alloc %q modified at index %d after plan snapshot index %d",
+					structs.ErrPlanStale, alloc.ID, alloc.ModifyIndex, snapshotIndex)
+			}
+		}
+	}
+
+	return nil
+}
+
 // addComputedAllocAttrs adds the computed/derived attributes to the allocation.
 // This method is used when an allocation is being denormalized.
 func addComputedAllocAttrs(allocs []*structs.Allocation, job *structs.Job) {
@@ -6035,6 +6063,9 @@ func (s *StateSnapshot) DenormalizeAllocationDiffSlice(allocDiffs []*structs.All
 			if allocDiff.FollowupEvalID != "" {
 				allocCopy.FollowupEvalID = allocDiff.FollowupEvalID
 			}
+			if allocDiff.MigrationHint != "" {
+				allocCopy.MigrationHint = allocDiff.MigrationHint
+			}
 		}
 		if allocDiff.ModifyTime != 0 {
 			allocCopy.ModifyTime = allocDiff.ModifyTime
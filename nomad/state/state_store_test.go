@@ -2,6 +2,7 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
@@ -246,6 +247,53 @@ func TestStateStore_UpsertPlanResults_AllocationsDenormalized(t *testing.T) {
 
 }
 
+// TestStateStore_UpsertPlanResults_SnapshotConflict asserts that a plan is
+// rejected if an allocation it wants to stop was modified by a different,
+// later Raft entry after the plan's snapshot index, simulating a race
+// between two plans that both believed the allocation was still eligible for
+// eviction.
+func TestStateStore_UpsertPlanResults_SnapshotConflict(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	state := testStateStore(t)
+	alloc := mock.Alloc()
+	job := alloc.Job
+
+	require.NoError(state.UpsertJob(structs.MsgTypeTestSetup, 900, job))
+	require.NoError(state.UpsertAllocs(structs.MsgTypeTestSetup, 1000, []*structs.Allocation{alloc}))
+
+	// The plan was evaluated against the snapshot at index 1000. Before it
+	// applies, an intervening client update reports the allocation complete.
+	planSnapshotIndex := uint64(1000)
+	updated := alloc.Copy()
+	updated.ClientStatus = structs.AllocClientStatusComplete
+	require.NoError(state.UpdateAllocsFromClient(structs.MsgTypeTestSetup, 1001, []*structs.Allocation{updated}))
+
+	stoppedAllocDiff := &structs.AllocationDiff{
+		ID:                 alloc.ID,
+		DesiredDescription: "plan wants to stop this alloc",
+	}
+	res := structs.ApplyPlanResultsRequest{
+		AllocUpdateRequest: structs.AllocUpdateRequest{
+			AllocsStopped: []*structs.AllocationDiff{stoppedAllocDiff},
+			Job:           job,
+		},
+		SnapshotIndex: planSnapshotIndex,
+	}
+
+	err := state.UpsertPlanResults(structs.MsgTypeTestSetup, 1002, &res)
+	require.Error(err)
+	require.True(errors.Is(err, structs.ErrPlanStale))
+
+	// The intervening update must not have been clobbered by the rejected
+	// plan.
+	out, err := state.AllocByID(nil, alloc.ID)
+	require.NoError(err)
+	require.Equal(structs.AllocClientStatusComplete, out.ClientStatus)
+	require.Equal(structs.AllocDesiredStatusRun, out.DesiredStatus)
+}
+
 // This test checks that the deployment is created and allocations count towards
 // the deployment
 func TestStateStore_UpsertPlanResults_Deployment(t *testing.T) {
@@ -2,6 +2,7 @@ package structs
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -98,6 +99,44 @@ func ValidCSIVolumeAttachmentMode(attachmentMode CSIVolumeAttachmentMode) bool {
 	}
 }
 
+// CSIVolumeType records the intended type of storage a volume provides,
+// independent of the AttachmentMode a claim requests it with. It's recorded
+// at registration/creation so that a mismatched attachment mode can be
+// rejected before it ever reaches the plugin.
+type CSIVolumeType string
+
+const (
+	CSIVolumeTypeUnknown    CSIVolumeType = ""
+	CSIVolumeTypeBlock      CSIVolumeType = "block"
+	CSIVolumeTypeFilesystem CSIVolumeType = "filesystem"
+)
+
+func ValidCSIVolumeType(volType CSIVolumeType) bool {
+	switch volType {
+	case CSIVolumeTypeUnknown, CSIVolumeTypeBlock, CSIVolumeTypeFilesystem:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidateAttachmentMode returns an error if attachmentMode contradicts
+// volType. An unknown volType is not validated, which preserves the
+// pre-existing behavior for volumes that don't record an intended type.
+func ValidateAttachmentMode(volType CSIVolumeType, attachmentMode CSIVolumeAttachmentMode) error {
+	switch volType {
+	case CSIVolumeTypeBlock:
+		if attachmentMode != CSIVolumeAttachmentModeBlockDevice {
+			return fmt.Errorf("attachment mode %q does not match volume type %q", attachmentMode, volType)
+		}
+	case CSIVolumeTypeFilesystem:
+		if attachmentMode != CSIVolumeAttachmentModeFilesystem {
+			return fmt.Errorf("attachment mode %q does not match volume type %q", attachmentMode, volType)
+		}
+	}
+	return nil
+}
+
 // CSIVolumeAccessMode indicates how a volume should be used in a storage topology
 // e.g whether the provider should make the volume available concurrently.
 type CSIVolumeAccessMode string
@@ -138,6 +177,19 @@ func ValidCSIVolumeWriteAccessMode(accessMode CSIVolumeAccessMode) bool {
 	}
 }
 
+const (
+	// CSIFSFormatPolicyAuto allows the node plugin to format an empty
+	// volume with the requested filesystem during staging. This is the
+	// default, and preserves the plugin's existing behavior.
+	CSIFSFormatPolicyAuto = "auto"
+
+	// CSIFSFormatPolicyNever requires the volume to already have a
+	// filesystem at staging time, so that a volume which unexpectedly
+	// has no filesystem fails the attach rather than being silently
+	// formatted.
+	CSIFSFormatPolicyNever = "never"
+)
+
 // CSIMountOptions contain optional additional configuration that can be used
 // when specifying that a Volume should be used with VolumeAccessTypeMount.
 type CSIMountOptions struct {
@@ -149,6 +201,11 @@ type CSIMountOptions struct {
 	// volume by the plugin. This may contain sensitive data and should not be
 	// leaked.
 	MountFlags []string
+
+	// FSFormatPolicy controls whether the node plugin may format an
+	// unformatted volume during staging. Valid values are
+	// CSIFSFormatPolicyAuto (the default) and CSIFSFormatPolicyNever.
+	FSFormatPolicy string
 }
 
 func (o *CSIMountOptions) Copy() *CSIMountOptions {
@@ -171,6 +228,20 @@ func (o *CSIMountOptions) Merge(p *CSIMountOptions) {
 	if p.MountFlags != nil {
 		o.MountFlags = p.MountFlags
 	}
+	if p.FSFormatPolicy != "" {
+		o.FSFormatPolicy = p.FSFormatPolicy
+	}
+}
+
+// ValidateFSFormatPolicy returns an error if the policy is set to a value
+// other than CSIFSFormatPolicyAuto or CSIFSFormatPolicyNever.
+func (o *CSIMountOptions) ValidateFSFormatPolicy() error {
+	switch o.FSFormatPolicy {
+	case "", CSIFSFormatPolicyAuto, CSIFSFormatPolicyNever:
+		return nil
+	default:
+		return fmt.Errorf("invalid fs_format_policy: %q", o.FSFormatPolicy)
+	}
 }
 
 // CSIMountOptions implements the Stringer and GoStringer interfaces to prevent
@@ -212,12 +283,62 @@ func (s *CSISecrets) GoString() string {
 	return s.String()
 }
 
+// CSIEncryptionContext carries per-attach encryption parameters supplied by
+// the caller of a volume claim, such as a key ID or wrapped data key. Like
+// CSISecrets, its contents may be sensitive and must not be leaked via logs.
+type CSIEncryptionContext map[string]string
+
+// CSIEncryptionContext implements the Stringer and GoStringer interfaces to
+// prevent accidental leakage of its values via logs.
+var _ fmt.Stringer = &CSIEncryptionContext{}
+var _ fmt.GoStringer = &CSIEncryptionContext{}
+
+func (c *CSIEncryptionContext) String() string {
+	redacted := map[string]string{}
+	for k := range *c {
+		redacted[k] = "[REDACTED]"
+	}
+	return fmt.Sprintf("csi.CSIEncryptionContext(%v)", redacted)
+}
+
+func (c *CSIEncryptionContext) GoString() string {
+	return c.String()
+}
+
+// ValidateEncryptionContext checks that every key required by a plugin's
+// controller capability is present in the EncryptionContext and non-empty.
+// It's a no-op when the plugin's capability doesn't require one.
+func (c CSIEncryptionContext) ValidateEncryptionContext(plugin *CSIPlugin) error {
+	if plugin == nil || !plugin.RequiresEncryptionContext {
+		return nil
+	}
+
+	var missing []string
+	for _, key := range plugin.RequiredEncryptionContextKeys {
+		if c[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required encryption context keys: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 type CSIVolumeClaim struct {
 	AllocationID   string
 	NodeID         string
 	ExternalNodeID string
 	Mode           CSIVolumeClaimMode
 	State          CSIVolumeClaimState
+
+	// RequireUnmounted, if set, makes the controller detach conditional on
+	// the node reporting that the volume is no longer mounted. This guards
+	// against detaching out from under a workload that's still writing to
+	// the volume, at the cost of an extra node RPC on every unpublish. The
+	// default (false) preserves the existing behavior of detaching as soon
+	// as the claim's last allocation is released.
+	RequireUnmounted bool
 }
 
 type CSIVolumeClaimState int
@@ -242,10 +363,14 @@ type CSIVolume struct {
 	Topologies     []*CSITopology
 	AccessMode     CSIVolumeAccessMode
 	AttachmentMode CSIVolumeAttachmentMode
-	MountOptions   *CSIMountOptions
-	Secrets        CSISecrets
-	Parameters     map[string]string
-	Context        map[string]string
+	// VolumeType records the volume's intended type (block or filesystem),
+	// so that a registration or attach with a contradictory AttachmentMode
+	// can be rejected. Unset (CSIVolumeTypeUnknown) skips this check.
+	VolumeType   CSIVolumeType
+	MountOptions *CSIMountOptions
+	Secrets      CSISecrets
+	Parameters   map[string]string
+	Context      map[string]string
 
 	// Allocations, tracking claim status
 	ReadAllocs  map[string]*Allocation // AllocID -> Allocation
@@ -580,6 +705,9 @@ func (v *CSIVolume) Validate() error {
 	if v.AttachmentMode == "" {
 		errs = append(errs, "missing attachment mode")
 	}
+	if err := ValidateAttachmentMode(v.VolumeType, v.AttachmentMode); err != nil {
+		errs = append(errs, err.Error())
+	}
 	if v.AttachmentMode == CSIVolumeAttachmentModeBlockDevice {
 		if v.MountOptions != nil {
 			if v.MountOptions.FSType != "" {
@@ -588,6 +716,14 @@ func (v *CSIVolume) Validate() error {
 			if v.MountOptions.MountFlags != nil && len(v.MountOptions.MountFlags) != 0 {
 				errs = append(errs, "mount options not allowed for block-device")
 			}
+			if v.MountOptions.FSFormatPolicy != "" {
+				errs = append(errs, "mount options not allowed for block-device")
+			}
+		}
+	}
+	if v.MountOptions != nil {
+		if err := v.MountOptions.ValidateFSFormatPolicy(); err != nil {
+			errs = append(errs, err.Error())
 		}
 	}
 
@@ -620,6 +756,29 @@ type CSIVolumeRegisterResponse struct {
 	QueryMeta
 }
 
+// CSIVolumeValidateRequest bulk-validates a set of volumes, such as the
+// volumes a job requests before the job is allowed to start running. Each
+// volume is validated independently so that one invalid volume doesn't
+// prevent the rest of the batch from being checked.
+type CSIVolumeValidateRequest struct {
+	Volumes []*CSIVolume
+	WriteRequest
+}
+
+// CSIVolumeValidateResponse carries one CSIVolumeValidationResult per volume
+// in the matching CSIVolumeValidateRequest, in the same order.
+type CSIVolumeValidateResponse struct {
+	Results []*CSIVolumeValidationResult
+	QueryMeta
+}
+
+// CSIVolumeValidationResult is the outcome of validating a single volume as
+// part of a CSIVolumeValidateRequest.
+type CSIVolumeValidationResult struct {
+	VolumeID string
+	Error    string
+}
+
 type CSIVolumeDeregisterRequest struct {
 	VolumeIDs []string
 	Force     bool
@@ -646,6 +805,33 @@ type CSIVolumeClaimBatchRequest struct {
 	Claims []CSIVolumeClaimRequest
 }
 
+// CSIVolumeMultiNodePublishRequest requests that a single
+// MultiNodeReaderOnly volume be published, read-only, to every node in
+// NodeIDs in one call. This is the one-volume, many-node counterpart to
+// CSIVolumeClaimBatchRequest, which claims many volumes onto a single
+// node.
+type CSIVolumeMultiNodePublishRequest struct {
+	VolumeID string
+	NodeIDs  []string
+
+	// Deadline is the absolute wall-clock time by which every node's
+	// attach must complete. A zero value means each attach is bounded
+	// only by the plugin RPC's own timeout.
+	Deadline time.Time
+
+	WriteRequest
+}
+
+// CSIVolumeMultiNodePublishResult is the per-node outcome of a
+// CSIVolumeMultiNodePublishRequest. Each node succeeds or fails
+// independently, so one unreachable node doesn't prevent the volume from
+// being published to the rest.
+type CSIVolumeMultiNodePublishResult struct {
+	NodeID         string
+	PublishContext map[string]string
+	Err            error
+}
+
 type CSIVolumeClaimRequest struct {
 	VolumeID       string
 	AllocationID   string
@@ -653,6 +839,33 @@ type CSIVolumeClaimRequest struct {
 	ExternalNodeID string
 	Claim          CSIVolumeClaimMode
 	State          CSIVolumeClaimState
+
+	// EncryptionContext carries per-attach encryption parameters required
+	// by some backends, such as a key ID or wrapped data key. It's
+	// forwarded into the controller publish request's volume context and
+	// validated against the plugin's RequiredEncryptionContextKeys.
+	EncryptionContext CSIEncryptionContext
+
+	// AttachContext carries non-secret, per-attach context overrides, for
+	// values that are known only at claim time rather than at volume
+	// registration. It's merged over the volume's registered Context, with
+	// these values taking precedence on key conflict.
+	AttachContext map[string]string
+
+	// Deadline is the absolute wall-clock time by which the attach must
+	// complete, as computed by the scheduler from the job's configured
+	// volume wait time. A zero value means the attach is bounded only by
+	// the plugin RPC's own timeout.
+	Deadline time.Time
+
+	// WaitForNodePlugin, when true, makes the controller publish wait for
+	// the target node's node plugin to be registered and healthy before
+	// sending the attach, instead of failing immediately. This covers the
+	// case where a node plugin is mid-restart: the controller attach would
+	// succeed but the subsequent node publish would fail anyway, so it's
+	// cheaper to wait here than to retry the whole claim.
+	WaitForNodePlugin bool
+
 	WriteRequest
 }
 
@@ -682,10 +895,27 @@ type CSIVolumeClaimResponse struct {
 	// `NodeStageVolume` or `NodePublishVolume` calls on the client
 	PublishContext map[string]string
 
+	// DevicePath is the host device path the volume was published to. It's
+	// only populated when the volume's AttachmentMode is
+	// CSIVolumeAttachmentModeBlockDevice, so that block-mode callers don't
+	// need to know the plugin-specific PublishContext key to find it.
+	DevicePath string
+
+	// DevicePaths lists every host device path the volume was published
+	// over, for multipath-aware block plugins backed by HA storage. It's
+	// only populated when the plugin returns a multipath publish context
+	// and the volume's AttachmentMode is CSIVolumeAttachmentModeBlockDevice.
+	DevicePaths []string
+
 	// Volume contains the expanded CSIVolume for use on the client after a Claim
 	// has completed.
 	Volume *CSIVolume
 
+	// TraceID can be used to correlate this request with Nomad's server
+	// logs and the CSI plugin's own logs for the attach operation it
+	// triggered.
+	TraceID string
+
 	QueryMeta
 }
 
@@ -717,9 +947,43 @@ type CSIVolumeUnpublishRequest struct {
 }
 
 type CSIVolumeUnpublishResponse struct {
+	// TraceID can be used to correlate this request with Nomad's server
+	// logs and the CSI plugin's own logs for the detach operation it
+	// triggered.
+	TraceID string
+
+	QueryMeta
+}
+
+// CSIVolumeDetachNodeRequest unpublishes every volume currently published to
+// a node, such as when evacuating a node that's failed and isn't coming
+// back. Unlike CSIVolumeUnpublishRequest, the caller doesn't need to know
+// which volumes are published to the node; they're discovered from state.
+type CSIVolumeDetachNodeRequest struct {
+	NodeID string
+
+	// PluginID, if set, restricts detachment to volumes served by this
+	// plugin, leaving any other volumes published to the node untouched.
+	PluginID string
+
+	WriteRequest
+}
+
+// CSIVolumeDetachNodeResponse carries one CSIVolumeDetachNodeResult per
+// volume that was published to the node, so that a volume that fails to
+// detach doesn't prevent the rest from being reported and retried.
+type CSIVolumeDetachNodeResponse struct {
+	Results []*CSIVolumeDetachNodeResult
 	QueryMeta
 }
 
+// CSIVolumeDetachNodeResult is the outcome of detaching a single volume as
+// part of a CSIVolumeDetachNodeRequest.
+type CSIVolumeDetachNodeResult struct {
+	VolumeID string
+	Error    string
+}
+
 // CSIPlugin collects fingerprint info context for the plugin for clients
 type CSIPlugin struct {
 	ID                 string
@@ -727,6 +991,13 @@ type CSIPlugin struct {
 	Version            string // the vendor verson from  CSI GetPluginInfoResponse
 	ControllerRequired bool
 
+	// RequiresEncryptionContext and RequiredEncryptionContextKeys mirror
+	// the controller's fingerprinted capability, so that a claim against
+	// this plugin can be validated without looking up a specific
+	// controller instance.
+	RequiresEncryptionContext     bool
+	RequiredEncryptionContextKeys []string
+
 	// Map Node.IDs to fingerprint results, split by type. Monolith type plugins have
 	// both sets of fingerprinting results.
 	Controllers map[string]*CSIInfo
@@ -772,6 +1043,7 @@ func (p *CSIPlugin) Copy() *CSIPlugin {
 	copy := *p
 	out := &copy
 	out.newStructs()
+	out.RequiredEncryptionContextKeys = helper.CopySliceString(p.RequiredEncryptionContextKeys)
 
 	for k, v := range p.Controllers {
 		out.Controllers[k] = v.Copy()
@@ -799,6 +1071,8 @@ func (p *CSIPlugin) AddPlugin(nodeID string, info *CSIInfo) error {
 		p.ControllerRequired = info.RequiresControllerPlugin &&
 			(info.ControllerInfo.SupportsAttachDetach ||
 				info.ControllerInfo.SupportsReadOnlyAttach)
+		p.RequiresEncryptionContext = info.ControllerInfo.RequiresEncryptionContext
+		p.RequiredEncryptionContextKeys = info.ControllerInfo.RequiredEncryptionContextKeys
 
 		prev, ok := p.Controllers[nodeID]
 		if ok {
@@ -987,6 +1261,47 @@ func (p *CSIPlugin) UpdateExpectedWithJob(job *Job, summary *JobSummary, termina
 	p.NodesExpected = p.NodeJobs.Count()
 }
 
+// CSIPluginVersionCount records the number of controller and node instances
+// of a plugin running a particular vendor version.
+type CSIPluginVersionCount struct {
+	Version     string
+	Controllers int
+	Nodes       int
+}
+
+// VersionSkew aggregates the ProviderVersion fingerprinted for each
+// controller and node instance of the plugin into a count per distinct
+// version, so an operator rolling out a plugin upgrade can see how much of
+// the fleet has and hasn't picked up the new version. The result is sorted
+// by Version for stable output.
+func (p *CSIPlugin) VersionSkew() []*CSIPluginVersionCount {
+	counts := map[string]*CSIPluginVersionCount{}
+
+	get := func(version string) *CSIPluginVersionCount {
+		c, ok := counts[version]
+		if !ok {
+			c = &CSIPluginVersionCount{Version: version}
+			counts[version] = c
+		}
+		return c
+	}
+
+	for _, info := range p.Controllers {
+		get(info.ProviderVersion).Controllers++
+	}
+	for _, info := range p.Nodes {
+		get(info.ProviderVersion).Nodes++
+	}
+
+	out := make([]*CSIPluginVersionCount, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+
+	return out
+}
+
 // JobDescription records Job identification and the count of expected plugin instances
 type JobDescription struct {
 	Namespace string
@@ -129,6 +129,82 @@ func TestVolume_Copy(t *testing.T) {
 
 }
 
+func TestCSIMountOptions_FSFormatPolicy(t *testing.T) {
+	cases := []struct {
+		name      string
+		policy    string
+		expectErr bool
+	}{
+		{"empty defaults to auto", "", false},
+		{"auto is valid", CSIFSFormatPolicyAuto, false},
+		{"never is valid", CSIFSFormatPolicyNever, false},
+		{"unknown policy is invalid", "sometimes", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := &CSIMountOptions{FSFormatPolicy: c.policy}
+			err := opts.ValidateFSFormatPolicy()
+			if c.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	o := &CSIMountOptions{FSFormatPolicy: CSIFSFormatPolicyAuto}
+	o.Merge(&CSIMountOptions{FSFormatPolicy: CSIFSFormatPolicyNever})
+	require.Equal(t, CSIFSFormatPolicyNever, o.FSFormatPolicy)
+
+	vol := NewCSIVolume("vol1", 0)
+	vol.PluginID = "plugin1"
+	vol.Namespace = "default"
+	vol.AccessMode = CSIVolumeAccessModeSingleNodeWriter
+	vol.AttachmentMode = CSIVolumeAttachmentModeBlockDevice
+	vol.VolumeType = CSIVolumeTypeBlock
+	vol.MountOptions = &CSIMountOptions{FSFormatPolicy: CSIFSFormatPolicyNever}
+	require.Error(t, vol.Validate())
+}
+
+func TestCSIVolume_ValidateAttachmentMode(t *testing.T) {
+	cases := []struct {
+		name           string
+		volType        CSIVolumeType
+		attachmentMode CSIVolumeAttachmentMode
+		expectErr      bool
+	}{
+		{"unknown type allows block", CSIVolumeTypeUnknown, CSIVolumeAttachmentModeBlockDevice, false},
+		{"unknown type allows filesystem", CSIVolumeTypeUnknown, CSIVolumeAttachmentModeFilesystem, false},
+		{"block type matches block attachment", CSIVolumeTypeBlock, CSIVolumeAttachmentModeBlockDevice, false},
+		{"block type rejects filesystem attachment", CSIVolumeTypeBlock, CSIVolumeAttachmentModeFilesystem, true},
+		{"filesystem type matches filesystem attachment", CSIVolumeTypeFilesystem, CSIVolumeAttachmentModeFilesystem, false},
+		{"filesystem type rejects block attachment", CSIVolumeTypeFilesystem, CSIVolumeAttachmentModeBlockDevice, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateAttachmentMode(c.volType, c.attachmentMode)
+			if c.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	vol := NewCSIVolume("vol1", 0)
+	vol.PluginID = "plugin1"
+	vol.Namespace = "default"
+	vol.AccessMode = CSIVolumeAccessModeSingleNodeWriter
+	vol.AttachmentMode = CSIVolumeAttachmentModeFilesystem
+	vol.VolumeType = CSIVolumeTypeBlock
+	require.Error(t, vol.Validate())
+
+	vol.VolumeType = CSIVolumeTypeFilesystem
+	require.NoError(t, vol.Validate())
+}
+
 func TestCSIPluginJobs(t *testing.T) {
 	plug := NewCSIPlugin("foo", 1000)
 	controller := &Job{
@@ -214,6 +290,45 @@ func TestCSIPluginCleanup(t *testing.T) {
 	require.Equal(t, 0, len(plug.Nodes))
 }
 
+func TestCSIPlugin_VersionSkew(t *testing.T) {
+	plug := NewCSIPlugin("foo", 1000)
+
+	plug.AddPlugin("n0", &CSIInfo{
+		PluginID:        "foo",
+		AllocID:         "a0",
+		Healthy:         true,
+		ProviderVersion: "1.0.0",
+		ControllerInfo:  &CSIControllerInfo{},
+	})
+	plug.AddPlugin("n1", &CSIInfo{
+		PluginID:        "foo",
+		AllocID:         "a1",
+		Healthy:         true,
+		ProviderVersion: "1.1.0",
+		ControllerInfo:  &CSIControllerInfo{},
+	})
+	plug.AddPlugin("n0", &CSIInfo{
+		PluginID:        "foo",
+		AllocID:         "a0",
+		Healthy:         true,
+		ProviderVersion: "1.0.0",
+		NodeInfo:        &CSINodeInfo{},
+	})
+	plug.AddPlugin("n2", &CSIInfo{
+		PluginID:        "foo",
+		AllocID:         "a2",
+		Healthy:         true,
+		ProviderVersion: "1.0.0",
+		NodeInfo:        &CSINodeInfo{},
+	})
+
+	skew := plug.VersionSkew()
+	require.Equal(t, []*CSIPluginVersionCount{
+		{Version: "1.0.0", Controllers: 1, Nodes: 2},
+		{Version: "1.1.0", Controllers: 1, Nodes: 0},
+	}, skew)
+}
+
 func TestDeleteNodeForType_Controller(t *testing.T) {
 	info := &CSIInfo{
 		PluginID:                 "foo",
@@ -64,8 +64,22 @@ var (
 	ErrDeploymentTerminalNoSetHealth = errors.New(errDeploymentTerminalNoSetHealth)
 	ErrDeploymentRunningNoUnblock    = errors.New(errDeploymentRunningNoUnblock)
 
-	ErrCSIClientRPCIgnorable = errors.New("CSI client error (ignorable)")
-	ErrCSIClientRPCRetryable = errors.New("CSI client error (retryable)")
+	ErrCSIClientRPCIgnorable   = errors.New("CSI client error (ignorable)")
+	ErrCSIClientRPCRetryable   = errors.New("CSI client error (retryable)")
+	ErrCSIClientRPCUnsupported = errors.New("CSI client error (unsupported)")
+
+	// The plan apply error taxonomy below lets a scheduler branch on
+	// errors.Is instead of matching strings, so it can tell a plan worth
+	// retrying immediately (ErrPlanStale) apart from one that needs a
+	// fresh evaluation (ErrPlanRejected), a leadership change (ErrNotLeader),
+	// a backoff (ErrPlanTimeout), or giving up entirely (ErrPlanInvalid).
+	// Plan evaluation and apply errors are wrapped with one of these via
+	// %w so the original message is preserved alongside it.
+	ErrPlanStale    = errors.New("plan stale")
+	ErrPlanRejected = errors.New("plan rejected")
+	ErrNotLeader    = errors.New("plan apply failed: not leader")
+	ErrPlanTimeout  = errors.New("plan apply timed out")
+	ErrPlanInvalid  = errors.New("plan invalid")
 )
 
 // IsErrNoLeader returns whether the error is due to there being no leader.
@@ -142,6 +142,124 @@ func AllocsFit(node *Node, allocs []*Allocation, netIdx *NetworkIndex, checkDevi
 	return true, "", used, nil
 }
 
+// AllocExceedsNodeCapacity reports whether alloc's own resource
+// requirement exceeds node's total capacity, ignoring reserved resources
+// and any other allocation on the node. An allocation that fails this
+// check can never be placed on node no matter what else is evicted from
+// it or how its reserved resources change, so it's pointless to keep
+// retrying the placement.
+func AllocExceedsNodeCapacity(node *Node, alloc *Allocation) (bool, string) {
+	used := alloc.ComparableResources()
+	total := node.ComparableResources()
+	if superset, dimension := total.Superset(used); !superset {
+		return true, dimension
+	}
+	return false, ""
+}
+
+// NodeCapacityCache precomputes a node's available capacity and the
+// resource aggregate of a fixed set of existing allocations exactly once.
+// AllocsFit then extends that aggregate by a candidate set of additional
+// allocations rather than re-summing existing from scratch, and Evict lets
+// a caller retract one of the existing allocations and re-check fit without
+// rebuilding the cache, which is what preemption's evict-and-recheck loop
+// uses to avoid resumming the node's remaining allocations on every
+// candidate it considers.
+type NodeCapacityCache struct {
+	node      *Node
+	available *ComparableResources
+	existing  []*Allocation
+	base      *ComparableResources
+}
+
+// NewNodeCapacityCache precomputes node's available capacity (after
+// reserved resources) and the resource aggregate of existingAlloc, mirroring
+// the bookkeeping AllocsFit does for its allocs argument.
+func NewNodeCapacityCache(node *Node, existingAlloc []*Allocation) *NodeCapacityCache {
+	base := new(ComparableResources)
+	for _, alloc := range existingAlloc {
+		if alloc.TerminalStatus() {
+			continue
+		}
+		base.Add(alloc.ComparableResources())
+	}
+
+	available := node.ComparableResources()
+	available.Subtract(node.ComparableReservedResources())
+
+	return &NodeCapacityCache{
+		node:      node,
+		available: available,
+		existing:  append([]*Allocation{}, existingAlloc...),
+		base:      base,
+	}
+}
+
+// Evict removes alloc from the cache's existing-allocation set, subtracting
+// its resources from the cached aggregate instead of resumming the
+// remaining allocations from scratch. This lets a caller that's trying
+// successive what-if removals, such as preemption's evict-and-recheck
+// loop, reuse a single cache across the whole loop rather than rebuilding
+// one for every candidate it considers.
+func (c *NodeCapacityCache) Evict(alloc *Allocation) {
+	if alloc.TerminalStatus() {
+		return
+	}
+	c.base.Subtract(alloc.ComparableResources())
+	for i, existing := range c.existing {
+		if existing.ID == alloc.ID {
+			c.existing = append(c.existing[:i], c.existing[i+1:]...)
+			break
+		}
+	}
+}
+
+// AllocsFit checks whether the cached existing allocations plus additional
+// fit on the node, extending the cached resource aggregate by additional's
+// resources instead of re-summing the existing allocations. Given the same
+// node and existingAlloc used to build the cache, this returns results
+// identical to calling AllocsFit with append(existingAlloc, additional...).
+func (c *NodeCapacityCache) AllocsFit(additional []*Allocation, netIdx *NetworkIndex, checkDevices bool) (bool, string, *ComparableResources, error) {
+	used := c.base.Copy()
+	for _, alloc := range additional {
+		if alloc.TerminalStatus() {
+			continue
+		}
+		used.Add(alloc.ComparableResources())
+	}
+
+	// Check that the node resources (after subtracting reserved) are a
+	// super set of those that are being allocated
+	if superset, dimension := c.available.Superset(used); !superset {
+		return false, dimension, used, nil
+	}
+
+	// Create the network index if missing
+	if netIdx == nil {
+		netIdx = NewNetworkIndex()
+		defer netIdx.Release()
+		if netIdx.SetNode(c.node) || netIdx.AddAllocs(c.existing) || netIdx.AddAllocs(additional) {
+			return false, "reserved port collision", used, nil
+		}
+	}
+
+	// Check if the network is overcommitted
+	if netIdx.Overcommitted() {
+		return false, "bandwidth exceeded", used, nil
+	}
+
+	// Check devices
+	if checkDevices {
+		accounter := NewDeviceAccounter(c.node)
+		if accounter.AddAllocs(c.existing) || accounter.AddAllocs(additional) {
+			return false, "device oversubscribed", used, nil
+		}
+	}
+
+	// Allocations fit!
+	return true, "", used, nil
+}
+
 func computeFreePercentage(node *Node, util *ComparableResources) (freePctCpu, freePctRam float64) {
 	// COMPAT(0.11): Remove in 0.11
 	reserved := node.ComparableReservedResources()
@@ -371,6 +371,73 @@ func TestAllocsFit(t *testing.T) {
 	require.EqualValues(2048, used.Flattened.Memory.MemoryMB)
 }
 
+func TestAllocExceedsNodeCapacity(t *testing.T) {
+	require := require.New(t)
+
+	n := &Node{
+		NodeResources: &NodeResources{
+			Cpu: NodeCpuResources{
+				CpuShares: 2000,
+			},
+			Memory: NodeMemoryResources{
+				MemoryMB: 2048,
+			},
+			Disk: NodeDiskResources{
+				DiskMB: 10000,
+			},
+		},
+		ReservedResources: &NodeReservedResources{
+			Cpu: NodeReservedCpuResources{
+				CpuShares: 1000,
+			},
+			Memory: NodeReservedMemoryResources{
+				MemoryMB: 1024,
+			},
+			Disk: NodeReservedDiskResources{
+				DiskMB: 5000,
+			},
+		},
+	}
+
+	// Requests more than is available once reserved resources are
+	// subtracted, but less than the node's total capacity: this alloc may
+	// fit later if something else is evicted or the reservation shrinks.
+	fitsLater := &Allocation{
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu: AllocatedCpuResources{
+						CpuShares: 1500,
+					},
+					Memory: AllocatedMemoryResources{
+						MemoryMB: 1200,
+					},
+				},
+			},
+		},
+	}
+	exceeds, dim := AllocExceedsNodeCapacity(n, fitsLater)
+	require.False(exceeds)
+	require.Empty(dim)
+
+	// Requests more than the node's total, unreduced capacity: this alloc
+	// can never fit on this node no matter what else changes.
+	neverFits := &Allocation{
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu: AllocatedCpuResources{
+						CpuShares: 2500,
+					},
+				},
+			},
+		},
+	}
+	exceeds, dim = AllocExceedsNodeCapacity(n, neverFits)
+	require.True(exceeds)
+	require.NotEmpty(dim)
+}
+
 func TestAllocsFit_TerminalAlloc(t *testing.T) {
 	require := require.New(t)
 
@@ -523,6 +590,223 @@ func TestAllocsFit_Devices(t *testing.T) {
 	require.True(fit)
 }
 
+// TestNodeCapacityCache_AllocsFit_Equivalence asserts that NodeCapacityCache.AllocsFit
+// returns results identical to calling AllocsFit directly with the existing
+// and additional allocations combined, across fit, overcommit, and device
+// oversubscription cases.
+func TestNodeCapacityCache_AllocsFit_Equivalence(t *testing.T) {
+	require := require.New(t)
+
+	n := MockNvidiaNode()
+
+	existing := &Allocation{
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu: AllocatedCpuResources{
+						CpuShares: 500,
+					},
+					Memory: AllocatedMemoryResources{
+						MemoryMB: 512,
+					},
+					Devices: []*AllocatedDeviceResource{
+						{
+							Type:      "gpu",
+							Vendor:    "nvidia",
+							Name:      "1080ti",
+							DeviceIDs: []string{n.NodeResources.Devices[0].Instances[0].ID},
+						},
+					},
+				},
+			},
+			Shared: AllocatedSharedResources{
+				DiskMB: 1000,
+			},
+		},
+	}
+
+	additional1 := &Allocation{
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu: AllocatedCpuResources{
+						CpuShares: 500,
+					},
+					Memory: AllocatedMemoryResources{
+						MemoryMB: 512,
+					},
+				},
+			},
+			Shared: AllocatedSharedResources{
+				DiskMB: 1000,
+			},
+		},
+	}
+
+	// Collides with existing's device, so fits as resources but not devices.
+	additional2 := additional1.Copy()
+	additional2.AllocatedResources.Tasks["web"].Devices = []*AllocatedDeviceResource{
+		{
+			Type:      "gpu",
+			Vendor:    "nvidia",
+			Name:      "1080ti",
+			DeviceIDs: []string{n.NodeResources.Devices[0].Instances[0].ID},
+		},
+	}
+
+	cases := []struct {
+		Name         string
+		Additional   []*Allocation
+		CheckDevices bool
+	}{
+		{"fits without devices", []*Allocation{additional1}, false},
+		{"fits with devices", []*Allocation{additional1}, true},
+		{"device collision", []*Allocation{additional2}, true},
+		{"device collision ignored", []*Allocation{additional2}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			wantFit, wantReason, wantUsed, wantErr := AllocsFit(
+				n, append([]*Allocation{existing}, tc.Additional...), nil, tc.CheckDevices)
+
+			cache := NewNodeCapacityCache(n, []*Allocation{existing})
+			gotFit, gotReason, gotUsed, gotErr := cache.AllocsFit(tc.Additional, nil, tc.CheckDevices)
+
+			require.Equal(wantErr, gotErr)
+			require.Equal(wantFit, gotFit)
+			require.Equal(wantReason, gotReason)
+			require.Equal(wantUsed, gotUsed)
+		})
+	}
+}
+
+// TestNodeCapacityCache_Evict_Equivalence asserts that evicting an
+// allocation from a NodeCapacityCache and re-checking fit returns results
+// identical to building a fresh cache from the existing set with that
+// allocation already removed, as preemption's evict-and-recheck loop
+// relies on.
+func TestNodeCapacityCache_Evict_Equivalence(t *testing.T) {
+	require := require.New(t)
+
+	n := &Node{
+		NodeResources: &NodeResources{
+			Cpu:    NodeCpuResources{CpuShares: 1000},
+			Memory: NodeMemoryResources{MemoryMB: 1000},
+			Disk:   NodeDiskResources{DiskMB: 1000},
+		},
+		ReservedResources: &NodeReservedResources{},
+	}
+
+	keep := &Allocation{
+		ID: "keep",
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu:    AllocatedCpuResources{CpuShares: 300},
+					Memory: AllocatedMemoryResources{MemoryMB: 300},
+				},
+			},
+			Shared: AllocatedSharedResources{DiskMB: 300},
+		},
+	}
+
+	evicted := &Allocation{
+		ID: "evicted",
+		AllocatedResources: &AllocatedResources{
+			Tasks: map[string]*AllocatedTaskResources{
+				"web": {
+					Cpu:    AllocatedCpuResources{CpuShares: 600},
+					Memory: AllocatedMemoryResources{MemoryMB: 600},
+				},
+			},
+			Shared: AllocatedSharedResources{DiskMB: 600},
+		},
+	}
+
+	additional := []*Allocation{
+		{
+			AllocatedResources: &AllocatedResources{
+				Tasks: map[string]*AllocatedTaskResources{
+					"web": {
+						Cpu:    AllocatedCpuResources{CpuShares: 500},
+						Memory: AllocatedMemoryResources{MemoryMB: 500},
+					},
+				},
+				Shared: AllocatedSharedResources{DiskMB: 500},
+			},
+		},
+	}
+
+	wantFit, wantReason, wantUsed, wantErr := AllocsFit(n, append([]*Allocation{keep}, additional...), nil, false)
+
+	cache := NewNodeCapacityCache(n, []*Allocation{keep, evicted})
+	cache.Evict(evicted)
+	gotFit, gotReason, gotUsed, gotErr := cache.AllocsFit(additional, nil, false)
+
+	require.Equal(wantErr, gotErr)
+	require.Equal(wantFit, gotFit)
+	require.Equal(wantReason, gotReason)
+	require.Equal(wantUsed, gotUsed)
+}
+
+// BenchmarkNodeCapacityCache_AllocsFit compares repeatedly checking fit for
+// many small candidate placements against a node with a large number of
+// existing allocations, using the cache versus recomputing AllocsFit from
+// scratch each time.
+func BenchmarkNodeCapacityCache_AllocsFit(b *testing.B) {
+	n := &Node{
+		NodeResources: &NodeResources{
+			Cpu:    NodeCpuResources{CpuShares: 500000},
+			Memory: NodeMemoryResources{MemoryMB: 500000},
+			Disk:   NodeDiskResources{DiskMB: 500000},
+		},
+		ReservedResources: &NodeReservedResources{},
+	}
+
+	existing := make([]*Allocation, 0, 500)
+	for i := 0; i < 500; i++ {
+		existing = append(existing, &Allocation{
+			AllocatedResources: &AllocatedResources{
+				Tasks: map[string]*AllocatedTaskResources{
+					"web": {
+						Cpu:    AllocatedCpuResources{CpuShares: 100},
+						Memory: AllocatedMemoryResources{MemoryMB: 100},
+					},
+				},
+				Shared: AllocatedSharedResources{DiskMB: 100},
+			},
+		})
+	}
+
+	candidate := []*Allocation{
+		{
+			AllocatedResources: &AllocatedResources{
+				Tasks: map[string]*AllocatedTaskResources{
+					"web": {
+						Cpu:    AllocatedCpuResources{CpuShares: 100},
+						Memory: AllocatedMemoryResources{MemoryMB: 100},
+					},
+				},
+				Shared: AllocatedSharedResources{DiskMB: 100},
+			},
+		},
+	}
+
+	b.Run("AllocsFit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			AllocsFit(n, append(existing, candidate...), nil, false)
+		}
+	})
+
+	b.Run("NodeCapacityCache", func(b *testing.B) {
+		cache := NewNodeCapacityCache(n, existing)
+		for i := 0; i < b.N; i++ {
+			cache.AllocsFit(candidate, nil, false)
+		}
+	})
+}
+
 // COMPAT(0.11): Remove in 0.11
 func TestScoreFitBinPack_Old(t *testing.T) {
 	node := &Node{}
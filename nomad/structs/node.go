@@ -95,6 +95,10 @@ type CSINodeInfo struct {
 	// RequiresNodeStageVolume indicates whether the client should Stage/Unstage
 	// volumes on this node.
 	RequiresNodeStageVolume bool
+
+	// RequiresNodeStageSecrets is true when the node plugin requires
+	// secrets to be supplied on NodeStageVolume and NodeUnstageVolume.
+	RequiresNodeStageSecrets bool
 }
 
 func (n *CSINodeInfo) Copy() *CSINodeInfo {
@@ -129,6 +133,45 @@ type CSIControllerInfo struct {
 	// SupportsListVolumesAttachedNodes indicates whether the plugin will return
 	// attached nodes data when making ListVolume RPCs
 	SupportsListVolumesAttachedNodes bool
+
+	// RequiresEncryptionContext is true when the controller requires
+	// per-attach encryption parameters. A claim against a volume served by
+	// this controller must supply every key in
+	// RequiredEncryptionContextKeys in its EncryptionContext, or the claim
+	// is rejected.
+	RequiresEncryptionContext bool
+
+	// RequiredEncryptionContextKeys lists the EncryptionContext keys that
+	// must be present on a claim request when RequiresEncryptionContext is
+	// true.
+	RequiredEncryptionContextKeys []string
+
+	// RequiresControllerCreateSecrets is true when the controller requires
+	// secrets to be supplied on CreateVolume.
+	RequiresControllerCreateSecrets bool
+
+	// RequiresControllerPublishSecrets is true when the controller requires
+	// secrets to be supplied on ControllerPublishVolume and
+	// ControllerUnpublishVolume.
+	RequiresControllerPublishSecrets bool
+
+	// RequiresControllerExpandSecrets is true when the controller requires
+	// secrets to be supplied on ControllerExpandVolume.
+	RequiresControllerExpandSecrets bool
+
+	// RequiresControllerDeleteSecrets is true when the controller requires
+	// secrets to be supplied on DeleteVolume.
+	RequiresControllerDeleteSecrets bool
+
+	// AccessibleTopology is the topology the controller plugin instance was
+	// registered with, for CSI providers that run zonal controllers (one
+	// controller instance per zone/rack/etc. rather than a single
+	// cluster-wide controller). This is set at registration time rather
+	// than fingerprinted from the plugin itself, since the CSI spec has no
+	// RPC for a controller to report its own topology. It's unset for
+	// controllers that aren't topology-scoped, in which case the plugin is
+	// treated as reachable from anywhere.
+	AccessibleTopology *CSITopology
 }
 
 func (c *CSIControllerInfo) Copy() *CSIControllerInfo {
@@ -138,6 +181,8 @@ func (c *CSIControllerInfo) Copy() *CSIControllerInfo {
 
 	nc := new(CSIControllerInfo)
 	*nc = *c
+	nc.RequiredEncryptionContextKeys = helper.CopySliceString(c.RequiredEncryptionContextKeys)
+	nc.AccessibleTopology = c.AccessibleTopology.Copy()
 
 	return nc
 }
@@ -218,6 +263,38 @@ func (c *CSIInfo) IsNode() bool {
 	return true
 }
 
+// RequiredSecretsOperations reports which volume operations this plugin has
+// declared require secrets, so that operators can provision them ahead of
+// time instead of discovering the requirement from a failed RPC. The
+// returned operation names are a subset of "create", "publish", "stage",
+// "expand", and "delete".
+func (c *CSIInfo) RequiredSecretsOperations() []string {
+	if c == nil {
+		return nil
+	}
+
+	var ops []string
+	if c.ControllerInfo != nil {
+		if c.ControllerInfo.RequiresControllerCreateSecrets {
+			ops = append(ops, "create")
+		}
+		if c.ControllerInfo.RequiresControllerPublishSecrets {
+			ops = append(ops, "publish")
+		}
+		if c.ControllerInfo.RequiresControllerExpandSecrets {
+			ops = append(ops, "expand")
+		}
+		if c.ControllerInfo.RequiresControllerDeleteSecrets {
+			ops = append(ops, "delete")
+		}
+	}
+	if c.NodeInfo != nil && c.NodeInfo.RequiresNodeStageSecrets {
+		ops = append(ops, "stage")
+	}
+
+	return ops
+}
+
 // DriverInfo is the current state of a single driver. This is updated
 // regularly as driver health changes on the node.
 type DriverInfo struct {
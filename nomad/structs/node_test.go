@@ -60,3 +60,32 @@ func TestDriverInfoEquals(t *testing.T) {
 		require.Equal(testCase.expected, first.HealthCheckEquals(second), testCase.errorMsg)
 	}
 }
+
+func TestCSIInfo_RequiredSecretsOperations(t *testing.T) {
+	require := require.New(t)
+
+	// a fake plugin that only declares secret requirements for a subset
+	// of the operations that can require them
+	info := &CSIInfo{
+		ControllerInfo: &CSIControllerInfo{
+			RequiresControllerCreateSecrets: true,
+			RequiresControllerDeleteSecrets: true,
+		},
+		NodeInfo: &CSINodeInfo{
+			RequiresNodeStageSecrets: true,
+		},
+	}
+
+	require.Equal([]string{"create", "delete", "stage"}, info.RequiredSecretsOperations())
+}
+
+func TestCSIInfo_RequiredSecretsOperations_None(t *testing.T) {
+	require := require.New(t)
+
+	info := &CSIInfo{
+		ControllerInfo: &CSIControllerInfo{},
+		NodeInfo:       &CSINodeInfo{},
+	}
+
+	require.Empty(info.RequiredSecretsOperations())
+}
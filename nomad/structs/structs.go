@@ -104,6 +104,8 @@ const (
 	// Namespace types were moved from enterprise and therefore start at 64
 	NamespaceUpsertRequestType MessageType = 64
 	NamespaceDeleteRequestType MessageType = 65
+
+	BatchedApplyPlanResultsRequestType MessageType = 66
 )
 
 const (
@@ -911,6 +913,24 @@ type ApplyPlanResultsRequest struct {
 	// PreemptionEvals is a slice of follow up evals for jobs whose allocations
 	// have been preempted to place allocs in this plan
 	PreemptionEvals []*Evaluation
+
+	// SnapshotIndex is the Raft index of the snapshot the plan was evaluated
+	// against. It lets the FSM detect that an allocation this plan assumes it
+	// can stop or preempt was modified by a different, later Raft entry after
+	// the snapshot was taken, and reject the apply instead of silently
+	// clobbering that change. A zero value skips the check, for plans applied
+	// before this was tracked.
+	SnapshotIndex uint64
+}
+
+// BatchedApplyPlanResultsRequest coalesces the results of several plans that
+// were evaluated against the same state snapshot and confirmed to touch
+// disjoint sets of nodes into a single Raft log entry. Each Requests entry
+// is applied exactly as it would be if submitted on its own via
+// ApplyPlanResultsRequestType; batching only saves the cost of a separate
+// Raft round trip per plan.
+type BatchedApplyPlanResultsRequest struct {
+	Requests []*ApplyPlanResultsRequest
 }
 
 // AllocUpdateRequest is used to submit changes to allocations, either
@@ -9011,6 +9031,14 @@ type Allocation struct {
 	// DesiredStatusDescription is meant to provide more human useful information
 	DesiredDescription string
 
+	// MigrationHint is set by applyPlan on an evicted allocation to record
+	// where the scheduler intends to move it: either the target node ID, if
+	// a same-plan replacement placement was found, or "any" if the
+	// allocation is being migrated but its replacement hasn't been placed
+	// yet. This field is purely informational for operators and defaults
+	// empty for evictions that aren't migrations.
+	MigrationHint string
+
 	// DesiredTransition is used to indicate that a state transition
 	// is desired for a given reason.
 	DesiredTransition DesiredTransition
@@ -9674,6 +9702,13 @@ type AllocMetric struct {
 	// exhausted of at least one resource
 	NodesExhausted int
 
+	// NodesExceeded is the number of exhausted nodes, counted in
+	// NodesExhausted, where the allocation's request exceeded the node's
+	// total capacity rather than merely its current availability. An
+	// allocation can never be placed on a node counted here, no matter
+	// how much its existing allocations change.
+	NodesExceeded int
+
 	// ClassExhausted is the number of nodes exhausted by class
 	ClassExhausted map[string]int
 
@@ -9763,6 +9798,14 @@ func (a *AllocMetric) ExhaustedNode(node *Node, dimension string) {
 	}
 }
 
+// ExceedsCapacity records node as exhausted, same as ExhaustedNode, and
+// additionally marks that the allocation's request exceeded node's total
+// capacity for dimension rather than merely its current availability.
+func (a *AllocMetric) ExceedsCapacity(node *Node, dimension string) {
+	a.ExhaustedNode(node, dimension)
+	a.NodesExceeded += 1
+}
+
 func (a *AllocMetric) ExhaustQuota(dimensions []string) {
 	if a.QuotaExhausted == nil {
 		a.QuotaExhausted = make([]string, 0, len(dimensions))
@@ -10210,6 +10253,7 @@ func (e *Evaluation) MakePlan(j *Job) *Plan {
 	}
 	if j != nil {
 		p.AllAtOnce = j.AllAtOnce
+		p.WriteConsistency = planWriteConsistencyForJobType(j.Type)
 	}
 	return p
 }
@@ -10299,6 +10343,17 @@ type Plan struct {
 	// msgpack omit empty fields during serialization
 	_struct bool `codec:",omitempty"` // nolint: structcheck
 
+	// CorrelationID identifies a single plan's journey through
+	// enqueue, evaluation, and apply for distributed debugging: it's
+	// included on every log line emitted while processing the plan and
+	// copied onto the returned PlanResult, so a plan can be traced across
+	// log aggregation without correlating on EvalID (which is shared by
+	// every plan an evaluation submits, including retries). It's
+	// deliberately left out of metric labels, since a unique ID per plan
+	// would make those metrics unbounded cardinality. PlanQueue.Enqueue
+	// generates one if the submitter left it empty.
+	CorrelationID string
+
 	// EvalID is the evaluation ID this plan is associated with
 	EvalID string
 
@@ -10353,6 +10408,196 @@ type Plan struct {
 	// Plan. The leader will wait to evaluate the plan until its StateStore
 	// has reached at least this index.
 	SnapshotIndex uint64
+
+	// SatisfyCount, when greater than zero, allows evaluatePlan to stop
+	// evaluating further nodes once this many placements in the plan have
+	// been confirmed to fit. This is an opt-in latency optimization for
+	// plans that include more candidate placements than are ultimately
+	// required, so that evaluation doesn't pay the cost of checking nodes
+	// whose fit no longer matters once the count is met. A zero value (the
+	// default) disables the short-circuit and evaluates every node.
+	SatisfyCount int
+
+	// NodeChurnLimit, when greater than zero, bounds how many evictions and
+	// placements combined a single node may absorb from this plan. A node
+	// whose combined NodeUpdate and NodeAllocation count for this plan
+	// exceeds the limit is deferred in full, the same way a node that
+	// fails its fit check is: it's left out of the committed result,
+	// counted toward the partial commit, and reported in RejectedNodes, so
+	// the scheduler refreshes and retries the excess churn in a later
+	// round instead of applying it all at once. This guards against rapid
+	// eviction+replacement cycles destabilizing a single node. A zero
+	// value (the default) leaves churn unlimited.
+	NodeChurnLimit int
+
+	// AllowAllocationDeltaOverride, when true, exempts this plan from the
+	// server's PlanAllocationDeltaLimit admission check, which otherwise
+	// rejects a plan whose net increase in allocation count (placements
+	// minus evictions) exceeds the configured threshold. This lets a
+	// scheduler that intends a large, deliberate placement (e.g. a
+	// legitimate bulk scale-up) proceed without raising or disabling the
+	// limit for every other plan. A false value (the default) leaves the
+	// plan subject to the limit.
+	AllowAllocationDeltaOverride bool
+
+	// Preempt, when true, allows plan evaluation to evict existing
+	// lower-priority allocations on a node in order to make a placement fit,
+	// when the placement would otherwise fail the fit check. This is
+	// distinct from NodePreemptions, which carries preemption decisions the
+	// scheduler already made before submitting the plan: Preempt instead
+	// asks the plan applier itself to compute a minimal preemption on a
+	// per-node basis as a last resort during evaluation. A false value (the
+	// default) leaves fit failures as outright rejections.
+	Preempt bool
+
+	// ApplyAfter, when set to a future time, makes the plan queue hold this
+	// plan rather than handing it to the apply worker immediately: the plan
+	// is still validated and queued on Enqueue (so the submitter gets a
+	// normal future to wait on), but it isn't evaluated or committed until
+	// ApplyAfter arrives. This is meant for schedulers coordinating changes
+	// with an operator-declared maintenance window. A zero value (the
+	// default) applies the plan as soon as it's dequeued, as before.
+	ApplyAfter time.Time
+
+	// ValidateCSIPlugins, when true, makes plan evaluation check that every
+	// CSI volume referenced by an allocation being placed is backed by a
+	// registered volume whose controller plugin is also registered. An
+	// allocation whose plugin is missing is treated as a fit failure with a
+	// descriptive reason, so the scheduler waits for the plugin rather than
+	// placing an allocation that's certain to fail at runtime. This is
+	// opt-in because it adds a state query per CSI volume reference during
+	// evaluation. A false value (the default) skips the check.
+	ValidateCSIPlugins bool
+
+	// DryRun, when true, makes the plan applier run evaluation exactly as
+	// it would for a normal plan, but skip applyPlan once evaluation
+	// completes: the submitter gets the full PlanResult back (placements,
+	// evictions, preemptions, refresh index) reflecting what the plan
+	// would have done, without anything being written to Raft or applied
+	// to the optimistic snapshot. This lets feasibility-checking tooling
+	// reuse the real scheduling evaluation path instead of approximating
+	// it. A false value (the default) applies the plan as before.
+	DryRun bool
+
+	// EvictionOrder controls the order that a node's evicted or stopped
+	// allocations, found in NodeUpdate, are applied in. The default value
+	// preserves the order the scheduler submitted them in.
+	EvictionOrder string
+
+	// ReconcileOrphanedAllocs, when true, makes plan evaluation check
+	// whether any node referenced by the plan has since been removed from
+	// the cluster, and if so, proposes stop evictions for that node's
+	// remaining non-terminal allocations as part of the plan result. This
+	// lets the cluster self-heal state left behind by a node that
+	// disappeared before its allocations were cleaned up. It's opt-in and
+	// conservative: it only inspects nodes the plan already references,
+	// rather than scanning the whole cluster. A false value (the default)
+	// leaves orphaned allocations untouched.
+	ReconcileOrphanedAllocs bool
+
+	// ForecastedAllocs, keyed by node ID, lists allocations that don't yet
+	// exist in the state store but are expected to land on that node soon,
+	// such as placements from a concurrent in-flight plan that hasn't been
+	// applied yet. When set, evaluateNodePlan includes them in the proposed
+	// fit-check set alongside the node's existing allocations, so a plan
+	// doing proactive scaling can ask whether it would still fit once that
+	// soon-to-start work is accounted for. A nil value (the default) leaves
+	// fit checking unchanged.
+	ForecastedAllocs map[string][]*Allocation
+
+	// NodeScoring, when set, makes plan evaluation rank the plan's nodes by
+	// how packed they currently are before evaluating fit, so that
+	// allocations are applied to higher-ranked nodes first. With
+	// SchedulerAlgorithmBinpack it favors the most-packed fitting nodes;
+	// with SchedulerAlgorithmSpread it favors the least-packed ones. This
+	// only reorders among nodes that turn out to fit; it never changes
+	// which nodes fit or what the plan ultimately applies, except insofar
+	// as it changes which nodes are favored when a SatisfyCount or
+	// AllAtOnce short-circuit means not every node is evaluated. An empty
+	// value (the default) leaves nodes in the order the scheduler added
+	// them to the plan.
+	NodeScoring SchedulerAlgorithm
+
+	// HeadroomOrdering, when true, makes plan evaluation rank the plan's
+	// nodes by how much spare capacity they have relative to their own
+	// size before evaluating fit, so that a plan targeting more candidate
+	// nodes than it needs to place on fills the roomiest nodes first
+	// instead of fragmenting capacity across many nodes. Like NodeScoring,
+	// this only reorders among nodes that turn out to fit; it's ignored if
+	// NodeScoring is also set. A false value (the default) leaves nodes in
+	// the order the scheduler added them to the plan.
+	HeadroomOrdering bool
+
+	// StrictValidation, when true, makes plan evaluation all-or-nothing
+	// without requiring the gang semantics of AllAtOnce: every node is
+	// still evaluated independently and a SatisfyCount short-circuit is
+	// disabled so the full plan gets a chance to fit, but if any node
+	// fails to fit, none of the plan's allocations are applied and the
+	// scheduler is forced to refresh instead of committing a partial
+	// result. A false value (the default) allows the usual partial
+	// commit of whichever nodes did fit.
+	StrictValidation bool
+
+	// WriteConsistency controls how long the plan's Raft apply will wait to
+	// enqueue behind other in-flight applies before giving up. It defaults
+	// to PlanWriteConsistencyStrict for every job type except batch, which
+	// defaults to PlanWriteConsistencyRelaxed. See PlanWriteConsistency for
+	// details.
+	WriteConsistency PlanWriteConsistency
+
+	// RelaxedSystemJobFit, when true, allows system-scheduler placements
+	// that fail the strict fit check solely on CPU to still fit, provided
+	// the shortfall is within a small margin of the node's available CPU.
+	// System jobs are usually small daemons that operators expect to run
+	// on every eligible node even when nodes are under tight CPU pressure,
+	// so a marginal CPU shortfall shouldn't keep them from placing the way
+	// it would a service job. Memory and disk are never relaxed. A false
+	// value (the default) applies the same strict fit check to system jobs
+	// as every other job type.
+	RelaxedSystemJobFit bool
+}
+
+const (
+	// EvictionOrderDefault preserves the order allocations were added to the
+	// plan's NodeUpdate list.
+	EvictionOrderDefault = ""
+
+	// EvictionOrderPriorityAscending evicts a node's lowest priority job
+	// allocations first.
+	EvictionOrderPriorityAscending = "priority"
+
+	// EvictionOrderOldestFirst evicts a node's longest-running allocations
+	// first.
+	EvictionOrderOldestFirst = "age"
+)
+
+// PlanWriteConsistency controls how long a plan's Raft apply will wait to
+// enqueue behind other in-flight applies, letting operators trade some
+// durability for lower latency under write pressure on workloads that are
+// cheap to retry.
+type PlanWriteConsistency string
+
+const (
+	// PlanWriteConsistencyStrict waits the usual amount of time for the
+	// plan's Raft apply to enqueue. This is the default for every job type
+	// except batch.
+	PlanWriteConsistencyStrict PlanWriteConsistency = "strict"
+
+	// PlanWriteConsistencyRelaxed shortens how long the plan's Raft apply
+	// will wait to enqueue, so that a contended apply pipeline fails fast
+	// and the scheduler can retry rather than blocking. This is the
+	// default for batch jobs, which are retried cheaply and don't carry
+	// the same latency expectations as service or system jobs.
+	PlanWriteConsistencyRelaxed PlanWriteConsistency = "relaxed"
+)
+
+// planWriteConsistencyForJobType returns the default PlanWriteConsistency
+// for a job of the given type.
+func planWriteConsistencyForJobType(jobType string) PlanWriteConsistency {
+	if jobType == JobTypeBatch {
+		return PlanWriteConsistencyRelaxed
+	}
+	return PlanWriteConsistencyStrict
 }
 
 // AppendStoppedAlloc marks an allocation to be stopped. The clientStatus of the
@@ -10479,6 +10724,10 @@ func (p *Plan) NormalizeAllocations() {
 
 // PlanResult is the result of a plan submitted to the leader.
 type PlanResult struct {
+	// CorrelationID is copied from the originating Plan so that the
+	// result can be traced back to the same plan across log aggregation.
+	CorrelationID string
+
 	// NodeUpdate contains all the updates that were committed.
 	NodeUpdate map[string][]*Allocation
 
@@ -10502,9 +10751,41 @@ type PlanResult struct {
 	// over committed) this can be used to force a worker refresh.
 	RefreshIndex uint64
 
+	// Refreshed reports whether any node's fit check failed, causing a
+	// worker refresh to be requested. It's set independent of the value
+	// RefreshIndex ends up with, since RefreshIndex == 0 is ambiguous
+	// early in a cluster's life: callers should check Refreshed rather
+	// than inferring it from RefreshIndex != 0.
+	Refreshed bool
+
 	// AllocIndex is the Raft index in which the evictions and
 	// allocations took place. This is used for the write index.
 	AllocIndex uint64
+
+	// RefreshReasons maps the ID of any node whose fit check failed during
+	// evaluation to a snapshot of that node's available resources, as
+	// observed at evaluation time. This is derived from the state snapshot
+	// already loaded to evaluate the plan, so it costs little to collect.
+	// The scheduler can use it to make a better-informed placement attempt
+	// on refresh instead of blindly resubmitting the same plan.
+	RefreshReasons map[string]*ComparableResources
+
+	// RejectedNodes maps the ID of any node whose fit check failed during
+	// evaluation to a short, human-readable reason (e.g. "cpu", "memory",
+	// "disk", "bandwidth exceeded", "device oversubscribed") describing why
+	// the node couldn't accommodate the plan's placements. The scheduler can
+	// surface this to operators so a failed placement attempt isn't a
+	// complete black box.
+	RejectedNodes map[string]string
+
+	// ResourceDelta maps the ID of any node that committed part of the plan
+	// to the net change in allocated CPU, memory, and disk the commit
+	// represents: new placements add to the delta, evictions and
+	// preemptions subtract from it. The devices of new placements are
+	// included, but the devices of evicted or preempted allocations are not
+	// removed from it. A consumer tracking its own view of node capacity can
+	// apply this directly instead of re-querying state.
+	ResourceDelta map[string]*ComparableResources
 }
 
 // IsNoOp checks if this plan result would do nothing
@@ -10527,6 +10808,34 @@ func (p *PlanResult) FullCommit(plan *Plan) (bool, int, int) {
 	return actual == expected, expected, actual
 }
 
+// NodeUtilizationProjection reports the utilization a node would have
+// across CPU, memory, and disk if a plan's proposed changes for that node
+// were applied. It's produced by a dry-run evaluation, so it's purely
+// informational: a percentage over 100 means the plan would overcommit the
+// node, not that evaluation failed.
+type NodeUtilizationProjection struct {
+	CPUPercent    float64
+	MemoryPercent float64
+	DiskPercent   float64
+}
+
+// PlanEstimate summarizes how likely a plan is to be accepted if it were
+// submitted for evaluation, based only on the per-node resource and
+// constraint fit check. It's advisory: quota, orphan reconciliation, and
+// the refresh-index check aren't re-run, so a plan with Confidence of 1
+// can still be rejected for one of those other reasons.
+type PlanEstimate struct {
+	// FitNodes is how many of the plan's target nodes passed the fit check.
+	FitNodes int
+
+	// TotalNodes is how many nodes the plan proposes changes for.
+	TotalNodes int
+
+	// Confidence is FitNodes/TotalNodes, in the range [0, 1]. A plan with no
+	// target nodes has a Confidence of 1.
+	Confidence float64
+}
+
 // PlanAnnotations holds annotations made by the scheduler to give further debug
 // information to operators.
 type PlanAnnotations struct {
@@ -3772,6 +3772,29 @@ func TestTaskArtifact_Validate_Checksum(t *testing.T) {
 	}
 }
 
+func TestEvaluation_MakePlan_WriteConsistency(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		jobType  string
+		expected PlanWriteConsistency
+	}{
+		{JobTypeService, PlanWriteConsistencyStrict},
+		{JobTypeSystem, PlanWriteConsistencyStrict},
+		{JobTypeBatch, PlanWriteConsistencyRelaxed},
+		{JobTypeCore, PlanWriteConsistencyStrict},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.jobType, func(t *testing.T) {
+			eval := &Evaluation{ID: uuid.Generate()}
+			job := &Job{Type: tc.jobType}
+			plan := eval.MakePlan(job)
+			assert.Equal(t, tc.expected, plan.WriteConsistency)
+		})
+	}
+}
+
 func TestPlan_NormalizeAllocations(t *testing.T) {
 	t.Parallel()
 	plan := &Plan{
@@ -34,29 +34,29 @@ type NodeGetInfoResponse struct {
 // "zone", "rack", etc.
 //
 // According to CSI, there are a few requirements for the keys within this map:
-// - Valid keys have two segments: an OPTIONAL prefix and name, separated
-//   by a slash (/), for example: "com.company.example/zone".
-// - The key name segment is REQUIRED. The prefix is OPTIONAL.
-// - The key name MUST be 63 characters or less, begin and end with an
-//   alphanumeric character ([a-z0-9A-Z]), and contain only dashes (-),
-//   underscores (_), dots (.), or alphanumerics in between, for example
-//   "zone".
-// - The key prefix MUST be 63 characters or less, begin and end with a
-//   lower-case alphanumeric character ([a-z0-9]), contain only
-//   dashes (-), dots (.), or lower-case alphanumerics in between, and
-//   follow domain name notation format
-//   (https://tools.ietf.org/html/rfc1035#section-2.3.1).
-// - The key prefix SHOULD include the plugin's host company name and/or
-//   the plugin name, to minimize the possibility of collisions with keys
-//   from other plugins.
-// - If a key prefix is specified, it MUST be identical across all
-//   topology keys returned by the SP (across all RPCs).
-// - Keys MUST be case-insensitive. Meaning the keys "Zone" and "zone"
-//   MUST not both exist.
-// - Each value (topological segment) MUST contain 1 or more strings.
-// - Each string MUST be 63 characters or less and begin and end with an
-//   alphanumeric character with '-', '_', '.', or alphanumerics in
-//   between.
+//   - Valid keys have two segments: an OPTIONAL prefix and name, separated
+//     by a slash (/), for example: "com.company.example/zone".
+//   - The key name segment is REQUIRED. The prefix is OPTIONAL.
+//   - The key name MUST be 63 characters or less, begin and end with an
+//     alphanumeric character ([a-z0-9A-Z]), and contain only dashes (-),
+//     underscores (_), dots (.), or alphanumerics in between, for example
+//     "zone".
+//   - The key prefix MUST be 63 characters or less, begin and end with a
+//     lower-case alphanumeric character ([a-z0-9]), contain only
+//     dashes (-), dots (.), or lower-case alphanumerics in between, and
+//     follow domain name notation format
+//     (https://tools.ietf.org/html/rfc1035#section-2.3.1).
+//   - The key prefix SHOULD include the plugin's host company name and/or
+//     the plugin name, to minimize the possibility of collisions with keys
+//     from other plugins.
+//   - If a key prefix is specified, it MUST be identical across all
+//     topology keys returned by the SP (across all RPCs).
+//   - Keys MUST be case-insensitive. Meaning the keys "Zone" and "zone"
+//     MUST not both exist.
+//   - Each value (topological segment) MUST contain 1 or more strings.
+//   - Each string MUST be 63 characters or less and begin and end with an
+//     alphanumeric character with '-', '_', '.', or alphanumerics in
+//     between.
 type Topology struct {
 	Segments map[string]string
 }
@@ -65,9 +65,16 @@ type Topology struct {
 // by nomad to simplify the interface required for testing.
 type CSIControllerClient interface {
 	ControllerGetCapabilities(ctx context.Context, in *csipbv1.ControllerGetCapabilitiesRequest, opts ...grpc.CallOption) (*csipbv1.ControllerGetCapabilitiesResponse, error)
+	CreateVolume(ctx context.Context, in *csipbv1.CreateVolumeRequest, opts ...grpc.CallOption) (*csipbv1.CreateVolumeResponse, error)
+	DeleteVolume(ctx context.Context, in *csipbv1.DeleteVolumeRequest, opts ...grpc.CallOption) (*csipbv1.DeleteVolumeResponse, error)
+	ControllerExpandVolume(ctx context.Context, in *csipbv1.ControllerExpandVolumeRequest, opts ...grpc.CallOption) (*csipbv1.ControllerExpandVolumeResponse, error)
 	ControllerPublishVolume(ctx context.Context, in *csipbv1.ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*csipbv1.ControllerPublishVolumeResponse, error)
 	ControllerUnpublishVolume(ctx context.Context, in *csipbv1.ControllerUnpublishVolumeRequest, opts ...grpc.CallOption) (*csipbv1.ControllerUnpublishVolumeResponse, error)
 	ValidateVolumeCapabilities(ctx context.Context, in *csipbv1.ValidateVolumeCapabilitiesRequest, opts ...grpc.CallOption) (*csipbv1.ValidateVolumeCapabilitiesResponse, error)
+	CreateSnapshot(ctx context.Context, in *csipbv1.CreateSnapshotRequest, opts ...grpc.CallOption) (*csipbv1.CreateSnapshotResponse, error)
+	DeleteSnapshot(ctx context.Context, in *csipbv1.DeleteSnapshotRequest, opts ...grpc.CallOption) (*csipbv1.DeleteSnapshotResponse, error)
+	ListVolumes(ctx context.Context, in *csipbv1.ListVolumesRequest, opts ...grpc.CallOption) (*csipbv1.ListVolumesResponse, error)
+	GetCapacity(ctx context.Context, in *csipbv1.GetCapacityRequest, opts ...grpc.CallOption) (*csipbv1.GetCapacityResponse, error)
 }
 
 // CSINodeClient defines the minimal CSI Node Plugin interface used
@@ -131,7 +138,9 @@ func newGrpcConn(addr string, logger hclog.Logger) (*grpc.ClientConn, error) {
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to open grpc connection to addr: %s, err: %v", addr, err)
+		return nil, &PluginConnectionError{
+			Err: fmt.Errorf("failed to open grpc connection to addr: %s, err: %v", addr, err),
+		}
 	}
 
 	return conn, nil
@@ -173,6 +182,13 @@ func (c *client) PluginProbe(ctx context.Context) (bool, error) {
 	// note: no grpc retries should be done here
 	req, err := c.identityClient.Probe(ctx, &csipbv1.ProbeRequest{})
 	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			// Probe is optional per the CSI spec. A plugin that hasn't
+			// implemented it is still reachable over gRPC, so treat it as
+			// healthy-by-connection rather than failing liveness checks
+			// for every plugin that omits it.
+			return true, nil
+		}
 		return false, err
 	}
 
@@ -255,6 +271,122 @@ func (c *client) ControllerGetCapabilities(ctx context.Context) (*ControllerCapa
 	return NewControllerCapabilitySet(resp), nil
 }
 
+// ControllerCreateVolume is used to provision a volume by name on the
+// remote storage provider. Per the CSI spec, CreateVolume calls for the
+// same name MUST be idempotent: a retry of a call that previously
+// succeeded should return the existing volume rather than provisioning a
+// second one. A compatible retry is answered with success by the plugin
+// itself. Every AlreadyExists response is returned as an error; the client
+// does not look up the existing volume or compare its capabilities.
+func (c *client) ControllerCreateVolume(ctx context.Context, req *ControllerCreateVolumeRequest, opts ...grpc.CallOption) (*ControllerCreateVolumeResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	resp, err := c.controllerClient.CreateVolume(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		switch code {
+		case codes.AlreadyExists:
+			// per the CSI spec, CreateVolume MUST be idempotent by name: a
+			// retry of a call that previously succeeded returns success
+			// with the existing volume's details rather than an error. A
+			// plugin only returns AlreadyExists when a volume with this
+			// name exists but with incompatible capabilities or
+			// parameters, which is a genuine conflict we can't resolve.
+			err = fmt.Errorf("volume %q already exists with incompatible capabilities or parameters: %v",
+				req.Name, err)
+		case codes.OutOfRange:
+			err = fmt.Errorf("requested capacity %d is outside the capabilities of the storage provider: %v",
+				req.CapacityRange.RequiredBytes, err)
+		case codes.Internal:
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	vol := resp.GetVolume()
+	if vol.GetVolumeId() == "" {
+		return nil, fmt.Errorf("plugin failed to return volume ID for volume %q", req.Name)
+	}
+
+	return &ControllerCreateVolumeResponse{
+		ExternalVolumeID: vol.GetVolumeId(),
+		CapacityBytes:    vol.GetCapacityBytes(),
+		VolumeContext:    vol.GetVolumeContext(),
+	}, nil
+}
+
+// ControllerDeleteVolume is used to reclaim the storage consumed by a
+// volume on the remote storage provider once it's no longer needed. Per the
+// CSI spec, DeleteVolume MUST be idempotent: deleting a volume ID that
+// doesn't exist is not an error.
+func (c *client) ControllerDeleteVolume(ctx context.Context, req *ControllerDeleteVolumeRequest, opts ...grpc.CallOption) (*ControllerDeleteVolumeResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	_, err = c.controllerClient.DeleteVolume(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		switch code {
+		case codes.NotFound:
+			// the volume is already gone, which is the desired end state.
+			return &ControllerDeleteVolumeResponse{}, nil
+		case codes.Internal:
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	return &ControllerDeleteVolumeResponse{}, nil
+}
+
+// ControllerExpandVolume is used to resize a volume on the remote storage
+// provider, which may still be attached to a node and in use.
+func (c *client) ControllerExpandVolume(ctx context.Context, req *ControllerExpandVolumeRequest, opts ...grpc.CallOption) (*ControllerExpandVolumeResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	resp, err := c.controllerClient.ControllerExpandVolume(ctx, pbrequest, opts...)
+	if err != nil {
+		return nil, wrapRPCError(err)
+	}
+
+	return &ControllerExpandVolumeResponse{
+		CapacityBytes:         resp.GetCapacityBytes(),
+		NodeExpansionRequired: resp.GetNodeExpansionRequired(),
+	}, nil
+}
+
 func (c *client) ControllerPublishVolume(ctx context.Context, req *ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*ControllerPublishVolumeResponse, error) {
 	if c == nil {
 		return nil, fmt.Errorf("Client not initialized")
@@ -277,9 +409,15 @@ func (c *client) ControllerPublishVolume(ctx context.Context, req *ControllerPub
 			err = fmt.Errorf("volume %q or node %q could not be found: %v",
 				req.ExternalID, req.NodeID, err)
 		case codes.AlreadyExists:
+			// per the CSI spec, a plugin only returns AlreadyExists from
+			// ControllerPublishVolume when the volume is already published
+			// to the node with incompatible capabilities or a read_only
+			// setting incompatible with this request; a compatible
+			// republish returns success, not AlreadyExists. This is
+			// therefore a genuine conflict we can't resolve.
 			err = fmt.Errorf(
-				"volume %q is already published at node %q but with capabilities or a read_only setting incompatible with this request: %v",
-				req.ExternalID, req.NodeID, err)
+				"%w: volume %q is already published at node %q with incompatible capabilities or a conflicting read_only setting: %v",
+				ErrVolumeAlreadyPublished, req.ExternalID, req.NodeID, err)
 		case codes.ResourceExhausted:
 			err = fmt.Errorf("node %q has reached the maximum allowable number of attached volumes: %v",
 				req.NodeID, err)
@@ -289,7 +427,7 @@ func (c *client) ControllerPublishVolume(ctx context.Context, req *ControllerPub
 		case codes.Internal:
 			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
 		}
-		return nil, err
+		return nil, wrapRPCError(err)
 	}
 
 	return &ControllerPublishVolumeResponse{
@@ -324,7 +462,7 @@ func (c *client) ControllerUnpublishVolume(ctx context.Context, req *ControllerU
 		case codes.Internal:
 			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
 		}
-		return nil, err
+		return nil, wrapRPCError(err)
 	}
 
 	return &ControllerUnpublishVolumeResponse{}, nil
@@ -356,7 +494,7 @@ func (c *client) ControllerValidateCapabilities(ctx context.Context, req *Contro
 		case codes.Internal:
 			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
 		}
-		return err
+		return wrapRPCError(err)
 	}
 
 	if resp.Message != "" {
@@ -383,6 +521,152 @@ func (c *client) ControllerValidateCapabilities(ctx context.Context, req *Contro
 	return nil
 }
 
+// ControllerCreateSnapshot is used to create a point-in-time snapshot of a
+// single volume on the remote storage provider. Per the CSI spec,
+// CreateSnapshot calls for the same name MUST be idempotent: a retry of a
+// call that previously succeeded should return the existing snapshot
+// rather than creating a second one.
+func (c *client) ControllerCreateSnapshot(ctx context.Context, req *ControllerCreateSnapshotRequest, opts ...grpc.CallOption) (*ControllerCreateSnapshotResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	resp, err := c.controllerClient.CreateSnapshot(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		switch code {
+		case codes.AlreadyExists:
+			err = fmt.Errorf("snapshot %q already exists for a different source volume: %v",
+				req.Name, err)
+		case codes.Internal:
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	snap := resp.GetSnapshot()
+	if snap.GetSnapshotId() == "" {
+		return nil, fmt.Errorf("plugin failed to return snapshot ID for snapshot %q", req.Name)
+	}
+
+	return &ControllerCreateSnapshotResponse{
+		ID:             snap.GetSnapshotId(),
+		SourceVolumeID: snap.GetSourceVolumeId(),
+		SizeBytes:      snap.GetSizeBytes(),
+		CreateTime:     snap.GetCreationTime().GetSeconds(),
+		IsReady:        snap.GetReadyToUse(),
+	}, nil
+}
+
+// ControllerDeleteSnapshot is used to reclaim the storage consumed by a
+// snapshot on the remote storage provider once it's no longer needed. Per
+// the CSI spec, DeleteSnapshot MUST be idempotent: deleting a snapshot ID
+// that doesn't exist is not an error.
+func (c *client) ControllerDeleteSnapshot(ctx context.Context, req *ControllerDeleteSnapshotRequest, opts ...grpc.CallOption) (*ControllerDeleteSnapshotResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	_, err = c.controllerClient.DeleteSnapshot(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		switch code {
+		case codes.NotFound:
+			// the snapshot is already gone, which is the desired end state.
+			return &ControllerDeleteSnapshotResponse{}, nil
+		case codes.Internal:
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	return &ControllerDeleteSnapshotResponse{}, nil
+}
+
+// ControllerListVolumes returns a single page of the volumes known to the
+// storage provider. Callers that want every volume are responsible for
+// following NextToken until it's empty; see client.CSI.ControllerListVolumes
+// for the caller that does this for a MaxEntries of 0.
+func (c *client) ControllerListVolumes(ctx context.Context, req *ControllerListVolumesRequest, opts ...grpc.CallOption) (*ControllerListVolumesResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	resp, err := c.controllerClient.ListVolumes(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		if code == codes.Internal {
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	entries := make([]*ControllerListVolumesResponseEntry, 0, len(resp.GetEntries()))
+	for _, entry := range resp.GetEntries() {
+		entries = append(entries, &ControllerListVolumesResponseEntry{
+			ExternalVolumeID: entry.GetVolume().GetVolumeId(),
+			CapacityBytes:    entry.GetVolume().GetCapacityBytes(),
+			PublishedNodeIDs: entry.GetStatus().GetPublishedNodeIds(),
+		})
+	}
+
+	return &ControllerListVolumesResponse{
+		Entries:   entries,
+		NextToken: resp.GetNextToken(),
+	}, nil
+}
+
+// ControllerGetCapacity queries the remaining storage capacity available
+// for volumes matching the (optional) request filters.
+func (c *client) ControllerGetCapacity(ctx context.Context, req *ControllerGetCapacityRequest, opts ...grpc.CallOption) (*ControllerGetCapacityResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Client not initialized")
+	}
+	if c.controllerClient == nil {
+		return nil, fmt.Errorf("controllerClient not initialized")
+	}
+
+	pbrequest := req.ToCSIRepresentation()
+	resp, err := c.controllerClient.GetCapacity(ctx, pbrequest, opts...)
+	if err != nil {
+		code := status.Code(err)
+		if code == codes.Internal {
+			err = fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
+		}
+		return nil, wrapRPCError(err)
+	}
+
+	return &ControllerGetCapacityResponse{
+		AvailableCapacity: resp.GetAvailableCapacity(),
+	}, nil
+}
+
 // compareCapabilities returns an error if the 'got' capabilities aren't found
 // within the 'expected' capability.
 //
@@ -545,7 +829,7 @@ func (c *client) NodeStageVolume(ctx context.Context, req *NodeStageVolumeReques
 		}
 	}
 
-	return err
+	return wrapRPCError(err)
 }
 
 func (c *client) NodeUnstageVolume(ctx context.Context, volumeID string, stagingTargetPath string, opts ...grpc.CallOption) error {
@@ -583,7 +867,7 @@ func (c *client) NodeUnstageVolume(ctx context.Context, volumeID string, staging
 		}
 	}
 
-	return err
+	return wrapRPCError(err)
 }
 
 func (c *client) NodePublishVolume(ctx context.Context, req *NodePublishVolumeRequest, opts ...grpc.CallOption) error {
@@ -617,7 +901,7 @@ func (c *client) NodePublishVolume(ctx context.Context, req *NodePublishVolumeRe
 			err = fmt.Errorf("node plugin returned an internal error, check the plugin allocation logs for more information: %v", err)
 		}
 	}
-	return err
+	return wrapRPCError(err)
 }
 
 func (c *client) NodeUnpublishVolume(ctx context.Context, volumeID, targetPath string, opts ...grpc.CallOption) error {
@@ -656,5 +940,5 @@ func (c *client) NodeUnpublishVolume(ctx context.Context, volumeID, targetPath s
 		}
 	}
 
-	return err
+	return wrapRPCError(err)
 }
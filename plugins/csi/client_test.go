@@ -65,6 +65,14 @@ func TestClient_RPC_PluginProbe(t *testing.T) {
 			},
 			ExpectedResponse: true,
 		},
+		{
+			// Probe is optional per the CSI spec; a plugin that hasn't
+			// implemented it is still reachable over gRPC, so we treat it
+			// as healthy-by-connection rather than failing liveness checks.
+			Name:             "treats Unimplemented as reachable",
+			ResponseErr:      status.Error(codes.Unimplemented, "unknown method Probe"),
+			ExpectedResponse: true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -365,6 +373,304 @@ func TestClient_RPC_NodeGetCapabilities(t *testing.T) {
 	}
 }
 
+func TestClient_RPC_ControllerCreateVolume(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerCreateVolumeRequest
+		ResponseErr      error
+		Response         *csipbv1.CreateVolumeResponse
+		ExpectedResponse *ControllerCreateVolumeResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles missing Name",
+			Request:     &ControllerCreateVolumeRequest{VolumeCapability: &VolumeCapability{}},
+			ExpectedErr: fmt.Errorf("missing Name"),
+		},
+		{
+			Name:        "handles underlying grpc errors",
+			Request:     &ControllerCreateVolumeRequest{Name: "vol", VolumeCapability: &VolumeCapability{}},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name:        "handles AlreadyExists with incompatible capabilities",
+			Request:     &ControllerCreateVolumeRequest{Name: "vol", VolumeCapability: &VolumeCapability{}},
+			ResponseErr: status.Errorf(codes.AlreadyExists, "volume with name \"vol\" already exists with different capabilities"),
+			ExpectedErr: fmt.Errorf("volume \"vol\" already exists with incompatible capabilities or parameters: rpc error: code = AlreadyExists desc = volume with name \"vol\" already exists with different capabilities"),
+		},
+		{
+			Name:        "handles missing volume ID in response",
+			Request:     &ControllerCreateVolumeRequest{Name: "vol", VolumeCapability: &VolumeCapability{}},
+			Response:    &csipbv1.CreateVolumeResponse{Volume: &csipbv1.Volume{}},
+			ExpectedErr: fmt.Errorf("plugin failed to return volume ID for volume \"vol\""),
+		},
+		{
+			Name:    "handles successful create",
+			Request: &ControllerCreateVolumeRequest{Name: "vol", VolumeCapability: &VolumeCapability{}},
+			Response: &csipbv1.CreateVolumeResponse{
+				Volume: &csipbv1.Volume{
+					VolumeId:      "vol-1",
+					CapacityBytes: 1000,
+					VolumeContext: map[string]string{"foo": "bar"},
+				},
+			},
+			ExpectedResponse: &ControllerCreateVolumeResponse{
+				ExternalVolumeID: "vol-1",
+				CapacityBytes:    1000,
+				VolumeContext:    map[string]string{"foo": "bar"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextCreateVolumeResponse = tc.Response
+
+			resp, err := client.ControllerCreateVolume(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
+// TestClient_RPC_ControllerCreateVolume_Idempotent exercises CreateVolume's
+// idempotency-by-name guarantee against a fake controller client that
+// behaves like a real storage provider: repeating a request for the same
+// name and capabilities returns the same volume, while reusing the name
+// with different capabilities is a genuine conflict.
+func TestClient_RPC_ControllerCreateVolume_Idempotent(t *testing.T) {
+	_, cc, _, client := newTestClient()
+	defer client.Close()
+
+	cc.NextCreateVolumeResponse = &csipbv1.CreateVolumeResponse{
+		Volume: &csipbv1.Volume{VolumeId: "vol-1", CapacityBytes: 1000},
+	}
+
+	req := &ControllerCreateVolumeRequest{
+		Name:             "test-volume",
+		VolumeCapability: &VolumeCapability{AccessMode: VolumeAccessModeSingleNodeWriter},
+	}
+
+	resp, err := client.ControllerCreateVolume(context.TODO(), req)
+	require.NoError(t, err)
+	require.Equal(t, "vol-1", resp.ExternalVolumeID)
+
+	// retrying the identical request is treated as success, returning the
+	// volume that was already provisioned
+	resp2, err := client.ControllerCreateVolume(context.TODO(), req)
+	require.NoError(t, err)
+	require.Equal(t, resp, resp2)
+
+	// reusing the name with a different capability is a real conflict
+	conflicting := &ControllerCreateVolumeRequest{
+		Name:             "test-volume",
+		VolumeCapability: &VolumeCapability{AccessMode: VolumeAccessModeMultiNodeMultiWriter},
+	}
+	_, err = client.ControllerCreateVolume(context.TODO(), conflicting)
+	require.EqualError(t, err,
+		`volume "test-volume" already exists with incompatible capabilities or parameters: rpc error: code = AlreadyExists desc = volume with name "test-volume" already exists with different capabilities`)
+}
+
+func TestClient_RPC_ControllerDeleteVolume(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerDeleteVolumeRequest
+		ResponseErr      error
+		Response         *csipbv1.DeleteVolumeResponse
+		ExpectedResponse *ControllerDeleteVolumeResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles missing ExternalVolumeID",
+			Request:     &ControllerDeleteVolumeRequest{},
+			ExpectedErr: fmt.Errorf("missing ExternalVolumeID"),
+		},
+		{
+			Name:        "handles underlying grpc errors",
+			Request:     &ControllerDeleteVolumeRequest{ExternalVolumeID: "vol-1"},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name:             "treats NotFound as success",
+			Request:          &ControllerDeleteVolumeRequest{ExternalVolumeID: "vol-1"},
+			ResponseErr:      status.Errorf(codes.NotFound, "volume not found"),
+			ExpectedResponse: &ControllerDeleteVolumeResponse{},
+		},
+		{
+			Name:             "handles successful delete",
+			Request:          &ControllerDeleteVolumeRequest{ExternalVolumeID: "vol-1"},
+			Response:         &csipbv1.DeleteVolumeResponse{},
+			ExpectedResponse: &ControllerDeleteVolumeResponse{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextDeleteVolumeResponse = tc.Response
+
+			resp, err := client.ControllerDeleteVolume(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
+func TestClient_RPC_ControllerExpandVolume(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerExpandVolumeRequest
+		ResponseErr      error
+		Response         *csipbv1.ControllerExpandVolumeResponse
+		ExpectedResponse *ControllerExpandVolumeResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles missing ExternalVolumeID",
+			Request:     &ControllerExpandVolumeRequest{CapacityRange: &CapacityRange{RequiredBytes: 100}},
+			ExpectedErr: fmt.Errorf("missing ExternalVolumeID"),
+		},
+		{
+			Name:        "handles missing CapacityRange",
+			Request:     &ControllerExpandVolumeRequest{ExternalVolumeID: "vol-1"},
+			ExpectedErr: fmt.Errorf("CapacityRange.RequiredBytes must be greater than 0"),
+		},
+		{
+			Name: "handles underlying grpc errors",
+			Request: &ControllerExpandVolumeRequest{
+				ExternalVolumeID: "vol-1",
+				CapacityRange:    &CapacityRange{RequiredBytes: 100},
+			},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name: "handles successful expand",
+			Request: &ControllerExpandVolumeRequest{
+				ExternalVolumeID: "vol-1",
+				CapacityRange:    &CapacityRange{RequiredBytes: 100},
+			},
+			Response: &csipbv1.ControllerExpandVolumeResponse{
+				CapacityBytes:         150,
+				NodeExpansionRequired: true,
+			},
+			ExpectedResponse: &ControllerExpandVolumeResponse{
+				CapacityBytes:         150,
+				NodeExpansionRequired: true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextExpandVolumeResponse = tc.Response
+
+			resp, err := client.ControllerExpandVolume(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
+func TestClient_RPC_ControllerListVolumes(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerListVolumesRequest
+		ResponseErr      error
+		Response         *csipbv1.ListVolumesResponse
+		ExpectedResponse *ControllerListVolumesResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles negative MaxEntries",
+			Request:     &ControllerListVolumesRequest{MaxEntries: -1},
+			ExpectedErr: fmt.Errorf("MaxEntries must not be negative"),
+		},
+		{
+			Name:        "handles underlying grpc errors",
+			Request:     &ControllerListVolumesRequest{},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name:    "handles a single page",
+			Request: &ControllerListVolumesRequest{},
+			Response: &csipbv1.ListVolumesResponse{
+				Entries: []*csipbv1.ListVolumesResponse_Entry{
+					{
+						Volume: &csipbv1.Volume{VolumeId: "vol-1", CapacityBytes: 100},
+						Status: &csipbv1.ListVolumesResponse_VolumeStatus{
+							PublishedNodeIds: []string{"node-1"},
+						},
+					},
+				},
+				NextToken: "",
+			},
+			ExpectedResponse: &ControllerListVolumesResponse{
+				Entries: []*ControllerListVolumesResponseEntry{
+					{
+						ExternalVolumeID: "vol-1",
+						CapacityBytes:    100,
+						PublishedNodeIDs: []string{"node-1"},
+					},
+				},
+				NextToken: "",
+			},
+		},
+		{
+			Name:    "forwards a non-empty NextToken",
+			Request: &ControllerListVolumesRequest{MaxEntries: 1},
+			Response: &csipbv1.ListVolumesResponse{
+				Entries:   []*csipbv1.ListVolumesResponse_Entry{},
+				NextToken: "page-2",
+			},
+			ExpectedResponse: &ControllerListVolumesResponse{
+				Entries:   []*ControllerListVolumesResponseEntry{},
+				NextToken: "page-2",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextListVolumesResponse = tc.Response
+
+			resp, err := client.ControllerListVolumes(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
 func TestClient_RPC_ControllerPublishVolume(t *testing.T) {
 	cases := []struct {
 		Name             string
@@ -476,6 +782,121 @@ func TestClient_RPC_ControllerUnpublishVolume(t *testing.T) {
 	}
 }
 
+func TestClient_RPC_ControllerCreateSnapshot(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerCreateSnapshotRequest
+		ResponseErr      error
+		Response         *csipbv1.CreateSnapshotResponse
+		ExpectedResponse *ControllerCreateSnapshotResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles missing ExternalSourceVolumeID",
+			Request:     &ControllerCreateSnapshotRequest{Name: "snap-1"},
+			ExpectedErr: fmt.Errorf("missing ExternalSourceVolumeID"),
+		},
+		{
+			Name:        "handles missing Name",
+			Request:     &ControllerCreateSnapshotRequest{ExternalSourceVolumeID: "vol-1"},
+			ExpectedErr: fmt.Errorf("missing Name"),
+		},
+		{
+			Name:        "handles underlying grpc errors",
+			Request:     &ControllerCreateSnapshotRequest{ExternalSourceVolumeID: "vol-1", Name: "snap-1"},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name:    "handles successful create",
+			Request: &ControllerCreateSnapshotRequest{ExternalSourceVolumeID: "vol-1", Name: "snap-1"},
+			Response: &csipbv1.CreateSnapshotResponse{
+				Snapshot: &csipbv1.Snapshot{
+					SnapshotId:     "snap-1",
+					SourceVolumeId: "vol-1",
+					SizeBytes:      100,
+					ReadyToUse:     true,
+				},
+			},
+			ExpectedResponse: &ControllerCreateSnapshotResponse{
+				ID:             "snap-1",
+				SourceVolumeID: "vol-1",
+				SizeBytes:      100,
+				IsReady:        true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextCreateSnapshotResponse = tc.Response
+
+			resp, err := client.ControllerCreateSnapshot(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
+func TestClient_RPC_ControllerDeleteSnapshot(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Request          *ControllerDeleteSnapshotRequest
+		ResponseErr      error
+		Response         *csipbv1.DeleteSnapshotResponse
+		ExpectedResponse *ControllerDeleteSnapshotResponse
+		ExpectedErr      error
+	}{
+		{
+			Name:        "handles missing ID",
+			Request:     &ControllerDeleteSnapshotRequest{},
+			ExpectedErr: fmt.Errorf("missing ID"),
+		},
+		{
+			Name:        "handles underlying grpc errors",
+			Request:     &ControllerDeleteSnapshotRequest{ID: "snap-1"},
+			ResponseErr: status.Errorf(codes.Internal, "some grpc error"),
+			ExpectedErr: fmt.Errorf("controller plugin returned an internal error, check the plugin allocation logs for more information: rpc error: code = Internal desc = some grpc error"),
+		},
+		{
+			Name:             "treats NotFound as success",
+			Request:          &ControllerDeleteSnapshotRequest{ID: "snap-1"},
+			ResponseErr:      status.Errorf(codes.NotFound, "snapshot not found"),
+			ExpectedResponse: &ControllerDeleteSnapshotResponse{},
+		},
+		{
+			Name:             "handles successful delete",
+			Request:          &ControllerDeleteSnapshotRequest{ID: "snap-1"},
+			Response:         &csipbv1.DeleteSnapshotResponse{},
+			ExpectedResponse: &ControllerDeleteSnapshotResponse{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, cc, _, client := newTestClient()
+			defer client.Close()
+
+			cc.NextErr = tc.ResponseErr
+			cc.NextDeleteSnapshotResponse = tc.Response
+
+			resp, err := client.ControllerDeleteSnapshot(context.TODO(), tc.Request)
+			if tc.ExpectedErr != nil {
+				require.EqualError(t, err, tc.ExpectedErr.Error())
+			}
+
+			require.Equal(t, tc.ExpectedResponse, resp)
+		})
+	}
+}
+
 func TestClient_RPC_ControllerValidateVolume(t *testing.T) {
 
 	cases := []struct {
@@ -0,0 +1,67 @@
+package csi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrVolumeAlreadyPublished is wrapped into the error returned by
+// ControllerPublishVolume when the plugin responds with codes.AlreadyExists.
+// Per the CSI spec, a plugin only returns AlreadyExists from
+// ControllerPublishVolume when the volume is already published to the node
+// with incompatible capabilities or a conflicting readonly flag; a
+// republish with matching capabilities is required to return success
+// directly, not AlreadyExists. So this is always a genuine conflict the
+// caller can't resolve by retrying, never a safe idempotent republish.
+var ErrVolumeAlreadyPublished = errors.New("volume is already published to this node with incompatible capabilities")
+
+// PluginConnectionError indicates that Nomad was unable to establish or
+// maintain a gRPC connection to the plugin process itself, as distinct from
+// the plugin successfully handling an RPC and returning an error for the
+// request. Callers can use this distinction to decide whether retrying
+// makes sense (the plugin process may simply not be up yet) rather than
+// treating it the same as a rejected request.
+type PluginConnectionError struct {
+	Err error
+}
+
+func (p *PluginConnectionError) Error() string {
+	if p.Err == nil {
+		return "could not connect to plugin"
+	}
+	return fmt.Sprintf("could not connect to plugin: %v", p.Err)
+}
+
+func (p *PluginConnectionError) Unwrap() error {
+	return p.Err
+}
+
+// PluginRPCError wraps an error returned by the plugin itself in response to
+// an RPC, as opposed to a failure to connect to the plugin at all. Its
+// message is the underlying error's message unchanged; the type itself is
+// the signal callers branch on.
+type PluginRPCError struct {
+	Err error
+}
+
+func (p *PluginRPCError) Error() string {
+	if p.Err == nil {
+		return "plugin RPC error"
+	}
+	return p.Err.Error()
+}
+
+func (p *PluginRPCError) Unwrap() error {
+	return p.Err
+}
+
+// wrapRPCError wraps a non-nil error returned by a plugin RPC in a
+// PluginRPCError, so that callers can distinguish it from a
+// PluginConnectionError. It preserves the error's message and its wrapped
+// chain, so existing errors.Is checks against sentinel errors keep working.
+func wrapRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PluginRPCError{Err: err}
+}
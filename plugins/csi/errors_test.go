@@ -0,0 +1,48 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/nomad/helper/testlog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestClient_RPC_ConnectionVsRPCError exercises the distinction the plugin
+// client makes between failing to reach the plugin at all (a dial failure)
+// and the plugin successfully handling the RPC but returning an error for
+// the request.
+func TestClient_RPC_ConnectionVsRPCError(t *testing.T) {
+	t.Run("dispense failure is a PluginConnectionError", func(t *testing.T) {
+		addr := filepath.Join(t.TempDir(), "nonexistent.sock")
+		_, err := NewClient(addr, testlog.HCLogger(t))
+		require.Error(t, err)
+
+		var connErr *PluginConnectionError
+		require.True(t, errors.As(err, &connErr))
+
+		var rpcErr *PluginRPCError
+		require.False(t, errors.As(err, &rpcErr))
+	})
+
+	t.Run("plugin-returned error is a PluginRPCError", func(t *testing.T) {
+		_, cc, _, client := newTestClient()
+		defer client.Close()
+
+		cc.NextErr = status.Errorf(codes.Internal, "some grpc error")
+
+		_, err := client.ControllerCreateVolume(context.TODO(),
+			&ControllerCreateVolumeRequest{Name: "vol", VolumeCapability: &VolumeCapability{}})
+		require.Error(t, err)
+
+		var rpcErr *PluginRPCError
+		require.True(t, errors.As(err, &rpcErr))
+
+		var connErr *PluginConnectionError
+		require.False(t, errors.As(err, &connErr))
+	})
+}
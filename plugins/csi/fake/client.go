@@ -12,6 +12,8 @@ import (
 	"github.com/hashicorp/nomad/plugins/csi"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var _ csi.CSIPlugin = &Client{}
@@ -42,17 +44,72 @@ type Client struct {
 	NextControllerGetCapabilitiesErr      error
 	ControllerGetCapabilitiesCallCount    int64
 
+	NextControllerCreateVolumeResponse *csi.ControllerCreateVolumeResponse
+	NextControllerCreateVolumeErr      error
+	ControllerCreateVolumeCallCount    int64
+
+	NextControllerDeleteVolumeResponse *csi.ControllerDeleteVolumeResponse
+	NextControllerDeleteVolumeErr      error
+	ControllerDeleteVolumeCallCount    int64
+
+	NextControllerExpandVolumeResponse *csi.ControllerExpandVolumeResponse
+	NextControllerExpandVolumeErr      error
+	ControllerExpandVolumeCallCount    int64
+
 	NextControllerPublishVolumeResponse *csi.ControllerPublishVolumeResponse
 	NextControllerPublishVolumeErr      error
 	ControllerPublishVolumeCallCount    int64
+	LastControllerPublishVolumeCtx      context.Context
+	LastControllerPublishVolumeRequest  *csi.ControllerPublishVolumeRequest
+
+	// BlockControllerPublishVolume, if set, makes ControllerPublishVolume
+	// hang until its context is canceled instead of returning immediately,
+	// to simulate a hung plugin for testing caller-side timeouts.
+	BlockControllerPublishVolume bool
+
+	// ControllerPublishVolumeFailures, if greater than zero, makes
+	// ControllerPublishVolume return a retriable codes.Unavailable error
+	// for that many calls before falling through to
+	// NextControllerPublishVolumeResponse/Err, to simulate a plugin that
+	// recovers after transient failures. It's decremented on each failing
+	// call.
+	ControllerPublishVolumeFailures int
 
 	NextControllerUnpublishVolumeResponse *csi.ControllerUnpublishVolumeResponse
 	NextControllerUnpublishVolumeErr      error
 	ControllerUnpublishVolumeCallCount    int64
 
+	// ControllerUnpublishVolumeFailures, if greater than zero, makes
+	// ControllerUnpublishVolume return a retriable codes.Unavailable error
+	// for that many calls before falling through to
+	// NextControllerUnpublishVolumeResponse/Err, to simulate a plugin that
+	// recovers after transient failures. It's decremented on each failing
+	// call.
+	ControllerUnpublishVolumeFailures int
+
 	NextControllerValidateVolumeErr   error
 	ControllerValidateVolumeCallCount int64
 
+	NextControllerCreateSnapshotResponse *csi.ControllerCreateSnapshotResponse
+	NextControllerCreateSnapshotErr      error
+	ControllerCreateSnapshotCallCount    int64
+
+	NextControllerDeleteSnapshotResponse *csi.ControllerDeleteSnapshotResponse
+	NextControllerDeleteSnapshotErr      error
+	ControllerDeleteSnapshotCallCount    int64
+
+	// ControllerListVolumesResponses is consumed one entry per call to
+	// ControllerListVolumes, in order, so tests can simulate a plugin that
+	// paginates across several calls. Once exhausted, the last response is
+	// repeated.
+	ControllerListVolumesResponses []*csi.ControllerListVolumesResponse
+	NextControllerListVolumesErr   error
+	ControllerListVolumesCallCount int64
+
+	NextControllerGetCapacityResponse *csi.ControllerGetCapacityResponse
+	NextControllerGetCapacityErr      error
+	ControllerGetCapacityCallCount    int64
+
 	NextNodeGetCapabilitiesResponse *csi.NodeCapabilitySet
 	NextNodeGetCapabilitiesErr      error
 	NodeGetCapabilitiesCallCount    int64
@@ -108,7 +165,7 @@ func (c *Client) PluginProbe(ctx context.Context) (bool, error) {
 
 // PluginGetInfo is used to return semantic data about the plugin.
 // Response:
-//  - string: name, the name of the plugin in domain notation format.
+//   - string: name, the name of the plugin in domain notation format.
 func (c *Client) PluginGetInfo(ctx context.Context) (string, string, error) {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
@@ -139,13 +196,60 @@ func (c *Client) ControllerGetCapabilities(ctx context.Context) (*csi.Controller
 	return c.NextControllerGetCapabilitiesResponse, c.NextControllerGetCapabilitiesErr
 }
 
-// ControllerPublishVolume is used to attach a remote volume to a node
-func (c *Client) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerPublishVolumeResponse, error) {
+// ControllerCreateVolume is used to provision a volume on the remote
+// storage provider.
+func (c *Client) ControllerCreateVolume(ctx context.Context, req *csi.ControllerCreateVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerCreateVolumeResponse, error) {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
 
-	c.ControllerPublishVolumeCallCount++
+	c.ControllerCreateVolumeCallCount++
+
+	return c.NextControllerCreateVolumeResponse, c.NextControllerCreateVolumeErr
+}
+
+// ControllerDeleteVolume is used to reclaim the storage consumed by a
+// volume on the remote storage provider.
+func (c *Client) ControllerDeleteVolume(ctx context.Context, req *csi.ControllerDeleteVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerDeleteVolumeResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	c.ControllerDeleteVolumeCallCount++
+
+	return c.NextControllerDeleteVolumeResponse, c.NextControllerDeleteVolumeErr
+}
+
+// ControllerExpandVolume is used to resize a volume on the remote storage
+// provider.
+func (c *Client) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerExpandVolumeResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	c.ControllerExpandVolumeCallCount++
 
+	return c.NextControllerExpandVolumeResponse, c.NextControllerExpandVolumeErr
+}
+
+// ControllerPublishVolume is used to attach a remote volume to a node
+func (c *Client) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerPublishVolumeResponse, error) {
+	c.Mu.Lock()
+	c.ControllerPublishVolumeCallCount++
+	c.LastControllerPublishVolumeCtx = ctx
+	c.LastControllerPublishVolumeRequest = req
+	block := c.BlockControllerPublishVolume
+	if c.ControllerPublishVolumeFailures > 0 {
+		c.ControllerPublishVolumeFailures--
+		c.Mu.Unlock()
+		return nil, status.Error(codes.Unavailable, "fake controller temporarily unavailable")
+	}
+	c.Mu.Unlock()
+
+	if block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	c.Mu.RLock()
+	defer c.Mu.RUnlock()
 	return c.NextControllerPublishVolumeResponse, c.NextControllerPublishVolumeErr
 }
 
@@ -156,6 +260,11 @@ func (c *Client) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 
 	c.ControllerUnpublishVolumeCallCount++
 
+	if c.ControllerUnpublishVolumeFailures > 0 {
+		c.ControllerUnpublishVolumeFailures--
+		return nil, status.Error(codes.Unavailable, "fake controller temporarily unavailable")
+	}
+
 	return c.NextControllerUnpublishVolumeResponse, c.NextControllerUnpublishVolumeErr
 }
 
@@ -168,6 +277,56 @@ func (c *Client) ControllerValidateCapabilities(ctx context.Context, req *csi.Co
 	return c.NextControllerValidateVolumeErr
 }
 
+// ControllerCreateSnapshot is used to create a point-in-time snapshot of a volume.
+func (c *Client) ControllerCreateSnapshot(ctx context.Context, req *csi.ControllerCreateSnapshotRequest, opts ...grpc.CallOption) (*csi.ControllerCreateSnapshotResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	c.ControllerCreateSnapshotCallCount++
+
+	return c.NextControllerCreateSnapshotResponse, c.NextControllerCreateSnapshotErr
+}
+
+// ControllerDeleteSnapshot is used to reclaim the storage consumed by a snapshot.
+func (c *Client) ControllerDeleteSnapshot(ctx context.Context, req *csi.ControllerDeleteSnapshotRequest, opts ...grpc.CallOption) (*csi.ControllerDeleteSnapshotResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	c.ControllerDeleteSnapshotCallCount++
+
+	return c.NextControllerDeleteSnapshotResponse, c.NextControllerDeleteSnapshotErr
+}
+
+// ControllerListVolumes returns the next response queued in
+// ControllerListVolumesResponses, simulating a plugin that paginates its
+// ListVolumes results across successive calls.
+func (c *Client) ControllerListVolumes(ctx context.Context, req *csi.ControllerListVolumesRequest, opts ...grpc.CallOption) (*csi.ControllerListVolumesResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	idx := c.ControllerListVolumesCallCount
+	c.ControllerListVolumesCallCount++
+
+	if c.NextControllerListVolumesErr != nil {
+		return nil, c.NextControllerListVolumesErr
+	}
+	if len(c.ControllerListVolumesResponses) == 0 {
+		return nil, nil
+	}
+	if int(idx) >= len(c.ControllerListVolumesResponses) {
+		return c.ControllerListVolumesResponses[len(c.ControllerListVolumesResponses)-1], nil
+	}
+	return c.ControllerListVolumesResponses[idx], nil
+}
+
+func (c *Client) ControllerGetCapacity(ctx context.Context, req *csi.ControllerGetCapacityRequest, opts ...grpc.CallOption) (*csi.ControllerGetCapacityResponse, error) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.ControllerGetCapacityCallCount++
+
+	return c.NextControllerGetCapacityResponse, c.NextControllerGetCapacityErr
+}
+
 func (c *Client) NodeGetCapabilities(ctx context.Context) (*csi.NodeCapabilitySet, error) {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
@@ -252,6 +411,15 @@ func (c *Client) Close() error {
 	c.NextControllerGetCapabilitiesResponse = nil
 	c.NextControllerGetCapabilitiesErr = fmt.Errorf("closed client")
 
+	c.NextControllerCreateVolumeResponse = nil
+	c.NextControllerCreateVolumeErr = fmt.Errorf("closed client")
+
+	c.NextControllerDeleteVolumeResponse = nil
+	c.NextControllerDeleteVolumeErr = fmt.Errorf("closed client")
+
+	c.NextControllerExpandVolumeResponse = nil
+	c.NextControllerExpandVolumeErr = fmt.Errorf("closed client")
+
 	c.NextControllerPublishVolumeResponse = nil
 	c.NextControllerPublishVolumeErr = fmt.Errorf("closed client")
 
@@ -260,6 +428,18 @@ func (c *Client) Close() error {
 
 	c.NextControllerValidateVolumeErr = fmt.Errorf("closed client")
 
+	c.NextControllerCreateSnapshotResponse = nil
+	c.NextControllerCreateSnapshotErr = fmt.Errorf("closed client")
+
+	c.NextControllerDeleteSnapshotResponse = nil
+	c.NextControllerDeleteSnapshotErr = fmt.Errorf("closed client")
+
+	c.ControllerListVolumesResponses = nil
+	c.NextControllerListVolumesErr = fmt.Errorf("closed client")
+
+	c.NextControllerGetCapacityResponse = nil
+	c.NextControllerGetCapacityErr = fmt.Errorf("closed client")
+
 	c.NextNodeGetCapabilitiesResponse = nil
 	c.NextNodeGetCapabilitiesErr = fmt.Errorf("closed client")
 
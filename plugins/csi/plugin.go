@@ -4,13 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/hashicorp/nomad/helper"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/base"
 	"google.golang.org/grpc"
 )
 
+// csiFSFormatPolicyContextKey is the VolumeContext key used to forward a
+// CSIFSFormatPolicyNever request to the node plugin during staging. The CSI
+// spec has no dedicated field for this, so it rides along in VolumeContext,
+// the same generic passthrough mechanism used for other plugin-specific
+// data; plugins that don't recognize the key simply ignore it.
+const csiFSFormatPolicyContextKey = "csi.hashicorp.com/fs-format-policy"
+
+// RequestedDevicePathContextKey is the VolumeContext key used to forward a
+// caller-requested device path for a block-mode ControllerPublishVolume
+// call. Like csiFSFormatPolicyContextKey, this rides along in VolumeContext
+// since the CSI spec has no dedicated field for it; plugins that don't
+// recognize the key simply ignore it.
+const RequestedDevicePathContextKey = "csi.hashicorp.com/requested-device-path"
+
 // CSIPlugin implements a lightweight abstraction layer around a CSI Plugin.
 // It validates that responses from storage providers (SP's), correctly conform
 // to the specification before returning response data or erroring.
@@ -35,6 +51,35 @@ type CSIPlugin interface {
 	// for a plugin.
 	ControllerGetCapabilities(ctx context.Context) (*ControllerCapabilitySet, error)
 
+	// ControllerGetCapacity is used to query the remaining storage capacity
+	// available for volumes matching the request's (entirely optional)
+	// filters, so that callers can make capacity-aware scheduling decisions
+	// before provisioning large volumes. Not every plugin supports this RPC,
+	// and ControllerCapabilitySet does not record whether a plugin does.
+	ControllerGetCapacity(ctx context.Context, req *ControllerGetCapacityRequest, opts ...grpc.CallOption) (*ControllerGetCapacityResponse, error)
+
+	// ControllerCreateVolume is used to provision a volume on the remote
+	// storage provider. CreateVolume is idempotent by name: retrying a call
+	// for a name that was already successfully provisioned returns the
+	// existing volume rather than an error.
+	ControllerCreateVolume(ctx context.Context, req *ControllerCreateVolumeRequest, opts ...grpc.CallOption) (*ControllerCreateVolumeResponse, error)
+
+	// ControllerDeleteVolume is used to reclaim the storage consumed by a
+	// volume on the remote storage provider once it's no longer needed.
+	// DeleteVolume is idempotent by volume ID: deleting a volume that's
+	// already gone is treated as success rather than an error.
+	ControllerDeleteVolume(ctx context.Context, req *ControllerDeleteVolumeRequest, opts ...grpc.CallOption) (*ControllerDeleteVolumeResponse, error)
+
+	// ControllerExpandVolume is used to resize a volume on the remote
+	// storage provider while it may still be in use.
+	ControllerExpandVolume(ctx context.Context, req *ControllerExpandVolumeRequest, opts ...grpc.CallOption) (*ControllerExpandVolumeResponse, error)
+
+	// ControllerListVolumes returns a single page of the volumes known to
+	// the storage provider, for reconciling them against Nomad's view. A
+	// non-empty NextToken in the response means more entries remain; pass
+	// it back as StartingToken to continue.
+	ControllerListVolumes(ctx context.Context, req *ControllerListVolumesRequest, opts ...grpc.CallOption) (*ControllerListVolumesResponse, error)
+
 	// ControllerPublishVolume is used to attach a remote volume to a cluster node.
 	ControllerPublishVolume(ctx context.Context, req *ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*ControllerPublishVolumeResponse, error)
 
@@ -45,6 +90,16 @@ type CSIPlugin interface {
 	// supports the requested capability.
 	ControllerValidateCapabilities(ctx context.Context, req *ControllerValidateVolumeRequest, opts ...grpc.CallOption) error
 
+	// ControllerCreateSnapshot is used to create a point-in-time snapshot
+	// of a single volume on the remote storage provider.
+	ControllerCreateSnapshot(ctx context.Context, req *ControllerCreateSnapshotRequest, opts ...grpc.CallOption) (*ControllerCreateSnapshotResponse, error)
+
+	// ControllerDeleteSnapshot is used to reclaim the storage consumed by a
+	// snapshot on the remote storage provider once it's no longer needed.
+	// DeleteSnapshot is idempotent by snapshot ID: deleting a snapshot
+	// that's already gone is treated as success rather than an error.
+	ControllerDeleteSnapshot(ctx context.Context, req *ControllerDeleteSnapshotRequest, opts ...grpc.CallOption) (*ControllerDeleteSnapshotResponse, error)
+
 	// NodeGetCapabilities is used to return the available capabilities from the
 	// Node Service.
 	NodeGetCapabilities(ctx context.Context) (*NodeCapabilitySet, error)
@@ -191,13 +246,23 @@ func (r *NodeStageVolumeRequest) ToCSIRepresentation() *csipbv1.NodeStageVolumeR
 		return nil
 	}
 
+	volumeContext := r.VolumeContext
+	if r.VolumeCapability != nil && r.VolumeCapability.MountVolume != nil &&
+		r.VolumeCapability.MountVolume.FSFormatPolicy == structs.CSIFSFormatPolicyNever {
+		volumeContext = helper.CopyMapStringString(volumeContext)
+		if volumeContext == nil {
+			volumeContext = make(map[string]string, 1)
+		}
+		volumeContext[csiFSFormatPolicyContextKey] = structs.CSIFSFormatPolicyNever
+	}
+
 	return &csipbv1.NodeStageVolumeRequest{
 		VolumeId:          r.ExternalID,
 		PublishContext:    r.PublishContext,
 		StagingTargetPath: r.StagingTargetPath,
 		VolumeCapability:  r.VolumeCapability.ToCSIRepresentation(),
 		Secrets:           r.Secrets,
-		VolumeContext:     r.VolumeContext,
+		VolumeContext:     volumeContext,
 	}
 }
 
@@ -267,11 +332,22 @@ func NewPluginCapabilitySet(capabilities *csipbv1.GetPluginCapabilitiesResponse)
 	return cs
 }
 
+// ControllerCapabilitySet does not currently include a GetVolume
+// capability: the CSI v1 spec version vendored by this build predates the
+// controller GetVolume RPC and its RPC_GET_VOLUME capability bit, so there's
+// no ControllerGetVolumeRequest/Response to decode a response into. Before
+// adding a ControllerGetVolume method here (e.g. to support capturing a
+// volume's published-nodes state for attach/detach auditing), the vendored
+// github.com/container-storage-interface/spec needs to be upgraded to a
+// version that defines it.
 type ControllerCapabilitySet struct {
 	HasPublishUnpublishVolume    bool
 	HasPublishReadonly           bool
 	HasListVolumes               bool
 	HasListVolumesPublishedNodes bool
+	HasCreateDeleteSnapshot      bool
+	HasCreateDeleteVolume        bool
+	HasExpandVolume              bool
 }
 
 func NewControllerCapabilitySet(resp *csipbv1.ControllerGetCapabilitiesResponse) *ControllerCapabilitySet {
@@ -289,6 +365,12 @@ func NewControllerCapabilitySet(resp *csipbv1.ControllerGetCapabilitiesResponse)
 				cs.HasListVolumes = true
 			case csipbv1.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES:
 				cs.HasListVolumesPublishedNodes = true
+			case csipbv1.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT:
+				cs.HasCreateDeleteSnapshot = true
+			case csipbv1.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME:
+				cs.HasCreateDeleteVolume = true
+			case csipbv1.ControllerServiceCapability_RPC_EXPAND_VOLUME:
+				cs.HasExpandVolume = true
 			default:
 				continue
 			}
@@ -322,6 +404,280 @@ func (r *ControllerValidateVolumeRequest) ToCSIRepresentation() *csipbv1.Validat
 	}
 }
 
+// ControllerGetCapacityRequest is the input to ControllerGetCapacity. All
+// fields are optional filters: an empty request asks for the storage
+// provider's total remaining capacity.
+type ControllerGetCapacityRequest struct {
+	Capabilities       *VolumeCapability
+	Parameters         map[string]string
+	AccessibleTopology *Topology
+}
+
+func (r *ControllerGetCapacityRequest) ToCSIRepresentation() *csipbv1.GetCapacityRequest {
+	if r == nil {
+		return nil
+	}
+
+	req := &csipbv1.GetCapacityRequest{
+		Parameters: r.Parameters,
+	}
+	if r.Capabilities != nil {
+		req.VolumeCapabilities = []*csipbv1.VolumeCapability{r.Capabilities.ToCSIRepresentation()}
+	}
+	if r.AccessibleTopology != nil {
+		req.AccessibleTopology = &csipbv1.Topology{Segments: r.AccessibleTopology.Segments}
+	}
+	return req
+}
+
+// ControllerGetCapacityResponse is the result of ControllerGetCapacity.
+type ControllerGetCapacityResponse struct {
+	// AvailableCapacity is the available capacity, in bytes, of the storage
+	// matching the request's filters.
+	AvailableCapacity int64
+}
+
+// ControllerCreateSnapshotRequest is the input to ControllerCreateSnapshot,
+// requesting a single point-in-time snapshot of one volume.
+type ControllerCreateSnapshotRequest struct {
+	// ExternalSourceVolumeID is the external ID of the volume to snapshot.
+	ExternalSourceVolumeID string
+
+	// Name is the suggested name for the snapshot, generated by the CO to
+	// achieve idempotency. The SP MUST NOT create more than one snapshot
+	// for the same name.
+	Name string
+
+	Secrets    structs.CSISecrets
+	Parameters map[string]string
+}
+
+func (r *ControllerCreateSnapshotRequest) ToCSIRepresentation() *csipbv1.CreateSnapshotRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.CreateSnapshotRequest{
+		SourceVolumeId: r.ExternalSourceVolumeID,
+		Name:           r.Name,
+		Secrets:        r.Secrets,
+		Parameters:     r.Parameters,
+	}
+}
+
+func (r *ControllerCreateSnapshotRequest) Validate() error {
+	if r.ExternalSourceVolumeID == "" {
+		return errors.New("missing ExternalSourceVolumeID")
+	}
+	if r.Name == "" {
+		return errors.New("missing Name")
+	}
+	return nil
+}
+
+// ControllerCreateSnapshotResponse describes the snapshot a successful
+// ControllerCreateSnapshot call created.
+type ControllerCreateSnapshotResponse struct {
+	ID             string
+	SourceVolumeID string
+	SizeBytes      int64
+	CreateTime     int64
+	IsReady        bool
+}
+
+// ControllerDeleteSnapshotRequest is the input to ControllerDeleteSnapshot.
+type ControllerDeleteSnapshotRequest struct {
+	ID      string
+	Secrets structs.CSISecrets
+}
+
+func (r *ControllerDeleteSnapshotRequest) ToCSIRepresentation() *csipbv1.DeleteSnapshotRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.DeleteSnapshotRequest{
+		SnapshotId: r.ID,
+		Secrets:    r.Secrets,
+	}
+}
+
+func (r *ControllerDeleteSnapshotRequest) Validate() error {
+	if r.ID == "" {
+		return errors.New("missing ID")
+	}
+	return nil
+}
+
+type ControllerDeleteSnapshotResponse struct{}
+
+// ControllerListVolumesRequest is the input to ControllerListVolumes. A
+// zero MaxEntries means the caller wants every volume; a non-zero value
+// bounds a single page, to be continued with StartingToken set to the
+// previous response's NextToken.
+type ControllerListVolumesRequest struct {
+	MaxEntries    int32
+	StartingToken string
+}
+
+func (r *ControllerListVolumesRequest) ToCSIRepresentation() *csipbv1.ListVolumesRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.ListVolumesRequest{
+		MaxEntries:    r.MaxEntries,
+		StartingToken: r.StartingToken,
+	}
+}
+
+func (r *ControllerListVolumesRequest) Validate() error {
+	if r.MaxEntries < 0 {
+		return errors.New("MaxEntries must not be negative")
+	}
+	return nil
+}
+
+// ControllerListVolumesResponse is the result of a single ControllerListVolumes
+// page. NextToken is empty once every volume has been returned.
+type ControllerListVolumesResponse struct {
+	Entries   []*ControllerListVolumesResponseEntry
+	NextToken string
+}
+
+// ControllerListVolumesResponseEntry describes a single volume known to the
+// storage provider.
+type ControllerListVolumesResponseEntry struct {
+	ExternalVolumeID string
+	CapacityBytes    int64
+	PublishedNodeIDs []string
+}
+
+type ControllerCreateVolumeRequest struct {
+	// Name is the suggested name for the volume, generated by the CO to
+	// achieve idempotency. The SP MUST NOT provision more than one volume
+	// for the same name.
+	Name string
+
+	CapacityRange    *CapacityRange
+	VolumeCapability *VolumeCapability
+	Parameters       map[string]string
+	Secrets          structs.CSISecrets
+}
+
+func (r *ControllerCreateVolumeRequest) ToCSIRepresentation() *csipbv1.CreateVolumeRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.CreateVolumeRequest{
+		Name:          r.Name,
+		CapacityRange: r.CapacityRange.ToCSIRepresentation(),
+		VolumeCapabilities: []*csipbv1.VolumeCapability{
+			r.VolumeCapability.ToCSIRepresentation(),
+		},
+		Parameters: r.Parameters,
+		Secrets:    r.Secrets,
+	}
+}
+
+func (r *ControllerCreateVolumeRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("missing Name")
+	}
+	if r.VolumeCapability == nil {
+		return errors.New("missing VolumeCapabilities")
+	}
+	return nil
+}
+
+type ControllerCreateVolumeResponse struct {
+	ExternalVolumeID string
+	CapacityBytes    int64
+	VolumeContext    map[string]string
+}
+
+type ControllerDeleteVolumeRequest struct {
+	ExternalVolumeID string
+	Secrets          structs.CSISecrets
+}
+
+func (r *ControllerDeleteVolumeRequest) ToCSIRepresentation() *csipbv1.DeleteVolumeRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.DeleteVolumeRequest{
+		VolumeId: r.ExternalVolumeID,
+		Secrets:  r.Secrets,
+	}
+}
+
+func (r *ControllerDeleteVolumeRequest) Validate() error {
+	if r.ExternalVolumeID == "" {
+		return errors.New("missing ExternalVolumeID")
+	}
+	return nil
+}
+
+type ControllerDeleteVolumeResponse struct{}
+
+// ControllerExpandVolumeRequest is used to resize a volume on the remote
+// storage provider, which may still be attached to a node and in use.
+type ControllerExpandVolumeRequest struct {
+	ExternalVolumeID string
+	CapacityRange    *CapacityRange
+	Secrets          structs.CSISecrets
+}
+
+func (r *ControllerExpandVolumeRequest) ToCSIRepresentation() *csipbv1.ControllerExpandVolumeRequest {
+	if r == nil {
+		return nil
+	}
+
+	return &csipbv1.ControllerExpandVolumeRequest{
+		VolumeId:      r.ExternalVolumeID,
+		CapacityRange: r.CapacityRange.ToCSIRepresentation(),
+		Secrets:       r.Secrets,
+	}
+}
+
+func (r *ControllerExpandVolumeRequest) Validate() error {
+	if r.ExternalVolumeID == "" {
+		return errors.New("missing ExternalVolumeID")
+	}
+	if r.CapacityRange == nil || r.CapacityRange.RequiredBytes <= 0 {
+		return errors.New("CapacityRange.RequiredBytes must be greater than 0")
+	}
+	return nil
+}
+
+// ControllerExpandVolumeResponse reports the actual capacity of the volume
+// after expansion, which may be larger than what was requested.
+type ControllerExpandVolumeResponse struct {
+	CapacityBytes         int64
+	NodeExpansionRequired bool
+}
+
+// CapacityRange describes the minimum and maximum capacity a CO will
+// accept for a newly provisioned volume. Either field may be left unset
+// (0) to indicate no bound on that end of the range.
+type CapacityRange struct {
+	RequiredBytes int64
+	LimitBytes    int64
+}
+
+func (c *CapacityRange) ToCSIRepresentation() *csipbv1.CapacityRange {
+	if c == nil {
+		return nil
+	}
+
+	return &csipbv1.CapacityRange{
+		RequiredBytes: c.RequiredBytes,
+		LimitBytes:    c.LimitBytes,
+	}
+}
+
 type ControllerPublishVolumeRequest struct {
 	ExternalID       string
 	NodeID           string
@@ -360,6 +716,42 @@ type ControllerPublishVolumeResponse struct {
 	PublishContext map[string]string
 }
 
+// PublishContextDevicePathKey is the PublishContext key that block-storage
+// CSI plugins conventionally use to return the host device path a volume
+// was attached to (following the convention set by Kubernetes' external-
+// attacher sidecar). It's not mandated by the CSI spec, so this is a
+// best-effort lookup rather than a guarantee that every block plugin sets it.
+const PublishContextDevicePathKey = "devicePath"
+
+// PublishContextDevicePathsKey is the PublishContext key some multipath-
+// aware block-storage plugins use to return every host device path a
+// volume was attached over, as a comma-separated list, so that HA storage
+// backends (such as iSCSI with multiple sessions) can be used with device-
+// mapper multipathing rather than exposing only a single path. Like
+// PublishContextDevicePathKey, this is a best-effort convention rather
+// than something mandated by the CSI spec.
+const PublishContextDevicePathsKey = "devicePaths"
+
+// ParseMultipathDevicePaths extracts the device paths set by a multipath-
+// aware plugin under PublishContextDevicePathsKey, splitting the comma-
+// separated value and discarding empty entries. It returns nil if the key
+// isn't set.
+func ParseMultipathDevicePaths(publishContext map[string]string) []string {
+	raw, ok := publishContext[PublishContextDevicePathsKey]
+	if !ok {
+		return nil
+	}
+
+	var paths []string
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
 type ControllerUnpublishVolumeRequest struct {
 	ExternalID string
 	NodeID     string
@@ -507,6 +899,28 @@ func VolumeCapabilityFromStructs(sAccessType structs.CSIVolumeAttachmentMode, sA
 	}, nil
 }
 
+// ValidateVolumeCapabilities checks that attachmentMode and accessMode are
+// each individually recognized, and that together they form a combination
+// the storage backend can actually satisfy. It's meant to be called before
+// dispatching to a plugin, so that an obviously invalid combination fails
+// fast with a local, descriptive error instead of an opaque one from the
+// plugin (or silent data loss from concurrent writers the plugin doesn't
+// actually arbitrate).
+func ValidateVolumeCapabilities(attachmentMode structs.CSIVolumeAttachmentMode, accessMode structs.CSIVolumeAccessMode) error {
+	if _, err := VolumeCapabilityFromStructs(attachmentMode, accessMode); err != nil {
+		return err
+	}
+
+	if accessMode == structs.CSIVolumeAccessModeMultiNodeMultiWriter &&
+		attachmentMode == structs.CSIVolumeAttachmentModeFilesystem {
+		return fmt.Errorf(
+			"volume capability is invalid: %s access mode is not supported for %s attachment mode, as most filesystems do not support concurrent writers across nodes; use %s instead and coordinate writes in the application",
+			accessMode, attachmentMode, structs.CSIVolumeAttachmentModeBlockDevice)
+	}
+
+	return nil
+}
+
 func (c *VolumeCapability) ToCSIRepresentation() *csipbv1.VolumeCapability {
 	if c == nil {
 		return nil
@@ -0,0 +1,138 @@
+package csi
+
+import (
+	"testing"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultipathDevicePaths(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name           string
+		PublishContext map[string]string
+		Expected       []string
+	}{
+		{
+			Name:           "key not set",
+			PublishContext: map[string]string{"foo": "bar"},
+			Expected:       nil,
+		},
+		{
+			Name:           "nil publish context",
+			PublishContext: nil,
+			Expected:       nil,
+		},
+		{
+			Name:           "single path",
+			PublishContext: map[string]string{PublishContextDevicePathsKey: "/dev/sda"},
+			Expected:       []string{"/dev/sda"},
+		},
+		{
+			Name:           "multiple paths with surrounding whitespace",
+			PublishContext: map[string]string{PublishContextDevicePathsKey: "/dev/sda, /dev/sdb , /dev/sdc"},
+			Expected:       []string{"/dev/sda", "/dev/sdb", "/dev/sdc"},
+		},
+		{
+			Name:           "drops empty entries",
+			PublishContext: map[string]string{PublishContextDevicePathsKey: "/dev/sda,,/dev/sdb,"},
+			Expected:       []string{"/dev/sda", "/dev/sdb"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, ParseMultipathDevicePaths(tc.PublishContext))
+		})
+	}
+}
+
+// TestNodeStageVolumeRequest_ToCSIRepresentation_FSFormatPolicy covers
+// staging an unformatted volume under both fs_format_policy settings: auto
+// preserves today's behavior by leaving VolumeContext untouched, while
+// never forwards a hint to the node plugin so it can refuse to format.
+func TestNodeStageVolumeRequest_ToCSIRepresentation_FSFormatPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name                  string
+		FSFormatPolicy        string
+		ExpectedVolumeContext map[string]string
+	}{
+		{
+			Name:                  "auto preserves current behavior",
+			FSFormatPolicy:        structs.CSIFSFormatPolicyAuto,
+			ExpectedVolumeContext: nil,
+		},
+		{
+			Name:                  "unset preserves current behavior",
+			FSFormatPolicy:        "",
+			ExpectedVolumeContext: nil,
+		},
+		{
+			Name:           "never forwards a hint to the plugin",
+			FSFormatPolicy: structs.CSIFSFormatPolicyNever,
+			ExpectedVolumeContext: map[string]string{
+				csiFSFormatPolicyContextKey: structs.CSIFSFormatPolicyNever,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := &NodeStageVolumeRequest{
+				ExternalID:        "vol-1",
+				StagingTargetPath: "/path",
+				VolumeCapability: &VolumeCapability{
+					AccessType: VolumeAccessTypeMount,
+					AccessMode: VolumeAccessModeSingleNodeWriter,
+					MountVolume: &structs.CSIMountOptions{
+						FSFormatPolicy: tc.FSFormatPolicy,
+					},
+				},
+			}
+
+			pbRequest := req.ToCSIRepresentation()
+			require.Equal(t, tc.ExpectedVolumeContext, pbRequest.VolumeContext)
+		})
+	}
+}
+
+// TestControllerGetCapacityRequest_ToCSIRepresentation covers that the
+// optional topology and parameters filters are forwarded to the plugin
+// call unchanged, since an empty request shouldn't accidentally strip
+// them.
+func TestControllerGetCapacityRequest_ToCSIRepresentation(t *testing.T) {
+	t.Parallel()
+
+	req := &ControllerGetCapacityRequest{
+		Capabilities: &VolumeCapability{
+			AccessType: VolumeAccessTypeMount,
+			AccessMode: VolumeAccessModeSingleNodeWriter,
+		},
+		Parameters: map[string]string{"type": "pd-ssd"},
+		AccessibleTopology: &Topology{
+			Segments: map[string]string{"zone": "us-east-1a"},
+		},
+	}
+
+	pbRequest := req.ToCSIRepresentation()
+	require.Equal(t, map[string]string{"type": "pd-ssd"}, pbRequest.Parameters)
+	require.Equal(t, &csipbv1.Topology{Segments: map[string]string{"zone": "us-east-1a"}}, pbRequest.AccessibleTopology)
+	require.Len(t, pbRequest.VolumeCapabilities, 1)
+}
+
+// TestControllerGetCapacityRequest_ToCSIRepresentation_Empty covers that an
+// entirely empty request (asking for total remaining capacity) doesn't
+// panic and leaves the optional fields unset.
+func TestControllerGetCapacityRequest_ToCSIRepresentation_Empty(t *testing.T) {
+	t.Parallel()
+
+	req := &ControllerGetCapacityRequest{}
+	pbRequest := req.ToCSIRepresentation()
+	require.Nil(t, pbRequest.AccessibleTopology)
+	require.Nil(t, pbRequest.VolumeCapabilities)
+}
@@ -2,9 +2,12 @@ package testing
 
 import (
 	"context"
+	"reflect"
 
 	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // IdentityClient is a CSI identity client used for testing
@@ -46,28 +49,81 @@ func (f *IdentityClient) Probe(ctx context.Context, in *csipbv1.ProbeRequest, op
 type ControllerClient struct {
 	NextErr                                error
 	NextCapabilitiesResponse               *csipbv1.ControllerGetCapabilitiesResponse
+	NextCreateVolumeResponse               *csipbv1.CreateVolumeResponse
+	NextDeleteVolumeResponse               *csipbv1.DeleteVolumeResponse
+	NextExpandVolumeResponse               *csipbv1.ControllerExpandVolumeResponse
 	NextPublishVolumeResponse              *csipbv1.ControllerPublishVolumeResponse
 	NextUnpublishVolumeResponse            *csipbv1.ControllerUnpublishVolumeResponse
 	NextValidateVolumeCapabilitiesResponse *csipbv1.ValidateVolumeCapabilitiesResponse
+	NextCreateSnapshotResponse             *csipbv1.CreateSnapshotResponse
+	NextDeleteSnapshotResponse             *csipbv1.DeleteSnapshotResponse
+	NextListVolumesResponse                *csipbv1.ListVolumesResponse
+	NextGetCapacityResponse                *csipbv1.GetCapacityResponse
+
+	// createdVolumes tracks volumes provisioned by CreateVolume, keyed by
+	// the requested name, so that repeated calls for the same name can be
+	// answered idempotently like a real storage provider would.
+	createdVolumes map[string]*csipbv1.CreateVolumeRequest
 }
 
 // NewControllerClient returns a new ControllerClient
 func NewControllerClient() *ControllerClient {
-	return &ControllerClient{}
+	return &ControllerClient{
+		createdVolumes: make(map[string]*csipbv1.CreateVolumeRequest),
+	}
 }
 
 func (f *ControllerClient) Reset() {
 	f.NextErr = nil
 	f.NextCapabilitiesResponse = nil
+	f.NextCreateVolumeResponse = nil
+	f.NextDeleteVolumeResponse = nil
+	f.NextExpandVolumeResponse = nil
 	f.NextPublishVolumeResponse = nil
 	f.NextUnpublishVolumeResponse = nil
 	f.NextValidateVolumeCapabilitiesResponse = nil
+	f.NextCreateSnapshotResponse = nil
+	f.NextDeleteSnapshotResponse = nil
+	f.NextListVolumesResponse = nil
+	f.NextGetCapacityResponse = nil
+	f.createdVolumes = make(map[string]*csipbv1.CreateVolumeRequest)
 }
 
 func (c *ControllerClient) ControllerGetCapabilities(ctx context.Context, in *csipbv1.ControllerGetCapabilitiesRequest, opts ...grpc.CallOption) (*csipbv1.ControllerGetCapabilitiesResponse, error) {
 	return c.NextCapabilitiesResponse, c.NextErr
 }
 
+// CreateVolume simulates a storage provider that enforces idempotency by
+// name: a request that repeats the volume_capabilities and parameters of
+// one it has already served returns the same response, while a request
+// that reuses a name with different capabilities or parameters returns
+// AlreadyExists, per the CSI spec.
+func (c *ControllerClient) CreateVolume(ctx context.Context, in *csipbv1.CreateVolumeRequest, opts ...grpc.CallOption) (*csipbv1.CreateVolumeResponse, error) {
+	if c.NextErr != nil {
+		return nil, c.NextErr
+	}
+
+	if prior, ok := c.createdVolumes[in.Name]; ok {
+		if !reflect.DeepEqual(prior.VolumeCapabilities, in.VolumeCapabilities) ||
+			!reflect.DeepEqual(prior.Parameters, in.Parameters) {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"volume with name %q already exists with different capabilities", in.Name)
+		}
+		return c.NextCreateVolumeResponse, nil
+	}
+
+	c.createdVolumes[in.Name] = in
+	return c.NextCreateVolumeResponse, nil
+}
+
+func (c *ControllerClient) DeleteVolume(ctx context.Context, in *csipbv1.DeleteVolumeRequest, opts ...grpc.CallOption) (*csipbv1.DeleteVolumeResponse, error) {
+	return c.NextDeleteVolumeResponse, c.NextErr
+}
+
+func (c *ControllerClient) ControllerExpandVolume(ctx context.Context, in *csipbv1.ControllerExpandVolumeRequest, opts ...grpc.CallOption) (*csipbv1.ControllerExpandVolumeResponse, error) {
+	return c.NextExpandVolumeResponse, c.NextErr
+}
+
 func (c *ControllerClient) ControllerPublishVolume(ctx context.Context, in *csipbv1.ControllerPublishVolumeRequest, opts ...grpc.CallOption) (*csipbv1.ControllerPublishVolumeResponse, error) {
 	return c.NextPublishVolumeResponse, c.NextErr
 }
@@ -80,6 +136,22 @@ func (c *ControllerClient) ValidateVolumeCapabilities(ctx context.Context, in *c
 	return c.NextValidateVolumeCapabilitiesResponse, c.NextErr
 }
 
+func (c *ControllerClient) CreateSnapshot(ctx context.Context, in *csipbv1.CreateSnapshotRequest, opts ...grpc.CallOption) (*csipbv1.CreateSnapshotResponse, error) {
+	return c.NextCreateSnapshotResponse, c.NextErr
+}
+
+func (c *ControllerClient) DeleteSnapshot(ctx context.Context, in *csipbv1.DeleteSnapshotRequest, opts ...grpc.CallOption) (*csipbv1.DeleteSnapshotResponse, error) {
+	return c.NextDeleteSnapshotResponse, c.NextErr
+}
+
+func (c *ControllerClient) ListVolumes(ctx context.Context, in *csipbv1.ListVolumesRequest, opts ...grpc.CallOption) (*csipbv1.ListVolumesResponse, error) {
+	return c.NextListVolumesResponse, c.NextErr
+}
+
+func (c *ControllerClient) GetCapacity(ctx context.Context, in *csipbv1.GetCapacityRequest, opts ...grpc.CallOption) (*csipbv1.GetCapacityResponse, error) {
+	return c.NextGetCapacityResponse, c.NextErr
+}
+
 // NodeClient is a CSI Node client used for testing
 type NodeClient struct {
 	NextErr                     error
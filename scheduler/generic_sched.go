@@ -211,6 +211,25 @@ func (s *GenericScheduler) createBlockedEval(planFailure bool) error {
 	return s.planner.CreateEval(s.blocked)
 }
 
+// allFailuresUnplaceable returns true if every failed task group's
+// allocation exceeded the total capacity of every node it was evaluated
+// against. In that case no amount of waiting for capacity to free up, or
+// evicting other allocations, will ever let the allocation place, so
+// there's no point creating a blocked eval to retry it forever.
+func allFailuresUnplaceable(failedTGAllocs map[string]*structs.AllocMetric) bool {
+	if len(failedTGAllocs) == 0 {
+		return false
+	}
+
+	for _, metric := range failedTGAllocs {
+		if metric.NodesEvaluated == 0 || metric.NodesExceeded < metric.NodesEvaluated {
+			return false
+		}
+	}
+
+	return true
+}
+
 // process is wrapped in retryMax to iteratively run the handler until we have no
 // further work or we've made the maximum number of attempts.
 func (s *GenericScheduler) process() (bool, error) {
@@ -267,7 +286,7 @@ func (s *GenericScheduler) process() (bool, error) {
 	delayInstead := len(s.followUpEvals) > 0 && s.eval.WaitUntil.IsZero()
 
 	if s.eval.Status != structs.EvalStatusBlocked && len(s.failedTGAllocs) != 0 && s.blocked == nil &&
-		!delayInstead {
+		!delayInstead && !allFailuresUnplaceable(s.failedTGAllocs) {
 		if err := s.createBlockedEval(false); err != nil {
 			s.logger.Error("failed to make blocked eval", "error", err)
 			return false, err
@@ -421,9 +421,19 @@ OUTER:
 		fit, dim, util, _ := structs.AllocsFit(option.Node, proposed, netIdx, false)
 		netIdx.Release()
 		if !fit {
+			// This task group's own request alone, regardless of what else
+			// is running or evicted, exceeds the node's total capacity: no
+			// amount of preemption or waiting for the node to free up will
+			// ever make it fit.
+			exceeds, exceedsDim := structs.AllocExceedsNodeCapacity(option.Node, &structs.Allocation{AllocatedResources: total})
+
 			// Skip the node if evictions are not enabled
 			if !iter.evict {
-				iter.ctx.Metrics().ExhaustedNode(option.Node, dim)
+				if exceeds {
+					iter.ctx.Metrics().ExceedsCapacity(option.Node, exceedsDim)
+				} else {
+					iter.ctx.Metrics().ExhaustedNode(option.Node, dim)
+				}
 				continue
 			}
 
@@ -439,7 +449,11 @@ OUTER:
 			// If we were unable to find preempted allocs to meet these requirements
 			// mark as exhausted and continue
 			if len(preemptedAllocs) == 0 {
-				iter.ctx.Metrics().ExhaustedNode(option.Node, dim)
+				if exceeds {
+					iter.ctx.Metrics().ExceedsCapacity(option.Node, exceedsDim)
+				} else {
+					iter.ctx.Metrics().ExhaustedNode(option.Node, dim)
+				}
 				continue
 			}
 		}
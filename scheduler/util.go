@@ -306,6 +306,65 @@ func progressMade(result *structs.PlanResult) bool {
 		len(result.DeploymentUpdates) != 0)
 }
 
+// requeuePlan builds a new plan for resubmission from a plan that failed to
+// fully commit, without recomputing placement from scratch. Nodes that
+// failed their fit check, per the result's RefreshReasons, are dropped
+// entirely so the new plan doesn't immediately fail against a node already
+// known to reject it. Allocations for nodes that weren't committed but also
+// didn't fail fit -- for example because AllAtOnce or StrictValidation
+// forced an all-or-nothing rejection of an otherwise-fitting node -- are
+// preserved unchanged so they can be resubmitted as-is.
+//
+// No scheduler calls this yet: GenericScheduler and SystemScheduler both
+// retry a partial failure by rerunning process() in full, which rediffs
+// against the refreshed state rather than patching the old plan. This is
+// here for a scheduler that wants to retry without paying for a full
+// recompute, not a currently-exercised path.
+func requeuePlan(plan *structs.Plan, result *structs.PlanResult) *structs.Plan {
+	newPlan := &structs.Plan{
+		EvalID:                  plan.EvalID,
+		EvalToken:               plan.EvalToken,
+		Priority:                plan.Priority,
+		AllAtOnce:               plan.AllAtOnce,
+		Job:                     plan.Job,
+		Annotations:             plan.Annotations,
+		SatisfyCount:            plan.SatisfyCount,
+		ValidateCSIPlugins:      plan.ValidateCSIPlugins,
+		EvictionOrder:           plan.EvictionOrder,
+		ReconcileOrphanedAllocs: plan.ReconcileOrphanedAllocs,
+		NodeScoring:             plan.NodeScoring,
+		StrictValidation:        plan.StrictValidation,
+		RelaxedSystemJobFit:     plan.RelaxedSystemJobFit,
+		WriteConsistency:        plan.WriteConsistency,
+	}
+
+	if len(plan.NodeUpdate) > 0 {
+		newPlan.NodeUpdate = make(map[string][]*structs.Allocation, len(plan.NodeUpdate))
+		for nodeID, allocs := range plan.NodeUpdate {
+			if _, failed := result.RefreshReasons[nodeID]; failed {
+				continue
+			}
+			newPlan.NodeUpdate[nodeID] = allocs
+		}
+	}
+
+	if len(plan.NodeAllocation) > 0 {
+		newPlan.NodeAllocation = make(map[string][]*structs.Allocation, len(plan.NodeAllocation))
+		for nodeID, allocs := range plan.NodeAllocation {
+			if _, failed := result.RefreshReasons[nodeID]; failed {
+				continue
+			}
+			if committed := result.NodeAllocation[nodeID]; len(committed) == len(allocs) {
+				// already fully applied for this node, nothing left to retry
+				continue
+			}
+			newPlan.NodeAllocation[nodeID] = allocs
+		}
+	}
+
+	return newPlan
+}
+
 // taintedNodes is used to scan the allocations and then check if the
 // underlying nodes are tainted, and should force a migration of the allocation.
 // All the nodes returned in the map are tainted.
@@ -1244,6 +1244,40 @@ func TestProgressMade(t *testing.T) {
 		progressMade(deployment) && progressMade(deploymentUpdates))
 }
 
+func TestRequeuePlan(t *testing.T) {
+	alloc1 := mock.Alloc()
+	alloc2 := mock.Alloc()
+	alloc3 := mock.Alloc()
+
+	plan := &structs.Plan{
+		EvalID: uuid.Generate(),
+		NodeAllocation: map[string][]*structs.Allocation{
+			"fits-and-committed":   {alloc1},
+			"fits-but-not-applied": {alloc2},
+			"failed-fit":           {alloc3},
+		},
+	}
+
+	result := &structs.PlanResult{
+		NodeAllocation: map[string][]*structs.Allocation{
+			"fits-and-committed": {alloc1},
+		},
+		RefreshReasons: map[string]*structs.ComparableResources{
+			"failed-fit": {},
+		},
+	}
+
+	newPlan := requeuePlan(plan, result)
+	require.Equal(t, plan.EvalID, newPlan.EvalID)
+	require.NotContains(t, newPlan.NodeAllocation, "failed-fit",
+		"non-fitting node should be excluded from the requeued plan")
+	require.NotContains(t, newPlan.NodeAllocation, "fits-and-committed",
+		"already-committed allocations should not be resubmitted")
+	require.Contains(t, newPlan.NodeAllocation, "fits-but-not-applied",
+		"deferred allocations for a fitting, uncommitted node should be preserved")
+	require.Equal(t, []*structs.Allocation{alloc2}, newPlan.NodeAllocation["fits-but-not-applied"])
+}
+
 func TestDesiredUpdates(t *testing.T) {
 	tg1 := &structs.TaskGroup{Name: "foo"}
 	tg2 := &structs.TaskGroup{Name: "bar"}